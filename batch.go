@@ -0,0 +1,85 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultBatchBuffer is the size of the output buffer used when FlushInterval
+// is set without FlushSize.
+const defaultBatchBuffer = 64 * 1024
+
+// batchWriter buffers writes to the output stream and flushes them according
+// to the FlushSize and FlushInterval options on StreamConfig.
+type batchWriter struct {
+	mu       sync.Mutex
+	w        *bufio.Writer
+	size     int
+	interval time.Duration
+	timer    *time.Timer
+	err      error
+}
+
+func newBatchWriter(w io.Writer, size int, interval time.Duration) *batchWriter {
+	bufSize := size
+	if bufSize <= 0 {
+		bufSize = defaultBatchBuffer
+	}
+	return &batchWriter{
+		w:        bufio.NewWriterSize(w, bufSize),
+		size:     size,
+		interval: interval,
+	}
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// If a flush from the timer failed, report it on the next write.
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if b.size > 0 && b.w.Buffered() >= b.size {
+		return n, b.flush()
+	}
+	if b.timer == nil && b.w.Buffered() > 0 {
+		b.timer = time.AfterFunc(b.interval, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.timer = nil
+			/* #nosec */
+			b.flush()
+		})
+	}
+	return n, nil
+}
+
+// Flush writes any buffered data to the underlying writer immediately.
+func (b *batchWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+func (b *batchWriter) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+	b.err = b.w.Flush()
+	return b.err
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+const benchStreamStart = `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`
+
+func newBenchSession(b *testing.B, in io.Reader) *xmpp.Session {
+	b.Helper()
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: io.MultiReader(strings.NewReader(benchStreamStart), in),
+		Writer: ioutil.Discard,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.MustParse("example.net"), jid.MustParse("example.com"), rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+	}))
+	if err != nil {
+		b.Fatalf("error negotiating session: %v", err)
+	}
+	return s
+}
+
+func BenchmarkSend(b *testing.B) {
+	s := newBenchSession(b, strings.NewReader(""))
+	msg := stanza.Message{
+		ID:   "1234",
+		To:   jid.MustParse("juliet@example.com"),
+		Type: stanza.ChatMessage,
+	}
+	body := xml.StartElement{Name: xml.Name{Local: "body"}}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		err := s.Send(ctx, msg.Wrap(xmlstream.Wrap(xmlstream.Token(xml.CharData("Art thou not Romeo?")), body)))
+		if err != nil {
+			b.Fatalf("error sending message: %v", err)
+		}
+	}
+}
+
+func BenchmarkServe(b *testing.B) {
+	const msg = `<message id='1234' from='romeo@example.net/orchard' to='juliet@example.com' type='chat'><body>Neither, fair saint, if either thee dislike.</body></message>`
+	s := newBenchSession(b, strings.NewReader(strings.Repeat(msg, b.N)))
+	h := xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		for {
+			_, err := t.Token()
+			switch {
+			case err == io.EOF:
+				return nil
+			case err != nil:
+				return err
+			}
+		}
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	err := s.Serve(h)
+	if err != nil {
+		b.Fatalf("error serving: %v", err)
+	}
+}
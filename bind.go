@@ -44,6 +44,112 @@ func BindCustom(server func(jid.JID, string) (jid.JID, error)) StreamFeature {
 	return bind(server)
 }
 
+// ConflictPolicy determines how BindServer handles a request for a resource
+// that is already bound to another session.
+type ConflictPolicy uint8
+
+// A list of conflict policies.
+const (
+	// ConflictReject refuses to bind the requested resource and returns a
+	// conflict error to the client.
+	ConflictReject ConflictPolicy = iota
+
+	// ConflictReplace binds the requested resource to the new session after
+	// asking the existing session to be terminated.
+	ConflictReplace
+
+	// ConflictSuffix binds a new resource made by appending a random suffix to
+	// the requested resource.
+	ConflictSuffix
+)
+
+// BindConfig configures the server side of resource binding.
+type BindConfig struct {
+	// Generate returns a resource for the user when the client does not request
+	// one.
+	// Resources should be random to prevent certain security issues related to
+	// guessing resourceparts.
+	// If Generate is nil, a random resource is generated.
+	Generate func(user jid.JID) string
+
+	// InUse reports whether the full JID is already bound to a session.
+	// If InUse is nil, no resources are ever considered to be in use.
+	InUse func(j jid.JID) bool
+
+	// Policy is used when the requested resource is in use.
+	Policy ConflictPolicy
+
+	// Replace is called with the full JID of the existing session when Policy
+	// is ConflictReplace.
+	// It should terminate the existing session, normally by sending a conflict
+	// stream error.
+	// If it returns an error the resource is not bound; errors of type
+	// stanza.Error are returned to the client and any other error causes
+	// negotiation to fail.
+	Replace func(j jid.JID) error
+}
+
+// maxSuffixAttempts is the number of suffixes tried by ConflictSuffix before
+// giving up.
+const maxSuffixAttempts = 10
+
+func (c BindConfig) bind(user jid.JID, requested string) (jid.JID, error) {
+	user = user.Bare()
+	if requested == "" {
+		for i := 0; i < maxSuffixAttempts; i++ {
+			res := attr.RandomID()
+			if c.Generate != nil {
+				res = c.Generate(user)
+			}
+			j, err := user.WithResource(res)
+			if err != nil {
+				return jid.JID{}, err
+			}
+			if c.InUse == nil || !c.InUse(j) {
+				return j, nil
+			}
+		}
+		return jid.JID{}, stanza.Error{Type: stanza.Cancel, Condition: stanza.Conflict}
+	}
+
+	j, err := user.WithResource(requested)
+	if err != nil {
+		return jid.JID{}, stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}
+	}
+	if c.InUse == nil || !c.InUse(j) {
+		return j, nil
+	}
+
+	switch c.Policy {
+	case ConflictReplace:
+		if c.Replace != nil {
+			if err = c.Replace(j); err != nil {
+				return jid.JID{}, err
+			}
+		}
+		return j, nil
+	case ConflictSuffix:
+		for i := 0; i < maxSuffixAttempts; i++ {
+			j, err = user.WithResource(requested + "-" + attr.RandomLen(6))
+			if err != nil {
+				return jid.JID{}, stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}
+			}
+			if !c.InUse(j) {
+				return j, nil
+			}
+		}
+	}
+	return jid.JID{}, stanza.Error{Type: stanza.Cancel, Condition: stanza.Conflict}
+}
+
+// BindServer is like BindResource but on server sessions the resource is
+// assigned using the provided config.
+// The requested resource is honored if it is valid and available, otherwise
+// the conflict policy is applied.
+func BindServer(cfg BindConfig) StreamFeature {
+	return bind(cfg.bind)
+}
+
 type bindIQ struct {
 	stanza.IQ
 
@@ -175,6 +281,9 @@ func bind(server func(jid.JID, string) (jid.JID, error)) StreamFeature {
 				if err != nil {
 					return mask, nil, err
 				}
+				if !ok {
+					session.setRemoteAddr(j)
+				}
 				return Ready, nil, w.Flush()
 			}
 
@@ -215,7 +324,9 @@ func bind(server func(jid.JID, string) (jid.JID, error)) StreamFeature {
 			}
 			resp := bindIQ{}
 			switch start.Name {
-			case xml.Name{Space: ns.Client, Local: "iq"}:
+			// The server side of this feature responds in the jabber:server
+			// namespace, so accept either.
+			case xml.Name{Space: ns.Client, Local: "iq"}, xml.Name{Space: ns.Server, Local: "iq"}:
 				if err = d.DecodeElement(&resp, &start); err != nil {
 					return mask, nil, err
 				}
@@ -227,9 +338,7 @@ func bind(server func(jid.JID, string) (jid.JID, error)) StreamFeature {
 			case resp.ID != reqID:
 				return mask, nil, stream.UndefinedCondition
 			case resp.Type == stanza.ResultIQ:
-				// TODO: this should not use internal session details.
-				session.in.Info.To = resp.Bind.JID
-				session.out.Info.From = resp.Bind.JID
+				session.setLocalAddr(resp.Bind.JID)
 			case resp.Type == stanza.ErrorIQ:
 				return mask, nil, resp.Err
 			default:
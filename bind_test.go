@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -122,6 +123,35 @@ var bindTestCases = [...]xmpptest.FeatureTestCase{
 		Out:        `<iq xmlns="jabber:server" type="result" id="123"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"><jid>test@example.net/empty</jid></bind></iq>`,
 		FinalState: xmpp.Ready,
 	},
+
+	// BindServer tests
+	5: {
+		State: xmpp.Received,
+		Feature: xmpp.BindServer(xmpp.BindConfig{
+			Generate: func(jid.JID) string { return "generated" },
+		}),
+		In:         `<iq type="set" id="123"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"/></iq>`,
+		Out:        `<iq xmlns="jabber:server" type="result" id="123"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"><jid>test@example.net/generated</jid></bind></iq>`,
+		FinalState: xmpp.Ready,
+	},
+	6: {
+		State: xmpp.Received,
+		Feature: xmpp.BindServer(xmpp.BindConfig{
+			InUse: func(j jid.JID) bool {
+				return j.Resourcepart() == "test"
+			},
+			Policy: xmpp.ConflictReplace,
+			Replace: func(j jid.JID) error {
+				if j.String() != "test@example.net/test" {
+					return errors.New("wrong JID replaced")
+				}
+				return nil
+			},
+		}),
+		In:         `<iq type="set" id="123"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"><resource>test</resource></bind></iq>`,
+		Out:        `<iq xmlns="jabber:server" type="result" id="123"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"><jid>test@example.net/test</jid></bind></iq>`,
+		FinalState: xmpp.Ready,
+	},
 }
 
 func TestBind(t *testing.T) {
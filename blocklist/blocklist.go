@@ -0,0 +1,168 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package blocklist implements blocking and unblocking of contacts.
+//
+// Blocking is implemented using XEP-0191: Blocking Command.
+// When blocking a contact, a report of spam or abuse can also be attached so
+// that the server operator is notified as described in
+// XEP-0377: Spam Reporting.
+// Servers that support reporting but where the user does not want to block the
+// sender can be sent a report using the Report function.
+package blocklist // import "mellium.im/xmpp/blocklist"
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package.
+const (
+	NS          = "urn:xmpp:blocking"
+	NSReporting = "urn:xmpp:reporting:1"
+)
+
+const nsJID = "urn:xmpp:jid:0"
+
+// Reason is the reason that a report was made.
+type Reason string
+
+// A list of possible reasons.
+const (
+	Spam  Reason = "urn:xmpp:reporting:spam"
+	Abuse Reason = "urn:xmpp:reporting:abuse"
+)
+
+// Complaint is a report of spam or abuse.
+type Complaint struct {
+	Reason Reason
+
+	// Text is an optional human readable description of the problem.
+	Text string
+
+	// Stanzas are the IDs of offending messages (as assigned by the server
+	// that stored them) that should be forwarded to the server operator.
+	Stanzas []stanza.ID
+}
+
+func (c Complaint) wrap(inner ...xml.TokenReader) xml.TokenReader {
+	for _, id := range c.Stanzas {
+		inner = append(inner, id.TokenReader())
+	}
+	if c.Text != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(c.Text)),
+			xml.StartElement{Name: xml.Name{Local: "text"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{
+			Name: xml.Name{Space: NSReporting, Local: "report"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "reason"}, Value: string(c.Reason)}},
+		},
+	)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (c Complaint) TokenReader() xml.TokenReader {
+	return c.wrap()
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (c Complaint) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Complaint) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Fetch returns the list of blocked JIDs.
+func Fetch(ctx context.Context, s *xmpp.Session) ([]jid.JID, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"urn:xmpp:blocking blocklist"`
+		Items   []struct {
+			JID jid.JID `xml:"jid,attr"`
+		} `xml:"item"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "blocklist"},
+	}), stanza.IQ{Type: stanza.GetIQ}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make([]jid.JID, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		blocked = append(blocked, item.JID)
+	}
+	return blocked, nil
+}
+
+// Block adds JIDs to the blocklist.
+func Block(ctx context.Context, s *xmpp.Session, items ...jid.JID) error {
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		itemList(nil, items),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "block"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+// BlockAndReport is like Block except that the complaint is attached to each
+// blocked JID.
+func BlockAndReport(ctx context.Context, s *xmpp.Session, c Complaint, items ...jid.JID) error {
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		itemList(&c, items),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "block"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+// Unblock removes JIDs from the blocklist.
+// If no JIDs are provided, all JIDs are unblocked.
+func Unblock(ctx context.Context, s *xmpp.Session, items ...jid.JID) error {
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		itemList(nil, items),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "unblock"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+// Report sends a complaint about the provided JIDs to the users server without
+// blocking them.
+func Report(ctx context.Context, s *xmpp.Session, c Complaint, items ...jid.JID) error {
+	var inner []xml.TokenReader
+	for _, j := range items {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(j.String())),
+			xml.StartElement{Name: xml.Name{Space: nsJID, Local: "jid"}},
+		))
+	}
+	return s.UnmarshalIQElement(ctx, c.wrap(inner...), stanza.IQ{
+		To:   s.LocalAddr().Domain(),
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+func itemList(c *Complaint, items []jid.JID) xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, j := range items {
+		var report xml.TokenReader
+		if c != nil {
+			report = c.TokenReader()
+		}
+		inner = append(inner, xmlstream.Wrap(report, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: j.String()}},
+		}))
+	}
+	return xmlstream.MultiReader(inner...)
+}
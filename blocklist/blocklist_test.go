@@ -0,0 +1,96 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blocklist_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/blocklist"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler       = blocklist.Complaint{}
+	_ xmlstream.Marshaler = blocklist.Complaint{}
+	_ xmlstream.WriterTo  = blocklist.Complaint{}
+)
+
+var complaint = blocklist.Complaint{
+	Reason: blocklist.Spam,
+	Text:   "Never came trouble to my house like this.",
+	Stanzas: []stanza.ID{{
+		ID: "28482-98726-73623",
+		By: jid.MustParse("romeo@example.net"),
+	}},
+}
+
+func TestMarshalComplaint(t *testing.T) {
+	b, err := xml.Marshal(complaint)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const want = `<report xmlns="urn:xmpp:reporting:1" reason="urn:xmpp:reporting:spam"><stanza-id xmlns="urn:xmpp:sid:0" id="28482-98726-73623" by="romeo@example.net"></stanza-id><text>Never came trouble to my house like this.</text></report>`
+	if s := string(b); s != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, s)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="get"><blocklist xmlns="urn:xmpp:blocking"/></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"><blocklist xmlns="urn:xmpp:blocking"><item jid="romeo@montague.net"/><item jid="iago@shakespeare.lit"/></blocklist></iq>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	blocked, err := blocklist.Fetch(context.Background(), cs.Client)
+	if err != nil {
+		t.Fatalf("error fetching blocklist: %v", err)
+	}
+	want := []jid.JID{
+		jid.MustParse("romeo@montague.net"),
+		jid.MustParse("iago@shakespeare.lit"),
+	}
+	if !reflect.DeepEqual(blocked, want) {
+		t.Errorf("wrong blocklist: want=%v, got=%v", want, blocked)
+	}
+	m.Done()
+}
+
+func TestBlockAndReport(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="set"><block xmlns="urn:xmpp:blocking"><item jid="romeo@example.net"><report xmlns="urn:xmpp:reporting:1" reason="urn:xmpp:reporting:spam"><stanza-id xmlns="urn:xmpp:sid:0" id="28482-98726-73623"/><text>Never came trouble to my house like this.</text></report></item></block></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	err := blocklist.BlockAndReport(context.Background(), cs.Client, complaint, jid.MustParse("romeo@example.net"))
+	if err != nil {
+		t.Fatalf("error blocking: %v", err)
+	}
+	m.Done()
+}
+
+func TestReport(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="set"><report xmlns="urn:xmpp:reporting:1" reason="urn:xmpp:reporting:spam"><jid xmlns="urn:xmpp:jid:0">romeo@example.net</jid><text>Never came trouble to my house like this.</text></report></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	err := blocklist.Report(context.Background(), cs.Client, complaint, jid.MustParse("romeo@example.net"))
+	if err != nil {
+		t.Fatalf("error reporting: %v", err)
+	}
+	m.Done()
+}
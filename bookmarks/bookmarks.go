@@ -0,0 +1,330 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package bookmarks implements XEP-0402: PEP Native Bookmarks.
+//
+// Bookmarks are stored as items on a PEP node of the users account, one item
+// per chat room.
+// Older clients store all bookmarks in a single element using
+// XEP-0048: Bookmarks and XEP-0049: Private XML Storage instead.
+// Because real deployments often have clients that support only one of the two
+// formats, Store can be configured to read legacy bookmarks and to write every
+// change to both formats during a migration window, and Migrate copies any
+// legacy bookmarks that are missing from PEP.
+package bookmarks // import "mellium.im/xmpp/bookmarks"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/pep"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package.
+const (
+	NS       = "urn:xmpp:bookmarks:1"
+	NSCompat = "urn:xmpp:bookmarks:1#compat"
+	NSLegacy = "storage:bookmarks"
+)
+
+const nsPrivate = "jabber:iq:private"
+
+// Channel is a bookmarked chat room.
+type Channel struct {
+	JID      jid.JID
+	Name     string
+	Autojoin bool
+	Nick     string
+	Password string
+}
+
+func (c Channel) wrap(space string, attrs ...xml.Attr) xml.TokenReader {
+	if c.Name != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "name"}, Value: c.Name})
+	}
+	if c.Autojoin {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "autojoin"}, Value: "true"})
+	}
+	var inner []xml.TokenReader
+	if c.Nick != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(c.Nick)),
+			xml.StartElement{Name: xml.Name{Local: "nick"}},
+		))
+	}
+	if c.Password != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(c.Password)),
+			xml.StartElement{Name: xml.Name{Local: "password"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: space, Local: "conference"}, Attr: attrs},
+	)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+//
+// The output is the conference element used by PEP Native Bookmarks, which
+// does not include the JID of the room since it is used as the item ID.
+func (c Channel) TokenReader() xml.TokenReader {
+	return c.wrap(NS)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (c Channel) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Channel) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// It accepts both the PEP Native Bookmarks and the legacy format of the
+// conference element.
+func (c *Channel) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		JID      jid.JID `xml:"jid,attr"`
+		Name     string  `xml:"name,attr"`
+		Autojoin string  `xml:"autojoin,attr"`
+		Nick     string  `xml:"nick"`
+		Password string  `xml:"password"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	autojoin, _ := strconv.ParseBool(s.Autojoin)
+	*c = Channel{
+		JID:      s.JID,
+		Name:     s.Name,
+		Autojoin: autojoin,
+		Nick:     s.Nick,
+		Password: s.Password,
+	}
+	return nil
+}
+
+// Store reads and writes bookmarks.
+// The zero value only uses PEP Native Bookmarks.
+type Store struct {
+	// Legacy causes bookmarks in private XML storage to be returned by Fetch
+	// along with those on the PEP node and makes Publish and Delete update both
+	// formats, so that clients supporting only one of them see the same
+	// bookmarks.
+	// If a room is bookmarked in both formats, the PEP bookmark is used.
+	Legacy bool
+}
+
+// Fetch returns all bookmarks of the account.
+func (st Store) Fetch(ctx context.Context, s *xmpp.Session) ([]Channel, error) {
+	channels, err := fetchPEP(ctx, s)
+	if err != nil || !st.Legacy {
+		return channels, err
+	}
+	legacy, err := FetchLegacy(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range legacy {
+		if indexOf(channels, c.JID) == -1 {
+			channels = append(channels, c)
+		}
+	}
+	return channels, nil
+}
+
+// Publish adds or updates the bookmark for a room.
+func (st Store) Publish(ctx context.Context, s *xmpp.Session, c Channel) error {
+	err := publishPEP(ctx, s, c)
+	if err != nil || !st.Legacy {
+		return err
+	}
+	legacy, err := FetchLegacy(ctx, s)
+	if err != nil {
+		return err
+	}
+	if idx := indexOf(legacy, c.JID); idx != -1 {
+		legacy[idx] = c
+	} else {
+		legacy = append(legacy, c)
+	}
+	return PublishLegacy(ctx, s, legacy)
+}
+
+// Delete removes the bookmark for a room.
+func (st Store) Delete(ctx context.Context, s *xmpp.Session, room jid.JID) error {
+	err := retractPEP(ctx, s, room)
+	if err != nil || !st.Legacy {
+		return err
+	}
+	legacy, err := FetchLegacy(ctx, s)
+	if err != nil {
+		return err
+	}
+	idx := indexOf(legacy, room)
+	if idx == -1 {
+		return nil
+	}
+	legacy = append(legacy[:idx], legacy[idx+1:]...)
+	return PublishLegacy(ctx, s, legacy)
+}
+
+// Fetch is like Store.Fetch using the zero value of Store.
+func Fetch(ctx context.Context, s *xmpp.Session) ([]Channel, error) {
+	return Store{}.Fetch(ctx, s)
+}
+
+// Publish is like Store.Publish using the zero value of Store.
+func Publish(ctx context.Context, s *xmpp.Session, c Channel) error {
+	return Store{}.Publish(ctx, s, c)
+}
+
+// Delete is like Store.Delete using the zero value of Store.
+func Delete(ctx context.Context, s *xmpp.Session, room jid.JID) error {
+	return Store{}.Delete(ctx, s, room)
+}
+
+// Migrate publishes any bookmarks from private XML storage that are missing
+// from the PEP node and returns the number of bookmarks that were copied.
+// Legacy bookmarks are left in place so that older clients keep working.
+func Migrate(ctx context.Context, s *xmpp.Session) (int, error) {
+	legacy, err := FetchLegacy(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	channels, err := fetchPEP(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, c := range legacy {
+		if indexOf(channels, c.JID) != -1 {
+			continue
+		}
+		err = publishPEP(ctx, s, c)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// FetchLegacy returns the bookmarks stored in private XML storage.
+func FetchLegacy(ctx context.Context, s *xmpp.Session) ([]Channel, error) {
+	var resp struct {
+		XMLName  xml.Name  `xml:"jabber:iq:private query"`
+		Channels []Channel `xml:"storage:bookmarks storage>conference"`
+	}
+	err := s.UnmarshalIQElement(ctx, privateQuery(nil), stanza.IQ{
+		Type: stanza.GetIQ,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Channels, nil
+}
+
+// PublishLegacy replaces all bookmarks in private XML storage.
+func PublishLegacy(ctx context.Context, s *xmpp.Session, channels []Channel) error {
+	var inner []xml.TokenReader
+	for _, c := range channels {
+		inner = append(inner, c.wrap(NSLegacy, xml.Attr{
+			Name:  xml.Name{Local: "jid"},
+			Value: c.JID.Bare().String(),
+		}))
+	}
+	return s.UnmarshalIQElement(ctx, privateQuery(xmlstream.MultiReader(inner...)), stanza.IQ{
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+func privateQuery(inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: NSLegacy, Local: "storage"}}),
+		xml.StartElement{Name: xml.Name{Space: nsPrivate, Local: "query"}},
+	)
+}
+
+func fetchPEP(ctx context.Context, s *xmpp.Session) ([]Channel, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Items   []struct {
+			ID      string  `xml:"id,attr"`
+			Channel Channel `xml:"urn:xmpp:bookmarks:1 conference"`
+		} `xml:"items>item"`
+	}
+	err := s.UnmarshalIQElement(ctx, pubsubQuery(xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "items"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: NS}},
+	})), stanza.IQ{Type: stanza.GetIQ}, &resp)
+	// If the node does not exist yet there are no bookmarks.
+	if errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]Channel, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		j, err := jid.Parse(item.ID)
+		if err != nil {
+			continue
+		}
+		c := item.Channel
+		c.JID = j
+		channels = append(channels, c)
+	}
+	return channels, nil
+}
+
+func publishPEP(ctx context.Context, s *xmpp.Session, c Channel) error {
+	opts := pep.PersistentWhitelist
+	opts.MaxItems = "max"
+	opts.SendLastPublishedItem = "never"
+	return pep.Publish(ctx, s, NS, c.JID.Bare().String(), opts, c)
+}
+
+func retractPEP(ctx context.Context, s *xmpp.Session, room jid.JID) error {
+	return s.UnmarshalIQElement(ctx, pubsubQuery(xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: room.Bare().String()}},
+		}),
+		xml.StartElement{
+			Name: xml.Name{Local: "retract"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "node"}, Value: NS},
+				{Name: xml.Name{Local: "notify"}, Value: "true"},
+			},
+		},
+	)), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+func pubsubQuery(inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: pep.NSPubSub, Local: "pubsub"}})
+}
+
+func indexOf(channels []Channel, room jid.JID) int {
+	for i, c := range channels {
+		if c.JID.Bare().Equal(room.Bare()) {
+			return i
+		}
+	}
+	return -1
+}
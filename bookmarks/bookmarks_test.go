@@ -0,0 +1,131 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler       = bookmarks.Channel{}
+	_ xml.Unmarshaler     = (*bookmarks.Channel)(nil)
+	_ xmlstream.Marshaler = bookmarks.Channel{}
+	_ xmlstream.WriterTo  = bookmarks.Channel{}
+)
+
+const (
+	pepResult    = `<iq type="result" xmlns="jabber:client"><pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="urn:xmpp:bookmarks:1"><item id="theplay@conference.shakespeare.lit"><conference xmlns="urn:xmpp:bookmarks:1" name="The Play's the Thing" autojoin="true"><nick>JC</nick></conference></item></items></pubsub></iq>`
+	legacyResult = `<iq type="result" xmlns="jabber:client"><query xmlns="jabber:iq:private"><storage xmlns="storage:bookmarks"><conference name="Old Name" autojoin="1" jid="theplay@conference.shakespeare.lit"/><conference name="Orchard" jid="orchard@conference.shakespeare.lit"><nick>JC</nick><password>secret</password></conference></storage></query></iq>`
+	notFound     = `<iq type="error" xmlns="jabber:client"><error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`
+)
+
+var (
+	thePlay = bookmarks.Channel{
+		JID:      jid.MustParse("theplay@conference.shakespeare.lit"),
+		Name:     "The Play's the Thing",
+		Autojoin: true,
+		Nick:     "JC",
+	}
+	orchard = bookmarks.Channel{
+		JID:      jid.MustParse("orchard@conference.shakespeare.lit"),
+		Name:     "Orchard",
+		Nick:     "JC",
+		Password: "secret",
+	}
+)
+
+func TestMarshal(t *testing.T) {
+	b, err := xml.Marshal(thePlay)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const want = `<conference xmlns="urn:xmpp:bookmarks:1" name="The Play&#39;s the Thing" autojoin="true"><nick>JC</nick></conference>`
+	if s := string(b); s != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, s)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	for i, tc := range [...]struct {
+		store bookmarks.Store
+		want  []bookmarks.Channel
+	}{
+		0: {want: []bookmarks.Channel{thePlay}},
+		1: {
+			store: bookmarks.Store{Legacy: true},
+			want:  []bookmarks.Channel{thePlay, orchard},
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m := xmpptest.NewMock(t)
+			m.Expect(`<iq type="get"><pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="urn:xmpp:bookmarks:1"/></pubsub></iq>`).Reply(pepResult)
+			if tc.store.Legacy {
+				m.Expect(`<iq type="get"><query xmlns="jabber:iq:private"><storage xmlns="storage:bookmarks"/></query></iq>`).Reply(legacyResult)
+			}
+			cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+			defer cs.Close()
+
+			channels, err := tc.store.Fetch(context.Background(), cs.Client)
+			if err != nil {
+				t.Fatalf("error fetching bookmarks: %v", err)
+			}
+			if !reflect.DeepEqual(channels, tc.want) {
+				t.Errorf("wrong bookmarks:\nwant=%+v,\n got=%+v", tc.want, channels)
+			}
+			m.Done()
+		})
+	}
+}
+
+func TestPublishLegacy(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="set"><pubsub xmlns="http://jabber.org/protocol/pubsub"><publish node="urn:xmpp:bookmarks:1"><item id="theplay@conference.shakespeare.lit"/></publish></pubsub></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	m.Expect(`<iq type="get"><query xmlns="jabber:iq:private"/></iq>`).Reply(legacyResult)
+	m.Expect(`<iq type="set"><query xmlns="jabber:iq:private"><storage xmlns="storage:bookmarks"><conference jid="theplay@conference.shakespeare.lit" name="The Play&#39;s the Thing" autojoin="true"/><conference jid="orchard@conference.shakespeare.lit"/></storage></query></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	err := bookmarks.Store{Legacy: true}.Publish(context.Background(), cs.Client, thePlay)
+	if err != nil {
+		t.Fatalf("error publishing bookmark: %v", err)
+	}
+	m.Done()
+}
+
+func TestMigrate(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="get"><query xmlns="jabber:iq:private"/></iq>`).Reply(legacyResult)
+	m.Expect(`<iq type="get"><pubsub xmlns="http://jabber.org/protocol/pubsub"/></iq>`).Reply(notFound)
+	m.Expect(`<iq type="set"><pubsub><publish><item id="theplay@conference.shakespeare.lit"/></publish></pubsub></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	m.Expect(`<iq type="set"><pubsub><publish><item id="orchard@conference.shakespeare.lit"/></publish></pubsub></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	n, err := bookmarks.Migrate(context.Background(), cs.Client)
+	if err != nil {
+		t.Fatalf("error migrating bookmarks: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("wrong number of migrated bookmarks: want=2, got=%d", n)
+	}
+	m.Done()
+}
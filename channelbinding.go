@@ -0,0 +1,102 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	// Register hash functions that may be used for tls-server-end-point channel
+	// binding.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Channel binding types that can be passed to Session.ChannelBinding.
+const (
+	// ChannelBindingTLSUnique is the channel binding type defined in RFC 5929.
+	// It is not available for TLS 1.3 connections.
+	ChannelBindingTLSUnique = "tls-unique"
+
+	// ChannelBindingTLSServerEndPoint is the channel binding type defined in RFC
+	// 5929 that is derived from the server's certificate.
+	ChannelBindingTLSServerEndPoint = "tls-server-end-point"
+
+	// ChannelBindingTLSExporter is the channel binding type defined in RFC 9266
+	// for use with TLS 1.3.
+	ChannelBindingTLSExporter = "tls-exporter"
+)
+
+// ErrNoChannelBinding is returned by ChannelBinding when the requested channel
+// binding data is not available for the session.
+var ErrNoChannelBinding = errors.New("xmpp: channel binding data not available")
+
+const exporterLabel = "EXPORTER-Channel-Binding"
+
+// ChannelBinding returns the channel binding data of the given type for the
+// TLS connection underlying the session.
+// If TLS has not been negotiated, or the channel binding type is not available
+// for the negotiated TLS version, an error wrapping ErrNoChannelBinding is
+// returned.
+//
+// The tls-server-end-point type is derived from the certificate presented by
+// the remote server and is therefore only available on sessions that were
+// initiated by us.
+//
+// The SCRAM-PLUS mechanisms used by the SASL feature currently only support
+// tls-unique, so they cannot be negotiated over TLS 1.3 connections.
+// The tls-exporter data returned by this method is provided for use with other
+// authentication mechanisms or protocols that bind to the TLS channel.
+func (s *Session) ChannelBinding(typ string) ([]byte, error) {
+	connState := s.ConnectionState()
+	if !connState.HandshakeComplete {
+		return nil, fmt.Errorf("%w: TLS has not been negotiated", ErrNoChannelBinding)
+	}
+
+	switch typ {
+	case ChannelBindingTLSUnique:
+		if len(connState.TLSUnique) == 0 {
+			return nil, fmt.Errorf("%w: %s is not supported by the negotiated TLS version", ErrNoChannelBinding, typ)
+		}
+		return connState.TLSUnique, nil
+	case ChannelBindingTLSExporter:
+		b, err := connState.ExportKeyingMaterial(exporterLabel, nil, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoChannelBinding, err)
+		}
+		return b, nil
+	case ChannelBindingTLSServerEndPoint:
+		if len(connState.PeerCertificates) == 0 || s.State()&Received == Received {
+			return nil, fmt.Errorf("%w: server certificate not available", ErrNoChannelBinding)
+		}
+		return serverEndPoint(connState.PeerCertificates[0])
+	}
+	return nil, fmt.Errorf("%w: unknown channel binding type %q", ErrNoChannelBinding, typ)
+}
+
+// serverEndPoint hashes the certificate using the hash function from its
+// signature algorithm as described in RFC 5929 §4.1.
+func serverEndPoint(cert *x509.Certificate) ([]byte, error) {
+	var h crypto.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.DSAWithSHA256, x509.ECDSAWithSHA256:
+		// MD5 and SHA-1 are upgraded to SHA-256.
+		h = crypto.SHA256
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		h = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("%w: unsupported certificate signature algorithm %v", ErrNoChannelBinding, cert.SignatureAlgorithm)
+	}
+	hash := h.New()
+	// hash.Write never returns an error per the documentation.
+	/* #nosec */
+	_, _ = hash.Write(cert.Raw)
+	return hash.Sum(nil), nil
+}
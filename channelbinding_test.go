@@ -0,0 +1,125 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stream"
+	"mellium.im/xmpp/xmpptest"
+)
+
+func testCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.net"},
+		DNSNames:     []string{"example.net"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestChannelBindingNoTLS(t *testing.T) {
+	s := xmpptest.NewSession(0, &bytes.Buffer{})
+	for _, typ := range []string{xmpp.ChannelBindingTLSUnique, xmpp.ChannelBindingTLSExporter, xmpp.ChannelBindingTLSServerEndPoint} {
+		_, err := s.ChannelBinding(typ)
+		if !errors.Is(err, xmpp.ErrNoChannelBinding) {
+			t.Errorf("wrong error for %s: want=%v, got=%v", typ, xmpp.ErrNoChannelBinding, err)
+		}
+	}
+}
+
+func TestChannelBinding(t *testing.T) {
+	cert := testCert(t)
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	/* #nosec */
+	clientTLS := tls.Client(clientConn, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	})
+	serverTLS := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+	})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serverTLS.Handshake()
+	}()
+	if err := clientTLS.Handshake(); err != nil {
+		t.Fatalf("error performing client handshake: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("error performing server handshake: %v", err)
+	}
+
+	// Skip stream negotiation entirely so that the session is created directly on
+	// top of the TLS connections.
+	ready := func(context.Context, *stream.Info, *stream.Info, *xmpp.Session, interface{}) (xmpp.SessionState, io.ReadWriter, interface{}, error) {
+		return xmpp.Ready, nil, nil, nil
+	}
+	client, err := xmpp.NewSession(context.Background(), jid.MustParse("example.net"), jid.MustParse("test@example.net"), clientTLS, 0, ready)
+	if err != nil {
+		t.Fatalf("error creating client session: %v", err)
+	}
+	server, err := xmpp.ReceiveSession(context.Background(), serverTLS, 0, ready)
+	if err != nil {
+		t.Fatalf("error creating server session: %v", err)
+	}
+
+	clientExporter, err := client.ChannelBinding(xmpp.ChannelBindingTLSExporter)
+	if err != nil {
+		t.Fatalf("error getting client exporter: %v", err)
+	}
+	serverExporter, err := server.ChannelBinding(xmpp.ChannelBindingTLSExporter)
+	if err != nil {
+		t.Fatalf("error getting server exporter: %v", err)
+	}
+	if len(clientExporter) != 32 || !bytes.Equal(clientExporter, serverExporter) {
+		t.Errorf("exporter mismatch: client=%x, server=%x", clientExporter, serverExporter)
+	}
+
+	_, err = client.ChannelBinding(xmpp.ChannelBindingTLSUnique)
+	if !errors.Is(err, xmpp.ErrNoChannelBinding) {
+		t.Errorf("expected tls-unique to be unavailable over TLS 1.3, got: %v", err)
+	}
+
+	endPoint, err := client.ChannelBinding(xmpp.ChannelBindingTLSServerEndPoint)
+	if err != nil {
+		t.Fatalf("error getting tls-server-end-point: %v", err)
+	}
+	expected := sha256.Sum256(cert.Certificate[0])
+	if !bytes.Equal(endPoint, expected[:]) {
+		t.Errorf("wrong tls-server-end-point: want=%x, got=%x", expected, endPoint)
+	}
+}
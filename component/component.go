@@ -7,15 +7,19 @@
 package component // import "mellium.im/xmpp/component"
 
 import (
+	"bytes"
 	"context"
 	/* #nosec */
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/stream"
 )
@@ -41,9 +45,9 @@ func ReceiveSession(ctx context.Context, addr jid.JID, secret []byte, rw io.Read
 // Negotiator returns a new function that can be used to negotiate a component
 // protocol connection when passed to xmpp.NewSession.
 //
-// It currently only supports the client side of the component protocol.
 // If recv is true (indicating that we are receiving a connection on the server
-// side) the returned xmpp.Negotiator will panic.
+// side) the returned xmpp.Negotiator waits for the component to open a stream
+// to addr and then verifies its handshake using secret.
 func Negotiator(addr jid.JID, secret []byte, recv bool) xmpp.Negotiator {
 	return func(ctx context.Context, in, out *stream.Info, s *xmpp.Session, _ interface{}) (mask xmpp.SessionState, _ io.ReadWriter, _ interface{}, err error) {
 		d := xml.NewDecoder(s.Conn())
@@ -51,45 +55,21 @@ func Negotiator(addr jid.JID, secret []byte, recv bool) xmpp.Negotiator {
 		if recv {
 			// If we're the receiving entity wait for a new stream, then send one in
 			// response.
-			panic("component: receiving connections not yet implemented")
-		} else {
-			// If we're the initiating entity, send a new stream and then wait for one
-			// in response.
-			_, err = fmt.Fprintf(s.Conn(), `<stream:stream xmlns='`+NSAccept+`' xmlns:stream='http://etherx.jabber.org/streams' to='%s'>`, addr)
-			if err != nil {
-				return mask, nil, nil, err
-			}
-			out.To = addr
-			out.XMLNS = NSAccept
+			return receive(d, addr, secret, in, out, s)
 		}
 
-		foundProc := false
-		var start xml.StartElement
-		// TODO: This loop is stupid and probably broken. Find a way to reuse existing
-		// logic from the xmpp package?
-	procloop:
-		for {
-			tok, err := d.Token()
-			if err != nil {
-				return mask, nil, nil, err
-			}
-			switch t := tok.(type) {
-			case xml.ProcInst:
-				if !foundProc {
-					foundProc = true
-					continue
-				}
-				return mask, nil, nil, errors.New("component: received unexpected proc inst from server")
-			case xml.StartElement:
-				start = t
-				break procloop
-			default:
-				return mask, nil, nil, errors.New("component: received unexpected token from server")
-			}
+		// If we're the initiating entity, send a new stream and then wait for one
+		// in response.
+		_, err = fmt.Fprintf(s.Conn(), `<stream:stream xmlns='`+NSAccept+`' xmlns:stream='http://etherx.jabber.org/streams' to='%s'>`, addr)
+		if err != nil {
+			return mask, nil, nil, err
 		}
+		out.To = addr
+		out.XMLNS = NSAccept
 
-		if start.Name.Local != "stream" || start.Name.Space != stream.NS {
-			return mask, nil, nil, errors.New("component: expected stream:stream from server")
+		start, err := streamStart(d, "server")
+		if err != nil {
+			return mask, nil, nil, err
 		}
 
 		err = in.FromStartElement(start)
@@ -97,26 +77,8 @@ func Negotiator(addr jid.JID, secret []byte, recv bool) xmpp.Negotiator {
 			return mask, nil, nil, err
 		}
 
-		var id string
-		for _, attr := range start.Attr {
-			if attr.Name.Local == "id" {
-				id = attr.Value
-				break
-			}
-		}
-
-		/* #nosec */
-		h := sha1.New()
-
-		// hash.Write never returns an error per the documentation.
-		/* #nosec */
-		_, _ = h.Write([]byte(id))
-
-		// hash.Write never returns an error per the documentation.
-		/* #nosec */
-		_, _ = h.Write(secret)
-
-		_, err = fmt.Fprintf(s.Conn(), `<handshake>%x</handshake>`, h.Sum(nil))
+		id := in.ID
+		_, err = fmt.Fprintf(s.Conn(), `<handshake>%x</handshake>`, handshake(id, secret))
 		if err != nil {
 			return mask, nil, nil, err
 		}
@@ -149,3 +111,127 @@ func Negotiator(addr jid.JID, secret []byte, recv bool) xmpp.Negotiator {
 		return mask, nil, nil, fmt.Errorf("component: unknown start element: %v", start)
 	}
 }
+
+func receive(d *xml.Decoder, addr jid.JID, secret []byte, in, out *stream.Info, s *xmpp.Session) (mask xmpp.SessionState, _ io.ReadWriter, _ interface{}, err error) {
+	start, err := streamStart(d, "component")
+	if err != nil {
+		return mask, nil, nil, err
+	}
+	err = in.FromStartElement(start)
+	if err != nil {
+		return mask, nil, nil, err
+	}
+
+	id := attr.RandomID()
+	out.XMLNS = NSAccept
+	out.From = addr
+	out.ID = id
+	_, err = fmt.Fprintf(s.Conn(), `<stream:stream xmlns='`+NSAccept+`' xmlns:stream='http://etherx.jabber.org/streams' from='%s' id='%s'>`, addr, id)
+	if err != nil {
+		return mask, nil, nil, err
+	}
+	if !in.To.Equal(addr) {
+		return mask, nil, nil, failHandshake(s, stream.HostUnknown)
+	}
+
+	var resp struct {
+		XMLName xml.Name `xml:"handshake"`
+		Hash    string   `xml:",chardata"`
+	}
+	// Skip any whitespace between the stream header and the handshake.
+	var tok xml.Token
+	for {
+		tok, err = d.Token()
+		if err != nil {
+			return mask, nil, nil, err
+		}
+		if cd, ok := tok.(xml.CharData); !ok || len(bytes.TrimSpace(cd)) != 0 {
+			break
+		}
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Local != "handshake" {
+		return mask, nil, nil, failHandshake(s, stream.NotAuthorized)
+	}
+	err = d.DecodeElement(&resp, &start)
+	if err != nil {
+		return mask, nil, nil, err
+	}
+
+	expected := fmt.Sprintf("%x", handshake(id, secret))
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(strings.TrimSpace(resp.Hash))), []byte(expected)) != 1 {
+		return mask, nil, nil, failHandshake(s, stream.NotAuthorized)
+	}
+
+	_, err = fmt.Fprint(s.Conn(), `<handshake/>`)
+	if err != nil {
+		return mask, nil, nil, err
+	}
+	return xmpp.Ready | xmpp.Authn, nil, nil, nil
+}
+
+// failHandshake writes the stream error and closes the stream, returning the
+// stream error.
+// Any errors encountered while writing are ignored since the stream is being
+// terminated anyways.
+func failHandshake(s *xmpp.Session, streamErr stream.Error) error {
+	e := xml.NewEncoder(s.Conn())
+	/* #nosec */
+	_, _ = streamErr.WriteXML(e)
+	/* #nosec */
+	_ = e.Flush()
+	/* #nosec */
+	_, _ = fmt.Fprint(s.Conn(), `</stream:stream>`)
+	return streamErr
+}
+
+// handshake returns the SHA-1 hash of the stream ID concatenated with the
+// shared secret.
+func handshake(id string, secret []byte) []byte {
+	/* #nosec */
+	h := sha1.New()
+
+	// hash.Write never returns an error per the documentation.
+	/* #nosec */
+	_, _ = h.Write([]byte(id))
+
+	// hash.Write never returns an error per the documentation.
+	/* #nosec */
+	_, _ = h.Write(secret)
+
+	return h.Sum(nil)
+}
+
+// streamStart reads the stream header sent by the peer, skipping an optional
+// XML declaration.
+func streamStart(d *xml.Decoder, peer string) (xml.StartElement, error) {
+	foundProc := false
+	var start xml.StartElement
+	// TODO: This loop is stupid and probably broken. Find a way to reuse existing
+	// logic from the xmpp package?
+procloop:
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return start, err
+		}
+		switch t := tok.(type) {
+		case xml.ProcInst:
+			if !foundProc {
+				foundProc = true
+				continue
+			}
+			return start, fmt.Errorf("component: received unexpected proc inst from %s", peer)
+		case xml.StartElement:
+			start = t
+			break procloop
+		default:
+			return start, fmt.Errorf("component: received unexpected token from %s", peer)
+		}
+	}
+
+	if start.Name.Local != "stream" || start.Name.Space != stream.NS {
+		return start, fmt.Errorf("component: expected stream:stream from %s", peer)
+	}
+	return start, nil
+}
@@ -7,15 +7,19 @@ package component_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"strings"
 	"testing"
 
 	"mellium.im/xmpp/component"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stream"
 )
 
 const header = `<?xml version="1.0" encoding="UTF-8"?>`
@@ -128,3 +132,136 @@ func TestComponent(t *testing.T) {
 		})
 	}
 }
+
+var receiveTestCases = [...]struct {
+	addr   string
+	secret string
+	err    error
+}{
+	0: {
+		addr:   "component.example.net",
+		secret: "secret",
+	},
+	1: {
+		addr:   "component.example.net",
+		secret: "wrong",
+		err:    stream.NotAuthorized,
+	},
+	2: {
+		addr:   "other.example.net",
+		secret: "secret",
+		err:    stream.HostUnknown,
+	},
+}
+
+func TestReceive(t *testing.T) {
+	serverAddr := jid.MustParse("component.example.net")
+	for i, tc := range receiveTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Use a real connection instead of net.Pipe so that the server can write
+			// a stream error while the client is still writing its handshake.
+			clientConn, serverConn := tcpPipe(t)
+			defer clientConn.Close()
+			defer serverConn.Close()
+
+			serverErr := make(chan error, 1)
+			go func() {
+				s, err := component.ReceiveSession(ctx, serverAddr, []byte("secret"), serverConn)
+				if err == nil && !s.LocalAddr().Equal(serverAddr) {
+					err = fmt.Errorf("wrong local address: want=%v, got=%v", serverAddr, s.LocalAddr())
+				}
+				serverErr <- err
+			}()
+
+			_, err := component.NewSession(ctx, jid.MustParse(tc.addr), []byte(tc.secret), clientConn)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected client error: want=%v, got=%v", tc.err, err)
+			}
+			// Unblock any remaining writes from the server.
+			/* #nosec */
+			clientConn.Close()
+			if err := <-serverErr; !errors.Is(err, tc.err) {
+				t.Errorf("unexpected server error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestReceiveWhitespace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	serverAddr := jid.MustParse("component.example.net")
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := component.ReceiveSession(ctx, serverAddr, []byte("secret"), serverConn)
+		serverErr <- err
+	}()
+
+	_, err := fmt.Fprint(clientConn, `<stream:stream xmlns='jabber:component:accept' xmlns:stream='http://etherx.jabber.org/streams' to='component.example.net'>`)
+	if err != nil {
+		t.Fatalf("error writing stream header: %v", err)
+	}
+	d := xml.NewDecoder(clientConn)
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error reading stream header: %v", err)
+	}
+	var id string
+	for _, a := range tok.(xml.StartElement).Attr {
+		if a.Name.Local == "id" {
+			id = a.Value
+		}
+	}
+	/* #nosec */
+	h := sha1.Sum([]byte(id + "secret"))
+	_, err = fmt.Fprintf(clientConn, "\n  <handshake>%x</handshake>", h)
+	if err != nil {
+		t.Fatalf("error writing handshake: %v", err)
+	}
+	tok, err = d.Token()
+	if err != nil {
+		t.Fatalf("error reading handshake response: %v", err)
+	}
+	if start, ok := tok.(xml.StartElement); !ok || start.Name.Local != "handshake" {
+		t.Errorf("expected handshake response, got: %v", tok)
+	}
+	if err := <-serverErr; err != nil {
+		t.Errorf("unexpected server error: %v", err)
+	}
+}
+
+// tcpPipe returns both ends of a loopback TCP connection.
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- c
+	}()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	serverConn := <-accepted
+	if serverConn == nil {
+		t.Fatalf("error accepting connection")
+	}
+	return clientConn, serverConn
+}
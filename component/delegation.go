@@ -0,0 +1,164 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/internal/marshal"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSDelegation is the namespace used by XEP-0355: Namespace Delegation.
+const NSDelegation = `urn:xmpp:delegation:1`
+
+var errNoDelegatedIQ = errors.New("component: delegation did not contain a forwarded IQ")
+
+// Delegation is a namespace that the server has delegated to the component.
+type Delegation struct {
+	Namespace string
+	// Attributes is a list of attribute names that the server uses to filter
+	// which stanzas in the namespace are delegated.
+	// If it is empty every stanza in the namespace is delegated.
+	Attributes []string
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (d *Delegation) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var delegated struct {
+		Namespace string `xml:"namespace,attr"`
+		Attribute []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"attribute"`
+	}
+	err := dec.DecodeElement(&delegated, &start)
+	if err != nil {
+		return err
+	}
+	d.Namespace = delegated.Namespace
+	d.Attributes = d.Attributes[:0]
+	for _, a := range delegated.Attribute {
+		d.Attributes = append(d.Attributes, a.Name)
+	}
+	return nil
+}
+
+// HandleDelegated returns an option that registers h to handle IQs that the
+// server forwards to the component for any delegated namespace.
+//
+// The handler is called with the forwarded IQ as if it had been received
+// directly, and any response that it writes is wrapped and sent back to the
+// server to be delivered to the original sender.
+// If the handler does not write a response, a service-unavailable error is
+// returned to the sender.
+func HandleDelegated(h mux.IQHandler) mux.Option {
+	return mux.IQ(stanza.SetIQ, xml.Name{Space: NSDelegation, Local: "delegation"}, delegatedHandler{h: h})
+}
+
+type delegatedHandler struct {
+	h mux.IQHandler
+}
+
+func (d delegatedHandler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+	// Find the IQ inside the forwarded element.
+	var inner xml.StartElement
+	var foundIQ bool
+	for !foundIQ {
+		tok, err := t.Token()
+		switch {
+		case err == io.EOF:
+			return errNoDelegatedIQ
+		case err != nil:
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "iq" {
+			inner = start
+			foundIQ = true
+		}
+	}
+	innerIQ, err := stanza.NewIQ(inner)
+	if err != nil {
+		return err
+	}
+
+	r := xmlstream.Inner(t)
+	var payload *xml.StartElement
+	for payload == nil {
+		tok, err := r.Token()
+		switch {
+		case err == io.EOF:
+			return errNoDelegatedIQ
+		case err != nil:
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			payload = &start
+		}
+	}
+
+	buf := &tokenBuffer{r: r}
+	err = d.h.HandleIQ(innerIQ, buf, payload)
+	if err != nil {
+		return err
+	}
+	resp := buf.Reader()
+	if len(buf.toks) == 0 {
+		resp = innerIQ.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ServiceUnavailable,
+		})
+	}
+	_, err = xmlstream.Copy(t, iq.Result(xmlstream.Wrap(
+		xmlstream.Wrap(resp, xml.StartElement{Name: xml.Name{Space: forward.NS, Local: "forwarded"}}),
+		xml.StartElement{Name: xml.Name{Space: NSDelegation, Local: "delegation"}},
+	)))
+	return err
+}
+
+// tokenBuffer reads from the delegated IQ and buffers any tokens written to it
+// so that they can be wrapped before being sent back to the server.
+type tokenBuffer struct {
+	r    xml.TokenReader
+	toks []xml.Token
+}
+
+func (b *tokenBuffer) Token() (xml.Token, error) {
+	return b.r.Token()
+}
+
+func (b *tokenBuffer) EncodeToken(t xml.Token) error {
+	b.toks = append(b.toks, xml.CopyToken(t))
+	return nil
+}
+
+func (b *tokenBuffer) Flush() error {
+	return nil
+}
+
+func (b *tokenBuffer) Encode(v interface{}) error {
+	return marshal.EncodeXML(b, v)
+}
+
+func (b *tokenBuffer) EncodeElement(v interface{}, start xml.StartElement) error {
+	return marshal.EncodeXMLElement(b, v, start)
+}
+
+// Reader returns a token reader over the buffered tokens.
+func (b *tokenBuffer) Reader() xml.TokenReader {
+	toks := b.toks
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if len(toks) == 0 {
+			return nil, io.EOF
+		}
+		t := toks[0]
+		toks = toks[1:]
+		return t, nil
+	})
+}
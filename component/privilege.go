@@ -0,0 +1,162 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSPrivilege is the namespace used by XEP-0356: Privileged Entity.
+const NSPrivilege = `urn:xmpp:privilege:1`
+
+// Privileges are the permissions that a server has granted to a component to
+// act on behalf of the server's users.
+// Any empty values are equivalent to "none".
+type Privileges struct {
+	// Roster is one of "none", "get", "set", or "both".
+	Roster string
+	// Message is one of "none" or "outgoing".
+	Message string
+	// Presence is one of "none", "managed_entity", or "roster".
+	Presence string
+}
+
+// CanGetRoster reports whether the component may fetch users' rosters.
+func (p Privileges) CanGetRoster() bool {
+	return p.Roster == "get" || p.Roster == "both"
+}
+
+// CanSetRoster reports whether the component may modify users' rosters.
+func (p Privileges) CanSetRoster() bool {
+	return p.Roster == "set" || p.Roster == "both"
+}
+
+// CanSendMessage reports whether the component may send messages on behalf of
+// users.
+func (p Privileges) CanSendMessage() bool {
+	return p.Message == "outgoing"
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (p *Privileges) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var priv struct {
+		Perm []struct {
+			Access string `xml:"access,attr"`
+			Type   string `xml:"type,attr"`
+		} `xml:"perm"`
+	}
+	err := d.DecodeElement(&priv, &start)
+	if err != nil {
+		return err
+	}
+	for _, perm := range priv.Perm {
+		switch perm.Access {
+		case "roster":
+			p.Roster = perm.Type
+		case "message":
+			p.Message = perm.Type
+		case "presence":
+			p.Presence = perm.Type
+		}
+	}
+	return nil
+}
+
+// FetchRoster fetches the roster of the provided user.
+// The server must have granted the component permission to get rosters.
+func FetchRoster(ctx context.Context, s *xmpp.Session, user jid.JID) *roster.Iter {
+	return roster.FetchIQ(ctx, stanza.IQ{To: user.Bare()}, s)
+}
+
+// SetRoster creates or updates an item in the roster of the provided user.
+// The server must have granted the component permission to set rosters.
+func SetRoster(ctx context.Context, s *xmpp.Session, user jid.JID, item roster.Item) error {
+	q := roster.IQ{
+		IQ: stanza.IQ{
+			Type: stanza.SetIQ,
+			To:   user.Bare(),
+		},
+	}
+	q.Query.Item = append(q.Query.Item, item)
+	resp, err := s.SendIQ(ctx, q.TokenReader())
+	if err != nil {
+		return err
+	}
+	return resp.Close()
+}
+
+// SendAs asks the server to send the message read from r on behalf of one of
+// its users.
+// The message must be in the jabber:client namespace and its from attribute
+// must be the bare JID of the user.
+// The server must have granted the component permission to send messages.
+func SendAs(ctx context.Context, s *xmpp.Session, server jid.JID, r xml.TokenReader) error {
+	return s.Send(ctx, stanza.Message{
+		To: server.Domain(),
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(r, xml.StartElement{Name: xml.Name{Space: forward.NS, Local: "forwarded"}}),
+		xml.StartElement{Name: xml.Name{Space: NSPrivilege, Local: "privilege"}},
+	)))
+}
+
+// Handle returns an option that registers a Handler for the privileges and
+// delegated namespaces advertised by the server.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		privilege := xml.Name{Space: NSPrivilege, Local: "privilege"}
+		delegation := xml.Name{Space: NSDelegation, Local: "delegation"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage} {
+			mux.MessagePayload(typ, privilege, h)(m)
+			mux.MessagePayload(typ, delegation, h)(m)
+		}
+	}
+}
+
+// Handler receives the privileges and delegated namespaces that the server
+// advertises after the component connects.
+// Any nil callbacks are ignored.
+type Handler struct {
+	Privileges  func(server jid.JID, p Privileges) error
+	Delegations func(server jid.JID, d []Delegation) error
+}
+
+// HandleMessagePayload implements mux.MessagePayloadHandler.
+func (h Handler) HandleMessagePayload(msg stanza.Message, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	d := xml.NewTokenDecoder(xmlstream.Wrap(t, *start))
+	switch start.Name.Space {
+	case NSPrivilege:
+		if h.Privileges == nil {
+			return nil
+		}
+		var p Privileges
+		err := d.Decode(&p)
+		if err != nil {
+			return err
+		}
+		return h.Privileges(msg.From, p)
+	case NSDelegation:
+		if h.Delegations == nil {
+			return nil
+		}
+		var del struct {
+			Delegated []Delegation `xml:"delegated"`
+		}
+		err := d.Decode(&del)
+		if err != nil {
+			return err
+		}
+		return h.Delegations(msg.From, del.Delegated)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/component"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var privilegeHandlerTestCases = [...]struct {
+	x           string
+	privileges  *component.Privileges
+	delegations []component.Delegation
+}{
+	0: {
+		x: `<message from="capulet.lit" to="pubsub.capulet.lit" xmlns="jabber:component:accept"><privilege xmlns="urn:xmpp:privilege:1"><perm access="roster" type="both"/><perm access="message" type="outgoing"/><perm access="presence" type="managed_entity"/></privilege></message>`,
+		privileges: &component.Privileges{
+			Roster:   "both",
+			Message:  "outgoing",
+			Presence: "managed_entity",
+		},
+	},
+	1: {
+		x: `<message from="capulet.lit" to="pubsub.capulet.lit" xmlns="jabber:component:accept"><delegation xmlns="urn:xmpp:delegation:1"><delegated namespace="urn:xmpp:mam:2"/><delegated namespace="http://jabber.org/protocol/pubsub"><attribute name="node"/></delegated></delegation></message>`,
+		delegations: []component.Delegation{
+			{Namespace: "urn:xmpp:mam:2"},
+			{Namespace: "http://jabber.org/protocol/pubsub", Attributes: []string{"node"}},
+		},
+	},
+}
+
+func TestPrivilegeHandler(t *testing.T) {
+	for i, tc := range privilegeHandlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var (
+				privileges  *component.Privileges
+				delegations []component.Delegation
+			)
+			m := mux.New(component.Handle(component.Handler{
+				Privileges: func(_ jid.JID, p component.Privileges) error {
+					privileges = &p
+					return nil
+				},
+				Delegations: func(_ jid.JID, d []component.Delegation) error {
+					delegations = d
+					return nil
+				},
+			}))
+			d := xml.NewDecoder(strings.NewReader(tc.x))
+			tok, _ := d.Token()
+			start := tok.(xml.StartElement)
+			err := m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+			}, &start)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(privileges, tc.privileges) {
+				t.Errorf("wrong privileges: want=%+v, got=%+v", tc.privileges, privileges)
+			}
+			if !reflect.DeepEqual(delegations, tc.delegations) {
+				t.Errorf("wrong delegations: want=%+v, got=%+v", tc.delegations, delegations)
+			}
+		})
+	}
+}
+
+func TestSendAs(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	err := component.SendAs(context.Background(), s, jid.MustParse("capulet.lit"), stanza.Message{
+		From: jid.MustParse("juliet@capulet.lit"),
+		To:   jid.MustParse("romeo@montague.lit"),
+	}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{`to="capulet.lit"`, `<privilege xmlns="urn:xmpp:privilege:1"><forwarded xmlns="urn:xmpp:forward:0"><message`, `from="juliet@capulet.lit"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %s, got: %s", want, out)
+		}
+	}
+}
+
+func TestDelegated(t *testing.T) {
+	const req = `<iq from="capulet.lit" to="pubsub.capulet.lit" type="set" id="delegate1" xmlns="jabber:component:accept"><delegation xmlns="urn:xmpp:delegation:1"><forwarded xmlns="urn:xmpp:forward:0"><iq from="juliet@capulet.lit/balcony" to="capulet.lit" type="get" id="mam1" xmlns="jabber:client"><query xmlns="urn:xmpp:mam:2"/></iq></forwarded></delegation></iq>`
+
+	var (
+		called  bool
+		handled bool
+	)
+	for _, respond := range []bool{true, false} {
+		var out bytes.Buffer
+		e := xml.NewEncoder(&out)
+		m := mux.New(component.HandleDelegated(mux.IQHandlerFunc(func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			called = true
+			handled = start.Name.Space == "urn:xmpp:mam:2" && iq.From.String() == "juliet@capulet.lit/balcony"
+			if !respond {
+				return nil
+			}
+			_, err := xmlstream.Copy(t, iq.Result(nil))
+			return err
+		})))
+		d := xml.NewDecoder(strings.NewReader(req))
+		tok, _ := d.Token()
+		start := tok.(xml.StartElement)
+		err := m.HandleXMPP(struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: d,
+			Encoder:     e,
+		}, &start)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = e.Flush(); err != nil {
+			t.Fatalf("error flushing: %v", err)
+		}
+		if !called || !handled {
+			t.Fatalf("delegated IQ was not passed to the handler correctly")
+		}
+		resp := out.String()
+		wants := []string{`id="delegate1"`, `to="capulet.lit"`, `<forwarded xmlns="urn:xmpp:forward:0"><iq`, `id="mam1"`, `to="juliet@capulet.lit/balcony"`}
+		if respond {
+			wants = append(wants, `type="result"`)
+		} else {
+			wants = append(wants, `type="error"`, `service-unavailable`)
+		}
+		for _, want := range wants {
+			if !strings.Contains(resp, want) {
+				t.Errorf("expected response to contain %s, got: %s", want, resp)
+			}
+		}
+	}
+}
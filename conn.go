@@ -9,6 +9,7 @@ import (
 	"crypto/tls"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -144,10 +145,13 @@ func (c *conn) Write(b []byte) (int, error) {
 
 // teeConn is a net.Conn that also copies reads and writes to the provided
 // writers.
+// Copies are made asynchronously so that a slow writer can never block the
+// stream.
 type teeConn struct {
 	net.Conn
 	tlsConn     *tls.Conn
 	ctx         context.Context
+	cancel      context.CancelFunc
 	multiWriter io.Writer
 	teeReader   io.Reader
 }
@@ -155,22 +159,32 @@ type teeConn struct {
 // newTeeConn creates a teeConn. If the provided context is canceled, writes
 // start passing through to the underlying net.Conn and are no longer copied to
 // in and out.
-func newTeeConn(ctx context.Context, c net.Conn, in, out io.Writer) teeConn {
+// Up to bufSize writes to each of in and out are buffered, after which data is
+// dropped and the number of bytes dropped is added to inDropped or outDropped.
+func newTeeConn(ctx context.Context, cancel context.CancelFunc, c net.Conn, in, out io.Writer, bufSize int, inDropped, outDropped *uint64) teeConn {
 	if tc, ok := c.(teeConn); ok {
 		return tc
 	}
 
-	tc := teeConn{Conn: c, ctx: ctx}
+	tc := teeConn{Conn: c, ctx: ctx, cancel: cancel}
 	tc.tlsConn, _ = c.(*tls.Conn)
 	if in != nil {
-		tc.teeReader = io.TeeReader(c, in)
+		tc.teeReader = io.TeeReader(c, newAsyncWriter(ctx, in, bufSize, inDropped))
 	}
 	if out != nil {
-		tc.multiWriter = io.MultiWriter(c, out)
+		tc.multiWriter = io.MultiWriter(c, newAsyncWriter(ctx, out, bufSize, outDropped))
 	}
 	return tc
 }
 
+// Close stops copying data and closes the underlying connection.
+func (tc teeConn) Close() error {
+	if tc.cancel != nil {
+		tc.cancel()
+	}
+	return tc.Conn.Close()
+}
+
 func (tc teeConn) ConnectionState() tls.ConnectionState {
 	if tc.tlsConn == nil {
 		return tls.ConnectionState{}
@@ -203,3 +217,60 @@ func (tc teeConn) Read(p []byte) (int, error) {
 	}
 	return tc.teeReader.Read(p)
 }
+
+// asyncWriter is an io.Writer that copies data to an underlying writer from a
+// separate goroutine.
+// Writes never block or fail: if too many writes are pending the data is
+// dropped and counted instead.
+type asyncWriter struct {
+	ctx     context.Context
+	c       chan []byte
+	dropped *uint64
+}
+
+func newAsyncWriter(ctx context.Context, w io.Writer, bufSize int, dropped *uint64) asyncWriter {
+	aw := asyncWriter{
+		ctx:     ctx,
+		c:       make(chan []byte, bufSize),
+		dropped: dropped,
+	}
+	go func() {
+		for {
+			select {
+			case b := <-aw.c:
+				/* #nosec */
+				w.Write(b)
+			case <-ctx.Done():
+				// Flush anything that was already buffered before exiting.
+				for {
+					select {
+					case b := <-aw.c:
+						/* #nosec */
+						w.Write(b)
+					default:
+						// Pretty printers are created for each teeConn so they must be
+						// closed with it.
+						if pw, ok := w.(*prettyWriter); ok {
+							/* #nosec */
+							pw.Close()
+						}
+						return
+					}
+				}
+			}
+		}
+	}()
+	return aw
+}
+
+func (aw asyncWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	copy(b, p)
+	select {
+	case aw.c <- b:
+	case <-aw.ctx.Done():
+	default:
+		atomic.AddUint64(aw.dropped, uint64(len(p)))
+	}
+	return len(p), nil
+}
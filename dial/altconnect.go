@@ -0,0 +1,38 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package dial
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"mellium.im/xmpp/internal/discover"
+	"mellium.im/xmpp/jid"
+)
+
+// LookupWebSocket discovers WebSocket endpoints for the domainpart of addr
+// using Web Host Metadata files (in both the JSON and XML formats) and DNS TXT
+// records as described in XEP-0156: Discovering Alternative XMPP Connection
+// Methods.
+//
+// Endpoints are returned in priority order: endpoints from host metadata files
+// (which are fetched over HTTPS) come before endpoints from DNS and secure
+// endpoints come before insecure ones.
+// If resolver or client are nil, defaults are used.
+func LookupWebSocket(ctx context.Context, resolver *net.Resolver, client *http.Client, addr jid.JID) ([]string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return discover.LookupWebSocket(ctx, resolver, client, addr)
+}
+
+// LookupBOSH is like LookupWebSocket except that it discovers BOSH endpoints.
+func LookupBOSH(ctx context.Context, resolver *net.Resolver, client *http.Client, addr jid.JID) ([]string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return discover.LookupBOSH(ctx, resolver, client, addr)
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package dial
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// SRVCache is a cache of SRV records that is safe for concurrent use.
+// Only successful lookups are cached.
+//
+// The zero value is an empty cache that holds records for one minute.
+type SRVCache struct {
+	// TTL is the amount of time that records are kept in the cache.
+	// Because the resolver in the standard library does not expose the time to
+	// live of DNS records, TTL should be set no higher than the TTL of the
+	// records being looked up.
+	// If TTL is zero a default of one minute is used.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]srvEntry
+}
+
+type srvEntry struct {
+	cname   string
+	addrs   []*net.SRV
+	expires time.Time
+}
+
+const defaultSRVTTL = time.Minute
+
+// Flush removes all records from the cache.
+func (c *SRVCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+func (c *SRVCache) lookup(ctx context.Context, lookup func(context.Context, string, string, string) (string, []*net.SRV, error), service, proto, name string) (string, []*net.SRV, error) {
+	key := service + "." + proto + "." + name
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && now.After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		return entry.cname, copySRV(entry.addrs), nil
+	}
+
+	cname, addrs, err := lookup(ctx, service, proto, name)
+	if err != nil {
+		return cname, addrs, err
+	}
+
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = defaultSRVTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]srvEntry)
+	}
+	c.entries[key] = srvEntry{
+		cname:   cname,
+		addrs:   copySRV(addrs),
+		expires: now.Add(ttl),
+	}
+	return cname, addrs, nil
+}
+
+// copySRV makes a deep copy of addrs so that callers can't modify the records
+// stored in the cache.
+func copySRV(addrs []*net.SRV) []*net.SRV {
+	if addrs == nil {
+		return nil
+	}
+	cp := make([]*net.SRV, 0, len(addrs))
+	for _, addr := range addrs {
+		a := *addr
+		cp = append(cp, &a)
+	}
+	return cp
+}
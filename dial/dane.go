@@ -0,0 +1,133 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package dial
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// Errors returned by DANE verification.
+var (
+	ErrTLSAMismatch = errors.New("dial: certificate does not match any TLSA record")
+)
+
+// TLSA certificate usages, selectors, and matching types defined in RFC 6698.
+const (
+	TLSAUsagePKIXTA uint8 = 0
+	TLSAUsagePKIXEE uint8 = 1
+	TLSAUsageDANETA uint8 = 2
+	TLSAUsageDANEEE uint8 = 3
+
+	TLSASelectorCert uint8 = 0
+	TLSASelectorSPKI uint8 = 1
+
+	TLSAMatchFull   uint8 = 0
+	TLSAMatchSHA256 uint8 = 1
+	TLSAMatchSHA512 uint8 = 2
+)
+
+// TLSA is a DNS-based Authentication of Named Entities (DANE) TLSA record as
+// defined in RFC 6698.
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+// Match reports whether the certificate matches the record's selector,
+// matching type, and data.
+// The certificate usage is not taken into account.
+func (r TLSA) Match(cert *x509.Certificate) bool {
+	var data []byte
+	switch r.Selector {
+	case TLSASelectorCert:
+		data = cert.Raw
+	case TLSASelectorSPKI:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch r.MatchingType {
+	case TLSAMatchFull:
+	case TLSAMatchSHA256:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case TLSAMatchSHA512:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(data, r.Data)
+}
+
+// verifyTLSA checks that the certificates in cs match at least one of the
+// records.
+// For PKIX usages the chain must also be valid for one of names using roots
+// (or the system roots if roots is nil).
+func verifyTLSA(records []TLSA, cs tls.ConnectionState, names []string, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return ErrTLSAMismatch
+	}
+	leaf := cs.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	verify := func(roots *x509.CertPool) [][]*x509.Certificate {
+		for _, name := range names {
+			chains, err := leaf.Verify(x509.VerifyOptions{
+				DNSName:       name,
+				Roots:         roots,
+				Intermediates: intermediates,
+			})
+			if err == nil {
+				return chains
+			}
+		}
+		return nil
+	}
+
+	for _, r := range records {
+		switch r.Usage {
+		case TLSAUsageDANEEE:
+			// RFC 7672 and RFC 7673 both say that the name and validity period are
+			// not checked for DANE-EE.
+			if r.Match(leaf) {
+				return nil
+			}
+		case TLSAUsageDANETA:
+			for _, cert := range cs.PeerCertificates {
+				if !r.Match(cert) {
+					continue
+				}
+				pool := x509.NewCertPool()
+				pool.AddCert(cert)
+				if verify(pool) != nil {
+					return nil
+				}
+			}
+		case TLSAUsagePKIXEE:
+			if r.Match(leaf) && verify(roots) != nil {
+				return nil
+			}
+		case TLSAUsagePKIXTA:
+			for _, chain := range verify(roots) {
+				for _, cert := range chain {
+					if r.Match(cert) {
+						return nil
+					}
+				}
+			}
+		}
+	}
+	return ErrTLSAMismatch
+}
@@ -0,0 +1,259 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package dial_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/dial"
+	"mellium.im/xmpp/jid"
+)
+
+func testCert(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.net"},
+		DNSNames:              []string{"example.net"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func TestDANE(t *testing.T) {
+	tlsCert, cert := testCert(t)
+	spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	errVerify := errors.New("verify failed")
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			/* #nosec */
+			c.(*tls.Conn).Handshake()
+			/* #nosec */
+			c.Close()
+		}
+	}()
+	_, portStr, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error splitting address: %v", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		t.Fatalf("error parsing port: %v", err)
+	}
+
+	for i, tc := range [...]struct {
+		records []dial.TLSA
+		verify  func(string, tls.ConnectionState) error
+		err     bool
+	}{
+		0: {
+			records: []dial.TLSA{{
+				Usage:        dial.TLSAUsageDANEEE,
+				Selector:     dial.TLSASelectorSPKI,
+				MatchingType: dial.TLSAMatchSHA256,
+				Data:         spkiSum[:],
+			}},
+		},
+		1: {
+			records: []dial.TLSA{{
+				Usage:        dial.TLSAUsageDANEEE,
+				Selector:     dial.TLSASelectorSPKI,
+				MatchingType: dial.TLSAMatchSHA256,
+				Data:         []byte("bad"),
+			}},
+			err: true,
+		},
+		2: {
+			// No records means normal verification which fails for a self-signed
+			// certificate.
+			err: true,
+		},
+		3: {
+			records: []dial.TLSA{{
+				Usage:        dial.TLSAUsageDANEEE,
+				Selector:     dial.TLSASelectorSPKI,
+				MatchingType: dial.TLSAMatchSHA256,
+				Data:         spkiSum[:],
+			}},
+			verify: func(target string, _ tls.ConnectionState) error {
+				if target != "127.0.0.1" {
+					t.Errorf("wrong target: want=127.0.0.1, got=%s", target)
+				}
+				return errVerify
+			},
+			err: true,
+		},
+		4: {
+			records: []dial.TLSA{{
+				Usage:        dial.TLSAUsageDANETA,
+				Selector:     dial.TLSASelectorCert,
+				MatchingType: dial.TLSAMatchFull,
+				Data:         cert.Raw,
+			}},
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := dial.Dialer{
+				LookupSRV: func(_ context.Context, service, _, _ string) (string, []*net.SRV, error) {
+					if service != "xmpps-client" {
+						return "", nil, nil
+					}
+					return "", []*net.SRV{{Target: "127.0.0.1", Port: uint16(port)}}, nil
+				},
+				LookupTLSA: func(_ context.Context, p uint16, proto, host string) ([]dial.TLSA, error) {
+					if p != uint16(port) || proto != "tcp" || host != "127.0.0.1" {
+						t.Errorf("unexpected TLSA lookup: port=%d, proto=%q, host=%q", p, proto, host)
+					}
+					return tc.records, nil
+				},
+				VerifyConnection: tc.verify,
+			}
+			conn, err := d.Dial(context.Background(), "tcp", jid.MustParse("me@example.net"))
+			switch {
+			case tc.err && err == nil:
+				/* #nosec */
+				conn.Close()
+				t.Fatalf("expected error dialing")
+			case !tc.err && err != nil:
+				t.Fatalf("unexpected error dialing: %v", err)
+			case err == nil:
+				/* #nosec */
+				conn.Close()
+			}
+		})
+	}
+}
+
+func TestConfigureTLS(t *testing.T) {
+	tlsCert, cert := testCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			/* #nosec */
+			c.(*tls.Conn).Handshake()
+			/* #nosec */
+			c.Close()
+		}
+	}()
+	_, portStr, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error splitting address: %v", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		t.Fatalf("error parsing port: %v", err)
+	}
+
+	// Get a port that nothing is listening on so that the first target fails and
+	// the callback is called again for the second.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	_, closedPortStr, err := net.SplitHostPort(closed.Addr().String())
+	if err != nil {
+		t.Fatalf("error splitting address: %v", err)
+	}
+	closedPort, err := strconv.ParseUint(closedPortStr, 10, 16)
+	if err != nil {
+		t.Fatalf("error parsing port: %v", err)
+	}
+	/* #nosec */
+	closed.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	origCfg := &tls.Config{ServerName: "example.com"}
+	var targets []string
+	d := dial.Dialer{
+		TLSConfig: origCfg,
+		LookupSRV: func(_ context.Context, service, _, _ string) (string, []*net.SRV, error) {
+			if service != "xmpps-client" {
+				return "", nil, nil
+			}
+			return "", []*net.SRV{
+				{Target: "127.0.0.1", Port: uint16(closedPort), Priority: 1},
+				{Target: "127.0.0.1", Port: uint16(port), Priority: 2},
+			}, nil
+		},
+		ConfigureTLS: func(target string, cfg *tls.Config) {
+			targets = append(targets, target)
+			if cfg == origCfg {
+				t.Errorf("expected a copy of the TLS config to be passed to the callback")
+			}
+			if cfg.ServerName != "example.com" {
+				t.Errorf("wrong server name in config: want=example.com, got=%s", cfg.ServerName)
+			}
+			cfg.ServerName = "example.net"
+			cfg.RootCAs = pool
+		},
+	}
+	conn, err := d.Dial(context.Background(), "tcp", jid.MustParse("me@example.net"))
+	if err != nil {
+		t.Fatalf("unexpected error dialing: %v", err)
+	}
+	/* #nosec */
+	conn.Close()
+	if len(targets) != 2 || targets[0] != "127.0.0.1" || targets[1] != "127.0.0.1" {
+		t.Errorf("expected callback to be called once per target, got: %v", targets)
+	}
+	if origCfg.ServerName != "example.com" || origCfg.RootCAs != nil {
+		t.Errorf("callback should not modify the original config")
+	}
+}
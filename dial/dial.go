@@ -10,6 +10,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"net/url"
 	"strconv"
 	"sync"
 
@@ -63,7 +64,63 @@ type Dialer struct {
 	// NoLookup is set) and then attempting to use the domains A or AAAA record.
 	// The nil value is interpreted as a tls.Config with the expected host set to
 	// that of the connection addresses domain part.
+	//
+	// TLSConfig is only used when dialing with implicit (direct) TLS.
+	// To configure the TLS upgrade performed after dialing without TLS, pass a
+	// separate config to xmpp.StartTLS.
 	TLSConfig *tls.Config
+
+	// If non-nil, ConfigureTLS is called before dialing each target with
+	// implicit TLS.
+	// It is passed the target host from the resolved SRV record and a copy of
+	// the TLS config that would otherwise be used, which it may modify.
+	// This can be used when different endpoints present different certificates,
+	// for example to set the ServerName or RootCAs based on the target.
+	ConfigureTLS func(target string, cfg *tls.Config)
+
+	// If non-nil, VerifyConnection is called after the TLS handshake with each
+	// target when dialing with implicit TLS.
+	// It is called in addition to the normal certificate verification (unless
+	// InsecureSkipVerify is set on the TLS config) and can be used to implement
+	// alternative verification policies such as certificate pinning or POSH.
+	// If it returns an error the connection is aborted.
+	VerifyConnection func(target string, cs tls.ConnectionState) error
+
+	// If non-nil, LookupTLSA is used to look up DANE TLSA records for each
+	// target before dialing with implicit TLS.
+	// If any records are returned, the certificate presented by the server must
+	// match one of them as described in RFC 6698 and RFC 7673 instead of being
+	// verified using the default root CAs.
+	// If no records are returned, the certificate is verified normally.
+	//
+	// The standard library does not support looking up TLSA records or
+	// validating DNSSEC signatures so LookupTLSA must be provided by the user and
+	// must only return records that have been authenticated using DNSSEC.
+	LookupTLSA func(ctx context.Context, port uint16, proto, host string) ([]TLSA, error)
+
+	// If non-nil, LookupSRV is used to look up SRV records instead of the
+	// Resolver from the embedded net.Dialer.
+	// This can be used to plug in alternative resolution mechanisms such as
+	// DNS-over-HTTPS or split-horizon DNS, or to provide static records in tests.
+	// It has the same semantics as net.Resolver.LookupSRV.
+	LookupSRV func(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+
+	// If non-nil, Proxy is called with the "host:port" address of each target
+	// to determine which proxy (if any) to connect through.
+	// If it returns a nil URL, the target is dialed directly.
+	// Supported proxy schemes are socks5, socks5h, and http (which uses the HTTP
+	// CONNECT method).
+	// To use the proxy configured in the environment set Proxy to
+	// ProxyFromEnvironment.
+	// For example, to connect through a local Tor daemon:
+	//
+	//     torURL, _ := url.Parse("socks5h://127.0.0.1:9050")
+	//     d.Proxy = func(string) (*url.URL, error) { return torURL, nil }
+	Proxy func(addr string) (*url.URL, error)
+
+	// If non-nil, SRVCache is used to cache the results of SRV lookups.
+	// A single cache may be shared between multiple dialers.
+	SRVCache *SRVCache
 }
 
 // Dial discovers and connects to the address on the named network.
@@ -105,7 +162,7 @@ func (d *Dialer) dial(ctx context.Context, network string, addr jid.JID) (net.Co
 				// Lookup xmpps-(client|server)
 				defer wg.Done()
 				xmppsService := connType(true, d.S2S)
-				addrs, err := discover.LookupService(ctx, d.Resolver, xmppsService, addr)
+				addrs, err := discover.LookupServiceFunc(ctx, d.lookupSRV, xmppsService, addr)
 				if err != nil {
 					xmppsErr = err
 				}
@@ -116,7 +173,7 @@ func (d *Dialer) dial(ctx context.Context, network string, addr jid.JID) (net.Co
 			// Lookup xmpp-(client|server)
 			defer wg.Done()
 			xmppService := connType(false, d.S2S)
-			addrs, err := discover.LookupService(ctx, d.Resolver, xmppService, addr)
+			addrs, err := discover.LookupServiceFunc(ctx, d.lookupSRV, xmppService, addr)
 			if err != nil {
 				xmppErr = err
 			}
@@ -140,22 +197,21 @@ func (d *Dialer) dial(ctx context.Context, network string, addr jid.JID) (net.Co
 	for _, addr := range addrs {
 		var c net.Conn
 		var e error
+		hostport := net.JoinHostPort(
+			addr.Target,
+			strconv.FormatUint(uint64(addr.Port), 10),
+		)
 		if d.NoTLS {
-			c, e = d.Dialer.DialContext(ctx, network, net.JoinHostPort(
-				addr.Target,
-				strconv.FormatUint(uint64(addr.Port), 10),
-			))
+			c, e = d.dialTarget(ctx, network, hostport)
 		} else {
-			if d.TLSConfig == nil {
-				c, e = tls.DialWithDialer(&d.Dialer, network, net.JoinHostPort(
-					addr.Target,
-					strconv.FormatUint(uint64(addr.Port), 10),
-				), &tls.Config{ServerName: domain})
-			} else {
-				c, e = tls.DialWithDialer(&d.Dialer, network, net.JoinHostPort(
-					addr.Target,
-					strconv.FormatUint(uint64(addr.Port), 10),
-				), d.TLSConfig)
+			var cfg *tls.Config
+			cfg, e = d.tlsConfig(ctx, domain, addr)
+			switch {
+			case e != nil:
+			case d.Proxy != nil:
+				c, e = d.dialTLSProxy(ctx, network, hostport, cfg)
+			default:
+				c, e = tls.DialWithDialer(&d.Dialer, network, hostport, cfg)
 			}
 		}
 		if e != nil {
@@ -168,6 +224,85 @@ func (d *Dialer) dial(ctx context.Context, network string, addr jid.JID) (net.Co
 	return nil, err
 }
 
+// lookupSRV looks up SRV records using the cache (if any) and the configured
+// lookup function or resolver.
+func (d *Dialer) lookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	lookup := d.LookupSRV
+	if lookup == nil {
+		lookup = d.Resolver.LookupSRV
+	}
+	if d.SRVCache == nil {
+		return lookup(ctx, service, proto, name)
+	}
+	return d.SRVCache.lookup(ctx, lookup, service, proto, name)
+}
+
+// tlsConfig returns the TLS config to use when dialing target with implicit
+// TLS.
+func (d *Dialer) tlsConfig(ctx context.Context, domain string, addr *net.SRV) (*tls.Config, error) {
+	var cfg *tls.Config
+	if d.TLSConfig == nil {
+		cfg = &tls.Config{ServerName: domain}
+	} else {
+		cfg = d.TLSConfig
+	}
+	if d.ConfigureTLS == nil && d.VerifyConnection == nil && d.LookupTLSA == nil {
+		return cfg, nil
+	}
+	// Make a copy so that modifications don't affect the config used for other
+	// targets.
+	cfg = cfg.Clone()
+	if d.ConfigureTLS != nil {
+		d.ConfigureTLS(addr.Target, cfg)
+	}
+
+	var records []TLSA
+	if d.LookupTLSA != nil {
+		var err error
+		records, err = d.LookupTLSA(ctx, addr.Port, "tcp", addr.Target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	verifiers := make([]func(tls.ConnectionState) error, 0, 3)
+	if cfg.VerifyConnection != nil {
+		verifiers = append(verifiers, cfg.VerifyConnection)
+	}
+	if len(records) > 0 {
+		// Certificates are verified against the TLSA records instead of using the
+		// default verification.
+		serverName := cfg.ServerName
+		if serverName == "" {
+			serverName = domain
+		}
+		roots := cfg.RootCAs
+		names := []string{serverName, addr.Target}
+		cfg.InsecureSkipVerify = true
+		verifiers = append(verifiers, func(cs tls.ConnectionState) error {
+			return verifyTLSA(records, cs, names, roots)
+		})
+	}
+	if d.VerifyConnection != nil {
+		target := addr.Target
+		verifiers = append(verifiers, func(cs tls.ConnectionState) error {
+			return d.VerifyConnection(target, cs)
+		})
+	}
+	if len(verifiers) > 0 {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, verify := range verifiers {
+				err := verify(cs)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
 func connType(useTLS, s2s bool) string {
 	switch {
 	case useTLS && s2s:
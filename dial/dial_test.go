@@ -0,0 +1,136 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package dial_test
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/dial"
+	"mellium.im/xmpp/jid"
+)
+
+func TestLookupSRV(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			/* #nosec */
+			c.Close()
+		}
+	}()
+	_, portStr, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error splitting address: %v", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		t.Fatalf("error parsing port: %v", err)
+	}
+
+	var lookups int
+	d := dial.Dialer{
+		NoTLS: true,
+		LookupSRV: func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+			lookups++
+			if service != "xmpp-client" || proto != "tcp" || name != "example.net" {
+				t.Errorf("unexpected lookup: service=%q, proto=%q, name=%q", service, proto, name)
+			}
+			return "", []*net.SRV{{Target: "127.0.0.1", Port: uint16(port)}}, nil
+		},
+		SRVCache: &dial.SRVCache{},
+	}
+	for i := 0; i < 2; i++ {
+		conn, err := d.Dial(context.Background(), "tcp", jid.MustParse("me@example.net"))
+		if err != nil {
+			t.Fatalf("error dialing %d: %v", i, err)
+		}
+		if addr := conn.RemoteAddr().String(); addr != ln.Addr().String() {
+			t.Errorf("dialed wrong address: want=%s, got=%s", ln.Addr(), addr)
+		}
+		/* #nosec */
+		conn.Close()
+	}
+	if lookups != 1 {
+		t.Errorf("expected records to be cached: want=1 lookup, got=%d", lookups)
+	}
+
+	d.SRVCache.Flush()
+	conn, err := d.Dial(context.Background(), "tcp", jid.MustParse("me@example.net"))
+	if err != nil {
+		t.Fatalf("error dialing after flush: %v", err)
+	}
+	/* #nosec */
+	conn.Close()
+	if lookups != 2 {
+		t.Errorf("expected lookup after flushing cache: want=2 lookups, got=%d", lookups)
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	errs := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer c.Close()
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			errs <- err
+			return
+		}
+		if req.Method != http.MethodConnect || req.Host != "xmpp.example.net:5222" {
+			t.Errorf("unexpected request: method=%q, host=%q", req.Method, req.Host)
+		}
+		_, err = c.Write([]byte("HTTP/1.1 200 OK\r\n\r\nhello"))
+		errs <- err
+	}()
+
+	proxyURL := &url.URL{Scheme: "http", Host: ln.Addr().String()}
+	d := dial.Dialer{
+		NoTLS: true,
+		Proxy: func(addr string) (*url.URL, error) {
+			return proxyURL, nil
+		},
+		LookupSRV: func(context.Context, string, string, string) (string, []*net.SRV, error) {
+			return "", []*net.SRV{{Target: "xmpp.example.net", Port: 5222}}, nil
+		},
+	}
+	conn, err := d.Dial(context.Background(), "tcp", jid.MustParse("me@example.net"))
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer conn.Close()
+	if err := <-errs; err != nil {
+		t.Fatalf("error in proxy: %v", err)
+	}
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("error reading from proxied conn: %v", err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("unexpected data from proxied conn: want=%q, got=%q", "hello", b)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package dial
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyFromEnvironment returns the URL of the proxy to use for connecting to
+// addr (a "host:port" pair) as indicated by the environment variables
+// ALL_PROXY and HTTPS_PROXY (or the lowercase versions thereof) with ALL_PROXY
+// taking precedence.
+// Hosts listed in the NO_PROXY environment variable are connected to directly.
+//
+// Proxy URLs may use the socks5, socks5h, or http schemes.
+// If no proxy is configured or addr should not use a proxy, a nil URL and nil
+// error are returned.
+func ProxyFromEnvironment(addr string) (*url.URL, error) {
+	cfg := httpproxy.Config{
+		HTTPSProxy: getEnvAny("ALL_PROXY", "all_proxy"),
+		NoProxy:    getEnvAny("NO_PROXY", "no_proxy"),
+	}
+	if cfg.HTTPSProxy == "" {
+		cfg.HTTPSProxy = getEnvAny("HTTPS_PROXY", "https_proxy")
+	}
+	return cfg.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+}
+
+func getEnvAny(names ...string) string {
+	for _, n := range names {
+		if val := os.Getenv(n); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// dialTarget connects to addr using the proxy if one is configured, or
+// directly otherwise.
+func (d *Dialer) dialTarget(ctx context.Context, network, addr string) (net.Conn, error) {
+	var proxyURL *url.URL
+	if d.Proxy != nil {
+		var err error
+		proxyURL, err = d.Proxy(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if proxyURL == nil {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u := proxyURL.User; u != nil {
+			auth = &proxy.Auth{User: u.Username()}
+			auth.Password, _ = u.Password()
+		}
+		pd, err := proxy.SOCKS5(network, proxyURL.Host, auth, &d.Dialer)
+		if err != nil {
+			return nil, err
+		}
+		if cd, ok := pd.(interface {
+			DialContext(context.Context, string, string) (net.Conn, error)
+		}); ok {
+			return cd.DialContext(ctx, network, addr)
+		}
+		return pd.Dial(network, addr)
+	case "http":
+		return d.dialConnect(ctx, network, proxyURL, addr)
+	}
+	return nil, fmt.Errorf("dial: unsupported proxy scheme %q", proxyURL.Scheme)
+}
+
+// dialConnect connects to addr by sending an HTTP CONNECT request to the
+// proxy.
+func (d *Dialer) dialConnect(ctx context.Context, network string, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Abort the request if the context is canceled while we're waiting on the
+	// proxy.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			/* #nosec */
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	err = req.Write(conn)
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	/* #nosec */
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		/* #nosec */
+		conn.Close()
+		return nil, fmt.Errorf("dial: proxy returned unexpected status %q", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn that first reads any data that was buffered while
+// reading the proxy response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// dialTLSProxy connects to addr through the configured proxy and then performs
+// a TLS handshake.
+func (d *Dialer) dialTLSProxy(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	conn, err := d.dialTarget(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			/* #nosec */
+			conn.Close()
+			return nil, err
+		}
+	}
+	if cfg.ServerName == "" {
+		// Mimic the behavior of tls.Dial which sets the server name from the
+		// address if it was not configured.
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			/* #nosec */
+			conn.Close()
+			return nil, err
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	err = tlsConn.Handshake()
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return nil, err
+	}
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -7,8 +7,22 @@
 // Package disco implements service discovery.
 package disco // import "mellium.im/xmpp/disco"
 
+import (
+	"mellium.im/xmpp/disco/info"
+)
+
 // Namespaces used by this package.
 const (
-	NSInfo  = `http://jabber.org/protocol/disco#info`
+	NSInfo  = info.NS
 	NSItems = `http://jabber.org/protocol/disco#items`
 )
+
+// FeatureIter is the interface implemented by types that advertise features
+// that should be returned in responses to disco#info queries.
+// For more information see info.FeatureIter.
+type FeatureIter = info.FeatureIter
+
+// FormIter is the interface implemented by types that advertise extended
+// information forms in response to disco#info queries.
+// For more information see info.FormIter.
+type FormIter = info.FormIter
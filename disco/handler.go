@@ -0,0 +1,176 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package disco
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Registry is a collection of identities and features that are advertised in
+// response to disco#info queries.
+//
+// Identities, features, and forms in the Identity, Feature, and Forms fields
+// are only advertised for queries without a node.
+// Any FeatureIters in Handlers are queried for all nodes, and if they also
+// implement FormIter they are queried for forms as well.
+type Registry struct {
+	Identity []Identity
+	Feature  []Feature
+	// Forms are result forms containing extended information about the entity
+	// as defined in XEP-0128: Service Discovery Extensions.
+	Forms    []*form.Data
+	Handlers []FeatureIter
+}
+
+// ForFeatures implements FeatureIter.
+func (r Registry) ForFeatures(node string, f func(Feature) error) error {
+	if node == "" {
+		for _, feature := range r.Feature {
+			if err := f(feature); err != nil {
+				return err
+			}
+		}
+	}
+	for _, h := range r.Handlers {
+		if err := h.ForFeatures(node, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForForms implements FormIter.
+func (r Registry) ForForms(node string, f func(*form.Data) error) error {
+	if node == "" {
+		for _, data := range r.Forms {
+			if err := f(data); err != nil {
+				return err
+			}
+		}
+	}
+	for _, h := range r.Handlers {
+		iter, ok := h.(FormIter)
+		if !ok {
+			continue
+		}
+		if err := iter.ForForms(node, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Info returns the identities, features, and forms in the registry for the
+// given node.
+// If the node is empty, the disco#info feature is always included.
+// Only the first form with any given FORM_TYPE is included.
+func (r Registry) Info(node string) (Info, error) {
+	info := Info{
+		InfoQuery: InfoQuery{Node: node},
+	}
+	seen := make(map[string]struct{})
+	if node == "" {
+		info.Identity = append(info.Identity, r.Identity...)
+		seen[NSInfo] = struct{}{}
+		info.Features = append(info.Features, Feature{Var: NSInfo})
+	}
+	err := r.ForFeatures(node, func(f Feature) error {
+		if _, ok := seen[f.Var]; ok {
+			return nil
+		}
+		seen[f.Var] = struct{}{}
+		info.Features = append(info.Features, f)
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+	seenForms := make(map[string]struct{})
+	err = r.ForForms(node, func(data *form.Data) error {
+		typ := FormType(data)
+		if _, ok := seenForms[typ]; ok && typ != "" {
+			return nil
+		}
+		seenForms[typ] = struct{}{}
+		info.Forms = append(info.Forms, data)
+		return nil
+	})
+	return info, err
+}
+
+// Handle returns an option that registers a Handler for disco#info queries.
+//
+// Unless h.IgnoreMux is set, the mux the option is applied to is added to the
+// Client and Account registries so that the namespaces of all handlers
+// registered on it are advertised automatically.
+// For more information see mux.ServeMux.ForFeatures.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		if !h.IgnoreMux {
+			h.Client.Handlers = appendIter(h.Client.Handlers, m)
+			h.Account.Handlers = appendIter(h.Account.Handlers, m)
+		}
+		mux.IQ(stanza.GetIQ, xml.Name{Local: "query", Space: NSInfo}, h)(m)
+	}
+}
+
+// appendIter is like append except that it never modifies the backing array of
+// the original slice, which may be shared with the caller.
+func appendIter(iters []FeatureIter, iter FeatureIter) []FeatureIter {
+	out := make([]FeatureIter, 0, len(iters)+1)
+	out = append(out, iters...)
+	return append(out, iter)
+}
+
+// Handler responds to disco#info queries.
+//
+// Queries addressed to a full JID are answered using the Client registry, which
+// should contain the features of the connected client or resource.
+// Queries addressed to a bare JID, or that do not have a "to" attribute, are
+// answered using the Account registry, which should contain the features of
+// the account (when acting as a server) or the service (when acting as a
+// component).
+// If the requested node does not have any identities or features, an
+// item-not-found error is returned.
+//
+// When registered using Handle, the features of other handlers registered on
+// the same mux are also advertised unless IgnoreMux is set.
+type Handler struct {
+	Client    Registry
+	Account   Registry
+	IgnoreMux bool
+}
+
+// HandleIQ responds to disco#info queries.
+func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if iq.Type != stanza.GetIQ || start.Name.Local != "query" || start.Name.Space != NSInfo {
+		return nil
+	}
+
+	reg := h.Account
+	if iq.To.Resourcepart() != "" {
+		reg = h.Client
+	}
+	_, node := attr.Get(start.Attr, "node")
+	info, err := reg.Info(node)
+	if err != nil {
+		return err
+	}
+	if len(info.Identity) == 0 && len(info.Features) == 0 {
+		_, err = xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ItemNotFound,
+		}))
+		return err
+	}
+	_, err = xmlstream.Copy(t, iq.Result(info.TokenReader()))
+	return err
+}
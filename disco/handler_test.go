@@ -0,0 +1,213 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package disco_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ mux.IQHandler     = disco.Handler{}
+	_ disco.FeatureIter = disco.Registry{}
+	_ disco.FeatureIter = (*mux.ServeMux)(nil)
+	_ disco.FormIter    = disco.Registry{}
+	_ disco.FormIter    = (*mux.ServeMux)(nil)
+)
+
+type formHandler struct {
+	mux.IQHandlerFunc
+	forms []*form.Data
+}
+
+func (h formHandler) ForForms(node string, f func(*form.Data) error) error {
+	for _, data := range h.forms {
+		if err := f(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var handlerTestCases = [...]struct {
+	to       string
+	node     string
+	features []string
+	forms    int
+	err      error
+}{
+	0: {
+		to:       "test@example.net/res",
+		features: []string{disco.NSInfo, "urn:example:client"},
+	},
+	1: {
+		to:       "test@example.net",
+		features: []string{disco.NSInfo, "urn:example:account"},
+		forms:    1,
+	},
+	2: {
+		features: []string{disco.NSInfo, "urn:example:account"},
+		forms:    1,
+	},
+	3: {
+		to:   "test@example.net",
+		node: "unknown",
+		err:  stanza.Error{Condition: stanza.ItemNotFound},
+	},
+}
+
+func TestHandler(t *testing.T) {
+	h := disco.Handler{
+		Client: disco.Registry{
+			Identity: []disco.Identity{{Category: "client", Type: "pc"}},
+			Feature:  []disco.Feature{{Var: "urn:example:client"}},
+		},
+		Account: disco.Registry{
+			Identity: []disco.Identity{{Category: "account", Type: "registered"}},
+			Feature:  []disco.Feature{{Var: "urn:example:account"}},
+			Forms: []*form.Data{form.New(
+				form.Result,
+				form.Hidden("FORM_TYPE", form.Value("urn:example:form")),
+			)},
+		},
+	}
+	for i, tc := range handlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandler(mux.New(disco.Handle(h))),
+			)
+			var to jid.JID
+			if tc.to != "" {
+				to = jid.MustParse(tc.to)
+			}
+			info, err := disco.GetInfo(context.Background(), tc.node, to, cs.Client)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if len(info.Features) != len(tc.features) {
+				t.Fatalf("wrong number of features: want=%d, got=%d", len(tc.features), len(info.Features))
+			}
+			for i, f := range tc.features {
+				if v := info.Features[i].Var; v != f {
+					t.Errorf("wrong feature at %d: want=%s, got=%s", i, f, v)
+				}
+			}
+			if tc.err == nil && len(info.Identity) != 1 {
+				t.Errorf("wrong number of identities: want=1, got=%d", len(info.Identity))
+			}
+			if len(info.Forms) != tc.forms {
+				t.Fatalf("wrong number of forms: want=%d, got=%d", tc.forms, len(info.Forms))
+			}
+			if tc.forms > 0 {
+				if typ, _ := info.Forms[0].GetString("FORM_TYPE"); typ != "urn:example:form" {
+					t.Errorf("wrong form type: want=urn:example:form, got=%s", typ)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerMuxFeatures(t *testing.T) {
+	nop := mux.IQHandlerFunc(func(stanza.IQ, xmlstream.TokenReadEncoder, *xml.StartElement) error {
+		return nil
+	})
+	for i, tc := range [...]struct {
+		h        disco.Handler
+		opts     []mux.Option
+		features []string
+	}{
+		0: {
+			opts: []mux.Option{
+				mux.IQ(stanza.GetIQ, xml.Name{Space: "urn:example:b"}, nop),
+				mux.IQ(stanza.SetIQ, xml.Name{Space: "urn:example:a"}, nop),
+				mux.IQ(stanza.ResultIQ, xml.Name{Space: "urn:example:result"}, nop),
+				mux.IQ(stanza.GetIQ, xml.Name{}, nop),
+			},
+			features: []string{disco.NSInfo, "urn:example:a", "urn:example:b"},
+		},
+		1: {
+			h: disco.Handler{IgnoreMux: true},
+			opts: []mux.Option{
+				mux.IQ(stanza.GetIQ, xml.Name{Space: "urn:example:b"}, nop),
+			},
+			features: []string{disco.NSInfo},
+		},
+		2: {
+			opts: []mux.Option{
+				mux.IQ(stanza.GetIQ, xml.Name{Space: "urn:example:b"}, nop),
+				mux.IQ(stanza.GetIQ, xml.Name{Space: "urn:example:a"}, nop),
+				mux.HideFeatures("urn:example:b"),
+			},
+			features: []string{disco.NSInfo, "urn:example:a"},
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandler(mux.New(append(tc.opts, disco.Handle(tc.h))...)),
+			)
+			info, err := disco.GetInfo(context.Background(), "", jid.JID{}, cs.Client)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var features []string
+			for _, f := range info.Features {
+				features = append(features, f.Var)
+			}
+			if !reflect.DeepEqual(features, tc.features) {
+				t.Errorf("wrong features: want=%v, got=%v", tc.features, features)
+			}
+		})
+	}
+}
+
+func TestHandlerMuxForms(t *testing.T) {
+	newForm := func(typ string) *form.Data {
+		return form.New(
+			form.Result,
+			form.Hidden("FORM_TYPE", form.Value(typ)),
+		)
+	}
+	h := formHandler{
+		IQHandlerFunc: func(stanza.IQ, xmlstream.TokenReadEncoder, *xml.StartElement) error {
+			return nil
+		},
+		forms: []*form.Data{newForm("urn:example:b"), newForm("urn:example:a")},
+	}
+	m := mux.New(
+		mux.IQ(stanza.GetIQ, xml.Name{Space: "urn:example:b"}, h),
+		disco.Handle(disco.Handler{
+			Account: disco.Registry{
+				Forms: []*form.Data{newForm("urn:example:a")},
+			},
+		}),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+	info, err := disco.GetInfo(context.Background(), "", jid.JID{}, cs.Client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var types []string
+	for _, data := range info.Forms {
+		types = append(types, disco.FormType(data))
+	}
+	if want := []string{"urn:example:a", "urn:example:b"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("wrong forms: want=%v, got=%v", want, types)
+	}
+	if _, ok := info.Form("urn:example:b"); !ok {
+		t.Errorf("expected to find form contributed by mux handler")
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco/info"
 	"mellium.im/xmpp/form"
 	"mellium.im/xmpp/internal/ns"
 	"mellium.im/xmpp/jid"
@@ -53,7 +54,7 @@ type Identity struct {
 // TokenReader implements xmlstream.Marshaler.
 func (i Identity) TokenReader() xml.TokenReader {
 	start := xml.StartElement{
-		Name: xml.Name{Space: NSInfo, Local: "query"},
+		Name: xml.Name{Space: NSInfo, Local: "identity"},
 		Attr: []xml.Attr{{
 			Name:  xml.Name{Local: "category"},
 			Value: i.Category,
@@ -81,33 +82,36 @@ func (i Identity) WriteXML(w xmlstream.TokenWriter) (int, error) {
 }
 
 // Feature represents a feature supported by an entity on the network.
-type Feature struct {
-	XMLName xml.Name `xml:"http://jabber.org/protocol/disco#info feature"`
-	Var     string   `xml:"var,attr"`
-}
+type Feature = info.Feature
 
-// TokenReader implements xmlstream.Marshaler.
-func (f Feature) TokenReader() xml.TokenReader {
-	return xmlstream.Wrap(nil, xml.StartElement{
-		Name: xml.Name{Space: NSInfo, Local: "feature"},
-		Attr: []xml.Attr{{
-			Name:  xml.Name{Local: "var"},
-			Value: f.Var,
-		}},
-	})
+// Info is a response to a disco info query.
+type Info struct {
+	InfoQuery
+	Identity []Identity   `xml:"identity"`
+	Features []Feature    `xml:"feature"`
+	Forms    []*form.Data `xml:"jabber:x:data x"`
 }
 
-// WriteXML implements xmlstream.WriterTo.
-func (f Feature) WriteXML(w xmlstream.TokenWriter) (int, error) {
-	return xmlstream.Copy(w, f.TokenReader())
+// Form returns the first extended information form with the provided
+// FORM_TYPE.
+// If no such form exists, ok is false.
+func (i Info) Form(formType string) (data *form.Data, ok bool) {
+	for _, data := range i.Forms {
+		if FormType(data) == formType {
+			return data, true
+		}
+	}
+	return nil, false
 }
 
-// Info is a response to a disco info query.
-type Info struct {
-	InfoQuery
-	Identity []Identity `xml:"identity"`
-	Features []Feature  `xml:"feature"`
-	Form     *form.Data `xml:"jabber:x:data x,omitempty"`
+// FormType returns the value of the hidden FORM_TYPE field of an extended
+// information form, or the empty string if it does not have one.
+func FormType(data *form.Data) string {
+	if data == nil {
+		return ""
+	}
+	typ, _ := data.GetString("FORM_TYPE")
+	return typ
 }
 
 // TokenReader implements xmlstream.Marshaler.
@@ -119,6 +123,9 @@ func (i Info) TokenReader() xml.TokenReader {
 	for _, ident := range i.Identity {
 		payloads = append(payloads, ident.TokenReader())
 	}
+	for _, f := range i.Forms {
+		payloads = append(payloads, f.TokenReader())
+	}
 	return i.InfoQuery.wrap(xmlstream.MultiReader(payloads...))
 }
 
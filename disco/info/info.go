@@ -0,0 +1,65 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package info contains types for advertising features in service discovery.
+//
+// Most users will want to use the aliases for these types in the disco package
+// instead.
+// This package exists so that packages imported by disco (such as mux) can
+// advertise the features they support without creating an import cycle.
+package info // import "mellium.im/xmpp/disco/info"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+)
+
+// NS is the namespace used by disco#info queries.
+const NS = `http://jabber.org/protocol/disco#info`
+
+// Feature represents a feature supported by an entity on the network.
+type Feature struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/disco#info feature"`
+	Var     string   `xml:"var,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (f Feature) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "feature"},
+		Attr: []xml.Attr{{
+			Name:  xml.Name{Local: "var"},
+			Value: f.Var,
+		}},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (f Feature) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, f.TokenReader())
+}
+
+// FeatureIter is the interface implemented by types that advertise features
+// that should be returned in responses to disco#info queries.
+// Handlers for various extensions implement FeatureIter so that the features
+// they handle can be advertised automatically.
+//
+// ForFeatures should call f once for each feature supported on the given node.
+// If f returns an error, ForFeatures should stop iterating and return the
+// error.
+type FeatureIter interface {
+	ForFeatures(node string, f func(Feature) error) error
+}
+
+// FormIter is the interface implemented by types that advertise extended
+// information forms that should be returned in responses to disco#info queries
+// as defined in XEP-0128: Service Discovery Extensions.
+//
+// ForForms should call f once for each form associated with the given node.
+// If f returns an error, ForForms should stop iterating and return the error.
+type FormIter interface {
+	ForForms(node string, f func(*form.Data) error) error
+}
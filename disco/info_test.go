@@ -91,14 +91,20 @@ func TestUnmarshal(t *testing.T) {
 	if v := info.Features[0].Var; v != disco.NSInfo {
 		t.Errorf("wrong first feature: want=%s, got=%s", disco.NSInfo, v)
 	}
-	if info.Form == nil {
-		t.Errorf("form was not unmarshaled")
+	if len(info.Forms) != 1 {
+		t.Fatalf("wrong number of forms: want=1, got=%d", len(info.Forms))
 	}
 	const serverInfo = "http://jabber.org/network/serverinfo"
-	if s, ok := info.Form.GetString("FORM_TYPE"); !ok || s != serverInfo {
+	if s, ok := info.Forms[0].GetString("FORM_TYPE"); !ok || s != serverInfo {
 		t.Errorf("wrong value for FORM_TYPE: want=%s, got=%s", serverInfo, s)
 	}
-	if s, ok := info.Form.GetString("c2s_port"); !ok || s != "5222" {
+	if s, ok := info.Forms[0].GetString("c2s_port"); !ok || s != "5222" {
 		t.Errorf("wrong value for FORM_TYPE: want=5222, got=%s", s)
 	}
+	if data, ok := info.Form(serverInfo); !ok || data != info.Forms[0] {
+		t.Errorf("expected form lookup by FORM_TYPE to return the first form")
+	}
+	if _, ok := info.Form("urn:example:missing"); ok {
+		t.Errorf("expected lookup of unknown FORM_TYPE to fail")
+	}
 }
@@ -13,9 +13,9 @@ import (
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/disco"
-	"mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
 )
 
 var testGetItems = [...]struct {
@@ -0,0 +1,177 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+	"errors"
+	"sync"
+
+	"mellium.im/xmpp/stream"
+)
+
+// EventType is the kind of lifecycle event that occurred.
+type EventType uint8
+
+// A list of event types.
+const (
+	// NegotiatedEvent is published once when negotiation of the session is
+	// complete and stanzas may be sent and received.
+	NegotiatedEvent EventType = iota
+
+	// StreamRestartEvent is published when the streams are restarted during
+	// negotiation, for example after StartTLS or SASL.
+	StreamRestartEvent
+
+	// OutputClosedEvent is published when the output stream is closed.
+	OutputClosedEvent
+
+	// InputClosedEvent is published when the input stream is closed.
+	// Err is the error that caused the input stream to be closed, if any.
+	InputClosedEvent
+
+	// StreamErrorEvent is published when a stream error is received from the
+	// remote entity after the session has been established.
+	// Err is the stream.Error that was received.
+	StreamErrorEvent
+
+	// FeaturesEvent is published each time a list of stream features is
+	// received during negotiation, including the list that is advertised
+	// after the streams are restarted following authentication.
+	// Features contains the advertised features and State is the state of the
+	// session when they were received.
+	FeaturesEvent
+
+	// ReconnectEvent is never published by a Session since each connection
+	// results in a new session.
+	// Applications that reconnect may publish it on a shared Events value to
+	// notify subscribers of each reconnect attempt.
+	ReconnectEvent
+)
+
+// Event is a notification of a change in the lifecycle of a session.
+type Event struct {
+	Type EventType
+
+	// Session is the session that published the event, or nil if the event was
+	// published by the application.
+	Session *Session
+
+	// Err is set for events that are caused by an error.
+	Err error
+
+	// State and Features are only set for FeaturesEvent.
+	// Features maps the namespace of each advertised feature to its
+	// canonical representation (see the Features method on Session).
+	State    SessionState
+	Features map[string]interface{}
+}
+
+// Events distributes lifecycle events to subscribers.
+// Subscribers are called one at a time on a separate goroutine in the order
+// that events were published so that they may safely use the session (for
+// example to check its State) without deadlocking.
+//
+// A single Events may be shared between many sessions using the Events option
+// on StreamConfig, which lets applications keep the same subscribers across
+// reconnects.
+// The zero value is ready to use, but it must not be copied after first use.
+type Events struct {
+	mu      sync.Mutex
+	subs    []subscriber
+	nextSub int
+	queue   []Event
+	running bool
+}
+
+type subscriber struct {
+	id int
+	f  func(Event)
+}
+
+// Subscribe registers f to be called with every event that is published after
+// Subscribe returns.
+// Calling the returned function removes the subscription.
+func (e *Events) Subscribe(f func(Event)) (unsubscribe func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	id := e.nextSub
+	e.nextSub++
+	e.subs = append(e.subs, subscriber{id: id, f: f})
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for i, sub := range e.subs {
+			if sub.id == id {
+				e.subs = append(e.subs[:i:i], e.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish queues an event to be delivered to all subscribers.
+// It never blocks waiting for subscribers.
+func (e *Events) Publish(ev Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.subs) == 0 {
+		return
+	}
+	e.queue = append(e.queue, ev)
+	if !e.running {
+		e.running = true
+		go e.deliver()
+	}
+}
+
+func (e *Events) deliver() {
+	for {
+		e.mu.Lock()
+		if len(e.queue) == 0 {
+			e.running = false
+			e.mu.Unlock()
+			return
+		}
+		ev := e.queue[0]
+		e.queue = e.queue[1:]
+		subs := e.subs
+		e.mu.Unlock()
+
+		for _, sub := range subs {
+			sub.f(ev)
+		}
+	}
+}
+
+// Subscribe registers f to be called with lifecycle events of the session.
+// It is equivalent to calling Subscribe on the Events configured for the
+// session, so if they are shared with other sessions f receives their events
+// as well.
+// Events that occur during negotiation can only be received by subscribing
+// before the session is created using the Events option on StreamConfig.
+func (s *Session) Subscribe(f func(Event)) (unsubscribe func()) {
+	return s.events.Subscribe(f)
+}
+
+func (s *Session) publish(typ EventType, err error) {
+	s.events.Publish(Event{Type: typ, Session: s, Err: err})
+}
+
+// streamErrReader publishes stream errors read from the wrapped reader.
+type streamErrReader struct {
+	r xml.TokenReader
+	s *Session
+}
+
+func (r streamErrReader) Token() (xml.Token, error) {
+	tok, err := r.r.Token()
+	var se stream.Error
+	if err != nil && errors.As(err, &se) {
+		r.s.in.gotStreamErr = true
+		r.s.publish(StreamErrorEvent, err)
+	}
+	return tok, err
+}
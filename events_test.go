@@ -0,0 +1,111 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stream"
+)
+
+func nextEvent(t *testing.T, c <-chan xmpp.Event) xmpp.Event {
+	t.Helper()
+	select {
+	case ev := <-c:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return xmpp.Event{}
+}
+
+func TestEventsPublish(t *testing.T) {
+	events := &xmpp.Events{}
+	c := make(chan xmpp.Event, 10)
+	unsubscribe := events.Subscribe(func(ev xmpp.Event) {
+		c <- ev
+	})
+	for _, typ := range []xmpp.EventType{xmpp.ReconnectEvent, xmpp.NegotiatedEvent} {
+		events.Publish(xmpp.Event{Type: typ})
+	}
+	if ev := nextEvent(t, c); ev.Type != xmpp.ReconnectEvent {
+		t.Errorf("wrong first event: want=%v, got=%v", xmpp.ReconnectEvent, ev.Type)
+	}
+	if ev := nextEvent(t, c); ev.Type != xmpp.NegotiatedEvent {
+		t.Errorf("wrong second event: want=%v, got=%v", xmpp.NegotiatedEvent, ev.Type)
+	}
+
+	unsubscribe()
+	events.Publish(xmpp.Event{Type: xmpp.ReconnectEvent})
+	select {
+	case ev := <-c:
+		t.Errorf("did not expect event after unsubscribing, got=%v", ev.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	events := &xmpp.Events{}
+	c := make(chan xmpp.Event, 10)
+	events.Subscribe(func(ev xmpp.Event) {
+		c <- ev
+	})
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:client'><stream:features><ready xmlns='urn:example'/></stream:features><stream:error><not-authorized xmlns='urn:ietf:params:xml:ns:xmpp-streams'/></stream:error>`),
+		Writer: &bytes.Buffer{},
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.MustParse("example.net"), jid.MustParse("me@example.net"), rw, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		Events: events,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	ev := nextEvent(t, c)
+	if ev.Type != xmpp.FeaturesEvent {
+		t.Errorf("wrong event after features: want=%v, got=%v", xmpp.FeaturesEvent, ev.Type)
+	}
+	if _, ok := ev.Features["urn:example"]; !ok || len(ev.Features) != 1 {
+		t.Errorf("wrong features in event: %v", ev.Features)
+	}
+	if f := s.Features(); len(f) != 1 {
+		t.Errorf("wrong features on session: %v", f)
+	}
+	if ev := nextEvent(t, c); ev.Type != xmpp.NegotiatedEvent || ev.Session != s {
+		t.Errorf("wrong event after negotiation: want=%v, got=%v", xmpp.NegotiatedEvent, ev.Type)
+	}
+
+	err = s.Serve(nil)
+	if !errors.Is(err, stream.NotAuthorized) {
+		t.Errorf("unexpected error from Serve: %v", err)
+	}
+
+	seen := make(map[xmpp.EventType]error)
+	for i := 0; i < 3; i++ {
+		ev := nextEvent(t, c)
+		seen[ev.Type] = ev.Err
+	}
+	for _, typ := range []xmpp.EventType{xmpp.StreamErrorEvent, xmpp.OutputClosedEvent, xmpp.InputClosedEvent} {
+		if _, ok := seen[typ]; !ok {
+			t.Errorf("expected event %v, got: %v", typ, seen)
+		}
+	}
+	if err := seen[xmpp.StreamErrorEvent]; !errors.Is(err, stream.NotAuthorized) {
+		t.Errorf("wrong error for stream error event: want=%v, got=%v", stream.NotAuthorized, err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+
+	"mellium.im/sasl"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/dial"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+// presence is a presence stanza with the common show and status children.
+type presence struct {
+	stanza.Presence
+	Show   string `xml:"show"`
+	Status string `xml:"status"`
+}
+
+func track(ctx context.Context, addr, pass string, t *tracker, xmlIn, xmlOut io.Writer, logger, debug *log.Logger) error {
+	j, err := jid.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("Error parsing address %q: %w", addr, err)
+	}
+
+	conn, err := dial.Client(ctx, "tcp", j)
+	if err != nil {
+		return fmt.Errorf("Error dialing sesion: %w", err)
+	}
+
+	s, err := xmpp.NewSession(ctx, j.Domain(), j, conn, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Lang: "en",
+		Features: func(_ *xmpp.Session, f ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			if f != nil {
+				return f
+			}
+			return []xmpp.StreamFeature{
+				xmpp.BindResource(),
+				xmpp.StartTLS(&tls.Config{
+					ServerName: j.Domain().String(),
+				}),
+				xmpp.SASL("", pass, sasl.ScramSha1Plus, sasl.ScramSha1, sasl.Plain),
+			}
+		},
+		TeeIn:  xmlIn,
+		TeeOut: xmlOut,
+	}))
+	if err != nil {
+		return fmt.Errorf("Error establishing a session: %w", err)
+	}
+	defer func() {
+		logger.Println("Closing conn…")
+		if err := s.Conn().Close(); err != nil {
+			logger.Printf("Error closing connection: %q", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		logger.Println("Closing session…")
+		if err := s.Close(); err != nil {
+			logger.Printf("Error closing session: %q", err)
+		}
+	}()
+
+	// Roster pushes are handled by the multiplexer, but we handle presence
+	// ourselves so that we see every presence exactly once regardless of its
+	// children.
+	m := mux.New(roster.Handle(roster.Handler{
+		Push: func(item roster.Item) error {
+			debug.Printf("Roster push for %s", item.JID)
+			t.SetItem(item)
+			return nil
+		},
+	}))
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(xmpp.HandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			if start.Name.Local != "presence" {
+				return m.HandleXMPP(r, start)
+			}
+
+			p := presence{}
+			err := xml.NewTokenDecoder(r).DecodeElement(&p, start)
+			if err != nil && err != io.EOF {
+				logger.Printf("Error decoding presence: %q", err)
+				return nil
+			}
+			debug.Printf("Presence of type %q from %s", p.Type, p.From)
+			t.SetPresence(p.From, p.Type, Resource{
+				Show:   p.Show,
+				Status: p.Status,
+			})
+			return nil
+		}))
+	}()
+
+	// Fetch the roster before sending initial presence so that we know who to
+	// track when the server starts sending us our contacts presence.
+	iter := roster.Fetch(ctx, s)
+	for iter.Next() {
+		t.SetItem(iter.Item())
+	}
+	err = iter.Err()
+	if e := iter.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return fmt.Errorf("Error fetching roster: %w", err)
+	}
+
+	err = s.Send(ctx, stanza.Presence{Type: stanza.AvailablePresence}.Wrap(nil))
+	if err != nil {
+		return fmt.Errorf("Error sending initial presence: %w", err)
+	}
+
+	return <-serveErr
+}
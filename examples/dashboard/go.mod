@@ -0,0 +1,14 @@
+module mellium.im/xmpp/examples/dashboard
+
+go 1.16
+
+require (
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897 // indirect
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b // indirect
+	golang.org/x/text v0.3.4 // indirect
+	mellium.im/sasl v0.2.1
+	mellium.im/xmlstream v0.15.3-0.20210221202126-7cc1407dad4c
+	mellium.im/xmpp v0.16.0
+)
+
+replace mellium.im/xmpp => ../../
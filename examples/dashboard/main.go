@@ -0,0 +1,130 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// The dashboard command logs in to an XMPP account, tracks the presence of
+// everyone on the roster, and serves a small web page that is updated live as
+// contacts come and go.
+//
+// For more information try running:
+//
+//     dashboard -help
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+)
+
+/* #nosec */
+const (
+	envAddr = "XMPP_ADDR"
+	envPass = "XMPP_PASS"
+)
+
+type logWriter struct {
+	logger *log.Logger
+}
+
+func (lw logWriter) Write(p []byte) (int, error) {
+	lw.logger.Printf("%s", p)
+	return len(p), nil
+}
+
+func main() {
+	// Setup logging and verbose logging that's disabled by default.
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	debug := log.New(ioutil.Discard, "DEBUG ", log.LstdFlags)
+
+	// Configure behavior based on flags and environment variables.
+	var (
+		addr     = os.Getenv(envAddr)
+		httpAddr = "localhost:8080"
+		verbose  bool
+		logXML   bool
+	)
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s:\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\n  $%s: The JID to log in as\n  $%s: The password\n\n", envAddr, envPass)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&httpAddr, "http", httpAddr, "the address on which to serve the dashboard")
+	flags.BoolVar(&verbose, "v", verbose, "turns on verbose debug logging")
+	flags.BoolVar(&logXML, "vv", logXML, "turns on verbose debug and XML logging")
+
+	switch err := flags.Parse(os.Args[1:]); err {
+	case flag.ErrHelp:
+		return
+	case nil:
+	default:
+		logger.Fatal(err)
+	}
+
+	// Return a sane error if the address is empty instead of erroring out when we
+	// try to parse it.
+	if addr == "" {
+		logger.Fatalf("Address not specified, set $%s", envAddr)
+	}
+
+	// Enable verbose logging if the flag was set.
+	if verbose || logXML {
+		debug.SetOutput(os.Stderr)
+	}
+
+	// Enable XML logging if the flag was set.
+	var xmlIn, xmlOut io.Writer
+	if logXML {
+		xmlIn = logWriter{log.New(os.Stdout, "IN ", log.LstdFlags)}
+		xmlOut = logWriter{log.New(os.Stdout, "OUT ", log.LstdFlags)}
+	}
+
+	pass := os.Getenv(envPass)
+	if pass == "" {
+		debug.Printf("The environment variable $%s is empty", envPass)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle SIGINT and gracefully shut down.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c:
+			cancel()
+		}
+	}()
+
+	t := newTracker()
+	srv := &http.Server{
+		Addr:    httpAddr,
+		Handler: t,
+	}
+	go func() {
+		<-ctx.Done()
+		/* #nosec */
+		srv.Close()
+	}()
+	go func() {
+		logger.Printf("Serving dashboard on http://%s/", httpAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Printf("Error serving dashboard: %q", err)
+			cancel()
+		}
+	}()
+
+	if err := track(ctx, addr, pass, t, xmlIn, xmlOut, logger, debug); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+// Contact is the current state of a roster item and all of its resources.
+type Contact struct {
+	JID       string              `json:"jid"`
+	Name      string              `json:"name"`
+	Resources map[string]Resource `json:"resources"`
+}
+
+// Resource is the last presence received from a single resource.
+type Resource struct {
+	Show   string `json:"show,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// tracker keeps track of the presence of roster items and notifies any
+// listening web clients of changes.
+type tracker struct {
+	mu       sync.Mutex
+	contacts map[string]*Contact
+	subs     map[chan []byte]struct{}
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		contacts: make(map[string]*Contact),
+		subs:     make(map[chan []byte]struct{}),
+	}
+}
+
+// SetItem adds or updates a roster item.
+func (t *tracker) SetItem(item roster.Item) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := item.JID.Bare().String()
+	c, ok := t.contacts[key]
+	if !ok {
+		c = &Contact{
+			JID:       key,
+			Resources: make(map[string]Resource),
+		}
+		t.contacts[key] = c
+	}
+	c.Name = item.Name
+	t.notify(c)
+}
+
+// SetPresence records a presence from the given address.
+func (t *tracker) SetPresence(from jid.JID, typ stanza.PresenceType, r Resource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.contacts[from.Bare().String()]
+	if !ok {
+		// Ignore presence from anyone who isn't on our roster.
+		return
+	}
+	switch typ {
+	case stanza.AvailablePresence:
+		c.Resources[from.Resourcepart()] = r
+	case stanza.UnavailablePresence, stanza.ErrorPresence:
+		delete(c.Resources, from.Resourcepart())
+	default:
+		return
+	}
+	t.notify(c)
+}
+
+// notify sends the contact to all subscribers.
+// It must be called with the lock held.
+func (t *tracker) notify(c *Contact) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	for sub := range t.subs {
+		// Drop updates for slow clients instead of blocking the XMPP session.
+		select {
+		case sub <- b:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel that receives updates to contacts and a snapshot
+// of all contacts at the time the subscription was created.
+func (t *tracker) subscribe() (chan []byte, [][]byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sub := make(chan []byte, 16)
+	t.subs[sub] = struct{}{}
+
+	keys := make([]string, 0, len(t.contacts))
+	for k := range t.contacts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	snapshot := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		b, err := json.Marshal(t.contacts[k])
+		if err != nil {
+			continue
+		}
+		snapshot = append(snapshot, b)
+	}
+	return sub, snapshot
+}
+
+func (t *tracker) unsubscribe(sub chan []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs, sub)
+}
+
+// ServeHTTP serves the dashboard page and a stream of server-sent events
+// containing updates.
+func (t *tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	case "/events":
+		t.serveEvents(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (t *tracker) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	sub, snapshot := t.subscribe()
+	defer t.unsubscribe(sub)
+
+	for _, b := range snapshot {
+		fmt.Fprintf(w, "data: %s\n\n", b)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case b := <-sub:
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		}
+	}
+}
+
+const page = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Presence Dashboard</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.25em 1em; text-align: left; border-bottom: 1px solid #ccc; }
+.online { color: green; }
+.offline { color: gray; }
+</style>
+</head>
+<body>
+<h1>Presence Dashboard</h1>
+<table>
+<thead><tr><th>Contact</th><th>State</th><th>Status</th></tr></thead>
+<tbody id="contacts"></tbody>
+</table>
+<script>
+const rows = {};
+const tbody = document.getElementById("contacts");
+const events = new EventSource("/events");
+events.onmessage = function(e) {
+	const c = JSON.parse(e.data);
+	let row = rows[c.jid];
+	if (!row) {
+		row = tbody.insertRow();
+		row.insertCell();
+		row.insertCell();
+		row.insertCell();
+		rows[c.jid] = row;
+	}
+	const resources = Object.values(c.resources || {});
+	const online = resources.length > 0;
+	row.cells[0].textContent = c.name ? c.name + " <" + c.jid + ">" : c.jid;
+	row.cells[1].textContent = online ? (resources[0].show || "available") : "offline";
+	row.cells[1].className = online ? "online" : "offline";
+	row.cells[2].textContent = online ? (resources[0].status || "") : "";
+};
+</script>
+</body>
+</html>
+`
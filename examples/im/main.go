@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"mellium.im/sasl"
+	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/dial"
 	"mellium.im/xmpp/jid"
@@ -44,13 +45,12 @@ func (w logWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-// messageBody is a message stanza that contains a body. It is normally used for
-// chat messages.
-type messageBody struct {
-	stanza.Message
-	Subject string `xml:"subject,omitempty"`
-	Thread  string `xml:"thread,omitempty"`
-	Body    string `xml:"body"`
+// textElement returns an element with the given name containing text.
+func textElement(name, text string) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(text)),
+		xml.StartElement{Name: xml.Name{Local: name}},
+	)
 }
 
 func main() {
@@ -281,17 +281,18 @@ func main() {
 		if msgType != "" {
 			typ = stanza.MessageType(msgType)
 		}
-		err = session.Encode(ctx, messageBody{
-			Message: stanza.Message{
-				ID:   msgID,
-				To:   parsedToAddr,
-				From: parsedAddr,
-				Type: typ,
-			},
-			Body:    msg,
-			Subject: subject,
-			Thread:  thread,
-		})
+		payload := []xml.TokenReader{textElement("body", msg)}
+		if subject != "" {
+			payload = append(payload, textElement("subject", subject))
+		}
+		if thread != "" {
+			payload = append(payload, textElement("thread", thread))
+		}
+		_, err = session.SendMessage(ctx, stanza.Message{
+			ID:   msgID,
+			To:   parsedToAddr,
+			Type: typ,
+		}, xmlstream.MultiReader(payload...))
 		if err != nil {
 			logger.Fatalf("error sending message: %v", err)
 		}
@@ -9,3 +9,7 @@ package xmpp
 var (
 	ErrNotStart = errNotStart
 )
+
+// NewPrettyWriter is exported so that the pretty printer used by TeePretty can
+// be tested without negotiating a session.
+var NewPrettyWriter = newPrettyWriter
@@ -0,0 +1,177 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package extdisco implements XEP-0215: External Service Discovery.
+//
+// External service discovery is used to find services that are not accessible
+// over XMPP, such as the STUN and TURN servers used to set up audio and video
+// calls, along with any short lived credentials needed to access them.
+package extdisco // import "mellium.im/xmpp/extdisco"
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:extdisco:2"
+
+// Service is an external service and the credentials needed to use it, if
+// any.
+type Service struct {
+	// Type is the kind of service, for example "stun" or "turn".
+	Type string
+	// Host is the hostname or IP address of the service.
+	Host string
+	// Port is the port of the service, or zero if it is not known.
+	Port uint16
+	// Transport is the underlying transport protocol, for example "udp" or
+	// "tcp".
+	Transport string
+	// Name is a human readable name for the service.
+	Name string
+	// Restricted indicates that credentials are required to use the service.
+	// If Username and Password are not set they can be requested with
+	// GetCredentials.
+	Restricted bool
+	Username   string
+	Password   string
+	// Expires is the time at which the credentials stop being valid.
+	// If it is the zero time they do not expire.
+	Expires time.Time
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s Service) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Local: "service"}}
+	add := func(name, value string) {
+		if value != "" {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+		}
+	}
+	if !s.Expires.IsZero() {
+		add("expires", s.Expires.UTC().Format(time.RFC3339))
+	}
+	add("host", s.Host)
+	add("name", s.Name)
+	add("password", s.Password)
+	if s.Port != 0 {
+		add("port", strconv.FormatUint(uint64(s.Port), 10))
+	}
+	if s.Restricted {
+		add("restricted", "true")
+	}
+	add("transport", s.Transport)
+	add("type", s.Type)
+	add("username", s.Username)
+	return xmlstream.Wrap(nil, start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s Service) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s Service) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (s *Service) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "expires":
+			t, err := time.Parse(time.RFC3339, attr.Value)
+			if err != nil {
+				return err
+			}
+			s.Expires = t
+		case "host":
+			s.Host = attr.Value
+		case "name":
+			s.Name = attr.Value
+		case "password":
+			s.Password = attr.Value
+		case "port":
+			port, err := strconv.ParseUint(attr.Value, 10, 16)
+			if err != nil {
+				return err
+			}
+			s.Port = uint16(port)
+		case "restricted":
+			s.Restricted = attr.Value == "true" || attr.Value == "1"
+		case "transport":
+			s.Transport = attr.Value
+		case "type":
+			s.Type = attr.Value
+		case "username":
+			s.Username = attr.Value
+		}
+	}
+	return d.Skip()
+}
+
+// Get requests the external services of the provided type offered by the
+// server.
+// If typ is empty, all services are requested.
+func Get(ctx context.Context, s *xmpp.Session, server jid.JID, typ string) ([]Service, error) {
+	return GetIQ(ctx, stanza.IQ{To: server}, s, typ)
+}
+
+// GetIQ is like Get but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func GetIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session, typ string) ([]Service, error) {
+	iq.Type = stanza.GetIQ
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: "services"}}
+	if typ != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: typ})
+	}
+	var resp struct {
+		XMLName  xml.Name  `xml:"urn:xmpp:extdisco:2 services"`
+		Services []Service `xml:"service"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(nil, start), iq, &resp)
+	return resp.Services, err
+}
+
+// GetCredentials requests credentials for a restricted service.
+// The Type and Host of the service are used to identify it, and Port is also
+// used if it is set.
+func GetCredentials(ctx context.Context, s *xmpp.Session, server jid.JID, service Service) (Service, error) {
+	req := Service{
+		Type: service.Type,
+		Host: service.Host,
+		Port: service.Port,
+	}
+	var resp struct {
+		XMLName  xml.Name  `xml:"urn:xmpp:extdisco:2 credentials"`
+		Services []Service `xml:"service"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		req.TokenReader(),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "credentials"}},
+	), stanza.IQ{
+		To:   server,
+		Type: stanza.GetIQ,
+	}, &resp)
+	if err != nil {
+		return Service{}, err
+	}
+	if len(resp.Services) == 0 {
+		return Service{}, stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound}
+	}
+	return resp.Services[0], nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package extdisco_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/extdisco"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler   = extdisco.Service{}
+	_ xml.Unmarshaler = (*extdisco.Service)(nil)
+)
+
+var marshalTestCases = [...]struct {
+	service extdisco.Service
+	out     string
+}{
+	0: {
+		service: extdisco.Service{Type: "stun", Host: "stun.example.com", Port: 3478, Transport: "udp"},
+		out:     `<service host="stun.example.com" port="3478" transport="udp" type="stun"></service>`,
+	},
+	1: {
+		service: extdisco.Service{
+			Type:       "turn",
+			Host:       "turn.example.com",
+			Restricted: true,
+			Username:   "user",
+			Password:   "pass",
+			Expires:    time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		out: `<service expires="2021-01-02T03:04:05Z" host="turn.example.com" password="pass" restricted="true" type="turn" username="user"></service>`,
+	},
+}
+
+func TestMarshal(t *testing.T) {
+	for i, tc := range marshalTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			b, err := xml.Marshal(tc.service)
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			if out := string(b); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+			var s extdisco.Service
+			err = xml.Unmarshal(b, &s)
+			if err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			if !reflect.DeepEqual(s, tc.service) {
+				t.Errorf("wrong unmarshaled value:\nwant=%+v,\n got=%+v", tc.service, s)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	cs := xmpptest.NewClientServer(xmpptest.ServerScript(
+		`<iq type="result" xmlns="jabber:client"><services xmlns="urn:xmpp:extdisco:2"><service host="stun.example.com" port="3478" type="stun"/><service host="turn.example.com" restricted="1" type="turn"/></services></iq>`,
+		`<iq type="result" xmlns="jabber:client"><credentials xmlns="urn:xmpp:extdisco:2"><service host="turn.example.com" type="turn" username="user" password="pass"/></credentials></iq>`,
+	))
+	defer cs.Close()
+
+	server := jid.MustParse("example.com")
+	services, err := extdisco.Get(context.Background(), cs.Client, server, "")
+	if err != nil {
+		t.Fatalf("error getting services: %v", err)
+	}
+	want := []extdisco.Service{
+		{Type: "stun", Host: "stun.example.com", Port: 3478},
+		{Type: "turn", Host: "turn.example.com", Restricted: true},
+	}
+	if !reflect.DeepEqual(services, want) {
+		t.Fatalf("wrong services:\nwant=%+v,\n got=%+v", want, services)
+	}
+
+	creds, err := extdisco.GetCredentials(context.Background(), cs.Client, server, services[1])
+	if err != nil {
+		t.Fatalf("error getting credentials: %v", err)
+	}
+	if creds.Username != "user" || creds.Password != "pass" {
+		t.Errorf("wrong credentials: %+v", creds)
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package fallback_test
+
+import (
+	"testing"
+
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/internal/xmpptest"
+)
+
+func TestConformance(t *testing.T) {
+	xmpptest.RunGoldenTests(t, "testdata", func(string) interface{} {
+		return &fallback.Fallback{}
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package fallback implements XEP-0428: Fallback Indication.
+//
+// Fallback indications mark all or part of a message body as fallback text
+// intended for clients that do not understand a richer element in the message
+// (such as a reply or reaction).
+// Clients that do understand the richer element may strip the fallback text
+// before displaying the message.
+package fallback // import "mellium.im/xmpp/fallback"
+
+import (
+	"encoding/xml"
+	"sort"
+	"strconv"
+
+	"mellium.im/xmlstream"
+)
+
+// NS is the namespace used by this package, provided as a convenience.
+const NS = "urn:xmpp:fallback:0"
+
+// Range is a range of characters in the body or subject that are part of the
+// fallback.
+// Start and End are offsets in Unicode code points (not bytes) and End is
+// exclusive.
+// If both are zero the range covers the entire text.
+type Range struct {
+	Start uint64
+	End   uint64
+}
+
+func (r Range) tokenReader(local string) xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Local: local}}
+	if r.Start != 0 || r.End != 0 {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "start"},
+			Value: strconv.FormatUint(r.Start, 10),
+		}, xml.Attr{
+			Name:  xml.Name{Local: "end"},
+			Value: strconv.FormatUint(r.End, 10),
+		})
+	}
+	return xmlstream.Wrap(nil, start)
+}
+
+// Fallback indicates that parts of the message are a fallback for the
+// specification with the namespace For.
+// If Body and Subject are both empty, the entire body is a fallback.
+type Fallback struct {
+	XMLName xml.Name `xml:"urn:xmpp:fallback:0 fallback"`
+	For     string   `xml:"for,attr,omitempty"`
+	Body    []Range  `xml:"body"`
+	Subject []Range  `xml:"subject"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (f Fallback) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: "fallback"}}
+	if f.For != "" {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "for"},
+			Value: f.For,
+		})
+	}
+	var inner []xml.TokenReader
+	for _, r := range f.Body {
+		inner = append(inner, r.tokenReader("body"))
+	}
+	for _, r := range f.Subject {
+		inner = append(inner, r.tokenReader("subject"))
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (f Fallback) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, f.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (f Fallback) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := f.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (f *Fallback) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type rangeAttrs struct {
+		Start *uint64 `xml:"start,attr"`
+		End   *uint64 `xml:"end,attr"`
+	}
+	s := struct {
+		XMLName xml.Name     `xml:"urn:xmpp:fallback:0 fallback"`
+		For     string       `xml:"for,attr"`
+		Body    []rangeAttrs `xml:"body"`
+		Subject []rangeAttrs `xml:"subject"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	toRanges := func(attrs []rangeAttrs) []Range {
+		var ranges []Range
+		for _, a := range attrs {
+			var r Range
+			if a.Start != nil && a.End != nil {
+				r.Start, r.End = *a.Start, *a.End
+			}
+			ranges = append(ranges, r)
+		}
+		return ranges
+	}
+	f.XMLName = s.XMLName
+	f.For = s.For
+	f.Body = toRanges(s.Body)
+	f.Subject = toRanges(s.Subject)
+	return nil
+}
+
+// Strip returns the text with all of the fallback ranges removed.
+// Ranges that are out of bounds are truncated to the length of the text and a
+// zero range removes the entire text.
+func Strip(text string, ranges []Range) string {
+	if len(ranges) == 0 {
+		return text
+	}
+	runes := []rune(text)
+	l := uint64(len(runes))
+	sorted := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Start == 0 && r.End == 0 {
+			return ""
+		}
+		if r.End > l {
+			r.End = l
+		}
+		if r.Start >= r.End {
+			continue
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	out := make([]rune, 0, len(runes))
+	var pos uint64
+	for _, r := range sorted {
+		if r.Start > pos {
+			out = append(out, runes[pos:r.Start]...)
+		}
+		if r.End > pos {
+			pos = r.End
+		}
+	}
+	out = append(out, runes[pos:]...)
+	return string(out)
+}
+
+// StripBody returns body with any fallback text for the namespace removed.
+// If ns is empty, fallback text for all namespaces is removed.
+func StripBody(body, ns string, fallbacks []Fallback) string {
+	var ranges []Range
+	for _, f := range fallbacks {
+		if ns != "" && f.For != ns {
+			continue
+		}
+		if len(f.Body) == 0 && len(f.Subject) == 0 {
+			return ""
+		}
+		ranges = append(ranges, f.Body...)
+	}
+	return Strip(body, ranges)
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package fallback_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+)
+
+var (
+	_ xml.Marshaler       = fallback.Fallback{}
+	_ xml.Unmarshaler     = (*fallback.Fallback)(nil)
+	_ xmlstream.Marshaler = fallback.Fallback{}
+	_ xmlstream.WriterTo  = fallback.Fallback{}
+)
+
+var marshalTestCases = [...]struct {
+	in  fallback.Fallback
+	out string
+}{
+	0: {
+		out: `<fallback xmlns="urn:xmpp:fallback:0"></fallback>`,
+	},
+	1: {
+		in: fallback.Fallback{
+			For:  "urn:xmpp:reply:0",
+			Body: []fallback.Range{{Start: 0, End: 33}},
+		},
+		out: `<fallback xmlns="urn:xmpp:fallback:0" for="urn:xmpp:reply:0"><body start="0" end="33"></body></fallback>`,
+	},
+	2: {
+		in: fallback.Fallback{
+			For:     "urn:example",
+			Body:    []fallback.Range{{}},
+			Subject: []fallback.Range{{Start: 1, End: 2}},
+		},
+		out: `<fallback xmlns="urn:xmpp:fallback:0" for="urn:example"><body></body><subject start="1" end="2"></subject></fallback>`,
+	},
+}
+
+func TestMarshal(t *testing.T) {
+	for i, tc := range marshalTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			b, err := xml.Marshal(tc.in)
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			if out := string(b); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+
+			var f fallback.Fallback
+			err = xml.Unmarshal(b, &f)
+			if err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			f.XMLName = xml.Name{}
+			if !reflect.DeepEqual(f, tc.in) {
+				t.Errorf("bad round trip: want=%+v, got=%+v", tc.in, f)
+			}
+		})
+	}
+}
+
+var stripTestCases = [...]struct {
+	body   string
+	ns     string
+	fb     []fallback.Fallback
+	output string
+}{
+	0: {
+		body:   "no fallback",
+		output: "no fallback",
+	},
+	1: {
+		body: "> Anna wrote:\n> Hi\nHello!",
+		ns:   "urn:xmpp:reply:0",
+		fb: []fallback.Fallback{{
+			For:  "urn:xmpp:reply:0",
+			Body: []fallback.Range{{Start: 0, End: 19}},
+		}},
+		output: "Hello!",
+	},
+	2: {
+		body: "👍 reacted",
+		fb: []fallback.Fallback{{
+			For:  "urn:xmpp:reactions:0",
+			Body: []fallback.Range{{Start: 1, End: 9}},
+		}},
+		output: "👍",
+	},
+	3: {
+		body: "encrypted message",
+		ns:   "eu.siacs.conversations.axolotl",
+		fb: []fallback.Fallback{{
+			For: "eu.siacs.conversations.axolotl",
+		}},
+		output: "",
+	},
+	4: {
+		body: "other fallback",
+		ns:   "urn:xmpp:reply:0",
+		fb: []fallback.Fallback{{
+			For: "urn:example",
+		}},
+		output: "other fallback",
+	},
+	5: {
+		body: "abcdef",
+		fb: []fallback.Fallback{{
+			Body: []fallback.Range{{Start: 4, End: 100}, {Start: 0, End: 2}, {Start: 1, End: 3}},
+		}},
+		output: "d",
+	},
+}
+
+func TestStripBody(t *testing.T) {
+	for i, tc := range stripTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := fallback.StripBody(tc.body, tc.ns, tc.fb)
+			if out != tc.output {
+				t.Errorf("wrong output: want=%q, got=%q", tc.output, out)
+			}
+		})
+	}
+}
@@ -75,6 +75,98 @@ type StreamFeature struct {
 	Negotiate func(ctx context.Context, session *Session, data interface{}) (mask SessionState, rw io.ReadWriter, err error)
 }
 
+// FeatureError is returned when negotiation fails because a stream feature
+// could not be negotiated.
+type FeatureError struct {
+	// Name is the name of the feature.
+	Name xml.Name
+
+	// Advertised is true if the remote entity advertised the feature.
+	Advertised bool
+
+	// Missing contains the state bits that the feature requires (see the
+	// Necessary field on StreamFeature) but that were not set on the session
+	// when the feature was advertised.
+	Missing SessionState
+}
+
+func (e *FeatureError) Error() string {
+	switch {
+	case !e.Advertised:
+		return fmt.Sprintf("xmpp: required stream feature %s was not advertised", e.Name.Space)
+	case e.Missing != 0:
+		return fmt.Sprintf("xmpp: stream feature %s cannot be negotiated without session state %v", e.Name.Space, e.Missing)
+	}
+	return fmt.Sprintf("xmpp: required stream feature %s was not negotiated", e.Name.Space)
+}
+
+// unmetFeature returns an error for the first feature that was advertised but
+// that cannot be negotiated because the session is missing the state that it
+// requires.
+func unmetFeature(s *Session, features []StreamFeature) error {
+	for _, f := range features {
+		if _, ok := s.features[f.Name.Space]; !ok {
+			continue
+		}
+		if missing := f.Necessary &^ s.state; missing != 0 {
+			return &FeatureError{Name: f.Name, Advertised: true, Missing: missing}
+		}
+	}
+	return nil
+}
+
+// checkRequired returns an error for the first of the required feature
+// namespaces that has not been negotiated.
+// Features that are prohibited by the current session state (for example
+// StartTLS on a connection that is already secured with direct TLS) are not
+// needed and are treated as negotiated.
+func checkRequired(s *Session, required []string, negotiated map[string]struct{}, features []StreamFeature) error {
+	for _, space := range required {
+		if _, ok := negotiated[space]; ok {
+			continue
+		}
+		e := &FeatureError{Name: xml.Name{Space: space}}
+		_, e.Advertised = s.features[space]
+		for _, f := range features {
+			if f.Name.Space != space {
+				continue
+			}
+			if f.Prohibited != 0 && s.state&f.Prohibited != 0 {
+				e = nil
+				break
+			}
+			e.Name = f.Name
+			if e.Advertised {
+				e.Missing = f.Necessary &^ s.state
+			}
+			break
+		}
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// secureAuth returns a copy of features in which authentication features
+// (those that are prohibited once the session is authenticated) require a
+// secure session.
+// If allowInsecure is set features are returned unchanged, so any state that a
+// feature requires on its own is still honored.
+func secureAuth(features []StreamFeature, allowInsecure bool) []StreamFeature {
+	if allowInsecure {
+		return features
+	}
+	secured := make([]StreamFeature, 0, len(features))
+	for _, f := range features {
+		if f.Prohibited&Authn == Authn {
+			f.Necessary |= Secure
+		}
+		secured = append(secured, f)
+	}
+	return secured
+}
+
 func containsStartTLS(features []StreamFeature) (startTLS StreamFeature, ok bool) {
 	for _, feature := range features {
 		if feature.Name.Space == ns.StartTLS {
@@ -155,8 +247,11 @@ func negotiateFeatures(ctx context.Context, s *Session, first, ws bool, features
 			return Ready, nil, nil
 		case len(list.cache) == 0:
 			// If we received a list with features we support but where none of them
-			// could be negotiated (eg. they were advertised in the wrong order), this
-			// is an error:
+			// could be negotiated (eg. they were advertised in the wrong order or
+			// their prerequisites were not met), this is an error:
+			if err = unmetFeature(s, features); err != nil {
+				return mask, nil, err
+			}
 			// TODO: This error isn't very good.
 			return mask, nil, errors.New("xmpp: features advertised out of order")
 		}
@@ -430,6 +525,12 @@ parsefeatures:
 		case xml.EndElement:
 			if tok.Name.Local == featuresLocal && tok.Name.Space == stream.NS {
 				// We've reached the end of the features list!
+				s.events.Publish(Event{
+					Type:     FeaturesEvent,
+					Session:  s,
+					State:    s.state,
+					Features: copyFeatures(s.features),
+				})
 				return sf, nil
 			}
 			// Oops, how did that happen? We shouldn't have been able to hit an end
@@ -0,0 +1,250 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package filetransfer provides a single API for accepting or rejecting
+// incoming file transfers regardless of the mechanism used to offer them.
+//
+// Offers are currently received as out of band data (including links to files
+// shared using HTTP upload) either attached to messages or sent in IQs.
+// Other mechanisms such as Jingle file transfer and stream initiation are
+// not yet supported.
+//
+// Offers sent in IQs are not responded to until they have been accepted (and
+// the download has completed) or rejected, which blocks the handler.
+// Sessions that receive them should be configured to handle stanzas
+// concurrently using the ConcurrentHandlers option of xmpp.StreamConfig.
+package filetransfer // import "mellium.im/xmpp/filetransfer"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/oob"
+	"mellium.im/xmpp/stanza"
+)
+
+var errHandled = errors.New("filetransfer: offer was already accepted or rejected")
+
+// Transport is the mechanism used to offer a file.
+type Transport string
+
+// A list of supported transports.
+const (
+	// OOB is used for out of band data attached to a message, including files
+	// uploaded using HTTP upload.
+	// Rejecting the offer does not inform the sender.
+	OOB Transport = "oob"
+
+	// OOBIQ is used for out of band data sent in an IQ.
+	// The sender is informed when the offer is accepted or rejected.
+	OOBIQ Transport = "oob-iq"
+)
+
+// Offer is an incoming file transfer.
+// Each offer must be accepted or rejected exactly once.
+type Offer struct {
+	// From is the address of the entity offering the file.
+	From jid.JID
+	// Transport is the mechanism used to offer the file.
+	Transport Transport
+	// Name is the name of the file, if known.
+	Name string
+	// Desc is a human readable description of the file, if any.
+	Desc string
+	// URL is the location of the file for transports that use one.
+	URL string
+
+	mu      sync.Mutex
+	handled bool
+	client  *http.Client
+	iq      stanza.IQ
+	reply   chan xml.TokenReader
+}
+
+// Accept downloads the file and writes it to w.
+func (o *Offer) Accept(ctx context.Context, w io.Writer) error {
+	if err := o.handle(); err != nil {
+		return err
+	}
+	err := o.download(ctx, w)
+	if o.reply == nil {
+		return err
+	}
+	if err != nil {
+		o.reply <- o.iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ItemNotFound,
+		})
+		return err
+	}
+	o.reply <- o.iq.Result(nil)
+	return nil
+}
+
+// Reject declines the offer.
+// If the transport supports it, the sender is informed.
+func (o *Offer) Reject() error {
+	if err := o.handle(); err != nil {
+		return err
+	}
+	if o.reply != nil {
+		o.reply <- o.iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.NotAcceptable,
+		})
+	}
+	return nil
+}
+
+func (o *Offer) handle() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.handled {
+		return errHandled
+	}
+	o.handled = true
+	return nil
+}
+
+func (o *Offer) download(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.URL, nil)
+	if err != nil {
+		return err
+	}
+	client := o.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	/* #nosec */
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("filetransfer: unexpected HTTP status %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Receiver collects incoming file transfer offers.
+type Receiver struct {
+	// Client is used to download files offered with a URL.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	offers chan *Offer
+}
+
+// NewReceiver returns a receiver that buffers up to n offers.
+// If the buffer is full when a new offer arrives, the offer is rejected.
+func NewReceiver(n int) *Receiver {
+	return &Receiver{
+		offers: make(chan *Offer, n),
+	}
+}
+
+// Offers returns a channel of incoming offers.
+func (r *Receiver) Offers() <-chan *Offer {
+	return r.offers
+}
+
+// Handle returns an option that registers the receiver to handle offers.
+func Handle(r *Receiver) mux.Option {
+	return func(m *mux.ServeMux) {
+		x := xml.Name{Space: oob.NS, Local: "x"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage} {
+			mux.MessagePayload(typ, x, r)(m)
+		}
+		mux.IQ(stanza.SetIQ, xml.Name{Space: oob.NSQuery, Local: "query"}, r)(m)
+	}
+}
+
+// HandleMessagePayload implements mux.MessagePayloadHandler.
+func (r *Receiver) HandleMessagePayload(msg stanza.Message, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var data oob.Data
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&data)
+	if err != nil {
+		return err
+	}
+	if data.URL == "" {
+		return nil
+	}
+	r.deliver(&Offer{
+		From:      msg.From,
+		Transport: OOB,
+		Name:      fileName(data.URL),
+		Desc:      data.Desc,
+		URL:       data.URL,
+		client:    r.Client,
+	})
+	return nil
+}
+
+// HandleIQ implements mux.IQHandler.
+func (r *Receiver) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var query oob.Query
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&query)
+	if err != nil {
+		return err
+	}
+	if query.URL == "" {
+		_, err = xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.BadRequest,
+		}))
+		return err
+	}
+	o := &Offer{
+		From:      iq.From,
+		Transport: OOBIQ,
+		Name:      fileName(query.URL),
+		Desc:      query.Desc,
+		URL:       query.URL,
+		client:    r.Client,
+		iq:        iq,
+		reply:     make(chan xml.TokenReader, 1),
+	}
+	if !r.deliver(o) {
+		_, err = xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.NotAcceptable,
+		}))
+		return err
+	}
+	_, err = xmlstream.Copy(t, <-o.reply)
+	return err
+}
+
+func (r *Receiver) deliver(o *Offer) bool {
+	select {
+	case r.offers <- o:
+		return true
+	default:
+		return false
+	}
+}
+
+func fileName(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Path == "" {
+		return ""
+	}
+	name := path.Base(parsed.Path)
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package filetransfer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/filetransfer"
+	"mellium.im/xmpp/mux"
+)
+
+const fileContents = "Some people have a way with words"
+
+var offerTestCases = [...]struct {
+	x         string
+	transport filetransfer.Transport
+	accept    bool
+	resp      string
+}{
+	0: {
+		x:         `<message from="romeo@example.net/orchard" to="juliet@example.com" type="chat" xmlns="jabber:client"><body>%[1]s</body><x xmlns="jabber:x:oob"><url>%[1]s</url><desc>A file</desc></x></message>`,
+		transport: filetransfer.OOB,
+		accept:    true,
+	},
+	1: {
+		x:         `<iq from="romeo@example.net/orchard" to="juliet@example.com/balcony" type="set" id="oob1" xmlns="jabber:client"><query xmlns="jabber:iq:oob"><url>%[1]s</url><desc>A file</desc></query></iq>`,
+		transport: filetransfer.OOBIQ,
+		accept:    true,
+		resp:      `type="result"`,
+	},
+	2: {
+		x:         `<iq from="romeo@example.net/orchard" to="juliet@example.com/balcony" type="set" id="oob1" xmlns="jabber:client"><query xmlns="jabber:iq:oob"><url>%[1]s</url><desc>A file</desc></query></iq>`,
+		transport: filetransfer.OOBIQ,
+		resp:      `not-acceptable`,
+	},
+}
+
+func TestOffers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, fileContents)
+	}))
+	defer srv.Close()
+	fileURL := srv.URL + "/words.txt"
+
+	for i, tc := range offerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			recv := filetransfer.NewReceiver(1)
+			recv.Client = srv.Client()
+			m := mux.New(filetransfer.Handle(recv))
+
+			var out bytes.Buffer
+			e := xml.NewEncoder(&out)
+			d := xml.NewDecoder(strings.NewReader(fmt.Sprintf(tc.x, fileURL)))
+			tok, _ := d.Token()
+			start := tok.(xml.StartElement)
+			errs := make(chan error, 1)
+			go func() {
+				errs <- m.HandleXMPP(struct {
+					xml.TokenReader
+					xmlstream.Encoder
+				}{
+					TokenReader: d,
+					Encoder:     e,
+				}, &start)
+			}()
+
+			offer := <-recv.Offers()
+			if offer.Transport != tc.transport {
+				t.Errorf("wrong transport: want=%q, got=%q", tc.transport, offer.Transport)
+			}
+			if offer.Name != "words.txt" || offer.Desc != "A file" {
+				t.Errorf("wrong file metadata: %+v", offer)
+			}
+			if tc.accept {
+				var file bytes.Buffer
+				err := offer.Accept(context.Background(), &file)
+				if err != nil {
+					t.Fatalf("error accepting offer: %v", err)
+				}
+				if s := file.String(); s != fileContents {
+					t.Errorf("wrong file contents: want=%q, got=%q", fileContents, s)
+				}
+			} else {
+				err := offer.Reject()
+				if err != nil {
+					t.Fatalf("error rejecting offer: %v", err)
+				}
+			}
+			if err := offer.Reject(); err == nil {
+				t.Errorf("expected error handling offer twice")
+			}
+
+			if err := <-errs; err != nil {
+				t.Fatalf("error handling offer: %v", err)
+			}
+			if err := e.Flush(); err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if resp := out.String(); !strings.Contains(resp, tc.resp) {
+				t.Errorf("expected response to contain %s, got: %s", tc.resp, resp)
+			}
+		})
+	}
+}
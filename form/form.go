@@ -235,6 +235,21 @@ func (d *Data) Get(id string) (v interface{}, ok bool) {
 	return nil, false
 }
 
+// Values returns the raw values of a form field as they appeared in the form,
+// regardless of its type.
+// Values set using Set are not included.
+// This is useful for result forms where fields are often sent without a type
+// but may still have multiple values.
+// If no form field with the given name exists, ok will be false.
+func (d *Data) Values(id string) (v []string, ok bool) {
+	for _, field := range d.fields {
+		if field.varName == id {
+			return field.value, true
+		}
+	}
+	return nil, false
+}
+
 // GetJID is like Get except that it asserts that the form submission is a JID.
 // If the form submission was not a JID or is not set, ok will be false.
 func (d *Data) GetJID(id string) (j jid.JID, ok bool) {
@@ -339,6 +354,9 @@ func (d *Data) Set(id string, v interface{}) (ok bool, err error) {
 			return false, fmt.Errorf("expected %T, got %T", vv, v)
 		}
 	}
+	if d.values == nil {
+		d.values = make(map[string]interface{})
+	}
 	d.values[id] = v
 	return ok, err
 }
@@ -509,3 +509,34 @@ func TestUnmarshalInvalidToken(t *testing.T) {
 		t.Fatalf("expected error when unmarshaling disallowed token type")
 	}
 }
+
+func TestValues(t *testing.T) {
+	const formData = `<x xmlns="jabber:x:data" type="result"><field var="FORM_TYPE" type="hidden"><value>urn:example</value></field><field var="addresses"><value>mailto:a@example.net</value><value>xmpp:a@example.net</value></field></x>`
+	data := &form.Data{}
+	err := xml.Unmarshal([]byte(formData), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	v, ok := data.Values("addresses")
+	if !ok || len(v) != 2 || v[0] != "mailto:a@example.net" || v[1] != "xmpp:a@example.net" {
+		t.Errorf("wrong values for untyped multi-valued field: %v, %t", v, ok)
+	}
+	if _, ok = data.Values("missing"); ok {
+		t.Errorf("expected missing field not to be found")
+	}
+}
+
+func TestSetUnmarshaled(t *testing.T) {
+	var data form.Data
+	err := xml.Unmarshal([]byte(`<x xmlns="jabber:x:data" type="form"><field type="text-single" var="name"><value>old</value></field></x>`), &data)
+	if err != nil {
+		t.Fatalf("error unmarshaling form: %v", err)
+	}
+	ok, err := data.Set("name", "new")
+	if err != nil || !ok {
+		t.Fatalf("error setting value: ok=%t, err=%v", ok, err)
+	}
+	if v, _ := data.GetString("name"); v != "new" {
+		t.Errorf("wrong value: want=new, got=%q", v)
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package gateway contains helpers for gateways (also known as transports) to
+// other networks as described in XEP-0100: Gateway Interaction.
+//
+// Users register their legacy account with a gateway using in-band
+// registration (jabber:iq:register), after which contacts on the legacy
+// network are addressed using JIDs at the domain of the gateway.
+// The localpart of those JIDs is the legacy ID escaped using
+// XEP-0106: JID Escaping, and users can ask the gateway to translate a legacy
+// ID into a JID using jabber:iq:gateway.
+//
+// Functions in this package are used by clients to interact with a gateway,
+// while Handler is used by authors of gateways to respond to these requests.
+package gateway // import "mellium.im/xmpp/gateway"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package.
+const (
+	NS         = "jabber:iq:gateway"
+	NSRegister = "jabber:iq:register"
+)
+
+// Registration is the legacy account of a user.
+type Registration struct {
+	// Instructions and Registered are only set on registrations returned by the
+	// gateway and are ignored when registering.
+	Instructions string
+	Registered   bool
+
+	Username string
+	Password string
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Registration) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	if r.Instructions != "" {
+		inner = append(inner, textElement("instructions", r.Instructions))
+	}
+	if r.Registered {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "registered"}}))
+	}
+	inner = append(inner,
+		textElement("username", r.Username),
+		textElement("password", r.Password),
+	)
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NSRegister, Local: "query"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Registration) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Registration) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (r *Registration) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Instructions string    `xml:"instructions"`
+		Registered   *struct{} `xml:"registered"`
+		Username     string    `xml:"username"`
+		Password     string    `xml:"password"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*r = Registration{
+		Instructions: s.Instructions,
+		Registered:   s.Registered != nil,
+		Username:     s.Username,
+		Password:     s.Password,
+	}
+	return nil
+}
+
+// Prompt describes the legacy IDs that a gateway can translate into JIDs.
+type Prompt struct {
+	// Desc is a human readable description of the legacy IDs.
+	Desc string
+	// Prompt is a human readable label for the legacy ID input field.
+	Prompt string
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (p Prompt) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	if p.Desc != "" {
+		inner = append(inner, textElement("desc", p.Desc))
+	}
+	inner = append(inner, textElement("prompt", p.Prompt))
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "query"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (p Prompt) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, p.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (p Prompt) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := p.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// LegacyJID returns the JID used to address a contact on the legacy network
+// through the gateway.
+// The legacy ID is escaped using XEP-0106: JID Escaping.
+func LegacyJID(legacyID string, gateway jid.JID) (jid.JID, error) {
+	return jid.NewEscaped(legacyID, gateway.Domainpart(), "")
+}
+
+// LegacyID returns the legacy ID of a contact addressed through a gateway.
+// It is the inverse of LegacyJID.
+func LegacyID(j jid.JID) string {
+	return j.UnescapedLocalpart()
+}
+
+// GetRegistration fetches the registration of the user with the gateway.
+// If the user is not registered, the returned registration contains the
+// instructions for registering.
+func GetRegistration(ctx context.Context, s *xmpp.Session, gateway jid.JID) (Registration, error) {
+	var r Registration
+	err := s.UnmarshalIQElement(ctx, registerQuery(nil), stanza.IQ{
+		To:   gateway,
+		Type: stanza.GetIQ,
+	}, &r)
+	return r, err
+}
+
+// Register registers the legacy account with the gateway.
+// Once registered, gateways normally request a subscription to the users
+// presence which should be approved.
+func Register(ctx context.Context, s *xmpp.Session, gateway jid.JID, r Registration) error {
+	r.Instructions = ""
+	r.Registered = false
+	return s.UnmarshalIQElement(ctx, r.TokenReader(), stanza.IQ{
+		To:   gateway,
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+// Unregister removes the registration of the user with the gateway.
+func Unregister(ctx context.Context, s *xmpp.Session, gateway jid.JID) error {
+	return s.UnmarshalIQElement(ctx, registerQuery(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "remove"}}),
+	), stanza.IQ{
+		To:   gateway,
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+// GetPrompt fetches the prompt that describes the legacy IDs that the gateway
+// can translate.
+func GetPrompt(ctx context.Context, s *xmpp.Session, gateway jid.JID) (Prompt, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"jabber:iq:gateway query"`
+		Desc    string   `xml:"desc"`
+		Prompt  string   `xml:"prompt"`
+	}
+	err := s.UnmarshalIQElement(ctx, gatewayQuery(nil), stanza.IQ{
+		To:   gateway,
+		Type: stanza.GetIQ,
+	}, &resp)
+	return Prompt{Desc: resp.Desc, Prompt: resp.Prompt}, err
+}
+
+// Translate asks the gateway for the JID of a contact on the legacy network.
+func Translate(ctx context.Context, s *xmpp.Session, gateway jid.JID, legacyID string) (jid.JID, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"jabber:iq:gateway query"`
+		JID     jid.JID  `xml:"jid"`
+	}
+	err := s.UnmarshalIQElement(ctx, gatewayQuery(textElement("prompt", legacyID)), stanza.IQ{
+		To:   gateway,
+		Type: stanza.SetIQ,
+	}, &resp)
+	return resp.JID, err
+}
+
+// Handle returns an option that registers a Handler for registration and
+// legacy ID translation requests.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		for _, typ := range []stanza.IQType{stanza.GetIQ, stanza.SetIQ} {
+			mux.IQ(typ, xml.Name{Space: NS, Local: "query"}, h)(m)
+			mux.IQ(typ, xml.Name{Space: NSRegister, Local: "query"}, h)(m)
+		}
+	}
+}
+
+// Handler responds to requests from users of a gateway.
+//
+// If a callback returns a stanza.Error it is sent in response to the request,
+// any other error is returned from HandleIQ.
+// If Register or Unregister are nil, registration requests are rejected.
+type Handler struct {
+	// Prompt is returned to users that ask how to address legacy contacts.
+	Prompt Prompt
+
+	// Translate returns the JID of a legacy contact.
+	// If nil, LegacyJID is used with the address that the request was sent to.
+	Translate func(from jid.JID, legacyID string) (jid.JID, error)
+
+	// Registration returns the current registration of a user or, if the user
+	// is not registered, a registration containing instructions.
+	// If nil, the user is assumed to be unregistered and an empty form is
+	// returned.
+	Registration func(from jid.JID) (Registration, error)
+
+	// Register and Unregister are called when a user registers or removes
+	// their legacy account.
+	Register   func(from jid.JID, r Registration) error
+	Unregister func(from jid.JID) error
+}
+
+// HandleIQ implements mux.IQHandler.
+func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var (
+		payload xml.TokenReader
+		err     error
+	)
+	switch start.Name.Space {
+	case NS:
+		payload, err = h.handleGateway(iq, t, start)
+	case NSRegister:
+		payload, err = h.handleRegister(iq, t, start)
+	default:
+		return nil
+	}
+	var stanzaErr stanza.Error
+	if errors.As(err, &stanzaErr) {
+		_, err = xmlstream.Copy(t, iq.Error(stanzaErr))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = xmlstream.Copy(t, iq.Result(payload))
+	return err
+}
+
+func (h Handler) handleGateway(iq stanza.IQ, t xml.TokenReader, start *xml.StartElement) (xml.TokenReader, error) {
+	if iq.Type == stanza.GetIQ {
+		return h.Prompt.TokenReader(), nil
+	}
+	var req struct {
+		Prompt string `xml:"prompt"`
+	}
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&req)
+	if err != nil {
+		return nil, err
+	}
+	var j jid.JID
+	if h.Translate != nil {
+		j, err = h.Translate(iq.From, req.Prompt)
+	} else {
+		j, err = LegacyJID(req.Prompt, iq.To)
+		if err != nil {
+			err = stanza.Error{Type: stanza.Modify, Condition: stanza.JIDMalformed, Err: err}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gatewayQuery(textElement("jid", j.String())), nil
+}
+
+func (h Handler) handleRegister(iq stanza.IQ, t xml.TokenReader, start *xml.StartElement) (xml.TokenReader, error) {
+	if iq.Type == stanza.GetIQ {
+		var r Registration
+		if h.Registration != nil {
+			var err error
+			r, err = h.Registration(iq.From)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return r.TokenReader(), nil
+	}
+
+	var req struct {
+		Remove   *struct{} `xml:"remove"`
+		Username string    `xml:"username"`
+		Password string    `xml:"password"`
+	}
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&req)
+	if err != nil {
+		return nil, err
+	}
+	notAllowed := stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAllowed}
+	if req.Remove != nil {
+		if h.Unregister == nil {
+			return nil, notAllowed
+		}
+		return nil, h.Unregister(iq.From)
+	}
+	if h.Register == nil {
+		return nil, notAllowed
+	}
+	return nil, h.Register(iq.From, Registration{
+		Username: req.Username,
+		Password: req.Password,
+	})
+}
+
+func registerQuery(inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: NSRegister, Local: "query"}})
+}
+
+func gatewayQuery(inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: NS, Local: "query"}})
+}
+
+func textElement(local, text string) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(text)),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
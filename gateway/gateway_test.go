@@ -0,0 +1,171 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package gateway_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/gateway"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler       = gateway.Registration{}
+	_ xml.Unmarshaler     = (*gateway.Registration)(nil)
+	_ xmlstream.Marshaler = gateway.Registration{}
+	_ xmlstream.WriterTo  = gateway.Registration{}
+	_ xml.Marshaler       = gateway.Prompt{}
+	_ xmlstream.Marshaler = gateway.Prompt{}
+	_ xmlstream.WriterTo  = gateway.Prompt{}
+	_ mux.IQHandler       = gateway.Handler{}
+)
+
+func TestLegacyJID(t *testing.T) {
+	gw := jid.MustParse("aim.shakespeare.lit")
+	j, err := gateway.LegacyJID("Romeo Montague@example", gw)
+	if err != nil {
+		t.Fatalf("error creating JID: %v", err)
+	}
+	const want = `romeo\20montague\40example@aim.shakespeare.lit`
+	if s := j.String(); !strings.EqualFold(s, want) {
+		t.Errorf("wrong JID: want=%s, got=%s", want, s)
+	}
+	if id := gateway.LegacyID(j); !strings.EqualFold(id, "Romeo Montague@example") {
+		t.Errorf("wrong legacy ID: want=%q, got=%q", "Romeo Montague@example", id)
+	}
+}
+
+var roundTripTestCases = [...]string{
+	0: "romeo",
+	1: "Romeo Montague@example",
+	2: "+1 555 0100",
+	3: `juliet's "nurse" <capulet&co>/ward:1`,
+	4: `literal\20escape\5c`,
+}
+
+func TestLegacyIDRoundTrip(t *testing.T) {
+	gw := jid.MustParse("aim.shakespeare.lit")
+	for i, tc := range roundTripTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			j, err := gateway.LegacyJID(tc, gw)
+			if err != nil {
+				t.Fatalf("error creating JID: %v", err)
+			}
+			if id := gateway.LegacyID(j); !strings.EqualFold(id, tc) {
+				t.Errorf("legacy ID did not round trip: want=%q, got=%q", tc, id)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	cs := xmpptest.NewClientServer(xmpptest.ServerScript(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="jabber:iq:gateway"><jid>CapuletNurse@aim.shakespeare.lit</jid></query></iq>`,
+	))
+	defer cs.Close()
+
+	j, err := gateway.Translate(context.Background(), cs.Client, jid.MustParse("aim.shakespeare.lit"), "CapuletNurse")
+	if err != nil {
+		t.Fatalf("error translating legacy ID: %v", err)
+	}
+	if want := jid.MustParse("CapuletNurse@aim.shakespeare.lit"); !j.Equal(want) {
+		t.Errorf("wrong JID: want=%s, got=%s", want, j)
+	}
+}
+
+var handlerTestCases = [...]struct {
+	in         string
+	out        string
+	registered *gateway.Registration
+	removed    bool
+}{
+	0: {
+		in:  `<iq type="get" id="1" from="romeo@montague.lit/orchard" to="aim.shakespeare.lit" xmlns="jabber:client"><query xmlns="jabber:iq:gateway"/></iq>`,
+		out: `<query xmlns="jabber:iq:gateway"><desc>Please enter the AOL Screen Name</desc><prompt>Screen Name</prompt></query>`,
+	},
+	1: {
+		in:  `<iq type="set" id="2" from="romeo@montague.lit/orchard" to="aim.shakespeare.lit" xmlns="jabber:client"><query xmlns="jabber:iq:gateway"><prompt>Capulet Nurse</prompt></query></iq>`,
+		out: `<query xmlns="jabber:iq:gateway"><jid>capulet\20nurse@aim.shakespeare.lit</jid></query>`,
+	},
+	2: {
+		in:  `<iq type="get" id="3" from="romeo@montague.lit/orchard" to="aim.shakespeare.lit" xmlns="jabber:client"><query xmlns="jabber:iq:register"/></iq>`,
+		out: `<query xmlns="jabber:iq:register"><instructions>Enter your screen name</instructions><username></username><password></password></query>`,
+	},
+	3: {
+		in:         `<iq type="set" id="4" from="romeo@montague.lit/orchard" to="aim.shakespeare.lit" xmlns="jabber:client"><query xmlns="jabber:iq:register"><username>RomeoMyRomeo</username><password>ILoveJuliet</password></query></iq>`,
+		out:        `type="result"`,
+		registered: &gateway.Registration{Username: "RomeoMyRomeo", Password: "ILoveJuliet"},
+	},
+	4: {
+		in:      `<iq type="set" id="5" from="romeo@montague.lit/orchard" to="aim.shakespeare.lit" xmlns="jabber:client"><query xmlns="jabber:iq:register"><remove/></query></iq>`,
+		out:     `type="result"`,
+		removed: true,
+	},
+}
+
+func TestHandler(t *testing.T) {
+	for i, tc := range handlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var (
+				registered *gateway.Registration
+				removed    bool
+			)
+			m := mux.New(gateway.Handle(gateway.Handler{
+				Prompt: gateway.Prompt{
+					Desc:   "Please enter the AOL Screen Name",
+					Prompt: "Screen Name",
+				},
+				Registration: func(jid.JID) (gateway.Registration, error) {
+					return gateway.Registration{Instructions: "Enter your screen name"}, nil
+				},
+				Register: func(_ jid.JID, r gateway.Registration) error {
+					registered = &r
+					return nil
+				},
+				Unregister: func(jid.JID) error {
+					removed = true
+					return nil
+				},
+			}))
+
+			var buf bytes.Buffer
+			e := xml.NewEncoder(&buf)
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			tok, _ := d.Token()
+			start := tok.(xml.StartElement)
+			err := m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+				Encoder:     e,
+			}, &start)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if out := buf.String(); !strings.Contains(out, tc.out) {
+				t.Errorf("wrong output: want=%s, got=%s", tc.out, out)
+			}
+			if (registered == nil) != (tc.registered == nil) || (registered != nil && *registered != *tc.registered) {
+				t.Errorf("wrong registration: want=%+v, got=%+v", tc.registered, registered)
+			}
+			if removed != tc.removed {
+				t.Errorf("wrong value for removed: want=%t, got=%t", tc.removed, removed)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@
 package xmpp
 
 import (
+	"context"
 	"encoding/xml"
 
 	"mellium.im/xmlstream"
@@ -25,3 +26,32 @@ type HandlerFunc func(t xmlstream.TokenReadEncoder, start *xml.StartElement) err
 func (f HandlerFunc) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
 	return f(t, start)
 }
+
+// A ContextHandler is like a Handler except that it is also passed a context.
+// If the Handler passed to Serve also implements ContextHandler,
+// HandleXMPPContext is called instead of HandleXMPP.
+//
+// The context is canceled when the session is closed and, if HandlerTimeout was
+// set on the session's StreamConfig, when the timeout elapses.
+// It should be used to bound any calls that the handler makes such as sending
+// an IQ and waiting for the response.
+type ContextHandler interface {
+	HandleXMPPContext(ctx context.Context, t xmlstream.TokenReadEncoder, start *xml.StartElement) error
+}
+
+// The ContextHandlerFunc type is an adapter to allow the use of ordinary
+// functions as XMPP handlers that accept a context.
+// If f is a function with the appropriate signature, ContextHandlerFunc(f) is a
+// Handler and a ContextHandler that calls f.
+type ContextHandlerFunc func(ctx context.Context, t xmlstream.TokenReadEncoder, start *xml.StartElement) error
+
+// HandleXMPP calls f with a background context.
+// It is only used if the handler is called directly instead of by Serve.
+func (f ContextHandlerFunc) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	return f(context.Background(), t, start)
+}
+
+// HandleXMPPContext calls f(ctx, t, start).
+func (f ContextHandlerFunc) HandleXMPPContext(ctx context.Context, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	return f(ctx, t, start)
+}
@@ -5,12 +5,23 @@
 package xmpp_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+)
+
+var (
+	_ xmpp.Handler        = xmpp.ContextHandlerFunc(nil)
+	_ xmpp.ContextHandler = xmpp.ContextHandlerFunc(nil)
 )
 
 var errHandlerFuncSentinal = errors.New("handler test")
@@ -39,3 +50,38 @@ func TestHandlerFunc(t *testing.T) {
 		t.Errorf("HandleXMPP did not return handlerfunc error, got %q", err)
 	}
 }
+
+func TestContextHandlerTimeout(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features><message id='1' from='juliet@example.net'/></stream:stream>`),
+		Writer: &bytes.Buffer{},
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		HandlerTimeout: 10 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	var ctxErr error
+	err = s.Serve(xmpp.ContextHandlerFunc(func(ctx context.Context, _ xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+		select {
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("error serving: %v", err)
+	}
+	if ctxErr != context.DeadlineExceeded {
+		t.Errorf("wrong context error: want=%v, got=%v", context.DeadlineExceeded, ctxErr)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package hints implements XEP-0334: Message Processing Hints.
+package hints // import "mellium.im/xmpp/hints"
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/ns"
+)
+
+const (
+	// NS is the XML namespace used by message processing hints.
+	// It is provided as a convenience.
+	NS = "urn:xmpp:hints"
+)
+
+// Hint is a message processing hint that can be added to messages to influence
+// how they are handled by intermediary entities such as servers.
+type Hint int
+
+// A list of message processing hints.
+const (
+	// NoPermanentStore indicates that the message should not be stored in a
+	// permanent or semi-permanent public or private archive (such as a message
+	// archive), but may be stored temporarily for offline delivery.
+	NoPermanentStore Hint = iota
+
+	// NoStore indicates that the message should not be stored at all, including
+	// for offline delivery.
+	NoStore
+
+	// NoCopy indicates that the message should not be copied to other resources
+	// (for example, by message carbons).
+	NoCopy
+
+	// Store indicates that the message should be stored in a history archive
+	// even if it would not normally be stored.
+	Store
+)
+
+func (h Hint) local() string {
+	switch h {
+	case NoPermanentStore:
+		return "no-permanent-store"
+	case NoStore:
+		return "no-store"
+	case NoCopy:
+		return "no-copy"
+	case Store:
+		return "store"
+	}
+	return ""
+}
+
+// String satisfies fmt.Stringer by returning the local name of the hint
+// element.
+func (h Hint) String() string {
+	if l := h.local(); l != "" {
+		return l
+	}
+	return fmt.Sprintf("Hint(%d)", int(h))
+}
+
+// TokenReader implements xmlstream.Marshaler.
+// If h is not one of the known hints the reader returns an error.
+func (h Hint) TokenReader() xml.TokenReader {
+	local := h.local()
+	if local == "" {
+		return xmlstream.ReaderFunc(func() (xml.Token, error) {
+			return nil, fmt.Errorf("hints: cannot marshal unknown hint %v", h)
+		})
+	}
+	return xmlstream.Wrap(
+		nil,
+		xml.StartElement{Name: xml.Name{Space: NS, Local: local}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (h Hint) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, h.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (h Hint) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := h.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (h *Hint) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Space != NS {
+		return fmt.Errorf("hints: unexpected namespace %q", start.Name.Space)
+	}
+	for _, hint := range [...]Hint{NoPermanentStore, NoStore, NoCopy, Store} {
+		if hint.local() == start.Name.Local {
+			*h = hint
+			return d.Skip()
+		}
+	}
+	return fmt.Errorf("hints: unknown hint %q", start.Name.Local)
+}
+
+func isMessage(name xml.Name) bool {
+	return name.Local == "message" && (name.Space == ns.Client || name.Space == ns.Server)
+}
+
+// Add returns a transformer that appends the provided hints to any top level
+// message read through it.
+// Messages nested inside of other elements (such as forwarded messages) are
+// not modified.
+func Add(hint ...Hint) xmlstream.Transformer {
+	return func(r xml.TokenReader) xml.TokenReader {
+		var (
+			depth int
+			msg   bool
+			inner xml.TokenReader
+		)
+		return xmlstream.ReaderFunc(func() (xml.Token, error) {
+			if inner != nil {
+				tok, err := inner.Token()
+				switch {
+				case tok != nil && err == io.EOF:
+					inner = nil
+					return tok, nil
+				case tok == nil && err == io.EOF:
+					inner = nil
+				default:
+					return tok, err
+				}
+			}
+
+			tok, err := r.Token()
+			switch t := tok.(type) {
+			case xml.StartElement:
+				depth++
+				if depth == 1 {
+					msg = isMessage(t.Name)
+				}
+			case xml.EndElement:
+				depth--
+				if depth == 0 && msg {
+					readers := make([]xml.TokenReader, 0, len(hint)+1)
+					for _, h := range hint {
+						readers = append(readers, h.TokenReader())
+					}
+					inner = xmlstream.MultiReader(append(readers, xmlstream.Token(t))...)
+					return inner.Token()
+				}
+			}
+			return tok, err
+		})
+	}
+}
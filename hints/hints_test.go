@@ -0,0 +1,102 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package hints_test
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/hints"
+)
+
+var (
+	_ xml.Marshaler       = hints.NoStore
+	_ xml.Unmarshaler     = (*hints.Hint)(nil)
+	_ xmlstream.Marshaler = hints.NoStore
+	_ xmlstream.WriterTo  = hints.NoStore
+)
+
+var addTestCases = [...]struct {
+	hints []hints.Hint
+	in    string
+	out   string
+}{
+	0: {},
+	1: {
+		hints: []hints.Hint{hints.NoCopy},
+		in:    `<message xmlns="jabber:client"/>`,
+		out:   `<message xmlns="jabber:client"><no-copy xmlns="urn:xmpp:hints"></no-copy></message>`,
+	},
+	2: {
+		hints: []hints.Hint{hints.NoStore, hints.NoPermanentStore},
+		in:    `<message xmlns="jabber:server"><body>test</body></message>`,
+		out:   `<message xmlns="jabber:server"><body xmlns="jabber:server">test</body><no-store xmlns="urn:xmpp:hints"></no-store><no-permanent-store xmlns="urn:xmpp:hints"></no-permanent-store></message>`,
+	},
+	3: {
+		hints: []hints.Hint{hints.Store},
+		in:    `<iq xmlns="jabber:client"/>`,
+		out:   `<iq xmlns="jabber:client"></iq>`,
+	},
+	4: {
+		hints: []hints.Hint{hints.NoCopy},
+		in:    `<message xmlns="jabber:client"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client"/></forwarded></message>`,
+		out:   `<message xmlns="jabber:client"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client"></message></forwarded><no-copy xmlns="urn:xmpp:hints"></no-copy></message>`,
+	},
+}
+
+func TestAdd(t *testing.T) {
+	for i, tc := range addTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			var r xml.TokenReader = d
+			if tok, _ := d.Token(); tok != nil {
+				// Wrap returns the final token along with io.EOF.
+				start := tok.(xml.StartElement)
+				r = xmlstream.Wrap(xmlstream.Inner(d), start)
+			}
+			r = hints.Add(tc.hints...)(r)
+			// Prevent duplicate xmlns attributes. See https://mellium.im/issue/75
+			r = xmlstream.RemoveAttr(func(start xml.StartElement, attr xml.Attr) bool {
+				return (start.Name.Local == "message" || start.Name.Local == "iq" || start.Name.Local == "forwarded") && attr.Name.Local == "xmlns"
+			})(r)
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, r)
+			if err != nil {
+				t.Fatalf("error encoding: %v", err)
+			}
+			if err = e.Flush(); err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+
+			if out := buf.String(); tc.out != out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestMarshalUnknown(t *testing.T) {
+	_, err := xml.Marshal(hints.Hint(100))
+	if err == nil {
+		t.Errorf("expected error marshaling unknown hint")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var msg struct {
+		Hints []hints.Hint `xml:"urn:xmpp:hints no-copy"`
+	}
+	err := xml.Unmarshal([]byte(`<message><no-copy xmlns="urn:xmpp:hints"/></message>`), &msg)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if len(msg.Hints) != 1 || msg.Hints[0] != hints.NoCopy {
+		t.Errorf("wrong hints: want=[%v], got=%v", hints.NoCopy, msg.Hints)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+)
+
+// An Interceptor transforms stanzas before they are written to a session.
+// It is passed a token reader over the entire stanza (including its start and
+// end elements) and a copy of the stanza's start element for convenience, and
+// returns a token reader that must contain exactly one element.
+//
+// Interceptors can be used to implement features that apply to all outgoing
+// stanzas such as adding origin IDs, chat markers, or processing hints without
+// changing every call site.
+// They are run while the session holds its output lock, so they must not write
+// to the session themselves.
+type Interceptor func(r xml.TokenReader, start xml.StartElement) xml.TokenReader
+
+// intercept runs all of the session's interceptors on the stanza read from r in
+// the order they were configured.
+func (s *Session) intercept(r xml.TokenReader, start xml.StartElement) xml.TokenReader {
+	for _, f := range s.interceptors {
+		r = f(r, start)
+	}
+	return r
+}
+
+// A Filter inspects and transforms incoming stanzas before they are passed to
+// the handler given to Serve.
+// It is passed a token reader over the entire stanza (including its start and
+// end elements) and a copy of the stanza's start element for convenience.
+// It returns a token reader that must contain exactly one element which is
+// passed on to the next filter and eventually the handler, or a nil token
+// reader to consume the stanza so that it is never handled.
+// Any part of the original stanza that was not read is discarded.
+//
+// Filters can be used to implement features that apply to all incoming stanzas
+// such as decrypting payloads, unwrapping forwarded messages, or dropping
+// stanzas from blocked addresses.
+// If a Filter returns an error, Serve stops and returns the error.
+type Filter func(r xml.TokenReader, start xml.StartElement) (xml.TokenReader, error)
+
+// filter runs all of the session's filters on the stanza read from r in the
+// order they were configured.
+func (s *Session) filter(r xml.TokenReader, start xml.StartElement) (xml.TokenReader, error) {
+	for _, f := range s.filters {
+		var err error
+		r, err = f(r, start)
+		if err != nil || r == nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
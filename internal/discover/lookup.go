@@ -7,12 +7,15 @@ package discover // import "mellium.im/xmpp/internal/discover"
 
 import (
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
-	"path"
+	"sort"
 	"strings"
 	"sync"
 
@@ -26,6 +29,7 @@ const (
 	wsRel        = "urn:xmpp:alt-connections:websocket"
 	boshRel      = "urn:xmpp:alt-connections:xbosh"
 	hostMetaXML  = "/.well-known/host-meta"
+	hostMetaJSON = "/.well-known/host-meta.json"
 	wsConnType   = "ws"
 	boshConnType = "bosh"
 )
@@ -148,12 +152,22 @@ func FallbackRecords(service, domain string) []*net.SRV {
 // returned.
 // Service should be one of "xmpp[s]-client" or "xmpp[s]-server".
 func LookupService(ctx context.Context, resolver *net.Resolver, service string, addr jid.JID) (addrs []*net.SRV, err error) {
+	return LookupServiceFunc(ctx, resolver.LookupSRV, service, addr)
+}
+
+// SRVFunc is a function that looks up SRV records with the same semantics as
+// net.Resolver.LookupSRV.
+type SRVFunc func(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+
+// LookupServiceFunc is like LookupService except that SRV records are looked up
+// using the provided function instead of a resolver.
+func LookupServiceFunc(ctx context.Context, lookup SRVFunc, service string, addr jid.JID) (addrs []*net.SRV, err error) {
 	switch service {
 	case "xmpp-client", "xmpp-server", "xmpps-client", "xmpps-server":
 	default:
 		return nil, ErrInvalidService
 	}
-	_, addrs, err = resolver.LookupSRV(ctx, service, "tcp", addr.Domainpart())
+	_, addrs, err = lookup(ctx, service, "tcp", addr.Domainpart())
 	if err != nil {
 		if !isNotFound(err) {
 			return nil, err
@@ -198,51 +212,58 @@ func lookupEndpoint(ctx context.Context, resolver *net.Resolver, client *http.Cl
 	}
 
 	var (
-		u  []string
-		e  error
-		wg sync.WaitGroup
+		metaURLs []string
+		metaErr  error
+		wg       sync.WaitGroup
 
 		name = addr.Domainpart()
 	)
 
-	ctx, cancel := context.WithCancel(ctx)
-
 	wg.Add(1)
 	go func() {
-		defer func() {
-			if err == nil && len(urls) > 0 {
-				cancel()
-			}
-			wg.Done()
-		}()
+		defer wg.Done()
 		urls, err = lookupDNS(ctx, resolver, name, conntype)
 	}()
 	if client != nil {
 		wg.Add(1)
 		go func() {
-			defer func() {
-				if e == nil && len(u) > 0 {
-					cancel()
-				}
-				wg.Done()
-			}()
-			u, e = lookupHostMeta(ctx, client, name, conntype)
+			defer wg.Done()
+			metaURLs, metaErr = lookupHostMeta(ctx, client, name, conntype)
 		}()
 	}
 	wg.Wait()
 
-	switch {
-	case err == nil && len(urls) > 0:
-		return urls, err
-	case e == nil && len(u) > 0:
-		return u, e
-	case err != nil:
-		return urls, err
-	case e != nil:
-		return u, e
+	if len(urls) == 0 && len(metaURLs) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, metaErr
 	}
 
-	return urls, err
+	// Host metadata is fetched over HTTPS so it is preferred over DNS records
+	// which may have been spoofed.
+	return prioritize(append(metaURLs, urls...)), nil
+}
+
+// prioritize removes duplicate URLs and sorts them so that secure endpoints
+// come first while otherwise maintaining their order.
+func prioritize(urls []string) []string {
+	seen := make(map[string]struct{}, len(urls))
+	deduped := urls[:0]
+	for _, u := range urls {
+		if _, ok := seen[u]; ok {
+			continue
+		}
+		seen[u] = struct{}{}
+		deduped = append(deduped, u)
+	}
+	isSecure := func(u string) bool {
+		return strings.HasPrefix(u, "wss:") || strings.HasPrefix(u, "https:")
+	}
+	sort.SliceStable(deduped, func(i, j int) bool {
+		return isSecure(deduped[i]) && !isSecure(deduped[j])
+	})
+	return deduped
 }
 
 func lookupDNS(ctx context.Context, resolver *net.Resolver, name, conntype string) (urls []string, err error) {
@@ -277,60 +298,70 @@ func lookupDNS(ctx context.Context, resolver *net.Resolver, name, conntype strin
 	return urls, err
 }
 
-// TODO(ssw): Memoize the following functions?
-
 func lookupHostMeta(ctx context.Context, client *http.Client, name, conntype string) (urls []string, err error) {
 	if conntype != wsConnType && conntype != boshConnType {
 		panic("xmpp.lookupEndpoint: Invalid conntype specified")
 	}
-
-	url, err := url.Parse(name)
-	if err != nil {
-		return urls, err
+	if err = ctx.Err(); err != nil {
+		return nil, err
 	}
-	url.Path = ""
 
-	xrd, err := getHostMetaXML(ctx, client, url.String())
+	// Prefer the JSON host metadata file and fall back to the XML version.
+	xrd, err := getHostMeta(ctx, client, name, hostMetaJSON, func(r io.Reader, xrd *XRD) error {
+		return json.NewDecoder(r).Decode(xrd)
+	})
 	if err != nil {
-		return urls, err
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		xrd, err = getHostMeta(ctx, client, name, hostMetaXML, decodeXRD)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	rel := wsRel
+	if conntype == boshConnType {
+		rel = boshRel
+	}
 	for _, link := range xrd.Links {
-		switch conntype {
-		case wsConnType:
-			if link.Rel == wsRel {
-				urls = append(urls, link.Href)
-			}
-		case boshConnType:
-			if link.Rel == boshRel {
-				urls = append(urls, link.Href)
-			}
+		if link.Rel == rel {
+			urls = append(urls, link.Href)
 		}
 	}
-	return urls, err
+	return urls, nil
 }
 
-func getHostMetaXML(
-	ctx context.Context, client *http.Client, name string) (xrd XRD, err error) {
-	resp, err := ctxhttp.Get(ctx, client, path.Join(name, hostMetaXML))
-	if err != nil {
-		return xrd, err
+// getHostMeta fetches the host metadata file at the given path from name over
+// HTTPS and decodes it using the provided function.
+func getHostMeta(ctx context.Context, client *http.Client, name, p string, decode func(io.Reader, *XRD) error) (xrd XRD, err error) {
+	u := url.URL{
+		Scheme: "https",
+		Host:   name,
+		Path:   p,
 	}
-	d := xml.NewDecoder(resp.Body)
-
-	t, err := d.Token()
+	resp, err := ctxhttp.Get(ctx, client, u.String())
 	if err != nil {
 		return xrd, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return xrd, fmt.Errorf("discover: unexpected status fetching %s: %s", p, resp.Status)
+	}
+	err = decode(resp.Body, &xrd)
+	return xrd, err
+}
+
+// decodeXRD decodes the first XRD element from an XML host metadata file.
+func decodeXRD(r io.Reader, xrd *XRD) error {
+	d := xml.NewDecoder(r)
 	for {
-		select {
-		case <-ctx.Done():
-			return xrd, ctx.Err()
-		default:
-			if se, ok := t.(xml.StartElement); ok && se.Name == xrdName {
-				err = d.DecodeElement(&xrd, &se)
-				return xrd, err
-			}
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if se, ok := t.(xml.StartElement); ok && se.Name == xrdName {
+			return d.DecodeElement(xrd, &se)
 		}
 	}
 }
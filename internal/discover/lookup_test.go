@@ -7,6 +7,10 @@ package discover
 import (
 	"context"
 	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"testing"
 
 	"mellium.im/xmpp/jid"
@@ -80,3 +84,78 @@ func TestLookupMethodsDoNotRunIfContextIsDone(t *testing.T) {
 		t.Error("lookupHostMeta should not run if the context is canceled.")
 	}
 }
+
+var hostMetaTestCases = [...]struct {
+	json     string
+	xml      string
+	conntype string
+	out      []string
+}{
+	0: {
+		json:     `{"links":[{"rel":"urn:xmpp:alt-connections:websocket","href":"wss://json.example.net/ws"},{"rel":"urn:xmpp:alt-connections:xbosh","href":"https://json.example.net/bosh"}]}`,
+		xml:      `<XRD xmlns='http://docs.oasis-open.org/ns/xri/xrd-1.0'><Link rel="urn:xmpp:alt-connections:websocket" href="wss://xml.example.net/ws"/></XRD>`,
+		conntype: "ws",
+		out:      []string{"wss://json.example.net/ws"},
+	},
+	1: {
+		xml:      `<?xml version='1.0'?><XRD xmlns='http://docs.oasis-open.org/ns/xri/xrd-1.0'><Link rel="urn:xmpp:alt-connections:websocket" href="wss://xml.example.net/ws"/></XRD>`,
+		conntype: "ws",
+		out:      []string{"wss://xml.example.net/ws"},
+	},
+	2: {
+		json:     `{"links":[{"rel":"urn:xmpp:alt-connections:websocket","href":"wss://json.example.net/ws"},{"rel":"urn:xmpp:alt-connections:xbosh","href":"https://json.example.net/bosh"}]}`,
+		conntype: "bosh",
+		out:      []string{"https://json.example.net/bosh"},
+	},
+}
+
+func TestLookupHostMeta(t *testing.T) {
+	for i, tc := range hostMetaTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body string
+				switch r.URL.Path {
+				case "/.well-known/host-meta.json":
+					body = tc.json
+				case "/.well-known/host-meta":
+					body = tc.xml
+				}
+				if body == "" {
+					http.NotFound(w, r)
+					return
+				}
+				/* #nosec */
+				w.Write([]byte(body))
+			}))
+			defer srv.Close()
+
+			urls, err := lookupHostMeta(context.Background(), srv.Client(), srv.Listener.Addr().String(), tc.conntype)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(urls, tc.out) {
+				t.Errorf("wrong urls: want=%v, got=%v", tc.out, urls)
+			}
+		})
+	}
+}
+
+func TestPrioritize(t *testing.T) {
+	urls := prioritize([]string{
+		"ws://a.example.net/ws",
+		"wss://b.example.net/ws",
+		"ws://a.example.net/ws",
+		"http://c.example.net/bosh",
+		"https://d.example.net/bosh",
+		"wss://b.example.net/ws",
+	})
+	expected := []string{
+		"wss://b.example.net/ws",
+		"https://d.example.net/bosh",
+		"ws://a.example.net/ws",
+		"http://c.example.net/bosh",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("wrong order: want=%v, got=%v", expected, urls)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package fuzz provides entry points for fuzzing the parts of the module that
+// handle untrusted input from the network.
+//
+// Each function has the signature expected by go-fuzz and returns 1 if the
+// input was parsed successfully (and should therefore be given priority when
+// generating new inputs) or 0 otherwise.
+// For example, to fuzz stanza handling run:
+//
+//	go-fuzz-build -func Serve mellium.im/xmpp/internal/fuzz
+//	go-fuzz
+//
+// Any panic is a bug.
+// Because the input is always finite, a call that never returns is also a
+// bug.
+package fuzz // import "mellium.im/xmpp/internal/fuzz"
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+
+	"mellium.im/sasl"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	intstream "mellium.im/xmpp/internal/stream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/stream"
+	"mellium.im/xmpp/xmpptest"
+)
+
+// StreamHeader parses data as the start of an XMPP stream, both as a TCP
+// stream and as a WebSocket framed stream.
+func StreamHeader(data []byte) int {
+	ret := 0
+	for _, ws := range []bool{false, true} {
+		err := intstream.Expect(context.Background(), &stream.Info{}, xml.NewDecoder(bytes.NewReader(data)), false, ws)
+		if err == nil {
+			ret = 1
+		}
+	}
+	return ret
+}
+
+// Features negotiates a client session using data as the input from the
+// server.
+func Features(data []byte) int {
+	location := jid.MustParse("example.net")
+	origin := jid.MustParse("test@example.net")
+	_, err := xmpp.NewSession(context.Background(), location, origin, struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: bytes.NewReader(data),
+		Writer: ioutil.Discard,
+	}, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{
+				xmpp.SASL("", "password", sasl.Plain),
+				xmpp.BindResource(),
+			}
+		},
+	}))
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+// Serve reads data as the body of an established stream and dispatches each
+// stanza to a handler that decodes it.
+func Serve(data []byte) int {
+	ret := 0
+	s := xmpptest.NewSession(0, struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: bytes.NewReader(data),
+		Writer: ioutil.Discard,
+	})
+	err := s.Serve(xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var err error
+		switch start.Name.Local {
+		case "iq":
+			_, err = stanza.NewIQ(*start)
+		case "message":
+			_, err = stanza.NewMessage(*start)
+		case "presence":
+			_, err = stanza.NewPresence(*start)
+		}
+		if err != nil {
+			return err
+		}
+		ret = 1
+		return xmlstream.Skip(t)
+	}))
+	if err != nil {
+		return 0
+	}
+	return ret
+}
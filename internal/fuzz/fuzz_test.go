@@ -0,0 +1,102 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build fuzz
+
+// This tool is meant to exercise the fuzzing entry points with random
+// documents that are likely to contain a high concentration of XMPP syntax
+// without requiring go-fuzz.
+// No care has been taken to try and make this fast, so it is very slow and does
+// not get run with the normal tests.
+
+package fuzz_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/internal/fuzz"
+)
+
+// The strings in this list will be selected with a higher probability than
+// other random runes so that the generated documents are mostly (but not
+// entirely) well formed XMPP.
+var highProbabilityAlphabet = []string{
+	`<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams" version="1.0">`,
+	`<open xmlns="urn:ietf:params:xml:ns:xmpp-framing"/>`,
+	`<stream:features>`, `</stream:features>`,
+	`<mechanisms xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><mechanism>PLAIN</mechanism></mechanisms>`,
+	`<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/>`,
+	`<bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"/>`,
+	`<stream:error>`, `</stream:error>`,
+	`<iq`, `</iq>`, `<message`, `</message>`, `<presence`, `</presence>`,
+	`<body>`, `</body>`, `<error`, `</error>`,
+	` type="get"`, ` type="result"`, ` type="error"`, ` id="1"`, ` from="juliet@example.com"`, ` to="romeo@example.net"`,
+	` xmlns="jabber:client"`, ` xml:lang="en"`,
+	`>`, `/>`, `<`, `</`, `&amp;`, `<![CDATA[`, `]]>`, `<!--`, `-->`, `<?xml version="1.0"?>`,
+	" ", "\n",
+}
+
+const (
+	// The maximum length of generated documents.
+	documentLength = 2048
+
+	// The number of documents to generate.
+	iterations = 1 << 16
+
+	// An ~1/3 chance of selecting something from the high probability alphabet.
+	probabilityOfSyntax = 3
+
+	// The amount of time that any one document may take to process before it is
+	// considered a hang.
+	timeout = 5 * time.Second
+)
+
+func randDoc(size int) []byte {
+	var b bytes.Buffer
+	for b.Len() < size {
+		l := len(highProbabilityAlphabet)
+		choice := rand.Intn(l * probabilityOfSyntax)
+		if choice >= len(highProbabilityAlphabet) {
+			b.WriteRune(rune(rand.Uint32()))
+			continue
+		}
+		b.WriteString(highProbabilityAlphabet[choice])
+	}
+	b.Truncate(size)
+	return b.Bytes()
+}
+
+func TestFuzz(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	for name, f := range map[string]func([]byte) int{
+		"StreamHeader": fuzz.StreamHeader,
+		"Features":     fuzz.Features,
+		"Serve":        fuzz.Serve,
+	} {
+		f := f
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < iterations; i++ {
+				doc := randDoc(rand.Intn(documentLength))
+				done := make(chan interface{})
+				go func() {
+					defer func() {
+						done <- recover()
+					}()
+					f(doc)
+				}()
+				select {
+				case r := <-done:
+					if r != nil {
+						t.Fatalf("Panic recovered %v on input:\n%q", r, doc)
+					}
+				case <-time.After(timeout):
+					t.Fatalf("Timed out processing input:\n%q", doc)
+				}
+			}
+		})
+	}
+}
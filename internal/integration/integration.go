@@ -40,31 +40,33 @@ import (
 type Cmd struct {
 	*exec.Cmd
 
-	name          string
-	cfgDir        string
-	killCtx       context.Context
-	kill          context.CancelFunc
-	cfgF          func() error
-	deferF        func(*Cmd) error
-	stdoutWriter  *testWriter
-	in, out       *testWriter
-	c2sListener   net.Listener
-	s2sListener   net.Listener
-	compListener  net.Listener
-	c2sNetwork    string
-	s2sNetwork    string
-	httpsListener net.Listener
-	httpListener  net.Listener
-	httpsNetwork  string
-	httpNetwork   string
-	compNetwork   string
-	shutdown      func(*Cmd) error
-	user          jid.JID
-	pass          string
-	clientCrt     []byte
-	clientCrtKey  interface{}
-	stdinPipe     io.WriteCloser
-	closed        chan error
+	name              string
+	cfgDir            string
+	killCtx           context.Context
+	kill              context.CancelFunc
+	cfgF              func() error
+	deferF            func(*Cmd) error
+	stdoutWriter      *testWriter
+	in, out           *testWriter
+	c2sListener       net.Listener
+	s2sListener       net.Listener
+	compListener      net.Listener
+	c2sNetwork        string
+	s2sNetwork        string
+	httpsListener     net.Listener
+	httpListener      net.Listener
+	httpsNetwork      string
+	httpNetwork       string
+	compNetwork       string
+	directTLSListener net.Listener
+	directTLSNetwork  string
+	shutdown          func(*Cmd) error
+	user              jid.JID
+	pass              string
+	clientCrt         []byte
+	clientCrtKey      interface{}
+	stdinPipe         io.WriteCloser
+	closed            chan error
 
 	// Config is meant to be used by internal packages like prosody and ejabberd
 	// to store their internal representation of the config before writing it out.
@@ -209,6 +211,24 @@ func (cmd *Cmd) HTTPListen(network, addr string) (net.Listener, error) {
 	return cmd.httpListener, nil
 }
 
+// DirectTLSListen returns a listener with a random port (for c2s connections
+// using direct TLS).
+// The listener is created on the first call to DirectTLSListen.
+// Subsequent calls ignore the arguments and return the existing listener.
+func (cmd *Cmd) DirectTLSListen(network, addr string) (net.Listener, error) {
+	if cmd.directTLSListener != nil {
+		return cmd.directTLSListener, nil
+	}
+
+	var err error
+	cmd.directTLSListener, err = net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	cmd.directTLSNetwork = network
+	return cmd.directTLSListener, nil
+}
+
 // ComponentListen returns a listener with a random port.
 // The listener is created on the first call to ComponentListener.
 // Subsequent calls ignore the arguments and return the existing listener.
@@ -0,0 +1,235 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/websocket"
+)
+
+// Transport is the method used to connect to the server in a cell of a test
+// matrix.
+type Transport uint8
+
+// A list of supported transports.
+const (
+	// StartTLS dials the c2s port and negotiates TLS using STARTTLS.
+	StartTLS Transport = iota
+
+	// DirectTLS dials the direct TLS port (see DirectTLSListen) and negotiates
+	// TLS immediately.
+	DirectTLS
+
+	// WebSocket dials the HTTPS port and uses the WebSocket subprotocol.
+	WebSocket
+)
+
+func (t Transport) String() string {
+	switch t {
+	case StartTLS:
+		return "starttls"
+	case DirectTLS:
+		return "directtls"
+	case WebSocket:
+		return "websocket"
+	}
+	return fmt.Sprintf("transport(%d)", uint8(t))
+}
+
+// Cell is a single combination of transport and TLS options in a test matrix.
+type Cell struct {
+	Transport Transport
+
+	// TLSVersion is the only TLS version that will be negotiated.
+	// If it is zero the defaults from crypto/tls are used.
+	TLSVersion uint16
+
+	// ChannelBinding controls whether only SASL mechanisms that use channel
+	// binding (the -PLUS variants) are used, or only mechanisms that do not.
+	ChannelBinding bool
+}
+
+// Name returns a name for the cell that is suitable for use as the name of a
+// subtest.
+func (c Cell) Name() string {
+	var version string
+	switch c.TLSVersion {
+	case 0:
+		version = "tlsdefault"
+	case tls.VersionTLS12:
+		version = "tls1.2"
+	case tls.VersionTLS13:
+		version = "tls1.3"
+	default:
+		version = fmt.Sprintf("tls%x", c.TLSVersion)
+	}
+	cb := "nocb"
+	if c.ChannelBinding {
+		cb = "cb"
+	}
+	return strings.Join([]string{c.Transport.String(), version, cb}, "/")
+}
+
+// TLSConfig returns a TLS config for connecting to the server under test with
+// the TLS version restricted as configured by the cell.
+func (c Cell) TLSConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName: serverName,
+		/* #nosec */
+		InsecureSkipVerify: true,
+		MinVersion:         c.TLSVersion,
+		MaxVersion:         c.TLSVersion,
+	}
+}
+
+// Mechanisms returns the SASL mechanisms to use for the cell.
+func (c Cell) Mechanisms() []sasl.Mechanism {
+	if c.ChannelBinding {
+		return []sasl.Mechanism{sasl.ScramSha256Plus, sasl.ScramSha1Plus}
+	}
+	return []sasl.Mechanism{sasl.ScramSha256, sasl.ScramSha1, sasl.Plain}
+}
+
+// Matrix returns all combinations of the provided transports, TLS versions, and
+// channel binding options.
+// If any of the lists are empty, a default of StartTLS, TLS 1.2 and 1.3, or
+// both true and false is used respectively.
+func Matrix(transports []Transport, versions []uint16, channelBinding []bool) []Cell {
+	if len(transports) == 0 {
+		transports = []Transport{StartTLS}
+	}
+	if len(versions) == 0 {
+		versions = []uint16{tls.VersionTLS12, tls.VersionTLS13}
+	}
+	if len(channelBinding) == 0 {
+		channelBinding = []bool{false, true}
+	}
+	var cells []Cell
+	for _, t := range transports {
+		for _, v := range versions {
+			for _, cb := range channelBinding {
+				cells = append(cells, Cell{
+					Transport:      t,
+					TLSVersion:     v,
+					ChannelBinding: cb,
+				})
+			}
+		}
+	}
+	return cells
+}
+
+// DialCell connects to the server using the transport and options of the
+// provided cell and negotiates a client-to-server session for the user j,
+// authenticating with pass and binding a resource.
+// Any extra stream features are negotiated after resource binding.
+//
+// If the server was not configured with a listener for the cell's transport,
+// the test is skipped.
+func (cmd *Cmd) DialCell(ctx context.Context, t *testing.T, cell Cell, j jid.JID, pass string, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
+	domain := j.Domainpart()
+	cfg := cell.TLSConfig(domain)
+	features = append([]xmpp.StreamFeature{
+		xmpp.SASL("", pass, cell.Mechanisms()...),
+		xmpp.BindResource(),
+	}, features...)
+
+	var (
+		conn net.Conn
+		ws   bool
+		err  error
+	)
+	switch cell.Transport {
+	case StartTLS:
+		if cmd.c2sListener == nil {
+			t.Skip("c2s not configured")
+		}
+		conn, err = cmd.Conn(ctx, false)
+		features = append([]xmpp.StreamFeature{xmpp.StartTLS(cfg)}, features...)
+	case DirectTLS:
+		if cmd.directTLSListener == nil {
+			t.Skip("direct TLS not configured")
+		}
+		conn, err = net.Dial(cmd.directTLSNetwork, cmd.directTLSListener.Addr().String())
+		if err == nil {
+			tlsConn := tls.Client(conn, cfg)
+			err = tlsConn.Handshake()
+			conn = tlsConn
+		}
+	case WebSocket:
+		if cmd.httpsListener == nil {
+			t.Skip("HTTPS not configured")
+		}
+		ws = true
+		port := cmd.HTTPSPort()
+		d := websocket.Dialer{
+			Origin:    "http://localhost:" + port + "/",
+			TLSConfig: cfg,
+		}
+		conn, err = d.DialDirect(ctx, "wss://localhost:"+port+"/xmpp-websocket")
+	default:
+		return nil, fmt.Errorf("unknown transport %v", cell.Transport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %w", cell.Transport, err)
+	}
+
+	var mask xmpp.SessionState
+	if ws {
+		mask |= xmpp.Secure
+	}
+	session, err := xmpp.NewSession(ctx, j.Domain(), j, conn, mask, xmpp.NewNegotiator(xmpp.StreamConfig{
+		WebSocket: ws,
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return features
+		},
+		TeeIn:  cmd.in,
+		TeeOut: cmd.out,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("error establishing session: %w", err)
+	}
+	return session, nil
+}
+
+// RunMatrix runs f as a subtest for each cell and logs a summary of the
+// results once all cells have run.
+func RunMatrix(ctx context.Context, t *testing.T, cmd *Cmd, cells []Cell, f func(context.Context, *testing.T, *Cmd, Cell)) {
+	var (
+		mu      sync.Mutex
+		results = make([]string, len(cells))
+	)
+	for i, cell := range cells {
+		i, cell := i, cell
+		t.Run(cell.Name(), func(t *testing.T) {
+			defer func() {
+				result := "PASS"
+				switch {
+				case t.Skipped():
+					result = "SKIP"
+				case t.Failed():
+					result = "FAIL"
+				}
+				mu.Lock()
+				results[i] = fmt.Sprintf("%-30s %s", cell.Name(), result)
+				mu.Unlock()
+			}()
+			f(ctx, t, cmd, cell)
+		})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	t.Logf("matrix results:\n%s", strings.Join(results, "\n"))
+}
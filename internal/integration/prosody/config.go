@@ -13,16 +13,17 @@ import (
 
 // Config contains options that can be written to a Prosody config file.
 type Config struct {
-	C2SPort   int
-	S2SPort   int
-	CompPort  int
-	HTTPPort  int
-	HTTPSPort int
-	Admins    []string
-	Modules   []string
-	VHosts    []string
-	Options   map[string]interface{}
-	Component map[string]string
+	C2SPort    int
+	C2STLSPort int
+	S2SPort    int
+	CompPort   int
+	HTTPPort   int
+	HTTPSPort  int
+	Admins     []string
+	Modules    []string
+	VHosts     []string
+	Options    map[string]interface{}
+	Component  map[string]string
 }
 
 const cfgBase = `daemonize = false
@@ -33,6 +34,7 @@ interfaces = { "::1", "127.0.0.1" }
 http_interfaces = { "::1", "127.0.0.1" }
 https_interfaces = { "::1", "127.0.0.1" }
 {{ if .C2SPort }}c2s_ports = { {{ .C2SPort }} }{{ end }}
+{{ if .C2STLSPort }}c2s_direct_tls_ports = { {{ .C2STLSPort }} }{{ end }}
 {{ if .S2SPort }}s2s_ports = { {{ .S2SPort }} }{{ end }}
 {{ if .CompPort }}component_ports = { {{.CompPort}} }{{ end }}
 {{ if .HTTPPort }}http_ports = { {{.HTTPPort}} }{{ end }}
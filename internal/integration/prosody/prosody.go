@@ -124,6 +124,30 @@ func ListenC2S() integration.Option {
 	}
 }
 
+// ListenDirectTLS listens for client-to-server (c2s) connections using direct
+// TLS on a random port.
+// It should normally be combined with ListenC2S since Prosody disables c2s
+// entirely if no c2s port is configured.
+func ListenDirectTLS() integration.Option {
+	return func(cmd *integration.Cmd) error {
+		tlsListener, err := cmd.DirectTLSListen("tcp", "[::1]:0")
+		if err != nil {
+			return err
+		}
+		// See the comment in ListenC2S for why the listener is closed.
+		tlsPort := tlsListener.Addr().(*net.TCPAddr).Port
+		err = tlsListener.Close()
+		if err != nil {
+			return err
+		}
+
+		cfg := getConfig(cmd)
+		cfg.C2STLSPort = tlsPort
+		cmd.Config = cfg
+		return nil
+	}
+}
+
 // ListenS2S listens for server-to-server (s2s) connections on a random port.
 func ListenS2S() integration.Option {
 	return func(cmd *integration.Cmd) error {
@@ -18,6 +18,67 @@ var (
 	ErrUnexpectedRestart    = errors.New("xmpp: unexpected stream restart")
 )
 
+// wellKnownPrefixes maps prefixes that some peers use without declaring them
+// to the namespaces they are conventionally bound to.
+var wellKnownPrefixes = map[string]string{
+	"stream": stream.NS,
+	"db":     "jabber:server:dialback",
+}
+
+// normalizeName replaces undeclared well-known prefixes with their namespace.
+// When the XML decoder encounters a prefix that has not been declared it leaves
+// the prefix in the Space field of the name instead of a namespace.
+func normalizeName(n *xml.Name) {
+	if ns, ok := wellKnownPrefixes[n.Space]; ok {
+		n.Space = ns
+	}
+}
+
+// normalizeStart normalizes the names in a start element and removes namespace
+// declarations.
+// The XML decoder has already resolved all prefixes into namespaces and leaving
+// the declarations around causes the encoder to write them out as attributes
+// in a bogus namespace (eg. xmlns:_xmlns="xmlns" _xmlns:foo="…") which leaks
+// the peer's prefixes into any stanzas that are re-encoded.
+func normalizeStart(start xml.StartElement) xml.StartElement {
+	normalizeName(&start.Name)
+
+	// Most start elements have nothing to remove or rename, so check first and
+	// avoid copying the attributes.
+	var rewrite bool
+	for _, a := range start.Attr {
+		if isDecl(start, a) {
+			rewrite = true
+			break
+		}
+		if _, ok := wellKnownPrefixes[a.Name.Space]; ok {
+			rewrite = true
+			break
+		}
+	}
+	if !rewrite {
+		return start
+	}
+
+	attrs := make([]xml.Attr, 0, len(start.Attr))
+	for _, a := range start.Attr {
+		if isDecl(start, a) {
+			continue
+		}
+		normalizeName(&a.Name)
+		attrs = append(attrs, a)
+	}
+	start.Attr = attrs
+	return start
+}
+
+// isDecl reports whether a is a namespace declaration that should be removed
+// from start.
+func isDecl(start xml.StartElement, a xml.Attr) bool {
+	return a.Name.Space == "xmlns" ||
+		a.Name.Space == "" && a.Name.Local == "xmlns" && start.Name.Space != ""
+}
+
 type reader struct {
 	r xml.TokenReader
 }
@@ -30,6 +91,8 @@ func (r reader) Token() (xml.Token, error) {
 
 	switch t := tok.(type) {
 	case xml.StartElement:
+		t = normalizeStart(t)
+		tok = t
 		if t.Name.Space != stream.NS {
 			return tok, err
 		}
@@ -51,6 +114,8 @@ func (r reader) Token() (xml.Token, error) {
 			return nil, ErrUnknownStreamElement
 		}
 	case xml.EndElement:
+		normalizeName(&t.Name)
+		tok = t
 		if t.Name.Space != stream.NS {
 			return tok, err
 		}
@@ -69,6 +134,9 @@ func (r reader) Token() (xml.Token, error) {
 
 // Reader returns a token reader that handles stream level tokens on an already
 // established stream.
+// Namespace declarations are removed from start elements and well-known
+// prefixes that were used without being declared are replaced with their
+// namespaces.
 func Reader(r xml.TokenReader) xml.TokenReader {
 	return reader{r: r}
 }
@@ -57,7 +57,10 @@ var readerTestCases = [...]struct {
 		err: stream.ErrUnknownStreamElement,
 	},
 	6: {
-		in: `<stream:error/>`,
+		// The stream prefix is well-known so it is accepted even if it is not
+		// declared.
+		in:  `<stream:error/>`,
+		err: streamerr.Error{},
 	},
 	7: {
 		in:  `<stream:error xmlns:stream='http://etherx.jabber.org/streams'/>`,
@@ -152,3 +155,33 @@ func TestDisallowedTokenType(t *testing.T) {
 		t.Errorf("expected unknown token type to be passed through: want=%#v, got=%#v", comment, tok)
 	}
 }
+
+func TestNormalizePrefixes(t *testing.T) {
+	const in = `<message xmlns='jabber:client' xmlns:foo='urn:example'><foo:bar foo:attr='x'/><db:result from='example.net'/></message>`
+	r := stream.Reader(xml.NewDecoder(strings.NewReader(in)))
+	var names []xml.Name
+	for {
+		tok, err := r.Token()
+		if err != nil {
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		names = append(names, start.Name)
+		for _, a := range start.Attr {
+			if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+				t.Errorf("namespace declaration %v was not removed from %v", a.Name, start.Name)
+			}
+		}
+	}
+	expected := []xml.Name{
+		{Space: "jabber:client", Local: "message"},
+		{Space: "urn:example", Local: "bar"},
+		{Space: "jabber:server:dialback", Local: "result"},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong names: want=%v, got=%v", expected, names)
+	}
+}
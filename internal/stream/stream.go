@@ -35,6 +35,7 @@ func Send(rw io.ReadWriter, streamData *stream.Info, s2s, ws bool, version strea
 	}
 
 	streamData.ID = id
+	streamData.Lang = lang
 	b := bufio.NewWriter(rw)
 	var err error
 	if ws {
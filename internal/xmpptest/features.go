@@ -15,6 +15,7 @@ import (
 	"testing"
 
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/xmpptest"
 )
 
 type testWriter struct {
@@ -66,7 +67,7 @@ func RunFeatureTests(t *testing.T, tcs []FeatureTestCase) {
 			}
 
 			buf.Reset()
-			s := NewSession(tc.State, struct {
+			s := xmpptest.NewSession(tc.State, struct {
 				io.Reader
 				io.Writer
 			}{
@@ -0,0 +1,164 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/ns"
+)
+
+// UpdateGoldenEnv is the name of an environment variable that causes
+// RunGoldenTests to overwrite golden files with the current output instead of
+// comparing against them when it is set to a non-empty value.
+const UpdateGoldenEnv = "XMPPTEST_UPDATE_GOLDEN"
+
+// RunGoldenTests checks that examples from the specification implemented by a
+// package survive a round trip through the package's types.
+//
+// Each file in dir with the extension ".xml" must contain a single example
+// element.
+// For every example, newValue is called with the base name of the file (without
+// the extension) and must return a pointer to the value that the example should
+// be unmarshaled into, or nil if the example is not supported in which case the
+// test fails.
+// The value is then marshaled again and the result must be semantically
+// equivalent to the original example (ignoring namespace prefixes, attribute
+// order, insignificant whitespace, and whether or not empty elements are
+// self-closing).
+// The exact output is also compared against the file with the same name and the
+// extension ".golden" so that any change to the byte-level encoding is caught.
+// To create or update the golden files, run the tests with the environment
+// variable named by UpdateGoldenEnv set.
+//
+// Examples in the specifications often omit the stream namespace on stanzas, so
+// elements without a namespace are assumed to be in the jabber:client
+// namespace.
+func RunGoldenTests(t *testing.T, dir string, newValue func(name string) interface{}) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		t.Fatalf("error finding examples: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no examples found in %s", dir)
+	}
+	update := os.Getenv(UpdateGoldenEnv) != ""
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".xml")
+		t.Run(name, func(t *testing.T) {
+			example, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatalf("error reading example: %v", err)
+			}
+			v := newValue(name)
+			if v == nil {
+				t.Fatalf("no value provided for example %s", name)
+			}
+			err = xml.NewDecoder(bytes.NewReader(example)).Decode(v)
+			if err != nil {
+				t.Fatalf("error unmarshaling example: %v", err)
+			}
+			out, err := encodeGolden(v)
+			if err != nil {
+				t.Fatalf("error marshaling example: %v", err)
+			}
+
+			want, err := canonical(example)
+			if err != nil {
+				t.Fatalf("error parsing example: %v", err)
+			}
+			got, err := canonical(out)
+			if err != nil {
+				t.Fatalf("error parsing output: %v", err)
+			}
+			if want != got {
+				t.Errorf("round trip not equivalent to example:\nwant=%s\n got=%s", want, got)
+			}
+
+			goldenFile := strings.TrimSuffix(file, ".xml") + ".golden"
+			if update {
+				err = ioutil.WriteFile(goldenFile, out, 0644)
+				if err != nil {
+					t.Fatalf("error updating golden file: %v", err)
+				}
+				return
+			}
+			golden, err := ioutil.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("error reading golden file (set %s to create it): %v", UpdateGoldenEnv, err)
+			}
+			if !bytes.Equal(golden, out) {
+				t.Errorf("output does not match golden file:\nwant=%s\n got=%s", golden, out)
+			}
+		})
+	}
+}
+
+func encodeGolden(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	var err error
+	switch m := v.(type) {
+	case xmlstream.Marshaler:
+		_, err = xmlstream.Copy(e, m.TokenReader())
+	default:
+		err = e.Encode(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = e.Flush()
+	return buf.Bytes(), err
+}
+
+// canonical returns a representation of the XML in b that only contains the
+// information that must survive a round trip.
+func canonical(b []byte) (string, error) {
+	var out strings.Builder
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			space := t.Name.Space
+			if space == "" {
+				space = ns.Client
+			}
+			fmt.Fprintf(&out, "<{%s}%s", space, t.Name.Local)
+			var attrs []string
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+					continue
+				}
+				attrs = append(attrs, fmt.Sprintf(" {%s}%s=%q", a.Name.Space, a.Name.Local, a.Value))
+			}
+			sort.Strings(attrs)
+			out.WriteString(strings.Join(attrs, ""))
+			out.WriteString(">")
+		case xml.EndElement:
+			out.WriteString("</>")
+		case xml.CharData:
+			out.Write(bytes.TrimSpace(t))
+		}
+	}
+	return out.String(), nil
+}
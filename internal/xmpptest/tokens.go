@@ -2,7 +2,11 @@
 // Use of this source code is governed by the BSD 2-clause
 // license that can be found in the LICENSE file.
 
-package xmpptest
+// Package xmpptest provides utilities for testing this module that are not
+// useful outside of it.
+// Helpers for testing code that uses this module are found in the public
+// mellium.im/xmpp/xmpptest package.
+package xmpptest // import "mellium.im/xmpp/internal/xmpptest"
 
 import (
 	"encoding/xml"
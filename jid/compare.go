@@ -0,0 +1,135 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jid
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Compare returns an integer comparing two JIDs.
+// The result will be 0 if a and b are equal, -1 if a < b, and +1 if a > b.
+//
+// JIDs are ordered by their domainpart, then their localpart, then their
+// resourcepart so that sorted lists keep the JIDs for each service and each
+// account together.
+// Parts are compared octet-for-octet in their canonical form, and a missing
+// part sorts before any other value.
+// Compare does not allocate.
+func Compare(a, b JID) int {
+	if c := bytes.Compare(a.domain(), b.domain()); c != 0 {
+		return c
+	}
+	if c := bytes.Compare(a.data[:a.locallen], b.data[:b.locallen]); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.data[a.locallen+a.domainlen:], b.data[b.locallen+b.domainlen:])
+}
+
+// Sort sorts a slice of JIDs in increasing order as defined by Compare.
+func Sort(j []JID) {
+	sort.Sort(Slice(j))
+}
+
+// Slice attaches the methods of sort.Interface to []JID, sorting in increasing
+// order as defined by Compare.
+type Slice []JID
+
+func (s Slice) Len() int           { return len(s) }
+func (s Slice) Less(i, j int) bool { return Compare(s[i], s[j]) < 0 }
+func (s Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Search searches for j in a slice sorted by Sort and returns the index at
+// which it was found, or the index at which it would be inserted if it is not
+// present.
+func (s Slice) Search(j JID) int {
+	return sort.Search(len(s), func(i int) bool {
+		return Compare(s[i], j) >= 0
+	})
+}
+
+// EqualString reports whether j is equal to the JID represented by s.
+//
+// If s is already in canonical form the comparison does not allocate, making
+// it suitable for comparing JIDs against attribute values in hot paths.
+// Otherwise s is parsed and normalized before being compared, and if s is not
+// a valid JID EqualString returns false.
+// Domainparts are compared without regard to case, as with ParseLenient.
+func (j JID) EqualString(s string) bool {
+	if j.equalCanonical(s) {
+		return true
+	}
+	if !mayNeedNormalization(s) {
+		return false
+	}
+	j2, err := Parse(s)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(j.data[:j.locallen], j2.data[:j2.locallen]) &&
+		bytes.EqualFold(j.domain(), j2.domain()) &&
+		bytes.Equal(j.data[j.locallen+j.domainlen:], j2.data[j2.locallen+j2.domainlen:])
+}
+
+func (j JID) domain() []byte {
+	return j.data[j.locallen : j.locallen+j.domainlen]
+}
+
+// equalCanonical compares j to s byte for byte without allocating.
+func (j JID) equalCanonical(s string) bool {
+	if j.locallen > 0 {
+		local := j.data[:j.locallen]
+		if len(s) <= len(local) || s[len(local)] != '@' || string(local) != s[:len(local)] {
+			return false
+		}
+		s = s[len(local)+1:]
+	}
+	domain := j.domain()
+	if len(s) < len(domain) || !asciiEqualFold(domain, s[:len(domain)]) {
+		return false
+	}
+	s = s[len(domain):]
+	resource := j.data[j.locallen+j.domainlen:]
+	if len(resource) == 0 {
+		return s == ""
+	}
+	return len(s) == len(resource)+1 && s[0] == '/' && string(resource) == s[1:]
+}
+
+// asciiEqualFold reports whether b and s are equal, ignoring the case of ASCII
+// letters, without allocating.
+func asciiEqualFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := 0; i < len(b); i++ {
+		c1, c2 := b[i], s[i]
+		if 'A' <= c1 && c1 <= 'Z' {
+			c1 += 'a' - 'A'
+		}
+		if 'A' <= c2 && c2 <= 'Z' {
+			c2 += 'a' - 'A'
+		}
+		if c1 != c2 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayNeedNormalization reports whether s could be a non-canonical
+// representation of a JID that would compare differently after parsing.
+func mayNeedNormalization(s string) bool {
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b >= utf8.RuneSelf || ('A' <= b && b <= 'Z') {
+			return true
+		}
+	}
+	// A domainpart with a trailing dot is normalized by removing the dot.
+	return strings.HasSuffix(s, ".") || strings.Contains(s, "./")
+}
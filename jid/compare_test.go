@@ -0,0 +1,112 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jid_test
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/jid"
+)
+
+var _ sort.Interface = jid.Slice{}
+
+var compareTestCases = [...]struct {
+	a, b string
+	cmp  int
+}{
+	0: {"example.net", "example.net", 0},
+	1: {"a@example.net", "b@example.net", -1},
+	2: {"b@example.net", "a@example.com", 1},
+	3: {"example.net", "a@example.net", -1},
+	4: {"a@example.net", "a@example.net/res", -1},
+	5: {"a@example.net/b", "a@example.net/a", 1},
+	6: {"z@a.example", "a@b.example", -1},
+}
+
+func TestCompare(t *testing.T) {
+	for i, tc := range compareTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, b := jid.MustParse(tc.a), jid.MustParse(tc.b)
+			if cmp := jid.Compare(a, b); cmp != tc.cmp {
+				t.Errorf("wrong comparison: want=%d, got=%d", tc.cmp, cmp)
+			}
+			if cmp := jid.Compare(b, a); cmp != -tc.cmp {
+				t.Errorf("wrong reverse comparison: want=%d, got=%d", -tc.cmp, cmp)
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	jids := []jid.JID{
+		jid.MustParse("b@example.net/res"),
+		jid.MustParse("example.com"),
+		jid.MustParse("b@example.net"),
+		jid.MustParse("a@example.net"),
+		jid.MustParse("example.net"),
+	}
+	jid.Sort(jids)
+	expected := []string{"example.com", "example.net", "a@example.net", "b@example.net", "b@example.net/res"}
+	for i, j := range jids {
+		if s := j.String(); s != expected[i] {
+			t.Errorf("wrong JID at %d: want=%s, got=%s", i, expected[i], s)
+		}
+	}
+
+	s := jid.Slice(jids)
+	if idx := s.Search(jid.MustParse("b@example.net")); idx != 3 {
+		t.Errorf("wrong index for existing JID: want=3, got=%d", idx)
+	}
+	if idx := s.Search(jid.MustParse("c@example.net")); idx != 5 {
+		t.Errorf("wrong index for missing JID: want=5, got=%d", idx)
+	}
+}
+
+var equalStringTestCases = [...]struct {
+	j     string
+	s     string
+	equal bool
+}{
+	0:  {j: "example.net", s: "example.net", equal: true},
+	1:  {j: "me@example.net", s: "me@example.net", equal: true},
+	2:  {j: "me@example.net/res", s: "me@example.net/res", equal: true},
+	3:  {j: "me@example.net", s: "me@example.net/res"},
+	4:  {j: "me@example.net/res", s: "me@example.net"},
+	5:  {j: "me@example.net", s: "Me@Example.Net", equal: true},
+	6:  {j: "me@example.net", s: "me@example.net.", equal: true},
+	7:  {j: "me@example.net/Res", s: "me@example.net/res"},
+	8:  {j: "me@example.net", s: "you@example.net"},
+	9:  {j: "me@example.net", s: "example.net"},
+	10: {j: "example.net", s: "me@example.net"},
+	11: {j: "me@example.net", s: "@@"},
+	12: {j: "me@example.net", s: ""},
+	13: {j: "me@example.net", s: "me@EXAMPLE.net", equal: true},
+	14: {j: "me@example.net/res", s: "Me@example.net/Res"},
+}
+
+func TestEqualString(t *testing.T) {
+	for i, tc := range equalStringTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			j := jid.MustParse(tc.j)
+			if eq := j.EqualString(tc.s); eq != tc.equal {
+				t.Errorf("unexpected result comparing %s to %q: want=%t, got=%t", tc.j, tc.s, tc.equal, eq)
+			}
+		})
+	}
+}
+
+func TestCompareMallocs(t *testing.T) {
+	a := jid.MustParse("olivia@example.net/ilyria")
+	b := jid.MustParse("viola@example.net/ilyria")
+	n := testing.AllocsPerRun(1000, func() {
+		_ = jid.Compare(a, b)
+		_ = a.EqualString("olivia@example.net/ilyria")
+	})
+	if n != 0 {
+		t.Errorf("got %f allocs, want 0", n)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jid
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errBinaryLen = errors.New("jid: invalid binary encoding length")
+
+// MarshalText satisfies the encoding.TextMarshaler interface and returns the
+// string representation of the JID.
+func (j JID) MarshalText() ([]byte, error) {
+	return []byte(j.String()), nil
+}
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface and parses
+// text as a JID.
+// Empty text results in the zero value.
+func (j *JID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*j = JID{}
+		return nil
+	}
+	jid, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*j = jid
+	return nil
+}
+
+// MarshalBinary satisfies the encoding.BinaryMarshaler interface.
+// The encoding is a compact form of the JID that avoids reparsing its string
+// representation to find the individual parts, and is only intended to be
+// decoded by UnmarshalBinary.
+func (j JID) MarshalBinary() ([]byte, error) {
+	if len(j.data) == 0 {
+		return []byte{}, nil
+	}
+	b := make([]byte, 2*binary.MaxVarintLen64+len(j.data))
+	n := binary.PutUvarint(b, uint64(j.locallen))
+	n += binary.PutUvarint(b[n:], uint64(j.domainlen))
+	n += copy(b[n:], j.data)
+	return b[:n], nil
+}
+
+// UnmarshalBinary satisfies the encoding.BinaryUnmarshaler interface.
+// Because the data may come from an untrusted source, each part of the JID is
+// validated as if it were being constructed with New.
+func (j *JID) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		*j = JID{}
+		return nil
+	}
+	locallen, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errBinaryLen
+	}
+	data = data[n:]
+	domainlen, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errBinaryLen
+	}
+	data = data[n:]
+	if locallen > uint64(len(data)) || domainlen > uint64(len(data))-locallen {
+		return errBinaryLen
+	}
+	jid, err := New(
+		string(data[:locallen]),
+		string(data[locallen:locallen+domainlen]),
+		string(data[locallen+domainlen:]),
+	)
+	if err != nil {
+		return err
+	}
+	*j = jid
+	return nil
+}
+
+// Scan satisfies the sql.Scanner interface so that JIDs can be read directly
+// from database columns containing their string representation.
+// A NULL value results in the zero value.
+func (j *JID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = JID{}
+		return nil
+	case string:
+		return j.UnmarshalText([]byte(v))
+	case []byte:
+		return j.UnmarshalText(v)
+	}
+	return fmt.Errorf("jid: cannot scan value of type %T into JID", src)
+}
+
+// Value satisfies the driver.Valuer interface and returns the string
+// representation of the JID, or NULL if it is the zero value.
+func (j JID) Value() (driver.Value, error) {
+	if len(j.data) == 0 {
+		return nil, nil
+	}
+	return j.String(), nil
+}
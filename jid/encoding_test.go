@@ -0,0 +1,136 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jid_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/jid"
+)
+
+var (
+	_ encoding.TextMarshaler     = jid.JID{}
+	_ encoding.TextUnmarshaler   = (*jid.JID)(nil)
+	_ encoding.BinaryMarshaler   = jid.JID{}
+	_ encoding.BinaryUnmarshaler = (*jid.JID)(nil)
+	_ sql.Scanner                = (*jid.JID)(nil)
+	_ driver.Valuer              = jid.JID{}
+)
+
+var encodingTestCases = [...]string{
+	0: "",
+	1: "example.net",
+	2: "me@example.net",
+	3: "me@example.net/res",
+	4: "example.net/res@res",
+	5: "[::1]",
+}
+
+func TestJSON(t *testing.T) {
+	for i, tc := range encodingTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var j jid.JID
+			if tc != "" {
+				j = jid.MustParse(tc)
+			}
+			b, err := json.Marshal(struct{ J jid.JID }{J: j})
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			if expected := `{"J":` + strconv.Quote(tc) + `}`; string(b) != expected {
+				t.Errorf("wrong JSON: want=%s, got=%s", expected, b)
+			}
+			var v struct{ J jid.JID }
+			err = json.Unmarshal(b, &v)
+			if err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			if !v.J.Equal(j) {
+				t.Errorf("JIDs not equal after round trip: want=%v, got=%v", j, v.J)
+			}
+		})
+	}
+}
+
+func TestBinary(t *testing.T) {
+	for i, tc := range encodingTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var j jid.JID
+			if tc != "" {
+				j = jid.MustParse(tc)
+			}
+			b, err := j.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			var j2 jid.JID
+			err = j2.UnmarshalBinary(b)
+			if err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			if !j2.Equal(j) {
+				t.Errorf("JIDs not equal after round trip: want=%v, got=%v", j, j2)
+			}
+		})
+	}
+}
+
+func TestBinaryInvalid(t *testing.T) {
+	for i, tc := range [...][]byte{
+		0: {0x80},
+		1: {0x01},
+		2: {0x05, 0x01, 'a'},
+		3: {0x00, 0x05, 'a', 'b'},
+		4: {0x01, 0x00, 'a'},
+		5: {0x01, 0x01, '@', 'a'},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var j jid.JID
+			if err := j.UnmarshalBinary(tc); err == nil {
+				t.Errorf("expected error unmarshaling invalid data %v", tc)
+			}
+		})
+	}
+}
+
+func TestSQL(t *testing.T) {
+	j := jid.MustParse("me@example.net/res")
+	v, err := j.Value()
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if v != "me@example.net/res" {
+		t.Errorf("wrong value: want=%q, got=%v", "me@example.net/res", v)
+	}
+	v, err = jid.JID{}.Value()
+	if err != nil || v != nil {
+		t.Errorf("expected nil value for zero JID, got %v, %v", v, err)
+	}
+
+	for i, src := range [...]interface{}{
+		0: "me@example.net/res",
+		1: []byte("me@example.net/res"),
+	} {
+		var j2 jid.JID
+		if err := j2.Scan(src); err != nil {
+			t.Errorf("%d: error scanning: %v", i, err)
+		}
+		if !j2.Equal(j) {
+			t.Errorf("%d: wrong JID scanned: want=%v, got=%v", i, j, j2)
+		}
+	}
+	j2 := j
+	if err := j2.Scan(nil); err != nil || !j2.Equal(jid.JID{}) {
+		t.Errorf("expected scanning NULL to result in the zero JID, got %v, %v", j2, err)
+	}
+	if err := j2.Scan(123); err == nil {
+		t.Errorf("expected error scanning an int")
+	}
+}
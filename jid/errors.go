@@ -0,0 +1,68 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jid
+
+import (
+	"errors"
+)
+
+// Errors that can be compared against errors returned when constructing a JID
+// using errors.Is to find out which part of the JID was invalid.
+var (
+	ErrInvalidLocalpart    = errors.New("jid: invalid localpart")
+	ErrInvalidDomainpart   = errors.New("jid: invalid domainpart")
+	ErrInvalidResourcepart = errors.New("jid: invalid resourcepart")
+)
+
+// PartError is returned when a JID cannot be constructed because one of its
+// parts is invalid.
+// Part is one of ErrInvalidLocalpart, ErrInvalidDomainpart, or
+// ErrInvalidResourcepart and Err is the specific reason that the part was
+// rejected (for example, an error from the PRECIS profile or a length check).
+type PartError struct {
+	Part error
+	Err  error
+}
+
+// Error satisfies the error interface by returning the text of the underlying
+// error.
+func (e *PartError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *PartError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the part of the JID that was invalid, or is
+// another *PartError for the same part and reason.
+func (e *PartError) Is(target error) bool {
+	if t, ok := target.(*PartError); ok {
+		return t.Part == e.Part && errors.Is(e.Err, t.Err)
+	}
+	return target == e.Part
+}
+
+func localErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PartError{Part: ErrInvalidLocalpart, Err: err}
+}
+
+func domainErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PartError{Part: ErrInvalidDomainpart, Err: err}
+}
+
+func resourceErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PartError{Part: ErrInvalidResourcepart, Err: err}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jid_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp/jid"
+)
+
+var partErrorTestCases = [...]struct {
+	in  string
+	err error
+}{
+	0: {in: "@example.net", err: jid.ErrInvalidLocalpart},
+	1: {in: strings.Repeat("a", 1024) + "@example.net", err: jid.ErrInvalidLocalpart},
+	2: {in: "a\"b@example.net", err: jid.ErrInvalidLocalpart},
+	3: {in: "", err: jid.ErrInvalidDomainpart},
+	4: {in: "me@[127.0.0.1]", err: jid.ErrInvalidDomainpart},
+	5: {in: "me@example.net/", err: jid.ErrInvalidResourcepart},
+	6: {in: "me@example.net/" + strings.Repeat("a", 1024), err: jid.ErrInvalidResourcepart},
+	7: {in: "me@example.net/\u0000", err: jid.ErrInvalidResourcepart},
+}
+
+func TestPartError(t *testing.T) {
+	for i, tc := range partErrorTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := jid.Parse(tc.in)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			var partErr *jid.PartError
+			if !errors.As(err, &partErr) {
+				t.Fatalf("expected error to be a *PartError, got %T", err)
+			}
+			if partErr.Part != tc.err {
+				t.Errorf("wrong part: want=%v, got=%v", tc.err, partErr.Part)
+			}
+			_, again := jid.Parse(tc.in)
+			if !errors.Is(err, again) {
+				t.Errorf("expected errors from parsing the same JID to match: %v, %v", err, again)
+			}
+		})
+	}
+}
+
+var lenientTestCases = [...]struct {
+	in  string
+	out string
+	err error
+}{
+	0: {in: "me@example.net", out: "me@example.net"},
+	1: {in: "  me@example.net\t", out: "me@example.net"},
+	2: {in: "xmpp:me@example.net", out: "me@example.net"},
+	3: {in: "XMPP:me@example.net?message", out: "me@example.net"},
+	4: {in: "me＠example.net／res", out: "me@example.net/res"},
+	5: {in: "me@EXAMPLE.net", out: "me@example.net"},
+	6: {in: "me@example.net/", out: "me@example.net"},
+	7: {in: "@example.net", err: jid.ErrInvalidLocalpart},
+	8: {in: "me@example.net/" + strings.Repeat("a", 1024), err: jid.ErrInvalidResourcepart},
+	9: {in: "xmpp:", err: jid.ErrInvalidDomainpart},
+}
+
+func TestParseLenient(t *testing.T) {
+	for i, tc := range lenientTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			j, err := jid.ParseLenient(tc.in)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			if out := j.String(); out != tc.out {
+				t.Errorf("wrong output: want=%q, got=%q", tc.out, out)
+			}
+		})
+	}
+}
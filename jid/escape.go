@@ -6,10 +6,14 @@ package jid
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 
 	"golang.org/x/text/transform"
 )
 
+var errEscapedSpace = errors.New("the localpart must not begin or end with a space")
+
 // Transformer implements the transform.Transformer and
 // transform.SpanningTransformer interfaces.
 //
@@ -56,6 +60,31 @@ var (
 	Unescape Transformer = Transformer{unescapeMapping{}}
 )
 
+// NewEscaped is like New except that the localpart is escaped using the
+// Escape transformer before the JID is constructed.
+// This lets gateways map identifiers from other networks (such as email
+// addresses or phone numbers) that contain characters which are not allowed in
+// a localpart into valid JIDs.
+// The original identifier can be recovered with UnescapedLocalpart, though
+// any uppercase letters will have been mapped to lowercase as with any other
+// localpart.
+//
+// As required by XEP-0106: JID Escaping, localparts that begin or end with a
+// space are not allowed.
+func NewEscaped(localpart, domainpart, resourcepart string) (JID, error) {
+	if strings.HasPrefix(localpart, " ") || strings.HasSuffix(localpart, " ") {
+		return JID{}, localErr(errEscapedSpace)
+	}
+	return New(Escape.String(localpart), domainpart, resourcepart)
+}
+
+// UnescapedLocalpart returns the localpart of the JID with any escape sequences
+// mapped back to their original characters using the Unescape transformer.
+// It is the inverse of NewEscaped.
+func (j JID) UnescapedLocalpart() string {
+	return Unescape.String(j.Localpart())
+}
+
 const escape = ` "&'/:<>@\`
 
 type escapeMapping struct {
@@ -86,20 +115,15 @@ func (t escapeMapping) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, e
 			n := copy(dst[nDst:], src[nSrc:nSrc+idx])
 			nDst += n
 			nSrc += n
-			if n != idx-nSrc {
+			if n != idx || len(dst)-nDst < 3 {
 				return nDst, nSrc, transform.ErrShortDst
 			}
 			c := src[nSrc]
-			n = copy(dst[nDst:], []byte{
-				'\\',
-				"0123456789abcdef"[c>>4],
-				"0123456789abcdef"[c&15],
-			})
-			nDst += n
+			dst[nDst] = '\\'
+			dst[nDst+1] = "0123456789abcdef"[c>>4]
+			dst[nDst+2] = "0123456789abcdef"[c&15]
+			nDst += 3
 			nSrc++
-			if n != 3 {
-				return nDst, nSrc, transform.ErrShortDst
-			}
 		}
 	}
 	return
@@ -220,20 +244,14 @@ func (t unescapeMapping) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int,
 			n := copy(dst[nDst:], src[nSrc:nSrc+idx])
 			nDst += n
 			nSrc += n
-			if n != idx {
+			if n != idx || nDst == len(dst) {
 				return nDst, nSrc, transform.ErrShortDst
 			}
-			if n == 0 {
-				n++
-			}
-			n = copy(dst[nDst:], []byte{
-				unhex(src[nSrc+n])<<4 | unhex(src[nSrc+n+1]),
-			})
-			nDst += n
+			// src[nSrc] is the escape character and the next two bytes are the hex
+			// encoded value.
+			dst[nDst] = unhex(src[nSrc+1])<<4 | unhex(src[nSrc+2])
+			nDst++
 			nSrc += 3
-			if n != 1 {
-				return nDst, nSrc, transform.ErrShortDst
-			}
 			continue
 		}
 		n := copy(dst[nDst:], src[nSrc:nSrc+idx+1])
@@ -28,6 +28,7 @@ var escapeTestCases = [...]struct {
 	4: {"", "", true, 0, nil, nil},
 	5: {"", "", false, 0, nil, nil},
 	6: {`a `, `a\20`, true, 1, nil, transform.ErrEndOfSpan},
+	7: {`ab@cd`, `ab\40cd`, true, 2, nil, transform.ErrEndOfSpan},
 }
 
 var unescapeTestCases = [...]struct {
@@ -45,6 +46,7 @@ var unescapeTestCases = [...]struct {
 	6: {`a\a\20`, `a\a `, false, 3, nil, transform.ErrEndOfSpan},
 	7: {`aa\2`, `aa\2`, true, 4, nil, nil},
 	8: {`aa\2`, `aa`, false, 2, transform.ErrShortSrc, transform.ErrShortSrc},
+	9: {`ab\40cd\20e`, `ab@cd e`, true, 2, nil, transform.ErrEndOfSpan},
 }
 
 func TestUnescape(t *testing.T) {
@@ -126,3 +128,37 @@ func TestUnescapeMallocs(t *testing.T) {
 		t.Errorf("got %f allocs, want 0", n)
 	}
 }
+
+func TestNewEscaped(t *testing.T) {
+	for i, tc := range [...]struct {
+		local   string
+		escaped string
+		err     bool
+	}{
+		0: {local: "d'artagnan", escaped: `d\27artagnan`},
+		1: {local: "user@host", escaped: `user\40host`},
+		2: {local: "+1 555 0100", escaped: `+1\20555\200100`},
+		3: {local: "c:\\net", escaped: `c\3a\5cnet`},
+		4: {local: " space", err: true},
+		5: {local: "space ", err: true},
+		6: {local: "nothingtodohere", escaped: "nothingtodohere"},
+	} {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			j, err := NewEscaped(tc.local, "example.net", "")
+			switch {
+			case tc.err && err == nil:
+				t.Fatalf("expected error escaping %q", tc.local)
+			case !tc.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.err:
+				return
+			}
+			if local := j.Localpart(); local != tc.escaped {
+				t.Errorf("wrong escaped localpart: want=%q, got=%q", tc.escaped, local)
+			}
+			if local := j.UnescapedLocalpart(); local != tc.local {
+				t.Errorf("wrong unescaped localpart: want=%q, got=%q", tc.local, local)
+			}
+		})
+	}
+}
@@ -68,8 +68,11 @@ func New(localpart, domainpart, resourcepart string) (JID, error) {
 	// Ensure that parts are valid UTF-8 (and short circuit the rest of the
 	// process if they're not). We'll check the domainpart after performing
 	// the IDNA ToUnicode operation.
-	if !utf8.ValidString(localpart) || !utf8.ValidString(resourcepart) {
-		return JID{}, errInvalidUTF8
+	if !utf8.ValidString(localpart) {
+		return JID{}, localErr(errInvalidUTF8)
+	}
+	if !utf8.ValidString(resourcepart) {
+		return JID{}, resourceErr(errInvalidUTF8)
 	}
 
 	// RFC 7622 §3.2.1.  Preparation
@@ -84,11 +87,11 @@ func New(localpart, domainpart, resourcepart string) (JID, error) {
 	var err error
 	domainpart, err = idna.ToUnicode(domainpart)
 	if err != nil {
-		return JID{}, err
+		return JID{}, domainErr(err)
 	}
 
 	if !utf8.ValidString(domainpart) {
-		return JID{}, errInvalidUTF8
+		return JID{}, domainErr(errInvalidUTF8)
 	}
 
 	// RFC 7622 §3.2.2.  Enforcement
@@ -107,7 +110,7 @@ func New(localpart, domainpart, resourcepart string) (JID, error) {
 	if localpart != "" {
 		data, err = precis.UsernameCaseMapped.Append(data, []byte(localpart))
 		if err != nil {
-			return JID{}, err
+			return JID{}, localErr(err)
 		}
 		lenlocal = len(data)
 	}
@@ -117,7 +120,7 @@ func New(localpart, domainpart, resourcepart string) (JID, error) {
 	if resourcepart != "" {
 		data, err = precis.OpaqueString.Append(data, []byte(resourcepart))
 		if err != nil {
-			return JID{}, err
+			return JID{}, resourceErr(err)
 		}
 	}
 
@@ -139,11 +142,11 @@ func (j JID) WithLocal(localpart string) (JID, error) {
 	data := make([]byte, 0, len(localpart)+len(j.data[j.locallen:]))
 	if localpart != "" {
 		if !utf8.ValidString(localpart) {
-			return j, errInvalidUTF8
+			return j, localErr(errInvalidUTF8)
 		}
 		data, err = precis.UsernameCaseMapped.Append(data, []byte(localpart))
 		if err != nil {
-			return j, err
+			return j, localErr(err)
 		}
 	}
 	ll := len(data)
@@ -154,7 +157,7 @@ func (j JID) WithLocal(localpart string) (JID, error) {
 
 	j.locallen = ll
 	j.data = data
-	return j, localChecks(data[:ll])
+	return j, localErr(localChecks(data[:ll]))
 }
 
 // WithDomain returns a copy of the JID with a new domainpart.
@@ -162,14 +165,14 @@ func (j JID) WithLocal(localpart string) (JID, error) {
 func (j JID) WithDomain(domainpart string) (JID, error) {
 	err := domainChecks(domainpart)
 	if err != nil {
-		return j, err
+		return j, domainErr(err)
 	}
 	domainpart, err = idna.ToUnicode(domainpart)
 	if err != nil {
-		return j, err
+		return j, domainErr(err)
 	}
 	if !utf8.ValidString(domainpart) {
-		return j, errInvalidUTF8
+		return j, domainErr(errInvalidUTF8)
 	}
 
 	dl := len(domainpart)
@@ -180,7 +183,7 @@ func (j JID) WithDomain(domainpart string) (JID, error) {
 
 	j.domainlen = dl
 	j.data = data
-	return j, domainChecks(domainpart)
+	return j, domainErr(domainChecks(domainpart))
 }
 
 // WithResource returns a copy of the JID with a new resourcepart.
@@ -192,15 +195,15 @@ func (j JID) WithResource(resourcepart string) (JID, error) {
 	copy(data, new.data)
 	if resourcepart != "" {
 		if !utf8.ValidString(resourcepart) {
-			return JID{}, errInvalidUTF8
+			return JID{}, resourceErr(errInvalidUTF8)
 		}
 		data, err = precis.OpaqueString.Append(data, []byte(resourcepart))
 		if err != nil {
-			return JID{}, err
+			return JID{}, resourceErr(err)
 		}
 		new.data = data
 	}
-	return new, resourceChecks(data[j.locallen+j.domainlen:])
+	return new, resourceErr(resourceChecks(data[j.locallen+j.domainlen:]))
 }
 
 // Bare returns a copy of the JID without a resourcepart. This is sometimes
@@ -361,7 +364,7 @@ func splitString(s string, safe bool) (localpart, domainpart, resourcepart strin
 	} else {
 		// If the resource part exists, make sure it isn't empty.
 		if safe && sep == len(s)-1 {
-			err = errNoResourcepart
+			err = resourceErr(errNoResourcepart)
 			return
 		}
 		resourcepart = s[sep+1:]
@@ -380,7 +383,7 @@ func splitString(s string, safe bool) (localpart, domainpart, resourcepart strin
 		domainpart = s
 	case safe && sep == 0:
 		// The JID starts with an @ sign (invalid empty localpart)
-		err = errNoLocalpart
+		err = localErr(errNoLocalpart)
 		return
 	default:
 		domainpart = s[sep+1:]
@@ -416,15 +419,15 @@ func checkIP6String(domainpart string) error {
 func commonChecks(localpart []byte, domainpart string, resourcepart []byte) error {
 	err := localChecks(localpart)
 	if err != nil {
-		return err
+		return localErr(err)
 	}
 
 	err = resourceChecks(resourcepart)
 	if err != nil {
-		return err
+		return resourceErr(err)
 	}
 
-	return domainChecks(domainpart)
+	return domainErr(domainChecks(domainpart))
 }
 
 func localChecks(localpart []byte) error {
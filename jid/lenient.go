@@ -0,0 +1,43 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jid
+
+import (
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+// ParseLenient is like Parse except that it first applies fallback mappings
+// to correct common mistakes in addresses entered by users:
+//
+//   - leading and trailing whitespace is removed,
+//   - an "xmpp:" URI scheme and any query component are removed,
+//   - fullwidth and halfwidth characters (including fullwidth forms of the "@"
+//     and "/" separators) are mapped to their canonical width,
+//   - the domainpart is mapped to lowercase,
+//   - and a trailing "/" without a resourcepart is removed.
+//
+// ParseLenient should only be used on input from users, addresses received
+// over the network should always be parsed with Parse.
+// If the address is still invalid, the error can be compared against
+// ErrInvalidLocalpart, ErrInvalidDomainpart, and ErrInvalidResourcepart using
+// errors.Is to find out which part of the address should be corrected.
+func ParseLenient(s string) (JID, error) {
+	s = strings.TrimSpace(width.Fold.String(s))
+	if len(s) >= 5 && strings.EqualFold(s[:5], "xmpp:") {
+		s = s[5:]
+		if idx := strings.IndexByte(s, '?'); idx != -1 {
+			s = s[:idx]
+		}
+	}
+	s = strings.TrimSuffix(s, "/")
+
+	localpart, domainpart, resourcepart, err := SplitString(s)
+	if err != nil {
+		return JID{}, err
+	}
+	return New(localpart, strings.ToLower(domainpart), resourcepart)
+}
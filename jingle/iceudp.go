@@ -0,0 +1,130 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"encoding/xml"
+	"strconv"
+
+	"mellium.im/xmlstream"
+)
+
+// Transport is an ICE-UDP transport.
+type Transport struct {
+	Ufrag       string       `xml:"ufrag,attr"`
+	Pwd         string       `xml:"pwd,attr"`
+	Fingerprint *Fingerprint `xml:"urn:xmpp:jingle:apps:dtls:0 fingerprint"`
+	Candidates  []Candidate  `xml:"candidate"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (t Transport) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("pwd", t.Pwd)
+	a.add("ufrag", t.Ufrag)
+
+	var inner []xml.TokenReader
+	if t.Fingerprint != nil {
+		inner = append(inner, t.Fingerprint.TokenReader())
+	}
+	for _, c := range t.Candidates {
+		inner = append(inner, c.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NSICEUDP, Local: "transport"}, Attr: a},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (t Transport) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, t.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (t Transport) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := t.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Fingerprint is the fingerprint of the certificate used for DTLS-SRTP.
+type Fingerprint struct {
+	// Hash is the hash function used, for example "sha-256".
+	Hash string `xml:"hash,attr"`
+	// Setup is the DTLS role, one of "active", "passive", or "actpass".
+	Setup string `xml:"setup,attr"`
+	// Value is the fingerprint as uppercase hex pairs separated by colons.
+	Value string `xml:",chardata"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (f Fingerprint) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("hash", f.Hash)
+	a.add("setup", f.Setup)
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(f.Value)),
+		xml.StartElement{Name: xml.Name{Space: NSDTLS, Local: "fingerprint"}, Attr: a},
+	)
+}
+
+// Candidate is an ICE candidate.
+type Candidate struct {
+	Component  uint8  `xml:"component,attr"`
+	Foundation string `xml:"foundation,attr"`
+	Generation uint8  `xml:"generation,attr"`
+	ID         string `xml:"id,attr"`
+	IP         string `xml:"ip,attr"`
+	Network    uint8  `xml:"network,attr"`
+	Port       uint16 `xml:"port,attr"`
+	Priority   uint32 `xml:"priority,attr"`
+	Protocol   string `xml:"protocol,attr"`
+	RelAddr    string `xml:"rel-addr,attr"`
+	RelPort    uint16 `xml:"rel-port,attr"`
+	// Type is the candidate type, one of "host", "prflx", "relay", or "srflx".
+	Type string `xml:"type,attr"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (c Candidate) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("component", strconv.FormatUint(uint64(c.Component), 10))
+	a.add("foundation", c.Foundation)
+	a.add("generation", strconv.FormatUint(uint64(c.Generation), 10))
+	a.add("id", c.ID)
+	a.add("ip", c.IP)
+	a.add("network", strconv.FormatUint(uint64(c.Network), 10))
+	a.add("port", strconv.FormatUint(uint64(c.Port), 10))
+	a.add("priority", strconv.FormatUint(uint64(c.Priority), 10))
+	a.add("protocol", c.Protocol)
+	a.add("rel-addr", c.RelAddr)
+	if c.RelPort != 0 {
+		a.add("rel-port", strconv.FormatUint(uint64(c.RelPort), 10))
+	}
+	a.add("type", c.Type)
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "candidate"},
+		Attr: a,
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (c Candidate) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (c Candidate) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
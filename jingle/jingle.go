@@ -0,0 +1,235 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package jingle implements the payloads used to set up audio and video calls
+// using XEP-0166: Jingle.
+//
+// Contents are described using the RTP application format from XEP-0167:
+// Jingle RTP Sessions, including RTP feedback and header extension negotiation
+// from XEP-0293 and XEP-0294, and are transported using XEP-0176: Jingle ICE-UDP
+// Transport Method.
+// DTLS fingerprints from XEP-0320: Use of DTLS-SRTP in Jingle Sessions are also
+// supported since they are required by WebRTC.
+//
+// Before a session is started, XEP-0353: Jingle Message Initiation can be used
+// to ring all of a contact's devices and find out which one will take the call.
+//
+// Contents can be converted to and from the Session Description Protocol (SDP)
+// for use with WebRTC implementations such as github.com/pion/webrtc.
+//
+// This package does not keep track of the state of Jingle sessions.
+// It is up to the user to respond to each action they receive by sending the
+// appropriate action in return.
+package jingle // import "mellium.im/xmpp/jingle"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package provided as a convenience.
+const (
+	NS          = "urn:xmpp:jingle:1"
+	NSRTP       = "urn:xmpp:jingle:apps:rtp:1"
+	NSAudio     = "urn:xmpp:jingle:apps:rtp:audio"
+	NSVideo     = "urn:xmpp:jingle:apps:rtp:video"
+	NSFeedback  = "urn:xmpp:jingle:apps:rtp:rtcp-fb:0"
+	NSHeaderExt = "urn:xmpp:jingle:apps:rtp:rtp-hdrext:0"
+	NSICEUDP    = "urn:xmpp:jingle:transports:ice-udp:1"
+	NSDTLS      = "urn:xmpp:jingle:apps:dtls:0"
+	NSMessage   = "urn:xmpp:jingle-message:0"
+)
+
+// Action is the type of a Jingle request.
+type Action string
+
+// A list of Jingle actions.
+const (
+	ContentAccept    Action = "content-accept"
+	ContentAdd       Action = "content-add"
+	ContentModify    Action = "content-modify"
+	ContentReject    Action = "content-reject"
+	ContentRemove    Action = "content-remove"
+	DescriptionInfo  Action = "description-info"
+	SecurityInfo     Action = "security-info"
+	SessionAccept    Action = "session-accept"
+	SessionInfo      Action = "session-info"
+	SessionInitiate  Action = "session-initiate"
+	SessionTerminate Action = "session-terminate"
+	TransportAccept  Action = "transport-accept"
+	TransportInfo    Action = "transport-info"
+	TransportReject  Action = "transport-reject"
+	TransportReplace Action = "transport-replace"
+)
+
+// Creator is the party that originally generated a content.
+type Creator string
+
+// Valid creators and senders.
+const (
+	Initiator Creator = "initiator"
+	Responder Creator = "responder"
+)
+
+// Senders indicates which parties in a session will be generating content.
+// If it is empty, both parties are assumed to be sending content.
+type Senders string
+
+// A list of possible senders.
+const (
+	SendersBoth      Senders = "both"
+	SendersInitiator Senders = "initiator"
+	SendersNone      Senders = "none"
+	SendersResponder Senders = "responder"
+)
+
+type attrs []xml.Attr
+
+func (a *attrs) add(name, value string) {
+	if value != "" {
+		*a = append(*a, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+	}
+}
+
+// Jingle is a Jingle request.
+type Jingle struct {
+	XMLName   xml.Name  `xml:"urn:xmpp:jingle:1 jingle"`
+	Action    Action    `xml:"action,attr"`
+	Initiator jid.JID   `xml:"initiator,attr"`
+	Responder jid.JID   `xml:"responder,attr"`
+	SID       string    `xml:"sid,attr"`
+	Content   []Content `xml:"content"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (j Jingle) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("action", string(j.Action))
+	a.add("initiator", j.Initiator.String())
+	a.add("responder", j.Responder.String())
+	a.add("sid", j.SID)
+
+	var inner []xml.TokenReader
+	for _, c := range j.Content {
+		inner = append(inner, c.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "jingle"}, Attr: a},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (j Jingle) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, j.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (j Jingle) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := j.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Content is the content of a session.
+// Description and Transport may be nil if they are not required by the
+// action being performed.
+type Content struct {
+	Creator     Creator      `xml:"creator,attr"`
+	Name        string       `xml:"name,attr"`
+	Senders     Senders      `xml:"senders,attr"`
+	Description *Description `xml:"urn:xmpp:jingle:apps:rtp:1 description"`
+	Transport   *Transport   `xml:"urn:xmpp:jingle:transports:ice-udp:1 transport"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (c Content) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("creator", string(c.Creator))
+	a.add("name", c.Name)
+	a.add("senders", string(c.Senders))
+
+	var inner []xml.TokenReader
+	if c.Description != nil {
+		inner = append(inner, c.Description.TokenReader())
+	}
+	if c.Transport != nil {
+		inner = append(inner, c.Transport.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Local: "content"}, Attr: a},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (c Content) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (c Content) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Send sends a Jingle request to the provided JID and blocks until it is
+// acknowledged.
+// If the request is rejected, the error is returned as a stanza.Error.
+func Send(ctx context.Context, s *xmpp.Session, to jid.JID, j Jingle) error {
+	return s.UnmarshalIQElement(ctx, j.TokenReader(), stanza.IQ{
+		To:   to,
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+// Handle returns an option that registers a Handler for Jingle requests.
+func Handle(h Handler) mux.Option {
+	return mux.IQ(stanza.SetIQ, xml.Name{Local: "jingle", Space: NS}, h)
+}
+
+// Handler responds to Jingle requests.
+//
+// The request is acknowledged if the Jingle function returns nil.
+// If it returns a stanza.Error, the error is sent in response to the request
+// instead.
+type Handler struct {
+	Jingle func(stanza.IQ, Jingle) error
+}
+
+// HandleIQ responds to Jingle IQs.
+func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var j Jingle
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&j)
+	if err != nil {
+		return err
+	}
+	if h.Jingle != nil {
+		err = h.Jingle(iq, j)
+	}
+	var stanzaErr stanza.Error
+	if errors.As(err, &stanzaErr) {
+		_, err = xmlstream.Copy(t, iq.Error(stanzaErr))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = xmlstream.Copy(t, iq.Result(nil))
+	return err
+}
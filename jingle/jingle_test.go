@@ -0,0 +1,202 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/jingle"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = jingle.Jingle{}
+	_ xmlstream.Marshaler = jingle.Jingle{}
+	_ xmlstream.WriterTo  = jingle.Jingle{}
+	_ xml.Unmarshaler     = (*jingle.Description)(nil)
+)
+
+var initiate = jingle.Jingle{
+	XMLName:   xml.Name{Space: jingle.NS, Local: "jingle"},
+	Action:    jingle.SessionInitiate,
+	Initiator: jid.MustParse("romeo@montague.lit/orchard"),
+	SID:       "a73sjjvkla37jfea",
+	Content: []jingle.Content{{
+		Creator: jingle.Initiator,
+		Name:    "voice",
+		Description: &jingle.Description{
+			Media: "audio",
+			PayloadTypes: []jingle.PayloadType{{
+				ID:        111,
+				Name:      "opus",
+				ClockRate: 48000,
+				Channels:  2,
+				Parameters: []jingle.Parameter{
+					{Name: "useinbandfec", Value: "1"},
+				},
+				Feedback: []jingle.Feedback{{Type: "transport-cc"}},
+			}, {
+				ID:        0,
+				Name:      "PCMU",
+				ClockRate: 8000,
+			}},
+			HeaderExtensions: []jingle.HeaderExtension{
+				{ID: 1, URI: "urn:ietf:params:rtp-hdrext:ssrc-audio-level"},
+			},
+			RTCPMux: true,
+		},
+		Transport: &jingle.Transport{
+			Ufrag: "8hhy",
+			Pwd:   "asd88fgpdd777uzjYhagZg",
+			Fingerprint: &jingle.Fingerprint{
+				Hash:  "sha-256",
+				Setup: "actpass",
+				Value: "02:1A:CC:54:27:AB:EB:9C:53:3F:3E:4B:65:2E:7D:46:3F:54:42:CD:54:F1:7A:03:A2:7D:F9:B0:7F:46:19:B2",
+			},
+			Candidates: []jingle.Candidate{{
+				Component:  1,
+				Foundation: "1",
+				Generation: 0,
+				ID:         "el0747fg11",
+				IP:         "10.0.1.1",
+				Network:    1,
+				Port:       8998,
+				Priority:   2130706431,
+				Protocol:   "udp",
+				Type:       "host",
+			}},
+		},
+	}},
+}
+
+const initiateXML = `<jingle xmlns="urn:xmpp:jingle:1" action="session-initiate" initiator="romeo@montague.lit/orchard" sid="a73sjjvkla37jfea"><content creator="initiator" name="voice"><description xmlns="urn:xmpp:jingle:apps:rtp:1" media="audio"><payload-type channels="2" clockrate="48000" id="111" name="opus"><parameter name="useinbandfec" value="1"></parameter><rtcp-fb xmlns="urn:xmpp:jingle:apps:rtp:rtcp-fb:0" type="transport-cc"></rtcp-fb></payload-type><payload-type clockrate="8000" id="0" name="PCMU"></payload-type><rtp-hdrext xmlns="urn:xmpp:jingle:apps:rtp:rtp-hdrext:0" id="1" uri="urn:ietf:params:rtp-hdrext:ssrc-audio-level"></rtp-hdrext><rtcp-mux></rtcp-mux></description><transport xmlns="urn:xmpp:jingle:transports:ice-udp:1" pwd="asd88fgpdd777uzjYhagZg" ufrag="8hhy"><fingerprint xmlns="urn:xmpp:jingle:apps:dtls:0" hash="sha-256" setup="actpass">02:1A:CC:54:27:AB:EB:9C:53:3F:3E:4B:65:2E:7D:46:3F:54:42:CD:54:F1:7A:03:A2:7D:F9:B0:7F:46:19:B2</fingerprint><candidate component="1" foundation="1" generation="0" id="el0747fg11" ip="10.0.1.1" network="1" port="8998" priority="2130706431" protocol="udp" type="host"></candidate></transport></content></jingle>`
+
+func TestMarshal(t *testing.T) {
+	b, err := xml.Marshal(initiate)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if out := string(b); out != initiateXML {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", initiateXML, out)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var j jingle.Jingle
+	err := xml.Unmarshal([]byte(initiateXML), &j)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !reflect.DeepEqual(j, initiate) {
+		t.Errorf("wrong value:\nwant=%+v,\n got=%+v", initiate, j)
+	}
+}
+
+var negotiateTestCases = [...]struct {
+	offer     []jingle.PayloadType
+	supported []jingle.PayloadType
+	out       []jingle.PayloadType
+}{
+	0: {},
+	1: {
+		offer: []jingle.PayloadType{
+			{ID: 96, Name: "VP8", ClockRate: 90000},
+			{ID: 98, Name: "H264", ClockRate: 90000},
+		},
+		supported: []jingle.PayloadType{{ID: 100, Name: "h264", ClockRate: 90000}},
+		out:       []jingle.PayloadType{{ID: 98, Name: "H264", ClockRate: 90000}},
+	},
+	2: {
+		offer: []jingle.PayloadType{
+			{ID: 111, Name: "opus", ClockRate: 48000, Channels: 2},
+			{ID: 0},
+			{ID: 8},
+		},
+		supported: []jingle.PayloadType{
+			{ID: 0, Name: "PCMU", ClockRate: 8000},
+			{ID: 111, Name: "opus", ClockRate: 48000},
+		},
+		out: []jingle.PayloadType{{ID: 0}},
+	},
+	3: {
+		offer:     []jingle.PayloadType{{ID: 9, Name: "G722", ClockRate: 8000, Channels: 1}},
+		supported: []jingle.PayloadType{{ID: 9, Name: "G722", ClockRate: 8000}},
+		out:       []jingle.PayloadType{{ID: 9, Name: "G722", ClockRate: 8000, Channels: 1}},
+	},
+}
+
+func TestNegotiate(t *testing.T) {
+	for i, tc := range negotiateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := jingle.Negotiate(tc.offer, tc.supported)
+			if !reflect.DeepEqual(out, tc.out) {
+				t.Errorf("wrong payload types:\nwant=%+v,\n got=%+v", tc.out, out)
+			}
+		})
+	}
+}
+
+var handlerTestCases = [...]struct {
+	err error
+	out string
+}{
+	0: {
+		out: `<iq xmlns="jabber:client" type="result" to="romeo@montague.lit/orchard" from="juliet@capulet.lit/balcony" id="123"></iq>`,
+	},
+	1: {
+		err: stanza.Error{Type: stanza.Cancel, Condition: stanza.ServiceUnavailable},
+		out: `<iq xmlns="jabber:client" type="error" to="romeo@montague.lit/orchard" from="juliet@capulet.lit/balcony" id="123"><error type="cancel"><service-unavailable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></service-unavailable></error></iq>`,
+	},
+}
+
+func TestHandler(t *testing.T) {
+	for i, tc := range handlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var got jingle.Jingle
+			m := mux.New(jingle.Handle(jingle.Handler{
+				Jingle: func(_ stanza.IQ, j jingle.Jingle) error {
+					got = j
+					return tc.err
+				},
+			}))
+			d := xml.NewDecoder(strings.NewReader(`<iq xmlns="jabber:client" type="set" id="123" from="romeo@montague.lit/orchard" to="juliet@capulet.lit/balcony">` + initiateXML + `</iq>`))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error popping start token: %v", err)
+			}
+			start := tok.(xml.StartElement)
+			var buf bytes.Buffer
+			e := xml.NewEncoder(&buf)
+			err = m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+				Encoder:     e,
+			}, &start)
+			if err != nil {
+				t.Fatalf("error handling IQ: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if !reflect.DeepEqual(got, initiate) {
+				t.Errorf("wrong request:\nwant=%+v,\n got=%+v", initiate, got)
+			}
+			if out := buf.String(); out != tc.out {
+				t.Errorf("wrong response:\nwant=%s,\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/hints"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// MessageAction is the type of a Jingle Message Initiation payload.
+type MessageAction string
+
+// A list of Jingle Message Initiation actions.
+const (
+	// ProposeMessage is sent by the initiator to all of the responder's
+	// devices to ring them.
+	ProposeMessage MessageAction = "propose"
+
+	// RetractMessage is sent by the initiator to cancel a proposal.
+	RetractMessage MessageAction = "retract"
+
+	// AcceptMessage is sent by the responder to their own bare JID to stop their
+	// other devices from ringing.
+	AcceptMessage MessageAction = "accept"
+
+	// ProceedMessage is sent by the responder to the initiator to indicate which
+	// device the session should be initiated with.
+	ProceedMessage MessageAction = "proceed"
+
+	// RejectMessage is sent by the responder to decline a proposal.
+	RejectMessage MessageAction = "reject"
+)
+
+var messageActions = [...]MessageAction{ProposeMessage, RetractMessage, AcceptMessage, ProceedMessage, RejectMessage}
+
+// Initiation is a Jingle Message Initiation payload.
+type Initiation struct {
+	Action MessageAction
+	// ID is the session ID that will be used for the Jingle session.
+	ID string
+	// Descriptions are the contents being proposed.
+	// They are only used with ProposeMessage.
+	Descriptions []Description
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (i Initiation) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, d := range i.Descriptions {
+		inner = append(inner, d.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{
+			Name: xml.Name{Space: NSMessage, Local: string(i.Action)},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: i.ID}},
+		},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (i Initiation) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, i.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (i Initiation) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := i.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML satisfies the xml.Unmarshaler interface.
+func (i *Initiation) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		ID           string        `xml:"id,attr"`
+		Descriptions []Description `xml:"urn:xmpp:jingle:apps:rtp:1 description"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	i.Action = MessageAction(start.Name.Local)
+	i.ID = s.ID
+	i.Descriptions = s.Descriptions
+	return nil
+}
+
+// SendMessage sends a Jingle Message Initiation payload to the provided JID.
+// Messages are sent with the chat type and a hint asking the server to store
+// them so that they are delivered to all of the recipient's devices and copied
+// to all of the sender's devices.
+func SendMessage(ctx context.Context, s *xmpp.Session, to jid.JID, i Initiation) error {
+	return s.Send(ctx, stanza.Message{
+		To:   to,
+		Type: stanza.ChatMessage,
+	}.Wrap(xmlstream.MultiReader(
+		i.TokenReader(),
+		hints.Store.TokenReader(),
+	)))
+}
+
+// Propose rings all of the devices belonging to the provided bare JID.
+// Sid is the ID of the session that will be initiated if the proposal is
+// accepted.
+func Propose(ctx context.Context, s *xmpp.Session, to jid.JID, sid string, descriptions ...Description) error {
+	return SendMessage(ctx, s, to.Bare(), Initiation{
+		Action:       ProposeMessage,
+		ID:           sid,
+		Descriptions: descriptions,
+	})
+}
+
+// Retract cancels a proposal previously sent to the provided JID.
+func Retract(ctx context.Context, s *xmpp.Session, to jid.JID, sid string) error {
+	return SendMessage(ctx, s, to.Bare(), Initiation{Action: RetractMessage, ID: sid})
+}
+
+// Accept tells the user's other devices that the proposal has been accepted on
+// this device so that they stop ringing.
+// It should be followed by a call to Proceed.
+func Accept(ctx context.Context, s *xmpp.Session, sid string) error {
+	return SendMessage(ctx, s, s.LocalAddr().Bare(), Initiation{Action: AcceptMessage, ID: sid})
+}
+
+// Proceed tells the device that sent a proposal that the session should be
+// initiated with this device.
+// To should be the full JID that the proposal was received from.
+func Proceed(ctx context.Context, s *xmpp.Session, to jid.JID, sid string) error {
+	return SendMessage(ctx, s, to, Initiation{Action: ProceedMessage, ID: sid})
+}
+
+// Reject declines a proposal received from the provided JID and tells the
+// user's other devices that it has been declined so that they stop ringing.
+func Reject(ctx context.Context, s *xmpp.Session, to jid.JID, sid string) error {
+	err := SendMessage(ctx, s, to, Initiation{Action: RejectMessage, ID: sid})
+	if err != nil {
+		return err
+	}
+	return SendMessage(ctx, s, s.LocalAddr().Bare(), Initiation{Action: RejectMessage, ID: sid})
+}
+
+// HandleMessages returns an option that registers a MessageHandler for Jingle
+// Message Initiation payloads.
+func HandleMessages(h MessageHandler) mux.Option {
+	return func(m *mux.ServeMux) {
+		for _, action := range messageActions {
+			name := xml.Name{Space: NSMessage, Local: string(action)}
+			for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage} {
+				mux.Message(typ, name, h)(m)
+			}
+		}
+	}
+}
+
+// MessageHandler decodes Jingle Message Initiation payloads and passes them to
+// the Initiation callback along with the message they were received in.
+// If the Initiation callback is nil, the payloads are ignored.
+type MessageHandler struct {
+	Initiation func(stanza.Message, Initiation) error
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+func (h MessageHandler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	d := xml.NewTokenDecoder(t)
+	// Pop the message start token.
+	_, err := d.Token()
+	if err != nil {
+		return err
+	}
+	for {
+		tok, err := d.Token()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space != NSMessage {
+			err = d.Skip()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var i Initiation
+		err = d.DecodeElement(&i, &start)
+		if err != nil {
+			return err
+		}
+		if h.Initiation == nil {
+			return nil
+		}
+		return h.Initiation(msg, i)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/hints"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/jingle"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler   = jingle.Initiation{}
+	_ xml.Unmarshaler = (*jingle.Initiation)(nil)
+)
+
+var messageHandlerTestCases = [...]struct {
+	in  string
+	out jingle.Initiation
+}{
+	0: {
+		in: `<message xmlns="jabber:client" type="chat" from="romeo@montague.lit/orchard" to="juliet@capulet.lit"><propose xmlns="urn:xmpp:jingle-message:0" id="ca3cf894-5325-482f-a412-a6e9f832298d"><description xmlns="urn:xmpp:jingle:apps:rtp:1" media="audio"/></propose><store xmlns="urn:xmpp:hints"/></message>`,
+		out: jingle.Initiation{
+			Action:       jingle.ProposeMessage,
+			ID:           "ca3cf894-5325-482f-a412-a6e9f832298d",
+			Descriptions: []jingle.Description{{Media: "audio"}},
+		},
+	},
+	1: {
+		in: `<message xmlns="jabber:client" type="chat" from="romeo@montague.lit/orchard" to="juliet@capulet.lit"><body>Incoming call</body><retract xmlns="urn:xmpp:jingle-message:0" id="abc"/></message>`,
+		out: jingle.Initiation{
+			Action: jingle.RetractMessage,
+			ID:     "abc",
+		},
+	},
+	2: {
+		in: `<message xmlns="jabber:client" from="juliet@capulet.lit/balcony" to="romeo@montague.lit/orchard"><proceed xmlns="urn:xmpp:jingle-message:0" id="abc"/></message>`,
+		out: jingle.Initiation{
+			Action: jingle.ProceedMessage,
+			ID:     "abc",
+		},
+	},
+}
+
+func TestMessageHandler(t *testing.T) {
+	for i, tc := range messageHandlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var got jingle.Initiation
+			called := false
+			m := mux.New(jingle.HandleMessages(jingle.MessageHandler{
+				Initiation: func(_ stanza.Message, init jingle.Initiation) error {
+					called = true
+					got = init
+					return nil
+				},
+			}))
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error popping start token: %v", err)
+			}
+			start := tok.(xml.StartElement)
+			var buf bytes.Buffer
+			err = m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+				Encoder:     xml.NewEncoder(&buf),
+			}, &start)
+			if err != nil {
+				t.Fatalf("error handling message: %v", err)
+			}
+			if !called {
+				t.Fatalf("handler was not called")
+			}
+			if !reflect.DeepEqual(got, tc.out) {
+				t.Errorf("wrong payload:\nwant=%+v,\n got=%+v", tc.out, got)
+			}
+		})
+	}
+}
+
+func TestPropose(t *testing.T) {
+	type sent struct {
+		stanza.Message
+		Propose jingle.Initiation `xml:"urn:xmpp:jingle-message:0 propose"`
+		Store   *hints.Hint       `xml:"urn:xmpp:hints store"`
+	}
+	msgs := make(chan sent, 1)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var msg sent
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		msgs <- msg
+		return nil
+	}))
+	defer cs.Close()
+
+	err := jingle.Propose(context.Background(), cs.Client, jid.MustParse("juliet@capulet.lit/balcony"), "abc", jingle.Description{Media: "video"})
+	if err != nil {
+		t.Fatalf("error sending proposal: %v", err)
+	}
+	msg := <-msgs
+	if msg.Type != stanza.ChatMessage {
+		t.Errorf("wrong message type: want=%q, got=%q", stanza.ChatMessage, msg.Type)
+	}
+	if to := msg.To.String(); to != "juliet@capulet.lit" {
+		t.Errorf("expected proposal to be sent to the bare JID, got %q", to)
+	}
+	if msg.Store == nil {
+		t.Errorf("expected store hint")
+	}
+	want := jingle.Initiation{
+		Action:       jingle.ProposeMessage,
+		ID:           "abc",
+		Descriptions: []jingle.Description{{Media: "video"}},
+	}
+	if !reflect.DeepEqual(msg.Propose, want) {
+		t.Errorf("wrong proposal:\nwant=%+v,\n got=%+v", want, msg.Propose)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmlstream"
+)
+
+// Description is an RTP application format description.
+type Description struct {
+	// Media is the type of media, for example "audio" or "video".
+	Media            string
+	PayloadTypes     []PayloadType
+	HeaderExtensions []HeaderExtension
+	// RTCPMux indicates that RTP and RTCP are multiplexed on the same port.
+	RTCPMux bool
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (d Description) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("media", d.Media)
+
+	var inner []xml.TokenReader
+	for _, p := range d.PayloadTypes {
+		inner = append(inner, p.TokenReader())
+	}
+	for _, h := range d.HeaderExtensions {
+		inner = append(inner, h.TokenReader())
+	}
+	if d.RTCPMux {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "rtcp-mux"}}))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NSRTP, Local: "description"}, Attr: a},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (d Description) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, d.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (d Description) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := d.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML satisfies the xml.Unmarshaler interface.
+func (d *Description) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Media            string            `xml:"media,attr"`
+		PayloadTypes     []PayloadType     `xml:"payload-type"`
+		HeaderExtensions []HeaderExtension `xml:"urn:xmpp:jingle:apps:rtp:rtp-hdrext:0 rtp-hdrext"`
+		RTCPMux          *struct{}         `xml:"rtcp-mux"`
+	}{}
+	err := dec.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	d.Media = s.Media
+	d.PayloadTypes = s.PayloadTypes
+	d.HeaderExtensions = s.HeaderExtensions
+	d.RTCPMux = s.RTCPMux != nil
+	return nil
+}
+
+// PayloadType is a codec that may be used to encode the media.
+type PayloadType struct {
+	// ID is the RTP payload type.
+	// IDs below 96 are statically assigned and may not have a Name.
+	ID        uint8  `xml:"id,attr"`
+	Name      string `xml:"name,attr"`
+	ClockRate uint32 `xml:"clockrate,attr"`
+	// Channels is the number of channels.
+	// If it is zero, there is a single channel.
+	Channels   uint8       `xml:"channels,attr"`
+	MaxPTime   uint32      `xml:"maxptime,attr"`
+	PTime      uint32      `xml:"ptime,attr"`
+	Parameters []Parameter `xml:"parameter"`
+	Feedback   []Feedback  `xml:"urn:xmpp:jingle:apps:rtp:rtcp-fb:0 rtcp-fb"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (p PayloadType) TokenReader() xml.TokenReader {
+	var a attrs
+	if p.Channels != 0 {
+		a.add("channels", strconv.FormatUint(uint64(p.Channels), 10))
+	}
+	if p.ClockRate != 0 {
+		a.add("clockrate", strconv.FormatUint(uint64(p.ClockRate), 10))
+	}
+	a.add("id", strconv.FormatUint(uint64(p.ID), 10))
+	if p.MaxPTime != 0 {
+		a.add("maxptime", strconv.FormatUint(uint64(p.MaxPTime), 10))
+	}
+	a.add("name", p.Name)
+	if p.PTime != 0 {
+		a.add("ptime", strconv.FormatUint(uint64(p.PTime), 10))
+	}
+
+	var inner []xml.TokenReader
+	for _, param := range p.Parameters {
+		inner = append(inner, param.TokenReader())
+	}
+	for _, fb := range p.Feedback {
+		inner = append(inner, fb.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Local: "payload-type"}, Attr: a},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (p PayloadType) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, p.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (p PayloadType) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := p.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// matches reports whether two payload types represent the same codec.
+func (p PayloadType) matches(other PayloadType) bool {
+	if p.Name == "" || other.Name == "" {
+		return p.ID == other.ID && p.ID < 96
+	}
+	channels := func(c uint8) uint8 {
+		if c == 0 {
+			return 1
+		}
+		return c
+	}
+	return strings.EqualFold(p.Name, other.Name) &&
+		p.ClockRate == other.ClockRate &&
+		channels(p.Channels) == channels(other.Channels)
+}
+
+// Negotiate returns the payload types from offer that are also in supported.
+// This can be used by the responder to pick the payload types to include when
+// accepting a session.
+//
+// Payload types are matched by name, clock rate, and number of channels.
+// Static payload types without a name are matched by ID.
+// The order, IDs, and parameters of the offered payload types are preserved.
+func Negotiate(offer, supported []PayloadType) []PayloadType {
+	var negotiated []PayloadType
+	for _, p := range offer {
+		for _, s := range supported {
+			if p.matches(s) {
+				negotiated = append(negotiated, p)
+				break
+			}
+		}
+	}
+	return negotiated
+}
+
+// Parameter is a format specific parameter of a payload type.
+type Parameter struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (p Parameter) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "parameter"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "name"}, Value: p.Name},
+			{Name: xml.Name{Local: "value"}, Value: p.Value},
+		},
+	})
+}
+
+// Feedback is an RTCP feedback message supported for a payload type.
+type Feedback struct {
+	Type    string `xml:"type,attr"`
+	Subtype string `xml:"subtype,attr"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (f Feedback) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("subtype", f.Subtype)
+	a.add("type", f.Type)
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSFeedback, Local: "rtcp-fb"},
+		Attr: a,
+	})
+}
+
+// HeaderExtension is an RTP header extension.
+type HeaderExtension struct {
+	ID      uint16  `xml:"id,attr"`
+	URI     string  `xml:"uri,attr"`
+	Senders Senders `xml:"senders,attr"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (h HeaderExtension) TokenReader() xml.TokenReader {
+	var a attrs
+	a.add("id", strconv.FormatUint(uint64(h.ID), 10))
+	a.add("senders", string(h.Senders))
+	a.add("uri", h.URI)
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSHeaderExt, Local: "rtp-hdrext"},
+		Attr: a,
+	})
+}
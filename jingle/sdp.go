@@ -0,0 +1,413 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmpp/internal/attr"
+)
+
+// SDP returns the candidate as an SDP candidate attribute without the leading
+// "a=", which is the format expected by most WebRTC implementations when
+// exchanging candidates individually.
+func (c Candidate) SDP() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "candidate:%s %d %s %d %s %d typ %s",
+		c.Foundation, c.Component, strings.ToLower(c.Protocol), c.Priority, c.IP, c.Port, c.Type)
+	if c.RelAddr != "" {
+		fmt.Fprintf(&b, " raddr %s rport %d", c.RelAddr, c.RelPort)
+	}
+	fmt.Fprintf(&b, " generation %d", c.Generation)
+	return b.String()
+}
+
+// ParseCandidate parses an SDP candidate attribute with or without the leading
+// "a=".
+// Since SDP candidates do not have IDs, a random ID is generated.
+func ParseCandidate(s string) (Candidate, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "a=")
+	if !strings.HasPrefix(s, "candidate:") {
+		return Candidate{}, fmt.Errorf("jingle: expected candidate attribute, got %q", s)
+	}
+	fields := strings.Fields(strings.TrimPrefix(s, "candidate:"))
+	if len(fields) < 8 || fields[6] != "typ" {
+		return Candidate{}, fmt.Errorf("jingle: malformed candidate %q", s)
+	}
+
+	c := Candidate{
+		Foundation: fields[0],
+		ID:         attr.RandomID(),
+		Protocol:   strings.ToLower(fields[2]),
+		IP:         fields[4],
+		Type:       fields[7],
+	}
+	component, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return c, fmt.Errorf("jingle: bad candidate component: %w", err)
+	}
+	c.Component = uint8(component)
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return c, fmt.Errorf("jingle: bad candidate priority: %w", err)
+	}
+	c.Priority = uint32(priority)
+	port, err := strconv.ParseUint(fields[5], 10, 16)
+	if err != nil {
+		return c, fmt.Errorf("jingle: bad candidate port: %w", err)
+	}
+	c.Port = uint16(port)
+
+	// The remaining fields are extension attributes in name/value pairs.
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			c.RelAddr = fields[i+1]
+		case "rport":
+			rport, err := strconv.ParseUint(fields[i+1], 10, 16)
+			if err != nil {
+				return c, fmt.Errorf("jingle: bad candidate rport: %w", err)
+			}
+			c.RelPort = uint16(rport)
+		case "generation":
+			gen, err := strconv.ParseUint(fields[i+1], 10, 8)
+			if err != nil {
+				return c, fmt.Errorf("jingle: bad candidate generation: %w", err)
+			}
+			c.Generation = uint8(gen)
+		case "network-id":
+			network, err := strconv.ParseUint(fields[i+1], 10, 8)
+			if err != nil {
+				return c, fmt.Errorf("jingle: bad candidate network-id: %w", err)
+			}
+			c.Network = uint8(network)
+		}
+	}
+	return c, nil
+}
+
+// direction returns the SDP direction attribute for senders when the session
+// description is generated by role.
+func direction(role Creator, senders Senders) string {
+	switch senders {
+	case SendersNone:
+		return "inactive"
+	case SendersInitiator, SendersResponder:
+		if string(senders) == string(role) {
+			return "sendonly"
+		}
+		return "recvonly"
+	}
+	return "sendrecv"
+}
+
+// senders is the inverse of direction.
+func senders(role Creator, dir string) Senders {
+	other := Initiator
+	if role == Initiator {
+		other = Responder
+	}
+	switch dir {
+	case "inactive":
+		return SendersNone
+	case "sendonly":
+		return Senders(role)
+	case "recvonly":
+		return Senders(other)
+	}
+	return SendersBoth
+}
+
+// MarshalSDP converts contents into an SDP session description containing a
+// media section for each content with an RTP description.
+//
+// Role is the party that the session description is being generated for and
+// is used to convert the senders of each content into an SDP direction.
+// For example, the initiator would convert the contents of a session-initiate
+// request into an offer and the responder would convert the same request into
+// a remote description.
+func MarshalSDP(role Creator, contents []Content) string {
+	var b strings.Builder
+	b.WriteString("v=0\r\no=- 0 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n")
+	for _, c := range contents {
+		if c.Description == nil {
+			continue
+		}
+		d := c.Description
+
+		proto := "RTP/AVPF"
+		if c.Transport != nil && c.Transport.Fingerprint != nil {
+			proto = "UDP/TLS/RTP/SAVPF"
+		}
+		fmt.Fprintf(&b, "m=%s 9 %s", d.Media, proto)
+		for _, p := range d.PayloadTypes {
+			fmt.Fprintf(&b, " %d", p.ID)
+		}
+		b.WriteString("\r\nc=IN IP4 0.0.0.0\r\n")
+		fmt.Fprintf(&b, "a=mid:%s\r\n", c.Name)
+		fmt.Fprintf(&b, "a=%s\r\n", direction(role, c.Senders))
+
+		if t := c.Transport; t != nil {
+			if t.Ufrag != "" {
+				fmt.Fprintf(&b, "a=ice-ufrag:%s\r\n", t.Ufrag)
+			}
+			if t.Pwd != "" {
+				fmt.Fprintf(&b, "a=ice-pwd:%s\r\n", t.Pwd)
+			}
+			if f := t.Fingerprint; f != nil {
+				fmt.Fprintf(&b, "a=fingerprint:%s %s\r\n", f.Hash, f.Value)
+				if f.Setup != "" {
+					fmt.Fprintf(&b, "a=setup:%s\r\n", f.Setup)
+				}
+			}
+		}
+		if d.RTCPMux {
+			b.WriteString("a=rtcp-mux\r\n")
+		}
+		for _, h := range d.HeaderExtensions {
+			fmt.Fprintf(&b, "a=extmap:%d %s\r\n", h.ID, h.URI)
+		}
+		for _, p := range d.PayloadTypes {
+			if p.Name != "" {
+				fmt.Fprintf(&b, "a=rtpmap:%d %s/%d", p.ID, p.Name, p.ClockRate)
+				if p.Channels > 1 {
+					fmt.Fprintf(&b, "/%d", p.Channels)
+				}
+				b.WriteString("\r\n")
+			}
+			if len(p.Parameters) > 0 {
+				params := make([]string, 0, len(p.Parameters))
+				for _, param := range p.Parameters {
+					if param.Name == "" {
+						params = append(params, param.Value)
+						continue
+					}
+					params = append(params, param.Name+"="+param.Value)
+				}
+				fmt.Fprintf(&b, "a=fmtp:%d %s\r\n", p.ID, strings.Join(params, ";"))
+			}
+			for _, fb := range p.Feedback {
+				fmt.Fprintf(&b, "a=rtcp-fb:%d %s", p.ID, fb.Type)
+				if fb.Subtype != "" {
+					fmt.Fprintf(&b, " %s", fb.Subtype)
+				}
+				b.WriteString("\r\n")
+			}
+		}
+		if c.Transport != nil {
+			for _, cand := range c.Transport.Candidates {
+				fmt.Fprintf(&b, "a=%s\r\n", cand.SDP())
+			}
+		}
+	}
+	return b.String()
+}
+
+// UnmarshalSDP converts an SDP session description into contents.
+// Media sections other than audio and video are ignored.
+//
+// Role is the party that generated the session description and is used to
+// convert SDP directions into senders and as the creator of each content.
+// Contents are named after the SDP media ID if present, or the media type
+// otherwise.
+func UnmarshalSDP(role Creator, sdp string) ([]Content, error) {
+	var (
+		contents []Content
+		cur      *Content
+		session  Transport
+	)
+	transport := func() *Transport {
+		if cur == nil {
+			return &session
+		}
+		return cur.Transport
+	}
+	payload := func(id string) (*PayloadType, error) {
+		n, err := strconv.ParseUint(id, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("jingle: bad payload type %q: %w", id, err)
+		}
+		for i, p := range cur.Description.PayloadTypes {
+			if uint64(p.ID) == n {
+				return &cur.Description.PayloadTypes[i], nil
+			}
+		}
+		return nil, fmt.Errorf("jingle: unknown payload type %d", n)
+	}
+
+	lines := strings.Split(sdp, "\n")
+	skip := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "m=") {
+			fields := strings.Fields(line[2:])
+			if len(fields) < 3 || (fields[0] != "audio" && fields[0] != "video") {
+				skip = true
+				continue
+			}
+			skip = false
+			fp := session.Fingerprint
+			if fp != nil {
+				f := *fp
+				fp = &f
+			}
+			contents = append(contents, Content{
+				Creator: role,
+				Name:    fields[0],
+				Senders: SendersBoth,
+				Description: &Description{
+					Media: fields[0],
+				},
+				Transport: &Transport{
+					Ufrag:       session.Ufrag,
+					Pwd:         session.Pwd,
+					Fingerprint: fp,
+				},
+			})
+			cur = &contents[len(contents)-1]
+			for _, id := range fields[3:] {
+				n, err := strconv.ParseUint(id, 10, 8)
+				if err != nil {
+					return nil, fmt.Errorf("jingle: bad payload type %q: %w", id, err)
+				}
+				cur.Description.PayloadTypes = append(cur.Description.PayloadTypes, PayloadType{ID: uint8(n)})
+			}
+			continue
+		}
+		if skip || !strings.HasPrefix(line, "a=") {
+			continue
+		}
+
+		name, value := line[2:], ""
+		if idx := strings.IndexByte(name, ':'); idx != -1 {
+			name, value = name[:idx], name[idx+1:]
+		}
+		switch name {
+		case "ice-ufrag":
+			transport().Ufrag = value
+		case "ice-pwd":
+			transport().Pwd = value
+		case "fingerprint":
+			t := transport()
+			if t.Fingerprint == nil {
+				t.Fingerprint = &Fingerprint{}
+			}
+			fields := strings.Fields(value)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("jingle: malformed fingerprint %q", value)
+			}
+			t.Fingerprint.Hash, t.Fingerprint.Value = fields[0], fields[1]
+		case "setup":
+			t := transport()
+			if t.Fingerprint == nil {
+				t.Fingerprint = &Fingerprint{}
+			}
+			t.Fingerprint.Setup = value
+		}
+		if cur == nil {
+			continue
+		}
+
+		switch name {
+		case "mid":
+			cur.Name = value
+		case "sendrecv", "sendonly", "recvonly", "inactive":
+			cur.Senders = senders(role, name)
+		case "rtcp-mux":
+			cur.Description.RTCPMux = true
+		case "candidate":
+			cand, err := ParseCandidate(line)
+			if err != nil {
+				return nil, err
+			}
+			cur.Transport.Candidates = append(cur.Transport.Candidates, cand)
+		case "extmap":
+			fields := strings.Fields(value)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("jingle: malformed extmap %q", value)
+			}
+			// Ignore the optional direction.
+			id := strings.SplitN(fields[0], "/", 2)[0]
+			n, err := strconv.ParseUint(id, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("jingle: bad extmap id %q: %w", id, err)
+			}
+			cur.Description.HeaderExtensions = append(cur.Description.HeaderExtensions, HeaderExtension{
+				ID:  uint16(n),
+				URI: fields[1],
+			})
+		case "rtpmap":
+			fields := strings.Fields(value)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("jingle: malformed rtpmap %q", value)
+			}
+			p, err := payload(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			enc := strings.Split(fields[1], "/")
+			p.Name = enc[0]
+			if len(enc) > 1 {
+				rate, err := strconv.ParseUint(enc[1], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("jingle: bad clock rate %q: %w", enc[1], err)
+				}
+				p.ClockRate = uint32(rate)
+			}
+			if len(enc) > 2 {
+				channels, err := strconv.ParseUint(enc[2], 10, 8)
+				if err != nil {
+					return nil, fmt.Errorf("jingle: bad channels %q: %w", enc[2], err)
+				}
+				p.Channels = uint8(channels)
+			}
+		case "fmtp":
+			fields := strings.SplitN(value, " ", 2)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("jingle: malformed fmtp %q", value)
+			}
+			p, err := payload(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			for _, param := range strings.Split(fields[1], ";") {
+				param = strings.TrimSpace(param)
+				if param == "" {
+					continue
+				}
+				kv := strings.SplitN(param, "=", 2)
+				if len(kv) == 1 {
+					p.Parameters = append(p.Parameters, Parameter{Value: kv[0]})
+					continue
+				}
+				p.Parameters = append(p.Parameters, Parameter{Name: kv[0], Value: kv[1]})
+			}
+		case "rtcp-fb":
+			fields := strings.Fields(value)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("jingle: malformed rtcp-fb %q", value)
+			}
+			fb := Feedback{Type: fields[1]}
+			if len(fields) > 2 {
+				fb.Subtype = fields[2]
+			}
+			if fields[0] == "*" {
+				for i := range cur.Description.PayloadTypes {
+					p := &cur.Description.PayloadTypes[i]
+					p.Feedback = append(p.Feedback, fb)
+				}
+				continue
+			}
+			p, err := payload(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			p.Feedback = append(p.Feedback, fb)
+		}
+	}
+	return contents, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/jingle"
+)
+
+var candidateTestCases = [...]struct {
+	in  string
+	out jingle.Candidate
+	sdp string
+	err bool
+}{
+	0: {
+		in: "candidate:1 1 UDP 2130706431 10.0.1.1 8998 typ host generation 0",
+		out: jingle.Candidate{
+			Component:  1,
+			Foundation: "1",
+			IP:         "10.0.1.1",
+			Port:       8998,
+			Priority:   2130706431,
+			Protocol:   "udp",
+			Type:       "host",
+		},
+		sdp: "candidate:1 1 udp 2130706431 10.0.1.1 8998 typ host generation 0",
+	},
+	1: {
+		in: "a=candidate:2 1 udp 1694498815 192.0.2.3 45664 typ srflx raddr 10.0.1.1 rport 8998 generation 1 network-id 2",
+		out: jingle.Candidate{
+			Component:  1,
+			Foundation: "2",
+			Generation: 1,
+			IP:         "192.0.2.3",
+			Network:    2,
+			Port:       45664,
+			Priority:   1694498815,
+			Protocol:   "udp",
+			RelAddr:    "10.0.1.1",
+			RelPort:    8998,
+			Type:       "srflx",
+		},
+		sdp: "candidate:2 1 udp 1694498815 192.0.2.3 45664 typ srflx raddr 10.0.1.1 rport 8998 generation 1",
+	},
+	2: {in: "candidate:1 1 udp", err: true},
+	3: {in: "candidate:1 1 udp 2130706431 10.0.1.1 badport typ host", err: true},
+	4: {in: "ice-ufrag:8hhy", err: true},
+}
+
+func TestCandidate(t *testing.T) {
+	for i, tc := range candidateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			c, err := jingle.ParseCandidate(tc.in)
+			switch {
+			case tc.err && err == nil:
+				t.Fatalf("expected error, got none")
+			case !tc.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.err:
+				return
+			}
+			if c.ID == "" {
+				t.Errorf("expected random ID to be generated")
+			}
+			c.ID = ""
+			if !reflect.DeepEqual(c, tc.out) {
+				t.Errorf("wrong candidate:\nwant=%+v,\n got=%+v", tc.out, c)
+			}
+			if sdp := c.SDP(); sdp != tc.sdp {
+				t.Errorf("wrong SDP:\nwant=%q,\n got=%q", tc.sdp, sdp)
+			}
+		})
+	}
+}
+
+func TestSDPRoundTrip(t *testing.T) {
+	contents := []jingle.Content{initiate.Content[0], {
+		Creator: jingle.Initiator,
+		Name:    "webcam",
+		Senders: jingle.SendersInitiator,
+		Description: &jingle.Description{
+			Media: "video",
+			PayloadTypes: []jingle.PayloadType{{
+				ID:        96,
+				Name:      "VP8",
+				ClockRate: 90000,
+				Feedback:  []jingle.Feedback{{Type: "nack", Subtype: "pli"}},
+			}},
+		},
+		Transport: &jingle.Transport{Ufrag: "8hhy", Pwd: "asd88fgpdd777uzjYhagZg"},
+	}}
+	// Senders is always set explicitly when parsing SDP.
+	contents[0].Senders = jingle.SendersBoth
+
+	for _, role := range []jingle.Creator{jingle.Initiator, jingle.Responder} {
+		t.Run(string(role), func(t *testing.T) {
+			sdp := jingle.MarshalSDP(role, contents)
+			out, err := jingle.UnmarshalSDP(role, sdp)
+			if err != nil {
+				t.Fatalf("error parsing SDP: %v\n%s", err, sdp)
+			}
+			if len(out) != len(contents) {
+				t.Fatalf("wrong number of contents: want=%d, got=%d", len(contents), len(out))
+			}
+			for i := range out {
+				out[i].Creator = jingle.Initiator
+				for j := range out[i].Transport.Candidates {
+					out[i].Transport.Candidates[j].ID = contents[i].Transport.Candidates[j].ID
+					// Network IDs are not included in the generated SDP.
+					out[i].Transport.Candidates[j].Network = contents[i].Transport.Candidates[j].Network
+				}
+			}
+			if !reflect.DeepEqual(out, contents) {
+				t.Errorf("wrong contents after round trip:\nwant=%+v,\n got=%+v\n%s", contents, out, sdp)
+			}
+		})
+	}
+}
+
+const browserSDP = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE 0 1\r\n" +
+	"a=ice-ufrag:EsAw\r\n" +
+	"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+	"a=fingerprint:sha-256 D2:FA:0E:C3:22:59:5E:14:95:69:92:3D:13:B4:84:24:2C:C2:A2:C0:3E:FD:34:8E:5E:EA:6F:AF:52:CE:E6:0F\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 0\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=setup:actpass\r\n" +
+	"a=mid:0\r\n" +
+	"a=recvonly\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=rtcp-fb:* transport-cc\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"a=candidate:1 1 udp 2130706431 10.0.1.1 8998 typ host\r\n" +
+	"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:1\r\n" +
+	"a=candidate:1 1 udp 2130706431 10.0.1.1 8999 typ host\r\n"
+
+func TestUnmarshalSDP(t *testing.T) {
+	contents, err := jingle.UnmarshalSDP(jingle.Responder, browserSDP)
+	if err != nil {
+		t.Fatalf("error parsing SDP: %v", err)
+	}
+	if len(contents) != 1 {
+		t.Fatalf("expected non-RTP media to be skipped, got %d contents", len(contents))
+	}
+	c := contents[0]
+	if c.Name != "0" || c.Creator != jingle.Responder || c.Senders != jingle.SendersInitiator {
+		t.Errorf("wrong content: %+v", c)
+	}
+	want := []jingle.PayloadType{{
+		ID:        111,
+		Name:      "opus",
+		ClockRate: 48000,
+		Channels:  2,
+		Parameters: []jingle.Parameter{
+			{Name: "minptime", Value: "10"},
+			{Name: "useinbandfec", Value: "1"},
+		},
+		Feedback: []jingle.Feedback{{Type: "transport-cc"}},
+	}, {
+		ID:       0,
+		Feedback: []jingle.Feedback{{Type: "transport-cc"}},
+	}}
+	if !reflect.DeepEqual(c.Description.PayloadTypes, want) {
+		t.Errorf("wrong payload types:\nwant=%+v,\n got=%+v", want, c.Description.PayloadTypes)
+	}
+	if !c.Description.RTCPMux {
+		t.Errorf("expected rtcp-mux to be set")
+	}
+	tr := c.Transport
+	if tr.Ufrag != "EsAw" || tr.Pwd != "P2uYro0UCOQ4zxjKXaWCBui1" {
+		t.Errorf("session level ICE credentials not applied: %+v", tr)
+	}
+	if tr.Fingerprint == nil || tr.Fingerprint.Hash != "sha-256" || tr.Fingerprint.Setup != "actpass" {
+		t.Errorf("wrong fingerprint: %+v", tr.Fingerprint)
+	}
+	if len(tr.Candidates) != 1 || tr.Candidates[0].Port != 8998 {
+		t.Errorf("wrong candidates: %+v", tr.Candidates)
+	}
+}
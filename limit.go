@@ -0,0 +1,105 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmpp/stream"
+)
+
+// decoderLookahead is the maximum number of bytes that the XML decoder may
+// have read from the connection without having returned them as tokens yet.
+// It matches the size of the buffer that encoding/xml uses.
+const decoderLookahead = 4096
+
+// byteCounter counts the bytes read from the connection so that oversized
+// stanzas can be rejected before the XML decoder buffers them in memory.
+type byteCounter struct {
+	r io.Reader
+	n int64
+	// If max is non-zero, reads fail once more than max bytes have been read.
+	max int64
+	// If metrics is not nil, the number of bytes read is reported to it.
+	metrics Metrics
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	if c.max > 0 && c.n > c.max {
+		return 0, stream.PolicyViolation
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if n > 0 && c.metrics != nil {
+		c.metrics.BytesRead(n)
+	}
+	return n, err
+}
+
+// newDecoder returns a decoder for the input stream that counts the bytes it
+// reads from r.
+func (s *Session) newDecoder(r io.Reader) *xml.Decoder {
+	s.in.counter = &byteCounter{r: r}
+	s.in.dec = xml.NewDecoder(s.in.counter)
+	return s.in.dec
+}
+
+// inputLimiter enforces the limits on incoming stanzas configured in
+// StreamConfig.
+type inputLimiter struct {
+	r        xml.TokenReader
+	d        *xml.Decoder
+	c        *byteCounter
+	maxSize  int64
+	maxDepth int
+	restrict bool
+
+	depth int
+	start int64
+	last  int64
+}
+
+func (l *inputLimiter) reset() {
+	l.start = l.d.InputOffset()
+	l.last = l.start
+	if l.maxSize > 0 {
+		l.c.max = l.start + l.maxSize + decoderLookahead
+	}
+}
+
+func (l *inputLimiter) Token() (xml.Token, error) {
+	tok, err := l.r.Token()
+	if err != nil {
+		return tok, err
+	}
+
+	switch tok.(type) {
+	case xml.StartElement:
+		if l.depth == 0 {
+			// Count the start token of the stanza as well.
+			l.start = l.last
+		}
+		l.depth++
+		if l.maxDepth > 0 && l.depth > l.maxDepth {
+			return nil, stream.PolicyViolation
+		}
+	case xml.EndElement:
+		l.depth--
+	case xml.Comment, xml.ProcInst, xml.Directive:
+		if l.restrict {
+			return nil, stream.RestrictedXML
+		}
+	}
+
+	l.last = l.d.InputOffset()
+	if l.maxSize > 0 && l.last-l.start > l.maxSize {
+		return nil, stream.PolicyViolation
+	}
+	if l.depth == 0 {
+		l.reset()
+	}
+	return tok, nil
+}
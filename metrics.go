@@ -0,0 +1,58 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"io"
+	"time"
+)
+
+// Metrics receives measurements from a session.
+// It can be used to export statistics to a monitoring system such as expvar
+// or Prometheus.
+//
+// Methods are called synchronously from the goroutine that did the work being
+// measured, possibly from many goroutines at once, so implementations must be
+// safe for concurrent use and should return quickly.
+type Metrics interface {
+	// Negotiated is called once when a session has been established with the
+	// amount of time that negotiation took.
+	// Because each connection or reconnection results in a new session, it can
+	// also be used to count reconnects.
+	Negotiated(d time.Duration)
+
+	// StanzaSent and StanzaReceived are called for each stanza written to or
+	// read from the session once it has been established.
+	// Kind is the local name of the stanza ("iq", "message", or "presence") and
+	// typ is the value of its type attribute, which may be empty.
+	StanzaSent(kind, typ string)
+	StanzaReceived(kind, typ string)
+
+	// BytesWritten and BytesRead are called with the number of bytes written to
+	// or read from the underlying connection once the session has been
+	// established.
+	BytesWritten(n int)
+	BytesRead(n int)
+
+	// IQLatency is called with the amount of time between sending an IQ with
+	// SendIQ (or any of the methods that depend on it) and receiving the
+	// response.
+	// IQs that time out or are canceled are not measured.
+	IQLatency(d time.Duration)
+}
+
+// metricsWriter reports the number of bytes written to the output stream.
+type metricsWriter struct {
+	w io.Writer
+	m Metrics
+}
+
+func (w metricsWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.m.BytesWritten(n)
+	}
+	return n, err
+}
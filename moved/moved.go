@@ -0,0 +1,250 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package moved implements XEP-0283: Moved.
+//
+// When a user moves to a new account, they publish the address of the new
+// account to a PEP node on the old account and then send subscription requests
+// to their contacts from the new account that include the old address.
+// Before updating their roster, contacts that receive such a request should
+// verify that the old account really points to the new one, otherwise anyone
+// could claim to be one of their contacts.
+package moved // import "mellium.im/xmpp/moved"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace of the moved element and the PEP node used to publish
+// the new address.
+const NS = "urn:xmpp:moved:1"
+
+const (
+	nsPubSub        = "http://jabber.org/protocol/pubsub"
+	nsPublishOption = "http://jabber.org/protocol/pubsub#publish-options"
+)
+
+// Errors returned by this package.
+var (
+	ErrMismatch    = errors.New("moved: old account does not point to the new account")
+	ErrNotInRoster = errors.New("moved: old account is not in the roster")
+)
+
+// Publish publishes the address of the new account to the PEP node of the old
+// account.
+// It must be called on a session belonging to the old account.
+// The node is configured so that anyone can read it, which is required for
+// contacts to be able to verify the move.
+func Publish(ctx context.Context, s *xmpp.Session, newAddr jid.JID) error {
+	opts := form.New(
+		form.Hidden("FORM_TYPE"),
+		form.List("pubsub#access_model"),
+	)
+	_, err := opts.Set("FORM_TYPE", nsPublishOption)
+	if err != nil {
+		return err
+	}
+	_, err = opts.Set("pubsub#access_model", "open")
+	if err != nil {
+		return err
+	}
+	submission, _ := opts.Submit()
+
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.MultiReader(
+			xmlstream.Wrap(
+				xmlstream.Wrap(
+					movedElement("new-jid", newAddr),
+					xml.StartElement{
+						Name: xml.Name{Local: "item"},
+						Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "current"}},
+					},
+				),
+				xml.StartElement{
+					Name: xml.Name{Local: "publish"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: NS}},
+				},
+			),
+			xmlstream.Wrap(
+				submission,
+				xml.StartElement{Name: xml.Name{Local: "publish-options"}},
+			),
+		),
+		xml.StartElement{Name: xml.Name{Space: nsPubSub, Local: "pubsub"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+func movedElement(local string, j jid.JID) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(j.Bare().String())),
+			xml.StartElement{Name: xml.Name{Local: local}},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "moved"}},
+	)
+}
+
+// Get fetches the address that the provided account has moved to.
+// If the account has not published a new address, the error returned by the
+// server (normally item-not-found) is returned.
+func Get(ctx context.Context, s *xmpp.Session, old jid.JID) (jid.JID, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Items   []struct {
+			NewJID jid.JID `xml:"urn:xmpp:moved:1 moved>new-jid"`
+		} `xml:"items>item"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "items"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: NS}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: nsPubSub, Local: "pubsub"}},
+	), stanza.IQ{
+		To:   old.Bare(),
+		Type: stanza.GetIQ,
+	}, &resp)
+	if err != nil {
+		return jid.JID{}, err
+	}
+	if len(resp.Items) == 0 {
+		return jid.JID{}, stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound}
+	}
+	return resp.Items[len(resp.Items)-1].NewJID, nil
+}
+
+// Verify checks that the old account has published the new account as the
+// address it moved to.
+// If it points somewhere else, ErrMismatch is returned.
+func Verify(ctx context.Context, s *xmpp.Session, old, newAddr jid.JID) error {
+	published, err := Get(ctx, s, old)
+	if err != nil {
+		return err
+	}
+	if !published.Bare().Equal(newAddr.Bare()) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// Subscribe sends a subscription request from the new account to a contact of
+// the old account that lets them know where we moved from.
+// It must be called on a session belonging to the new account.
+func Subscribe(ctx context.Context, s *xmpp.Session, to, old jid.JID) error {
+	return s.Send(ctx, stanza.Presence{
+		To:   to.Bare(),
+		Type: stanza.SubscribePresence,
+	}.Wrap(movedElement("old-jid", old)))
+}
+
+// UpdateRoster verifies that old moved to newAddr and then replaces old with
+// newAddr in the roster.
+//
+// The new roster item keeps the name and groups of the old one.
+// If old was allowed to see our presence the pending subscription request from
+// newAddr is approved, and if we were subscribed to the presence of old we
+// subscribe to newAddr.
+// Finally old is removed from the roster.
+//
+// If the move cannot be verified or old is not in the roster, the roster is
+// not changed and the subscription request should be treated like any other.
+func UpdateRoster(ctx context.Context, s *xmpp.Session, old, newAddr jid.JID) error {
+	err := Verify(ctx, s, old, newAddr)
+	if err != nil {
+		return err
+	}
+
+	var (
+		item  roster.Item
+		found bool
+	)
+	iter := roster.Fetch(ctx, s)
+	for iter.Next() {
+		if i := iter.Item(); i.JID.Bare().Equal(old.Bare()) {
+			item = i
+			found = true
+		}
+	}
+	err = iter.Err()
+	if err != nil {
+		/* #nosec */
+		iter.Close()
+		return err
+	}
+	err = iter.Close()
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotInRoster
+	}
+
+	err = roster.Set(ctx, s, roster.Item{
+		JID:   newAddr.Bare(),
+		Name:  item.Name,
+		Group: item.Group,
+	})
+	if err != nil {
+		return err
+	}
+	if item.Subscription == "from" || item.Subscription == "both" {
+		err = s.Send(ctx, stanza.Presence{
+			To:   newAddr.Bare(),
+			Type: stanza.SubscribedPresence,
+		}.Wrap(nil))
+		if err != nil {
+			return err
+		}
+	}
+	if item.Subscription == "to" || item.Subscription == "both" {
+		err = s.Send(ctx, stanza.Presence{
+			To:   newAddr.Bare(),
+			Type: stanza.SubscribePresence,
+		}.Wrap(nil))
+		if err != nil {
+			return err
+		}
+	}
+	return roster.Delete(ctx, s, old.Bare())
+}
+
+// Handle returns an option that registers a Handler for subscription requests
+// that include a moved notification.
+func Handle(h Handler) mux.Option {
+	return mux.PresencePayload(stanza.SubscribePresence, xml.Name{Space: NS, Local: "moved"}, h)
+}
+
+// Handler receives subscription requests from contacts that have moved to a new
+// account.
+type Handler struct {
+	// Moved is called with the subscription request and the old address that the
+	// sender claims to have moved from.
+	// The claim has not been verified, see Verify and UpdateRoster.
+	Moved func(p stanza.Presence, old jid.JID) error
+}
+
+// HandlePresencePayload implements mux.PresencePayloadHandler.
+func (h Handler) HandlePresencePayload(p stanza.Presence, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if h.Moved == nil {
+		return nil
+	}
+	var moved struct {
+		OldJID jid.JID `xml:"old-jid"`
+	}
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&moved)
+	if err != nil {
+		return err
+	}
+	return h.Moved(p, moved.OldJID)
+}
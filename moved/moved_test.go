@@ -0,0 +1,135 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package moved_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/moved"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+const movedResult = `<iq type="result" xmlns="jabber:client"><pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="urn:xmpp:moved:1"><item id="current"><moved xmlns="urn:xmpp:moved:1"><new-jid>%s</new-jid></moved></item></items></pubsub></iq>`
+
+var verifyTestCases = [...]struct {
+	resp string
+	err  error
+}{
+	0: {resp: strings.Replace(movedResult, "%s", "juliet@capulet.example", 1)},
+	1: {
+		resp: strings.Replace(movedResult, "%s", "nurse@capulet.example", 1),
+		err:  moved.ErrMismatch,
+	},
+	2: {
+		resp: `<iq type="error" xmlns="jabber:client"><error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`,
+		err:  stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound},
+	},
+}
+
+func TestVerify(t *testing.T) {
+	for i, tc := range verifyTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m := xmpptest.NewMock(t)
+			m.Expect(`<iq type="get" to="juliet@montague.example"><pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="urn:xmpp:moved:1"/></pubsub></iq>`).Reply(tc.resp)
+			cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+			defer cs.Close()
+
+			err := moved.Verify(context.Background(), cs.Client,
+				jid.MustParse("juliet@montague.example/balcony"),
+				jid.MustParse("juliet@capulet.example"))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			m.Done()
+		})
+	}
+}
+
+func TestUpdateRoster(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="get"><pubsub><items node="urn:xmpp:moved:1"/></pubsub></iq>`).Reply(
+		strings.Replace(movedResult, "%s", "juliet@capulet.example", 1),
+	)
+	m.Expect(`<iq type="get"><query xmlns="jabber:iq:roster"/></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="jabber:iq:roster"><item jid="nurse@capulet.example" subscription="both"/><item jid="juliet@montague.example" name="Juliet" subscription="from"><group>Friends</group></item></query></iq>`,
+	)
+	m.Expect(`<iq type="set"><query xmlns="jabber:iq:roster"><item jid="juliet@capulet.example" name="Juliet"><group>Friends</group></item></query></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	m.Expect(`<presence type="subscribed" to="juliet@capulet.example"/>`)
+	m.Expect(`<iq type="set"><query xmlns="jabber:iq:roster"><item jid="juliet@montague.example" subscription="remove"/></query></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"/>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	err := moved.UpdateRoster(context.Background(), cs.Client,
+		jid.MustParse("juliet@montague.example"),
+		jid.MustParse("juliet@capulet.example"))
+	if err != nil {
+		t.Fatalf("error updating roster: %v", err)
+	}
+	m.Done()
+}
+
+func TestUpdateRosterNotFound(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="get"><pubsub><items node="urn:xmpp:moved:1"/></pubsub></iq>`).Reply(
+		strings.Replace(movedResult, "%s", "juliet@capulet.example", 1),
+	)
+	m.Expect(`<iq type="get"><query xmlns="jabber:iq:roster"/></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="jabber:iq:roster"><item jid="nurse@capulet.example" subscription="both"/></query></iq>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	err := moved.UpdateRoster(context.Background(), cs.Client,
+		jid.MustParse("juliet@montague.example"),
+		jid.MustParse("juliet@capulet.example"))
+	if err != moved.ErrNotInRoster {
+		t.Fatalf("wrong error: want=%v, got=%v", moved.ErrNotInRoster, err)
+	}
+	m.Done()
+}
+
+func TestHandle(t *testing.T) {
+	var old jid.JID
+	m := mux.New(moved.Handle(moved.Handler{
+		Moved: func(_ stanza.Presence, j jid.JID) error {
+			old = j
+			return nil
+		},
+	}))
+	d := xml.NewDecoder(strings.NewReader(`<presence xmlns="jabber:client" type="subscribe" from="juliet@capulet.example" to="romeo@montague.example"><moved xmlns="urn:xmpp:moved:1"><old-jid>juliet@montague.example</old-jid></moved></presence>`))
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error popping start token: %v", err)
+	}
+	start := tok.(xml.StartElement)
+	var buf bytes.Buffer
+	err = m.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: d,
+		Encoder:     xml.NewEncoder(&buf),
+	}, &start)
+	if err != nil {
+		t.Fatalf("error handling presence: %v", err)
+	}
+	if want := "juliet@montague.example"; old.String() != want {
+		t.Errorf("wrong old JID: want=%s, got=%s", want, old)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSRequest is the FORM_TYPE used by voice requests.
+const NSRequest = `http://jabber.org/protocol/muc#request`
+
+var (
+	errMissingRequired = errors.New("muc: room configuration is missing required fields")
+	errNoConfigForm    = errors.New("muc: room did not return a configuration form")
+	errNoJID           = errors.New("muc: affiliation changes require a JID")
+	errNoNick          = errors.New("muc: role changes require a nickname")
+)
+
+// Affiliation is a long lived association between a user and a room.
+type Affiliation string
+
+// A list of affiliations defined by XEP-0045.
+const (
+	AffiliationOwner   Affiliation = "owner"
+	AffiliationAdmin   Affiliation = "admin"
+	AffiliationMember  Affiliation = "member"
+	AffiliationOutcast Affiliation = "outcast"
+	AffiliationNone    Affiliation = "none"
+)
+
+// Role is a temporary association between an occupant and a room that lasts
+// for the duration of a visit.
+type Role string
+
+// A list of roles defined by XEP-0045.
+const (
+	RoleModerator   Role = "moderator"
+	RoleParticipant Role = "participant"
+	RoleVisitor     Role = "visitor"
+	RoleNone        Role = "none"
+)
+
+// Item is a user or occupant in an affiliation list or in a request to change
+// an affiliation or role.
+type Item struct {
+	JID         jid.JID     `xml:"jid,attr"`
+	Nick        string      `xml:"nick,attr"`
+	Affiliation Affiliation `xml:"affiliation,attr"`
+	Role        Role        `xml:"role,attr"`
+	Reason      string      `xml:"reason"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (i Item) TokenReader() xml.TokenReader {
+	var attrs []xml.Attr
+	if i.Affiliation != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "affiliation"}, Value: string(i.Affiliation)})
+	}
+	if !i.JID.Equal(jid.JID{}) {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "jid"}, Value: i.JID.String()})
+	}
+	if i.Nick != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "nick"}, Value: i.Nick})
+	}
+	if i.Role != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "role"}, Value: string(i.Role)})
+	}
+	var inner xml.TokenReader
+	if i.Reason != "" {
+		inner = xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(i.Reason)),
+			xml.StartElement{Name: xml.Name{Local: "reason"}},
+		)
+	}
+	return xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Local: "item"},
+		Attr: attrs,
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (i Item) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, i.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (i Item) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := i.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Room is used to configure and moderate a chat room.
+// Most methods require that the user have an appropriate affiliation or role
+// in the room and will return a stanza error if they do not.
+type Room struct {
+	addr jid.JID
+	s    *xmpp.Session
+}
+
+// NewRoom returns a Room that sends administrative requests for the room at
+// addr over the provided session.
+func NewRoom(s *xmpp.Session, addr jid.JID) *Room {
+	return &Room{
+		addr: addr.Bare(),
+		s:    s,
+	}
+}
+
+// Addr returns the bare JID of the room.
+func (r *Room) Addr() jid.JID {
+	return r.addr
+}
+
+// Config fetches the room configuration form.
+// The returned form can be modified and passed to SetConfig.
+func (r *Room) Config(ctx context.Context) (*form.Data, error) {
+	var query struct {
+		Form *form.Data `xml:"jabber:x:data x"`
+	}
+	err := r.s.UnmarshalIQElement(ctx, ownerQuery(nil), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   r.addr,
+	}, &query)
+	if err != nil {
+		return nil, err
+	}
+	if query.Form == nil {
+		return nil, errNoConfigForm
+	}
+	return query.Form, nil
+}
+
+// SetConfig submits a room configuration form previously returned by Config.
+// If any required fields have not been set an error is returned and the form
+// is not submitted.
+func (r *Room) SetConfig(ctx context.Context, config *form.Data) error {
+	submission, ok := config.Submit()
+	if !ok {
+		return errMissingRequired
+	}
+	return r.s.UnmarshalIQElement(ctx, ownerQuery(submission), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   r.addr,
+	}, nil)
+}
+
+// Destroy destroys the room.
+// If alternate is not the zero value, occupants are told that they can join
+// the alternate room instead.
+func (r *Room) Destroy(ctx context.Context, alternate jid.JID, reason string) error {
+	var attrs []xml.Attr
+	if !alternate.Equal(jid.JID{}) {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "jid"}, Value: alternate.String()})
+	}
+	var inner xml.TokenReader
+	if reason != "" {
+		inner = xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(reason)),
+			xml.StartElement{Name: xml.Name{Local: "reason"}},
+		)
+	}
+	return r.s.UnmarshalIQElement(ctx, ownerQuery(xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Local: "destroy"},
+		Attr: attrs,
+	})), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   r.addr,
+	}, nil)
+}
+
+// RequestVoice asks the moderators of the room to grant us voice so that we
+// can send messages to a moderated room.
+func (r *Room) RequestVoice(ctx context.Context) error {
+	data := form.New(
+		form.Hidden("FORM_TYPE"),
+		form.List("muc#role"),
+	)
+	/* #nosec */
+	data.Set("FORM_TYPE", NSRequest)
+	/* #nosec */
+	data.Set("muc#role", string(RoleParticipant))
+	submission, _ := data.Submit()
+	return r.s.Send(ctx, stanza.Message{
+		To:   r.addr,
+		Type: stanza.NormalMessage,
+	}.Wrap(submission))
+}
+
+// Affiliations fetches the list of users with the provided affiliation.
+func (r *Room) Affiliations(ctx context.Context, a Affiliation) ([]Item, error) {
+	var query struct {
+		Items []Item `xml:"item"`
+	}
+	err := r.s.UnmarshalIQElement(ctx, adminQuery(Item{Affiliation: a}.TokenReader()), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   r.addr,
+	}, &query)
+	return query.Items, err
+}
+
+// SetAffiliations changes the affiliations of the users with the JIDs in
+// items in a single request.
+// Each item must have a JID and an affiliation.
+func (r *Room) SetAffiliations(ctx context.Context, items ...Item) error {
+	for _, item := range items {
+		if item.JID.Equal(jid.JID{}) {
+			return errNoJID
+		}
+	}
+	return r.setItems(ctx, items)
+}
+
+// SetRoles changes the roles of the occupants with the nicknames in items in
+// a single request.
+// Each item must have a nickname and a role.
+func (r *Room) SetRoles(ctx context.Context, items ...Item) error {
+	for _, item := range items {
+		if item.Nick == "" {
+			return errNoNick
+		}
+	}
+	return r.setItems(ctx, items)
+}
+
+func (r *Room) setItems(ctx context.Context, items []Item) error {
+	inner := make([]xml.TokenReader, 0, len(items))
+	for _, item := range items {
+		inner = append(inner, item.TokenReader())
+	}
+	return r.s.UnmarshalIQElement(ctx, adminQuery(xmlstream.MultiReader(inner...)), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   r.addr,
+	}, nil)
+}
+
+func ownerQuery(inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}})
+}
+
+func adminQuery(inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}})
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler       = muc.Item{}
+	_ xmlstream.Marshaler = muc.Item{}
+	_ xmlstream.WriterTo  = muc.Item{}
+)
+
+type adminQuery struct {
+	XMLName xml.Name
+	Items   []muc.Item `xml:"item"`
+	Destroy struct {
+		JID    jid.JID `xml:"jid,attr"`
+		Reason string  `xml:"reason"`
+	} `xml:"destroy"`
+	Form *form.Data `xml:"jabber:x:data x"`
+}
+
+// roomServer returns a ClientServer where the server decodes the payload of
+// every IQ it receives into req and responds with the provided result payload.
+func roomServer(req *adminQuery, result string) *xmpptest.ClientServer {
+	return xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			iq, err := stanza.NewIQ(*start)
+			if err != nil {
+				return err
+			}
+			*req = adminQuery{}
+			err = xml.NewTokenDecoder(xmlstream.Inner(e)).Decode(req)
+			if err != nil {
+				return err
+			}
+			var payload xml.TokenReader
+			if result != "" {
+				payload = xml.NewDecoder(strings.NewReader(result))
+			}
+			_, err = xmlstream.Copy(e, iq.Result(payload))
+			return err
+		}),
+	)
+}
+
+func TestAffiliations(t *testing.T) {
+	var req adminQuery
+	cs := roomServer(&req, `<query xmlns="http://jabber.org/protocol/muc#admin"><item affiliation="member" jid="hag66@shakespeare.lit" nick="thirdwitch"/><item affiliation="member" jid="hecate@shakespeare.lit"/></query>`)
+	room := muc.NewRoom(cs.Client, jid.MustParse("coven@chat.shakespeare.lit/firstwitch"))
+	if want := jid.MustParse("coven@chat.shakespeare.lit"); !room.Addr().Equal(want) {
+		t.Errorf("wrong room address: want=%v, got=%v", want, room.Addr())
+	}
+
+	items, err := room.Affiliations(context.Background(), muc.AffiliationMember)
+	if err != nil {
+		t.Fatalf("error fetching affiliations: %v", err)
+	}
+	if req.XMLName.Space != muc.NSAdmin {
+		t.Errorf("wrong request namespace: want=%s, got=%s", muc.NSAdmin, req.XMLName.Space)
+	}
+	if expected := []muc.Item{{Affiliation: muc.AffiliationMember}}; !reflect.DeepEqual(req.Items, expected) {
+		t.Errorf("wrong request items: want=%+v, got=%+v", expected, req.Items)
+	}
+	expected := []muc.Item{{
+		JID:         jid.MustParse("hag66@shakespeare.lit"),
+		Nick:        "thirdwitch",
+		Affiliation: muc.AffiliationMember,
+	}, {
+		JID:         jid.MustParse("hecate@shakespeare.lit"),
+		Affiliation: muc.AffiliationMember,
+	}}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("wrong items:\nwant=%+v,\n got=%+v", expected, items)
+	}
+}
+
+func TestSetRolesAndAffiliations(t *testing.T) {
+	var req adminQuery
+	cs := roomServer(&req, "")
+	room := muc.NewRoom(cs.Client, jid.MustParse("coven@chat.shakespeare.lit"))
+
+	err := room.SetAffiliations(context.Background(), muc.Item{Nick: "thirdwitch", Affiliation: muc.AffiliationOutcast})
+	if err == nil {
+		t.Errorf("expected error setting affiliation without a JID")
+	}
+	err = room.SetRoles(context.Background(), muc.Item{JID: jid.MustParse("hag66@shakespeare.lit"), Role: muc.RoleVisitor})
+	if err == nil {
+		t.Errorf("expected error setting role without a nickname")
+	}
+	if req.XMLName.Local != "" {
+		t.Fatalf("invalid requests should not be sent, got: %+v", req)
+	}
+
+	roles := []muc.Item{
+		{Nick: "thirdwitch", Role: muc.RoleVisitor, Reason: "Be quiet"},
+		{Nick: "secondwitch", Role: muc.RoleParticipant},
+	}
+	err = room.SetRoles(context.Background(), roles...)
+	if err != nil {
+		t.Fatalf("error setting roles: %v", err)
+	}
+	if !reflect.DeepEqual(req.Items, roles) {
+		t.Errorf("wrong request items: want=%+v, got=%+v", roles, req.Items)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var req adminQuery
+	cs := roomServer(&req, `<query xmlns="http://jabber.org/protocol/muc#owner"><x xmlns="jabber:x:data" type="form"><field type="text-single" var="muc#roomconfig_roomname"><value>A Dark Cave</value></field></x></query>`)
+	room := muc.NewRoom(cs.Client, jid.MustParse("coven@chat.shakespeare.lit"))
+
+	config, err := room.Config(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching config: %v", err)
+	}
+	name, _ := config.GetString("muc#roomconfig_roomname")
+	if name != "A Dark Cave" {
+		t.Errorf("wrong room name: want=%q, got=%q", "A Dark Cave", name)
+	}
+
+	_, err = config.Set("muc#roomconfig_roomname", "The Coven")
+	if err != nil {
+		t.Fatalf("error setting room name: %v", err)
+	}
+	err = room.SetConfig(context.Background(), config)
+	if err != nil {
+		t.Fatalf("error submitting config: %v", err)
+	}
+	if req.XMLName.Space != muc.NSOwner || req.Form == nil {
+		t.Fatalf("expected form submission in owner namespace, got: %+v", req)
+	}
+	if name, _ := req.Form.GetString("muc#roomconfig_roomname"); name != "The Coven" {
+		t.Errorf("wrong room name submitted: want=%q, got=%q", "The Coven", name)
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	var req adminQuery
+	cs := roomServer(&req, "")
+	room := muc.NewRoom(cs.Client, jid.MustParse("coven@chat.shakespeare.lit"))
+	alt := jid.MustParse("heath@chat.shakespeare.lit")
+	err := room.Destroy(context.Background(), alt, "Macbeth doth come.")
+	if err != nil {
+		t.Fatalf("error destroying room: %v", err)
+	}
+	if !req.Destroy.JID.Equal(alt) {
+		t.Errorf("wrong alternate room: want=%v, got=%v", alt, req.Destroy.JID)
+	}
+	if req.Destroy.Reason != "Macbeth doth come." {
+		t.Errorf("wrong reason: want=%q, got=%q", "Macbeth doth come.", req.Destroy.Reason)
+	}
+}
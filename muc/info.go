@@ -0,0 +1,120 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"strconv"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/jid"
+)
+
+// NSRoomInfo is the FORM_TYPE of the extended information form that rooms
+// include in their service discovery information.
+const NSRoomInfo = `http://jabber.org/protocol/muc#roominfo`
+
+// RoomInfo is metadata about a room gathered from its service discovery
+// information.
+type RoomInfo struct {
+	Name        string
+	Description string
+	Subject     string
+	Lang        string
+	LogsURL     string
+	Contacts    []jid.JID
+
+	// Occupants is the number of occupants in the room, or -1 if the room does
+	// not advertise it.
+	Occupants int
+
+	MembersOnly       bool
+	Persistent        bool
+	Moderated         bool
+	PasswordProtected bool
+	Public            bool
+
+	// Anonymous is true if the real JIDs of occupants can only be discovered by
+	// moderators (a "semi-anonymous" room).
+	Anonymous bool
+
+	// OccupantID is true if the room adds occupant IDs to the messages and
+	// presences it sends.
+	OccupantID bool
+}
+
+// GetRoomInfo queries a room for its service discovery information and
+// returns the metadata it advertises.
+func GetRoomInfo(ctx context.Context, s *xmpp.Session, room jid.JID) (RoomInfo, error) {
+	info, err := disco.GetInfo(ctx, "", room.Bare(), s)
+	if err != nil {
+		return RoomInfo{}, err
+	}
+	return roomInfo(info), nil
+}
+
+func roomInfo(info disco.Info) RoomInfo {
+	ri := RoomInfo{
+		Occupants: -1,
+	}
+	for _, ident := range info.Identity {
+		if ident.Category == "conference" && ident.Name != "" {
+			ri.Name = ident.Name
+			break
+		}
+	}
+	for _, f := range info.Features {
+		switch f.Var {
+		case "muc_membersonly":
+			ri.MembersOnly = true
+		case "muc_persistent":
+			ri.Persistent = true
+		case "muc_moderated":
+			ri.Moderated = true
+		case "muc_passwordprotected":
+			ri.PasswordProtected = true
+		case "muc_public":
+			ri.Public = true
+		case "muc_semianonymous":
+			ri.Anonymous = true
+		case NSOccupantID:
+			ri.OccupantID = true
+		}
+	}
+
+	data, ok := info.Form(NSRoomInfo)
+	if !ok {
+		return ri
+	}
+	// Result forms often omit field types, so read the raw values instead of
+	// relying on the typed getters.
+	value := func(id string) string {
+		v, _ := data.Values(id)
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0]
+	}
+	if name := value("muc#roomconfig_roomname"); name != "" {
+		ri.Name = name
+	}
+	ri.Description = value("muc#roominfo_description")
+	ri.Subject = value("muc#roominfo_subject")
+	ri.Lang = value("muc#roominfo_lang")
+	ri.LogsURL = value("muc#roominfo_logs")
+	if n, err := strconv.Atoi(value("muc#roominfo_occupants")); err == nil {
+		ri.Occupants = n
+	}
+	contacts, _ := data.Values("muc#roominfo_contactjid")
+	for _, c := range contacts {
+		j, err := jid.Parse(c)
+		if err != nil {
+			continue
+		}
+		ri.Contacts = append(ri.Contacts, j)
+	}
+	return ri
+}
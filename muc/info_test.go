@@ -0,0 +1,64 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/xmpptest"
+)
+
+func TestGetRoomInfo(t *testing.T) {
+	cs := xmpptest.NewClientServer(xmpptest.ServerScript(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="http://jabber.org/protocol/disco#info">` +
+			`<identity category="conference" name="A Dark Cave" type="text"/>` +
+			`<feature var="http://jabber.org/protocol/muc"/>` +
+			`<feature var="muc_passwordprotected"/>` +
+			`<feature var="muc_hidden"/>` +
+			`<feature var="muc_persistent"/>` +
+			`<feature var="muc_membersonly"/>` +
+			`<feature var="muc_semianonymous"/>` +
+			`<feature var="urn:xmpp:occupant-id:0"/>` +
+			`<x xmlns="jabber:x:data" type="result">` +
+			`<field var="FORM_TYPE" type="hidden"><value>http://jabber.org/protocol/muc#roominfo</value></field>` +
+			`<field var="muc#roominfo_description" label="Description"><value>The place for all good witches!</value></field>` +
+			`<field var="muc#roominfo_contactjid" label="Contact Addresses"><value>crone1@shakespeare.lit</value><value>crone2@shakespeare.lit</value></field>` +
+			`<field var="muc#roominfo_subject" label="Current Discussion Topic"><value>Spells</value></field>` +
+			`<field var="muc#roominfo_occupants" label="Number of occupants"><value>3</value></field>` +
+			`<field var="muc#roominfo_lang" label="Language of discussion"><value>en</value></field>` +
+			`<field var="muc#roominfo_logs" label="URL for discussion logs"><value>http://www.shakespeare.lit/chatlogs/darkcave/</value></field>` +
+			`</x></query></iq>`,
+	))
+	defer cs.Close()
+
+	info, err := muc.GetRoomInfo(context.Background(), cs.Client, jid.MustParse("darkcave@chat.shakespeare.lit/thirdwitch"))
+	if err != nil {
+		t.Fatalf("error getting room info: %v", err)
+	}
+	want := muc.RoomInfo{
+		Name:        "A Dark Cave",
+		Description: "The place for all good witches!",
+		Subject:     "Spells",
+		Lang:        "en",
+		LogsURL:     "http://www.shakespeare.lit/chatlogs/darkcave/",
+		Contacts: []jid.JID{
+			jid.MustParse("crone1@shakespeare.lit"),
+			jid.MustParse("crone2@shakespeare.lit"),
+		},
+		Occupants:         3,
+		MembersOnly:       true,
+		Persistent:        true,
+		PasswordProtected: true,
+		Anonymous:         true,
+		OccupantID:        true,
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("wrong room info:\nwant=%+v,\n got=%+v", want, info)
+	}
+}
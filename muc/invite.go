@@ -0,0 +1,285 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSConference is the namespace used by direct invitations as defined in
+// XEP-0249: Direct MUC Invitations.
+const NSConference = `jabber:x:conference`
+
+var errDirectDecline = errors.New("muc: direct invitations cannot be declined")
+
+// Invitation is an invitation to join a chat room.
+// Mediated invitations are sent through the room, direct invitations
+// (XEP-0249: Direct MUC Invitations) are sent straight to the invitee.
+type Invitation struct {
+	// Room is the bare JID of the chat room.
+	Room jid.JID
+	// From is the JID of the user that sent the invitation.
+	From jid.JID
+	// Reason is an optional human readable reason for the invitation.
+	Reason string
+	// Password is the room password, if any.
+	Password string
+	// Continue indicates that the invitation is a continuation of a one-to-one
+	// chat in the thread identified by Thread.
+	Continue bool
+	Thread   string
+	// Direct is true if the invitation is (or should be sent as) a direct
+	// invitation.
+	Direct bool
+}
+
+// Declined is sent through a chat room when an invitee declines a mediated
+// invitation.
+type Declined struct {
+	// Room is the bare JID of the chat room.
+	Room jid.JID
+	// From is the JID of the user that declined the invitation.
+	From jid.JID
+	// Reason is an optional human readable reason for declining.
+	Reason string
+}
+
+// PrivateMessage is a message sent directly from one occupant of a chat room to
+// another.
+type PrivateMessage struct {
+	stanza.Message
+	Body string
+	// OccupantID is the stable identifier of the sender added by the room, if
+	// any.
+	OccupantID OccupantID
+}
+
+// Invite sends inv to the user at the provided address.
+// If inv.Direct is set, the invitation is sent directly to the user, otherwise
+// it is sent to the room to be forwarded to the user.
+func Invite(ctx context.Context, s *xmpp.Session, to jid.JID, inv Invitation) error {
+	if inv.Direct {
+		attrs := []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: inv.Room.Bare().String()}}
+		if inv.Password != "" {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "password"}, Value: inv.Password})
+		}
+		if inv.Reason != "" {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "reason"}, Value: inv.Reason})
+		}
+		if inv.Continue {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "continue"}, Value: "true"})
+			if inv.Thread != "" {
+				attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "thread"}, Value: inv.Thread})
+			}
+		}
+		return s.Send(ctx, stanza.Message{
+			To:   to,
+			Type: stanza.NormalMessage,
+		}.Wrap(xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: NSConference, Local: "x"},
+			Attr: attrs,
+		})))
+	}
+
+	var inner []xml.TokenReader
+	if inv.Reason != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(inv.Reason)),
+			xml.StartElement{Name: xml.Name{Local: "reason"}},
+		))
+	}
+	if inv.Continue {
+		var attrs []xml.Attr
+		if inv.Thread != "" {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "thread"}, Value: inv.Thread})
+		}
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "continue"},
+			Attr: attrs,
+		}))
+	}
+	payload := []xml.TokenReader{xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{
+			Name: xml.Name{Local: "invite"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "to"}, Value: to.String()}},
+		},
+	)}
+	if inv.Password != "" {
+		payload = append(payload, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(inv.Password)),
+			xml.StartElement{Name: xml.Name{Local: "password"}},
+		))
+	}
+	return s.Send(ctx, stanza.Message{
+		To:   inv.Room.Bare(),
+		Type: stanza.NormalMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.MultiReader(payload...),
+		xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}},
+	)))
+}
+
+// Decline declines a mediated invitation.
+// Direct invitations have no mechanism for declining them and will result in
+// an error.
+func Decline(ctx context.Context, s *xmpp.Session, inv Invitation, reason string) error {
+	if inv.Direct {
+		return errDirectDecline
+	}
+	var inner xml.TokenReader
+	if reason != "" {
+		inner = xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(reason)),
+			xml.StartElement{Name: xml.Name{Local: "reason"}},
+		)
+	}
+	return s.Send(ctx, stanza.Message{
+		To:   inv.Room.Bare(),
+		Type: stanza.NormalMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(inner, xml.StartElement{
+			Name: xml.Name{Local: "decline"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "to"}, Value: inv.From.String()}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}},
+	)))
+}
+
+// SendPrivate sends a private message to the occupant of a chat room with the
+// provided occupant JID (the room JID with the occupants nickname as the
+// resourcepart).
+func SendPrivate(ctx context.Context, s *xmpp.Session, occupant jid.JID, body string) error {
+	return s.Send(ctx, stanza.Message{
+		To:   occupant,
+		Type: stanza.ChatMessage,
+	}.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(body)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}}),
+	)))
+}
+
+// HandleInvites returns an option that registers a Handler for invitations,
+// declined invitations, and private messages.
+func HandleInvites(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		user := xml.Name{Space: NSUser, Local: "x"}
+		conference := xml.Name{Space: NSConference, Local: "x"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage} {
+			mux.Message(typ, user, h)(m)
+			mux.Message(typ, conference, h)(m)
+		}
+		mux.Message(stanza.ChatMessage, user, h)(m)
+	}
+}
+
+// Handler receives invitations, declined invitations, and private messages
+// and passes them to the corresponding callback.
+// Any nil callbacks are ignored.
+type Handler struct {
+	Invite  func(Invitation) error
+	Decline func(Declined) error
+	Private func(PrivateMessage) error
+}
+
+type mucMessage struct {
+	Body       string     `xml:"body"`
+	OccupantID OccupantID `xml:"urn:xmpp:occupant-id:0 occupant-id"`
+	User       *struct {
+		Invite *struct {
+			From     jid.JID `xml:"from,attr"`
+			Reason   string  `xml:"reason"`
+			Continue *struct {
+				Thread string `xml:"thread,attr"`
+			} `xml:"continue"`
+		} `xml:"invite"`
+		Decline *struct {
+			From   jid.JID `xml:"from,attr"`
+			Reason string  `xml:"reason"`
+		} `xml:"decline"`
+		Password string `xml:"password"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+	Conference *struct {
+		JID      jid.JID `xml:"jid,attr"`
+		Password string  `xml:"password,attr"`
+		Reason   string  `xml:"reason,attr"`
+		Continue string  `xml:"continue,attr"`
+		Thread   string  `xml:"thread,attr"`
+	} `xml:"jabber:x:conference x"`
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	var m mucMessage
+	err := xml.NewTokenDecoder(t).Decode(&m)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case m.Conference != nil:
+		if h.Invite == nil {
+			return nil
+		}
+		cont, _ := strconv.ParseBool(m.Conference.Continue)
+		return h.Invite(Invitation{
+			Room:     m.Conference.JID,
+			From:     msg.From,
+			Reason:   m.Conference.Reason,
+			Password: m.Conference.Password,
+			Continue: cont,
+			Thread:   m.Conference.Thread,
+			Direct:   true,
+		})
+	case m.User == nil:
+		return nil
+	case m.User.Invite != nil:
+		if h.Invite == nil {
+			return nil
+		}
+		inv := Invitation{
+			Room:     msg.From.Bare(),
+			From:     m.User.Invite.From,
+			Reason:   m.User.Invite.Reason,
+			Password: m.User.Password,
+		}
+		if m.User.Invite.Continue != nil {
+			inv.Continue = true
+			inv.Thread = m.User.Invite.Continue.Thread
+		}
+		return h.Invite(inv)
+	case m.User.Decline != nil:
+		if h.Decline == nil {
+			return nil
+		}
+		return h.Decline(Declined{
+			Room:   msg.From.Bare(),
+			From:   m.User.Decline.From,
+			Reason: m.User.Decline.Reason,
+		})
+	case msg.Type == stanza.ChatMessage && msg.From.Resourcepart() != "":
+		if h.Private == nil {
+			return nil
+		}
+		return h.Private(PrivateMessage{
+			Message:    msg,
+			Body:       m.Body,
+			OccupantID: m.OccupantID,
+		})
+	}
+	return nil
+}
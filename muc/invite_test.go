@@ -0,0 +1,147 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var inviteHandlerTestCases = [...]struct {
+	x       string
+	invite  *muc.Invitation
+	decline *muc.Declined
+	private string
+}{
+	0: {
+		x: `<message from="coven@chat.shakespeare.lit" to="hecate@shakespeare.lit" xmlns="jabber:client"><x xmlns="http://jabber.org/protocol/muc#user"><invite from="crone1@shakespeare.lit/desktop"><reason>Hey Hecate, this is the place for all good witches!</reason><continue thread="e0ffe42b28561960c6b12b944a092794b9683a38"/></invite><password>cauldronburn</password></x></message>`,
+		invite: &muc.Invitation{
+			Room:     jid.MustParse("coven@chat.shakespeare.lit"),
+			From:     jid.MustParse("crone1@shakespeare.lit/desktop"),
+			Reason:   "Hey Hecate, this is the place for all good witches!",
+			Password: "cauldronburn",
+			Continue: true,
+			Thread:   "e0ffe42b28561960c6b12b944a092794b9683a38",
+		},
+	},
+	1: {
+		x: `<message from="crone1@shakespeare.lit/desktop" to="hecate@shakespeare.lit" type="normal" xmlns="jabber:client"><x xmlns="jabber:x:conference" jid="darkcave@macbeth.shakespeare.lit" password="cauldronburn" reason="Hey Hecate, this is the place for all good witches!"/></message>`,
+		invite: &muc.Invitation{
+			Room:     jid.MustParse("darkcave@macbeth.shakespeare.lit"),
+			From:     jid.MustParse("crone1@shakespeare.lit/desktop"),
+			Reason:   "Hey Hecate, this is the place for all good witches!",
+			Password: "cauldronburn",
+			Direct:   true,
+		},
+	},
+	2: {
+		x: `<message from="coven@chat.shakespeare.lit" to="crone1@shakespeare.lit/desktop" xmlns="jabber:client"><x xmlns="http://jabber.org/protocol/muc#user"><decline from="hecate@shakespeare.lit"><reason>Sorry, I'm too busy right now.</reason></decline></x></message>`,
+		decline: &muc.Declined{
+			Room:   jid.MustParse("coven@chat.shakespeare.lit"),
+			From:   jid.MustParse("hecate@shakespeare.lit"),
+			Reason: "Sorry, I'm too busy right now.",
+		},
+	},
+	3: {
+		x:       `<message from="coven@chat.shakespeare.lit/firstwitch" to="crone1@shakespeare.lit/desktop" type="chat" xmlns="jabber:client"><body>I'll give thee a wind.</body><x xmlns="http://jabber.org/protocol/muc#user"/></message>`,
+		private: "I'll give thee a wind.",
+	},
+}
+
+func TestInviteHandler(t *testing.T) {
+	for i, tc := range inviteHandlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var (
+				invite  *muc.Invitation
+				decline *muc.Declined
+				private string
+			)
+			m := mux.New(muc.HandleInvites(muc.Handler{
+				Invite: func(inv muc.Invitation) error {
+					invite = &inv
+					return nil
+				},
+				Decline: func(d muc.Declined) error {
+					decline = &d
+					return nil
+				},
+				Private: func(msg muc.PrivateMessage) error {
+					private = msg.Body
+					return nil
+				},
+			}))
+			d := xml.NewDecoder(strings.NewReader(tc.x))
+			tok, _ := d.Token()
+			start := tok.(xml.StartElement)
+			err := m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+			}, &start)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(invite, tc.invite) {
+				t.Errorf("wrong invite: want=%+v, got=%+v", tc.invite, invite)
+			}
+			if !reflect.DeepEqual(decline, tc.decline) {
+				t.Errorf("wrong decline: want=%+v, got=%+v", tc.decline, decline)
+			}
+			if private != tc.private {
+				t.Errorf("wrong private message: want=%q, got=%q", tc.private, private)
+			}
+		})
+	}
+}
+
+func TestInvite(t *testing.T) {
+	room := jid.MustParse("coven@chat.shakespeare.lit")
+	to := jid.MustParse("hecate@shakespeare.lit")
+
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	err := muc.Invite(context.Background(), s, to, muc.Invitation{
+		Room:     room,
+		Reason:   "Join us",
+		Password: "cauldronburn",
+	})
+	if err != nil {
+		t.Fatalf("error sending mediated invite: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{`to="coven@chat.shakespeare.lit"`, `<invite to="hecate@shakespeare.lit"><reason>Join us</reason></invite>`, `<password>cauldronburn</password>`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected mediated invite to contain %s, got: %s", want, out)
+		}
+	}
+
+	buf.Reset()
+	inv := muc.Invitation{Room: room, Direct: true}
+	err = muc.Invite(context.Background(), s, to, inv)
+	if err != nil {
+		t.Fatalf("error sending direct invite: %v", err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, `to="hecate@shakespeare.lit"`) || !strings.Contains(out, `jid="coven@chat.shakespeare.lit"`) {
+		t.Errorf("wrong direct invite: %s", out)
+	}
+
+	err = muc.Decline(context.Background(), s, inv, "")
+	if err == nil {
+		t.Errorf("expected error declining direct invite")
+	}
+}
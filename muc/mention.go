@@ -0,0 +1,238 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"unicode"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/uri"
+)
+
+// NSReference is the namespace used by XEP-0372: References, provided as a
+// convenience.
+const NSReference = `urn:xmpp:reference:0`
+
+// ReferenceMention is the reference type used to mention a user.
+const ReferenceMention = "mention"
+
+// Reference is a reference to an entity or other resource from within the body
+// of a message as defined in XEP-0372: References.
+// Begin and End are offsets in Unicode code points (not bytes) and End is
+// exclusive.
+// If both are zero the reference does not point to a specific part of the
+// body.
+type Reference struct {
+	Type  string
+	URI   string
+	Begin uint64
+	End   uint64
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Reference) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Space: NSReference, Local: "reference"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "type"}, Value: r.Type},
+			{Name: xml.Name{Local: "uri"}, Value: r.URI},
+		},
+	}
+	if r.Begin != 0 || r.End != 0 {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "begin"},
+			Value: strconv.FormatUint(r.Begin, 10),
+		}, xml.Attr{
+			Name:  xml.Name{Local: "end"},
+			Value: strconv.FormatUint(r.End, 10),
+		})
+	}
+	return xmlstream.Wrap(nil, start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Reference) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Reference) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (r *Reference) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	_, r.Type = attr.Get(start.Attr, "type")
+	_, r.URI = attr.Get(start.Attr, "uri")
+	r.Begin, r.End = 0, 0
+	beginIdx, begin := attr.Get(start.Attr, "begin")
+	endIdx, end := attr.Get(start.Attr, "end")
+	if beginIdx != -1 && endIdx != -1 {
+		var err error
+		r.Begin, err = strconv.ParseUint(begin, 10, 64)
+		if err != nil {
+			return err
+		}
+		r.End, err = strconv.ParseUint(end, 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+	return d.Skip()
+}
+
+// Mention is a part of a message body in which a user was addressed.
+// Begin and End are offsets in Unicode code points (not bytes) and End is
+// exclusive.
+// If both are zero the mention does not point to a specific part of the body.
+type Mention struct {
+	Begin uint64
+	End   uint64
+
+	// Reference is true if the mention was explicitly marked by the sender using
+	// a reference and false if it was found by searching the body.
+	Reference bool
+}
+
+// MentionMatcher finds mentions of an occupant in messages sent to a chat
+// room.
+type MentionMatcher struct {
+	// Nick is the occupant's nickname in the room.
+	// If set, the body is searched for the nickname and references to an
+	// occupant with this nickname are treated as mentions.
+	Nick string
+
+	// Addr is an address of the user, either their occupant JID
+	// (room@service/nick) or their real JID.
+	// References to this address are treated as mentions.
+	Addr jid.JID
+
+	// Keywords is a list of additional words that should be treated as
+	// mentions when they appear in the body.
+	Keywords []string
+
+	// CaseSensitive controls whether the nickname and keywords must match the
+	// case of the body exactly.
+	CaseSensitive bool
+
+	// NoHeuristics disables searching the body for the nickname and keywords so
+	// that only explicit references are treated as mentions.
+	NoHeuristics bool
+}
+
+// Mentions returns the mentions found in a message with the given body and
+// references, sorted by their position in the body.
+// Words in the body only match if they are not surrounded by letters or
+// digits, and mentions found in the body that overlap an explicit reference
+// are only returned once.
+func (m MentionMatcher) Mentions(body string, refs []Reference) []Mention {
+	var mentions []Mention
+	for _, ref := range refs {
+		if ref.Type != ReferenceMention || !m.matchURI(ref.URI) {
+			continue
+		}
+		mentions = append(mentions, Mention{
+			Begin:     ref.Begin,
+			End:       ref.End,
+			Reference: true,
+		})
+	}
+
+	if !m.NoHeuristics {
+		text := []rune(body)
+		words := m.Keywords
+		if m.Nick != "" {
+			words = append([]string{m.Nick}, words...)
+		}
+		for _, word := range words {
+			for _, found := range m.find(text, []rune(word)) {
+				if !overlaps(mentions, found) {
+					mentions = append(mentions, found)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(mentions, func(i, j int) bool {
+		return mentions[i].Begin < mentions[j].Begin
+	})
+	return mentions
+}
+
+// matchURI reports whether the URI of a reference points to the user.
+func (m MentionMatcher) matchURI(rawURI string) bool {
+	u, err := uri.Parse(rawURI)
+	if err != nil {
+		return false
+	}
+	if !m.Addr.Equal(jid.JID{}) {
+		if u.ToAddr.Equal(m.Addr) {
+			return true
+		}
+		// If we were given a bare JID, references to any of its resources match.
+		if m.Addr.Resourcepart() == "" && u.ToAddr.Bare().Equal(m.Addr) {
+			return true
+		}
+	}
+	return m.Nick != "" && u.ToAddr.Resourcepart() == m.Nick
+}
+
+// find returns all occurrences of word in text that fall on word boundaries.
+func (m MentionMatcher) find(text, word []rune) []Mention {
+	if len(word) == 0 {
+		return nil
+	}
+	var found []Mention
+	for i := 0; i+len(word) <= len(text); i++ {
+		if !m.equal(text[i:i+len(word)], word) {
+			continue
+		}
+		if i > 0 && isWordRune(text[i-1]) {
+			continue
+		}
+		end := i + len(word)
+		if end < len(text) && isWordRune(text[end]) {
+			continue
+		}
+		found = append(found, Mention{Begin: uint64(i), End: uint64(end)})
+	}
+	return found
+}
+
+func (m MentionMatcher) equal(a, b []rune) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if m.CaseSensitive || unicode.ToLower(a[i]) != unicode.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// overlaps reports whether m overlaps any of the mentions that were already
+// found.
+func overlaps(mentions []Mention, m Mention) bool {
+	for _, other := range mentions {
+		if other.Begin < m.End && m.Begin < other.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+)
+
+var (
+	_ xml.Marshaler       = muc.Reference{}
+	_ xml.Unmarshaler     = (*muc.Reference)(nil)
+	_ xmlstream.Marshaler = muc.Reference{}
+	_ xmlstream.WriterTo  = muc.Reference{}
+)
+
+var mentionTestCases = [...]struct {
+	matcher muc.MentionMatcher
+	body    string
+	refs    []muc.Reference
+	out     []muc.Mention
+}{
+	0: {
+		matcher: muc.MentionMatcher{Nick: "juliet"},
+		body:    "hello world",
+	},
+	1: {
+		matcher: muc.MentionMatcher{Nick: "juliet"},
+		body:    "Juliet: wherefore art thou?",
+		out:     []muc.Mention{{Begin: 0, End: 6}},
+	},
+	2: {
+		matcher: muc.MentionMatcher{Nick: "juliet", CaseSensitive: true},
+		body:    "Juliet: wherefore art thou?",
+	},
+	3: {
+		matcher: muc.MentionMatcher{Nick: "juliet"},
+		body:    "julietta is not juliet",
+		out:     []muc.Mention{{Begin: 16, End: 22}},
+	},
+	4: {
+		matcher: muc.MentionMatcher{Nick: "jülïet"},
+		body:    "hi jülïet!",
+		out:     []muc.Mention{{Begin: 3, End: 9}},
+	},
+	5: {
+		matcher: muc.MentionMatcher{Nick: "juliet"},
+		body:    "juliet: hi",
+		refs: []muc.Reference{{
+			Type:  muc.ReferenceMention,
+			URI:   "xmpp:coven@chat.shakespeare.lit/juliet",
+			Begin: 0,
+			End:   6,
+		}},
+		out: []muc.Mention{{Begin: 0, End: 6, Reference: true}},
+	},
+	6: {
+		matcher: muc.MentionMatcher{
+			Addr:         jid.MustParse("coven@chat.shakespeare.lit/thirdwitch"),
+			NoHeuristics: true,
+		},
+		body: "hi witch, thirdwitch",
+		refs: []muc.Reference{{
+			Type:  muc.ReferenceMention,
+			URI:   "xmpp:coven@chat.shakespeare.lit/firstwitch",
+			Begin: 3,
+			End:   8,
+		}, {
+			Type:  muc.ReferenceMention,
+			URI:   "xmpp:coven@chat.shakespeare.lit/thirdwitch",
+			Begin: 10,
+			End:   20,
+		}},
+		out: []muc.Mention{{Begin: 10, End: 20, Reference: true}},
+	},
+	7: {
+		matcher: muc.MentionMatcher{
+			Nick:     "romeo",
+			Keywords: []string{"montague"},
+		},
+		body: "Montague! Where is romeo?",
+		out:  []muc.Mention{{Begin: 0, End: 8}, {Begin: 19, End: 24}},
+	},
+	8: {
+		matcher: muc.MentionMatcher{Addr: jid.MustParse("romeo@montague.lit")},
+		body:    "hi",
+		refs: []muc.Reference{{
+			Type: "data",
+			URI:  "xmpp:romeo@montague.lit",
+		}, {
+			Type: muc.ReferenceMention,
+			URI:  "xmpp:romeo@montague.lit/orchard",
+		}},
+		out: []muc.Mention{{Reference: true}},
+	},
+}
+
+func TestMentions(t *testing.T) {
+	for i, tc := range mentionTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mentions := tc.matcher.Mentions(tc.body, tc.refs)
+			if !reflect.DeepEqual(mentions, tc.out) {
+				t.Errorf("wrong mentions:\nwant=%+v,\n got=%+v", tc.out, mentions)
+			}
+		})
+	}
+}
+
+func TestMarshalReference(t *testing.T) {
+	const expected = `<reference xmlns="urn:xmpp:reference:0" type="mention" uri="xmpp:juliet@capulet.lit" begin="0" end="6"></reference>`
+	ref := muc.Reference{
+		Type: muc.ReferenceMention,
+		URI:  "xmpp:juliet@capulet.lit",
+		End:  6,
+	}
+	b, err := xml.Marshal(ref)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if out := string(b); out != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, out)
+	}
+
+	var unmarshaled muc.Reference
+	err = xml.Unmarshal(b, &unmarshaled)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if unmarshaled != ref {
+		t.Errorf("wrong unmarshaled value: want=%+v, got=%+v", ref, unmarshaled)
+	}
+}
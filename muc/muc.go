@@ -0,0 +1,18 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package muc implements XEP-0045: Multi-User Chat.
+package muc // import "mellium.im/xmpp/muc"
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS      = `http://jabber.org/protocol/muc`
+	NSUser  = `http://jabber.org/protocol/muc#user`
+	NSOwner = `http://jabber.org/protocol/muc#owner`
+	NSAdmin = `http://jabber.org/protocol/muc#admin`
+
+	// NSOccupantID is the namespace used by
+	// XEP-0421: Anonymous unique occupant identifiers for MUCs.
+	NSOccupantID = `urn:xmpp:occupant-id:0`
+)
@@ -0,0 +1,65 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/stanza"
+)
+
+// OccupantID is a stable identifier for an occupant of a room as defined in
+// XEP-0421: Anonymous unique occupant identifiers for MUCs.
+//
+// Unlike the nickname it does not change when the occupant changes their
+// nickname or rejoins the room, and unlike the real JID it is available in
+// semi-anonymous rooms, which makes it suitable for correlating reactions,
+// corrections, and moderation actions with an occupant.
+// Rooms that support occupant IDs strip any occupant-id elements sent by
+// occupants, so they should only be trusted if the room advertises
+// NSOccupantID (see RoomInfo).
+type OccupantID struct {
+	XMLName xml.Name `xml:"urn:xmpp:occupant-id:0 occupant-id"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (o OccupantID) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSOccupantID, Local: "occupant-id"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: o.ID}},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (o OccupantID) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, o.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (o OccupantID) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := o.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Message is a message sent by a room along with the occupant ID of the
+// occupant that sent it.
+type Message struct {
+	stanza.Message
+	Body       string     `xml:"body,omitempty"`
+	OccupantID OccupantID `xml:"urn:xmpp:occupant-id:0 occupant-id"`
+}
+
+// Presence is a presence sent by a room along with the occupant ID and status
+// codes of the occupant it refers to.
+type Presence struct {
+	stanza.Presence
+	Status     Statuses   `xml:"http://jabber.org/protocol/muc#user x"`
+	OccupantID OccupantID `xml:"urn:xmpp:occupant-id:0 occupant-id"`
+}
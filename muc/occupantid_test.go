@@ -0,0 +1,54 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/muc"
+)
+
+var (
+	_ xml.Marshaler       = muc.OccupantID{}
+	_ xmlstream.Marshaler = muc.OccupantID{}
+	_ xmlstream.WriterTo  = muc.OccupantID{}
+)
+
+func TestMarshalOccupantID(t *testing.T) {
+	b, err := xml.Marshal(muc.OccupantID{ID: "dd72603deec90a38ba552f7c68cbcc61bca202cd"})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const want = `<occupant-id xmlns="urn:xmpp:occupant-id:0" id="dd72603deec90a38ba552f7c68cbcc61bca202cd"></occupant-id>`
+	if s := string(b); s != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, s)
+	}
+}
+
+func TestUnmarshalOccupantID(t *testing.T) {
+	const id = "dd72603deec90a38ba552f7c68cbcc61bca202cd"
+	var msg muc.Message
+	err := xml.Unmarshal([]byte(`<message xmlns="jabber:client" from="room@muc.example.com/nick" type="groupchat"><body>Hello</body><occupant-id xmlns="urn:xmpp:occupant-id:0" id="`+id+`"/></message>`), &msg)
+	if err != nil {
+		t.Fatalf("error unmarshaling message: %v", err)
+	}
+	if msg.OccupantID.ID != id || msg.Body != "Hello" {
+		t.Errorf("wrong message: want id=%s, body=Hello; got id=%s, body=%s", id, msg.OccupantID.ID, msg.Body)
+	}
+
+	var p muc.Presence
+	err = xml.Unmarshal([]byte(`<presence xmlns="jabber:client" from="room@muc.example.com/nick"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" role="participant"/><status code="110"/></x><occupant-id xmlns="urn:xmpp:occupant-id:0" id="`+id+`"/></presence>`), &p)
+	if err != nil {
+		t.Fatalf("error unmarshaling presence: %v", err)
+	}
+	if p.OccupantID.ID != id {
+		t.Errorf("wrong occupant ID: want=%s, got=%s", id, p.OccupantID.ID)
+	}
+	if !p.Status.IsSelfPresence() {
+		t.Errorf("expected self presence status code to be decoded")
+	}
+}
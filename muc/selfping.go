@@ -0,0 +1,151 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/stanza"
+)
+
+// SelfPing sends a ping to our own occupant JID (the room JID with our
+// nickname as the resourcepart) to check whether we are still joined to the
+// room as described in XEP-0410: MUC Self-Ping (Schrödinger's Chat).
+//
+// If the room could not be reached or the result is otherwise inconclusive,
+// an error is returned and the check should be retried later.
+func SelfPing(ctx context.Context, s *xmpp.Session, occupant jid.JID) (joined bool, err error) {
+	err = s.UnmarshalIQ(ctx, ping.IQ{IQ: stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   occupant,
+	}}.TokenReader(), nil)
+	if err == nil {
+		return true, nil
+	}
+
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		return false, err
+	}
+	switch stanzaErr.Condition {
+	case stanza.ServiceUnavailable, stanza.FeatureNotImplemented:
+		// We are joined, but the client we pinged does not support pings.
+		return true, nil
+	case stanza.ItemNotFound:
+		// We are joined, but our nickname is in the process of being changed.
+		return true, nil
+	case stanza.RemoteServerNotFound, stanza.RemoteServerTimeout:
+		return false, err
+	}
+	return false, nil
+}
+
+// Keeper periodically checks that we are still joined to a set of rooms using
+// SelfPing and rejoins any that the server has dropped us from, for instance
+// because it restarted or because our connection was interrupted.
+//
+// The zero value is ready to use.
+// A Keeper is safe for concurrent use by multiple goroutines.
+type Keeper struct {
+	// Interval is the time between checks.
+	// If it is zero, rooms are checked every 15 minutes.
+	Interval time.Duration
+
+	// Rejoin is called with our occupant JID when we are no longer joined to a
+	// room.
+	// If it is nil, a join presence that requests no history is sent to the
+	// room.
+	Rejoin func(ctx context.Context, occupant jid.JID) error
+
+	mu    sync.Mutex
+	rooms map[string]jid.JID
+}
+
+// Add starts checking the room that occupant belongs to.
+// If we are already checking the room, the nickname is updated.
+func (k *Keeper) Add(occupant jid.JID) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.rooms == nil {
+		k.rooms = make(map[string]jid.JID)
+	}
+	k.rooms[occupant.Bare().String()] = occupant
+}
+
+// Remove stops checking the room with the provided address.
+func (k *Keeper) Remove(room jid.JID) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.rooms, room.Bare().String())
+}
+
+// Run checks each room every interval until the context is canceled or a
+// rejoin fails.
+// It blocks, so it will normally be run in its own goroutine alongside a call
+// to Serve.
+//
+// Checks that are inconclusive are ignored and retried on the next interval.
+func (k *Keeper) Run(ctx context.Context, s *xmpp.Session) error {
+	interval := k.Interval
+	if interval == 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		k.mu.Lock()
+		occupants := make([]jid.JID, 0, len(k.rooms))
+		for _, occupant := range k.rooms {
+			occupants = append(occupants, occupant)
+		}
+		k.mu.Unlock()
+
+		for _, occupant := range occupants {
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			joined, err := SelfPing(pingCtx, s, occupant)
+			cancel()
+			switch {
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case err != nil || joined:
+				continue
+			}
+			err = k.rejoin(ctx, s, occupant)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (k *Keeper) rejoin(ctx context.Context, s *xmpp.Session, occupant jid.JID) error {
+	if k.Rejoin != nil {
+		return k.Rejoin(ctx, occupant)
+	}
+	return s.Send(ctx, stanza.Presence{
+		To: occupant,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "history"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "maxchars"}, Value: "0"}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "x"}},
+	)))
+}
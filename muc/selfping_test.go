@@ -0,0 +1,67 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var selfPingTestCases = [...]struct {
+	cond   stanza.Condition
+	joined bool
+	err    bool
+}{
+	0: {joined: true},
+	1: {cond: stanza.ServiceUnavailable, joined: true},
+	2: {cond: stanza.FeatureNotImplemented, joined: true},
+	3: {cond: stanza.ItemNotFound, joined: true},
+	4: {cond: stanza.RemoteServerTimeout, err: true},
+	5: {cond: stanza.NotAcceptable},
+	6: {cond: stanza.BadRequest},
+}
+
+func TestSelfPing(t *testing.T) {
+	occupant := jid.MustParse("coven@chat.shakespeare.lit/thirdwitch")
+	for i, tc := range selfPingTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+					iq, err := stanza.NewIQ(*start)
+					if err != nil {
+						return err
+					}
+					if !iq.To.Equal(occupant) {
+						t.Errorf("wrong ping recipient: want=%v, got=%v", occupant, iq.To)
+					}
+					resp := iq.Result(nil)
+					if tc.cond != "" {
+						resp = iq.Error(stanza.Error{Type: stanza.Cancel, Condition: tc.cond})
+					}
+					_, err = xmlstream.Copy(e, resp)
+					return err
+				}),
+			)
+			joined, err := muc.SelfPing(context.Background(), cs.Client, occupant)
+			switch {
+			case tc.err && err == nil:
+				t.Errorf("expected an error")
+			case !tc.err && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+			if joined != tc.joined {
+				t.Errorf("wrong result: want=%t, got=%t", tc.joined, joined)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+)
+
+// Status is a status code that may be sent by a chat room in presence or
+// messages to indicate why an occupant's state changed or to provide
+// information about the room.
+type Status uint16
+
+// A list of status codes registered with the XMPP Registrar.
+const (
+	// StatusNonAnonymous is sent when entering a room to indicate that any
+	// occupant is allowed to see the user's full JID.
+	StatusNonAnonymous Status = 100
+
+	// StatusAffiliationChanged is sent in a message when a user's affiliation
+	// changes while they are not in the room.
+	StatusAffiliationChanged Status = 101
+
+	// StatusShowUnavailable is sent in a message when the room now shows
+	// unavailable members.
+	StatusShowUnavailable Status = 102
+
+	// StatusHideUnavailable is sent in a message when the room no longer shows
+	// unavailable members.
+	StatusHideUnavailable Status = 103
+
+	// StatusConfigChanged is sent in a message when a non-privacy-related room
+	// configuration change has occurred.
+	StatusConfigChanged Status = 104
+
+	// StatusSelfPresence is sent in presence to indicate that the presence refers
+	// to the user receiving it.
+	StatusSelfPresence Status = 110
+
+	// StatusLoggingEnabled is sent when room logging is enabled.
+	StatusLoggingEnabled Status = 170
+
+	// StatusLoggingDisabled is sent when room logging is disabled.
+	StatusLoggingDisabled Status = 171
+
+	// StatusRoomNonAnonymous is sent when the room is now non-anonymous.
+	StatusRoomNonAnonymous Status = 172
+
+	// StatusRoomSemiAnonymous is sent when the room is now semi-anonymous.
+	StatusRoomSemiAnonymous Status = 173
+
+	// StatusRoomCreated is sent in presence to indicate that a new room has been
+	// created.
+	StatusRoomCreated Status = 201
+
+	// StatusNickAssigned is sent in presence to indicate that the service has
+	// assigned or modified the occupant's nickname.
+	StatusNickAssigned Status = 210
+
+	// StatusBanned is sent in presence to indicate that the user has been banned
+	// from the room.
+	StatusBanned Status = 301
+
+	// StatusNickChanged is sent in presence to indicate that an occupant has
+	// changed their nickname.
+	StatusNickChanged Status = 303
+
+	// StatusKicked is sent in presence to indicate that an occupant has been
+	// kicked from the room.
+	StatusKicked Status = 307
+
+	// StatusRemovedAffiliation is sent in presence to indicate that the user has
+	// been removed from the room because of an affiliation change.
+	StatusRemovedAffiliation Status = 321
+
+	// StatusRemovedMembersOnly is sent in presence to indicate that the user has
+	// been removed from the room because the room has been changed to
+	// members-only and the user is not a member.
+	StatusRemovedMembersOnly Status = 322
+
+	// StatusRemovedShutdown is sent in presence to indicate that the user is
+	// being removed from the room because the service is shutting down.
+	StatusRemovedShutdown Status = 332
+
+	// StatusRemovedError is sent in presence to indicate that the user is being
+	// removed from the room because of a technical problem.
+	StatusRemovedError Status = 333
+)
+
+// String returns a human readable description of the status code.
+func (s Status) String() string {
+	switch s {
+	case StatusNonAnonymous:
+		return "any occupant is allowed to see the user's full JID"
+	case StatusAffiliationChanged:
+		return "affiliation changed while not in the room"
+	case StatusShowUnavailable:
+		return "room now shows unavailable members"
+	case StatusHideUnavailable:
+		return "room now does not show unavailable members"
+	case StatusConfigChanged:
+		return "non-privacy-related room configuration change has occurred"
+	case StatusSelfPresence:
+		return "presence is from the user's own occupant"
+	case StatusLoggingEnabled:
+		return "room logging is now enabled"
+	case StatusLoggingDisabled:
+		return "room logging is now disabled"
+	case StatusRoomNonAnonymous:
+		return "room is now non-anonymous"
+	case StatusRoomSemiAnonymous:
+		return "room is now semi-anonymous"
+	case StatusRoomCreated:
+		return "a new room has been created"
+	case StatusNickAssigned:
+		return "service has assigned or modified the occupant's nickname"
+	case StatusBanned:
+		return "user has been banned from the room"
+	case StatusNickChanged:
+		return "occupant is changing their nickname"
+	case StatusKicked:
+		return "user has been kicked from the room"
+	case StatusRemovedAffiliation:
+		return "user has been removed because of an affiliation change"
+	case StatusRemovedMembersOnly:
+		return "user has been removed because the room is now members-only"
+	case StatusRemovedShutdown:
+		return "user has been removed because of a system shutdown"
+	case StatusRemovedError:
+		return "user has been removed because of a technical problem"
+	}
+	return fmt.Sprintf("status code %d", uint16(s))
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s Status) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSUser, Local: "status"},
+		Attr: []xml.Attr{{
+			Name:  xml.Name{Local: "code"},
+			Value: strconv.FormatUint(uint64(s), 10),
+		}},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s Status) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s Status) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (s *Status) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	_, code := attr.Get(start.Attr, "code")
+	c, err := strconv.ParseUint(code, 10, 16)
+	if err != nil {
+		return fmt.Errorf("muc: invalid status code %q: %w", code, err)
+	}
+	*s = Status(c)
+	return d.Skip()
+}
+
+// Statuses is a set of status codes that was sent in a single presence or
+// message.
+// When unmarshaled it decodes the status codes in a muc#user payload and
+// ignores any other children.
+type Statuses []Status
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (s *Statuses) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	x := struct {
+		Status []Status `xml:"http://jabber.org/protocol/muc#user status"`
+	}{}
+	err := d.DecodeElement(&x, &start)
+	if err != nil {
+		return err
+	}
+	*s = x.Status
+	return nil
+}
+
+// Has returns true if the status code is in the set.
+func (s Statuses) Has(code Status) bool {
+	for _, c := range s {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSelfPresence returns true if the set indicates that the presence refers to
+// the user receiving it.
+func (s Statuses) IsSelfPresence() bool {
+	return s.Has(StatusSelfPresence)
+}
+
+// IsKick returns true if the set indicates that the occupant was kicked from the
+// room.
+func (s Statuses) IsKick() bool {
+	return s.Has(StatusKicked)
+}
+
+// IsBan returns true if the set indicates that the occupant was banned from the
+// room.
+func (s Statuses) IsBan() bool {
+	return s.Has(StatusBanned)
+}
+
+// IsNickChange returns true if the set indicates that the occupant is changing
+// their nickname.
+func (s Statuses) IsNickChange() bool {
+	return s.Has(StatusNickChanged)
+}
+
+// IsRemoved returns true if the set indicates that the occupant was removed
+// from the room for any reason, including being kicked or banned.
+func (s Statuses) IsRemoved() bool {
+	for _, c := range s {
+		switch c {
+		case StatusBanned, StatusKicked, StatusRemovedAffiliation,
+			StatusRemovedMembersOnly, StatusRemovedShutdown, StatusRemovedError:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/muc"
+)
+
+var (
+	_ xml.Marshaler       = muc.Status(0)
+	_ xml.Unmarshaler     = (*muc.Status)(nil)
+	_ xmlstream.Marshaler = muc.Status(0)
+	_ xmlstream.WriterTo  = muc.Status(0)
+	_ xml.Unmarshaler     = (*muc.Statuses)(nil)
+)
+
+var statusTestCases = [...]struct {
+	in   string
+	out  muc.Statuses
+	self bool
+	kick bool
+	ban  bool
+	err  bool
+}{
+	0: {
+		in: `<x xmlns="http://jabber.org/protocol/muc#user"/>`,
+	},
+	1: {
+		in:   `<x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="none" role="none"/><status code="110"/><status code="307"/></x>`,
+		out:  muc.Statuses{muc.StatusSelfPresence, muc.StatusKicked},
+		self: true,
+		kick: true,
+	},
+	2: {
+		in:  `<x xmlns="http://jabber.org/protocol/muc#user"><status code="301"/></x>`,
+		out: muc.Statuses{muc.StatusBanned},
+		ban: true,
+	},
+	3: {
+		in:  `<x xmlns="http://jabber.org/protocol/muc#user"><status code="abc"/></x>`,
+		err: true,
+	},
+}
+
+func TestStatuses(t *testing.T) {
+	for i, tc := range statusTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var s muc.Statuses
+			err := xml.Unmarshal([]byte(tc.in), &s)
+			switch {
+			case tc.err && err == nil:
+				t.Fatalf("expected error unmarshaling")
+			case tc.err:
+				return
+			case err != nil:
+				t.Fatalf("unexpected error unmarshaling: %v", err)
+			}
+			if len(s) != len(tc.out) {
+				t.Fatalf("wrong statuses: want=%v, got=%v", tc.out, s)
+			}
+			for i, c := range tc.out {
+				if s[i] != c {
+					t.Errorf("wrong status at %d: want=%d, got=%d", i, c, s[i])
+				}
+			}
+			if self := s.IsSelfPresence(); self != tc.self {
+				t.Errorf("wrong value for self presence: want=%t, got=%t", tc.self, self)
+			}
+			if kick := s.IsKick(); kick != tc.kick {
+				t.Errorf("wrong value for kick: want=%t, got=%t", tc.kick, kick)
+			}
+			if ban := s.IsBan(); ban != tc.ban {
+				t.Errorf("wrong value for ban: want=%t, got=%t", tc.ban, ban)
+			}
+		})
+	}
+}
+
+func TestMarshalStatus(t *testing.T) {
+	const expected = `<status xmlns="http://jabber.org/protocol/muc#user" code="110"></status>`
+	b, err := xml.Marshal(muc.StatusSelfPresence)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if out := string(b); out != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, out)
+	}
+}
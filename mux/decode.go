@@ -0,0 +1,137 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/stanza"
+)
+
+// DecodeFunc is the type of function called by handlers created with
+// DecodeHandler.
+// The value v is a pointer to a newly allocated value of the same type that was
+// passed to DecodeHandler, and e may be used to write a response.
+type DecodeFunc func(v interface{}, e xmlstream.Encoder) error
+
+// DecodeIQFunc is like DecodeFunc except that it is used for IQ payloads and
+// also receives the IQ stanza that contained the payload.
+// If a DecodeIQFunc returns a stanza.Error it is sent to the remote entity as
+// an error response instead of being returned from the handler.
+type DecodeIQFunc func(iq stanza.IQ, v interface{}, e xmlstream.Encoder) error
+
+// DecodeHandler returns a handler that decodes each element it handles into a
+// new value with the same type as v using encoding/xml and then calls f.
+// This lets handlers work with fully decoded structs instead of raw token
+// streams at the cost of buffering the entire element.
+//
+// The value v is only used as a template for its type, it is never modified.
+func DecodeHandler(v interface{}, f DecodeFunc) xmpp.Handler {
+	typ := elemType(v)
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		val := reflect.New(typ).Interface()
+		err := decodeElement(t, start, val)
+		if err != nil {
+			return err
+		}
+		return f(val, t)
+	})
+}
+
+// DecodeIQHandler is like DecodeHandler except that it decodes IQ payloads.
+// If the payload cannot be decoded a bad-request error is sent in response to
+// the IQ.
+func DecodeIQHandler(v interface{}, f DecodeIQFunc) IQHandler {
+	typ := elemType(v)
+	return IQHandlerFunc(func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		val := reflect.New(typ).Interface()
+		err := decodeElement(t, start, val)
+		if err != nil {
+			return iqError(iq, t, stanza.Error{
+				Type:      stanza.Modify,
+				Condition: stanza.BadRequest,
+			})
+		}
+		err = f(iq, val, t)
+		var stanzaErr stanza.Error
+		if errors.As(err, &stanzaErr) {
+			return iqError(iq, t, stanzaErr)
+		}
+		return err
+	})
+}
+
+// decodeElement decodes the element started by start into v.
+// The start token has already been consumed from t, so it is added back and
+// the decoder is limited to the children of the element.
+func decodeElement(t xml.TokenReader, start *xml.StartElement, v interface{}) error {
+	return xml.NewTokenDecoder(xmlstream.Wrap(xmlstream.Inner(t), *start)).Decode(v)
+}
+
+// iqError responds to iq with e unless iq is itself a response.
+func iqError(iq stanza.IQ, w xmlstream.TokenWriter, e stanza.Error) error {
+	if iq.Type == stanza.ErrorIQ || iq.Type == stanza.ResultIQ {
+		return nil
+	}
+	iq.To, iq.From = iq.From, iq.To
+	iq.Type = stanza.ErrorIQ
+	_, err := xmlstream.Copy(w, iq.Wrap(e.TokenReader()))
+	return err
+}
+
+// Decode returns an option that registers a DecodeHandler for top level
+// elements matching the XML name of v.
+// The name is taken from the tag on the XMLName field of v, which must be a
+// struct or a pointer to a struct.
+// If v does not have an XMLName field, Decode panics.
+func Decode(v interface{}, f DecodeFunc) Option {
+	return Handle(xmlName(v), DecodeHandler(v, f))
+}
+
+// DecodeIQ returns an option that registers a DecodeIQHandler for IQ payloads
+// matching the type and the XML name of v.
+// For more information see Decode.
+func DecodeIQ(typ stanza.IQType, v interface{}, f DecodeIQFunc) Option {
+	return IQ(typ, xmlName(v), DecodeIQHandler(v, f))
+}
+
+func elemType(v interface{}) reflect.Type {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		panic("mux: nil decode template")
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// xmlName returns the name from the tag on the XMLName field of v.
+func xmlName(v interface{}) xml.Name {
+	typ := elemType(v)
+	if typ.Kind() != reflect.Struct {
+		panic("mux: decode template must be a struct or a pointer to a struct")
+	}
+	field, ok := typ.FieldByName("XMLName")
+	if !ok || field.Type != reflect.TypeOf(xml.Name{}) {
+		panic("mux: decode template " + typ.String() + " has no XMLName field")
+	}
+	tag := field.Tag.Get("xml")
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	var name xml.Name
+	if i := strings.LastIndex(tag, " "); i >= 0 {
+		name.Space, name.Local = tag[:i], tag[i+1:]
+	} else {
+		name.Local = tag
+	}
+	return name
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+type decodeQuery struct {
+	XMLName xml.Name `xml:"com.example query"`
+	Value   string   `xml:"value"`
+}
+
+func TestDecodeIQ(t *testing.T) {
+	m := mux.New(mux.DecodeIQ(stanza.GetIQ, decodeQuery{}, func(iq stanza.IQ, v interface{}, e xmlstream.Encoder) error {
+		q := v.(*decodeQuery)
+		_, err := xmlstream.Copy(e, iq.Result(xmlstream.Wrap(
+			xmlstream.Wrap(xmlstream.Token(xml.CharData(strings.ToUpper(q.Value))), xml.StartElement{Name: xml.Name{Local: "value"}}),
+			xml.StartElement{Name: xml.Name{Space: exampleNS, Local: "query"}},
+		)))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	var resp decodeQuery
+	payload := xmlstream.Wrap(
+		xmlstream.Wrap(xmlstream.Token(xml.CharData("test")), xml.StartElement{Name: xml.Name{Local: "value"}}),
+		xml.StartElement{Name: xml.Name{Space: exampleNS, Local: "query"}},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := cs.Client.UnmarshalIQElement(ctx, payload, stanza.IQ{Type: stanza.GetIQ}, &resp)
+	if err != nil {
+		t.Fatalf("error sending IQ: %v", err)
+	}
+	if resp.Value != "TEST" {
+		t.Errorf("wrong response value: want=%q, got=%q", "TEST", resp.Value)
+	}
+}
+
+func TestDecodeIQError(t *testing.T) {
+	m := mux.New(mux.DecodeIQ(stanza.GetIQ, decodeQuery{}, func(stanza.IQ, interface{}, xmlstream.Encoder) error {
+		return stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound}
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	payload := xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: exampleNS, Local: "query"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := cs.Client.UnmarshalIQElement(ctx, payload, stanza.IQ{Type: stanza.GetIQ}, nil)
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		t.Fatalf("expected stanza error, got: %v", err)
+	}
+	if stanzaErr.Condition != stanza.ItemNotFound {
+		t.Errorf("wrong error condition: want=%v, got=%v", stanza.ItemNotFound, stanzaErr.Condition)
+	}
+}
+
+func TestDecodeNoXMLName(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic when registering a template with no XMLName")
+		}
+	}()
+	mux.Decode(struct{ Value string }{}, func(interface{}, xmlstream.Encoder) error {
+		return nil
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"sort"
+
+	"mellium.im/xmpp/disco/info"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/stanza"
+)
+
+// HideFeatures returns an option that prevents the provided namespaces from
+// being advertised by ForFeatures even if a handler is registered for them.
+func HideFeatures(space ...string) Option {
+	return func(m *ServeMux) {
+		if m.hidden == nil {
+			m.hidden = make(map[string]struct{})
+		}
+		for _, s := range space {
+			m.hidden[s] = struct{}{}
+		}
+	}
+}
+
+// ForFeatures implements info.FeatureIter (and therefore disco.FeatureIter).
+//
+// For queries without a node it advertises the payload namespace of every IQ
+// handler registered for get or set requests, and of every message and
+// presence handler, so that the advertised features can not drift from the
+// features that are actually handled.
+// Handlers registered with a wildcard namespace are not advertised.
+// Any registered handlers that themselves implement info.FeatureIter are also
+// queried for all nodes.
+// Features are reported in sorted order and namespaces hidden with
+// HideFeatures are never reported.
+func (m *ServeMux) ForFeatures(node string, f func(info.Feature) error) error {
+	seen := make(map[string]struct{})
+	var features []string
+	add := func(feature string) {
+		if feature == "" || feature == ns.Client || feature == ns.Server {
+			return
+		}
+		if _, ok := m.hidden[feature]; ok {
+			return
+		}
+		if _, ok := seen[feature]; ok {
+			return
+		}
+		seen[feature] = struct{}{}
+		features = append(features, feature)
+	}
+	addIter := func(h interface{}) error {
+		iter, ok := unwrapHandler(h).(info.FeatureIter)
+		if !ok {
+			return nil
+		}
+		return iter.ForFeatures(node, func(feature info.Feature) error {
+			add(feature.Var)
+			return nil
+		})
+	}
+
+	for _, h := range m.patterns {
+		if err := addIter(h); err != nil {
+			return err
+		}
+	}
+	for pat, h := range m.iqPatterns {
+		if node == "" && (pat.Type == string(stanza.GetIQ) || pat.Type == string(stanza.SetIQ)) {
+			add(pat.Payload.Space)
+		}
+		if err := addIter(h); err != nil {
+			return err
+		}
+	}
+	for pat, h := range m.msgPatterns {
+		if node == "" {
+			add(pat.Payload.Space)
+		}
+		if err := addIter(h); err != nil {
+			return err
+		}
+	}
+	for pat, h := range m.presencePatterns {
+		if node == "" {
+			add(pat.Payload.Space)
+		}
+		if err := addIter(h); err != nil {
+			return err
+		}
+	}
+	for _, r := range m.routes {
+		if err := addIter(r.h); err != nil {
+			return err
+		}
+	}
+
+	// Map iteration order is random, so sort the features to keep responses
+	// stable.
+	sort.Strings(features)
+	for _, feature := range features {
+		if err := f(info.Feature{Var: feature}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForForms implements info.FormIter (and therefore disco.FormIter).
+//
+// Any registered handlers that implement info.FormIter are queried for all
+// nodes.
+func (m *ServeMux) ForForms(node string, f func(*form.Data) error) error {
+	var handlers []interface{}
+	for _, h := range m.patterns {
+		handlers = append(handlers, h)
+	}
+	for _, h := range m.iqPatterns {
+		handlers = append(handlers, h)
+	}
+	for _, h := range m.msgPatterns {
+		handlers = append(handlers, h)
+	}
+	for _, h := range m.presencePatterns {
+		handlers = append(handlers, h)
+	}
+	for _, r := range m.routes {
+		handlers = append(handlers, r.h)
+	}
+	for _, h := range handlers {
+		iter, ok := unwrapHandler(h).(info.FormIter)
+		if !ok {
+			continue
+		}
+		if err := iter.ForForms(node, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unwrapHandler returns the handler wrapped by the payload handler adapters so
+// that the interfaces it implements can be detected.
+func unwrapHandler(h interface{}) interface{} {
+	switch wrapped := h.(type) {
+	case msgPayload:
+		return wrapped.h
+	case presencePayload:
+		return wrapped.h
+	}
+	return h
+}
@@ -0,0 +1,189 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+)
+
+// A Matcher reports whether a top level element should be handled by the
+// handler it was registered with.
+// It is passed the start element of the top level element and the name of its
+// first child element (the payload), which will be empty if the element has no
+// children.
+type Matcher func(start xml.StartElement, payload xml.Name) bool
+
+type route struct {
+	match Matcher
+	h     xmpp.Handler
+}
+
+// Match returns an option that matches any top level element for which m
+// returns true.
+// Matchers are tried in the order they were registered and take precedence
+// over handlers registered by name or stanza type.
+func Match(m Matcher, h xmpp.Handler) Option {
+	return func(mux *ServeMux) {
+		if m == nil {
+			panic("mux: nil matcher")
+		}
+		if h == nil {
+			panic("mux: nil handler")
+		}
+		mux.routes = append(mux.routes, route{match: m, h: h})
+	}
+}
+
+// MatchFunc returns an option that matches any top level element for which m
+// returns true.
+// For more information see Match.
+func MatchFunc(m Matcher, h xmpp.HandlerFunc) Option {
+	return Match(m, h)
+}
+
+// All returns a Matcher that matches if all of the provided matchers match.
+func All(m ...Matcher) Matcher {
+	return func(start xml.StartElement, payload xml.Name) bool {
+		for _, f := range m {
+			if !f(start, payload) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any returns a Matcher that matches if any of the provided matchers match.
+func Any(m ...Matcher) Matcher {
+	return func(start xml.StartElement, payload xml.Name) bool {
+		for _, f := range m {
+			if f(start, payload) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Stanza returns a Matcher that matches IQ, message, or presence stanzas with
+// the given local name (eg. "iq").
+func Stanza(local string) Matcher {
+	return func(start xml.StartElement, _ xml.Name) bool {
+		return start.Name.Local == local && stanzaSpace(start.Name.Space)
+	}
+}
+
+// Types returns a Matcher that matches elements with a type attribute set to
+// any of the provided values.
+// The type attribute is compared as-is, so a message with no type attribute
+// will only be matched by the empty string, not by "normal".
+func Types(typ ...string) Matcher {
+	return func(start xml.StartElement, _ xml.Name) bool {
+		var t string
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "type" && attr.Name.Space == "" {
+				t = attr.Value
+				break
+			}
+		}
+		for _, v := range typ {
+			if v == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PayloadSpace returns a Matcher that matches elements where the namespace of
+// the payload matches pattern.
+// Any "*" in pattern matches any sequence of characters, for example
+// "urn:xmpp:jingle:*" will match the namespaces of all Jingle payloads.
+func PayloadSpace(pattern string) Matcher {
+	return func(_ xml.StartElement, payload xml.Name) bool {
+		return wildcardMatch(pattern, payload.Space)
+	}
+}
+
+// FromDomain returns a Matcher that matches elements where the domainpart of
+// the from attribute matches pattern.
+// Any "*" in pattern matches any sequence of characters, for example
+// "*.muc.example.com" will match elements from any subdomain of
+// muc.example.com.
+// Elements with a missing or invalid from attribute never match.
+func FromDomain(pattern string) Matcher {
+	return func(start xml.StartElement, _ xml.Name) bool {
+		for _, attr := range start.Attr {
+			if attr.Name.Local != "from" || attr.Name.Space != "" {
+				continue
+			}
+			j, err := jid.Parse(attr.Value)
+			if err != nil {
+				return false
+			}
+			return wildcardMatch(pattern, j.Domainpart())
+		}
+		return false
+	}
+}
+
+func wildcardMatch(pattern, s string) bool {
+	idx := strings.IndexByte(pattern, '*')
+	if idx == -1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, pattern[:idx]) {
+		return false
+	}
+	s = s[idx:]
+	pattern = pattern[idx+1:]
+	for i := 0; i <= len(s); i++ {
+		if wildcardMatch(pattern, s[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoute reads ahead to find the payload name, then returns the first
+// route handler that matches (if any) along with a token stream that replays
+// any tokens that were read.
+func (m *ServeMux) matchRoute(t xmlstream.TokenReadEncoder, start *xml.StartElement) (xmpp.Handler, xmlstream.TokenReadEncoder, error) {
+	r := &bufReader{r: t}
+	var payload xml.Name
+	for {
+		tok, err := r.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		if s, ok := tok.(xml.StartElement); ok {
+			payload = s.Name
+			break
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			break
+		}
+	}
+	r.offset = 0
+	t = struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: r,
+		Encoder:     t,
+	}
+
+	for _, route := range m.routes {
+		if route.match(*start, payload) {
+			return route.h, t, nil
+		}
+	}
+	return nil, t, nil
+}
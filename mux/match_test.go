@@ -0,0 +1,140 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux_test
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var matchTestCases = [...]struct {
+	m   []mux.Option
+	x   string
+	err error
+}{
+	0: {
+		m: []mux.Option{
+			mux.Match(mux.All(mux.Stanza("iq"), mux.FromDomain("*.muc.example.com")), passHandler{}),
+			mux.IQ(stanza.GetIQ, xml.Name{}, failHandler{}),
+		},
+		x:   `<iq type="get" from="room@chat.muc.example.com" xmlns="jabber:client"><test xmlns="com.example"/></iq>`,
+		err: errPassTest,
+	},
+	1: {
+		m: []mux.Option{
+			mux.Match(mux.All(mux.Stanza("iq"), mux.FromDomain("*.muc.example.com")), failHandler{}),
+			mux.IQ(stanza.GetIQ, xml.Name{}, passHandler{}),
+		},
+		x:   `<iq type="get" from="muc.example.com" xmlns="jabber:client"><test xmlns="com.example"/></iq>`,
+		err: errPassTest,
+	},
+	2: {
+		m: []mux.Option{
+			mux.Match(mux.Types("chat", "groupchat"), passHandler{}),
+		},
+		x:   `<message type="groupchat" xmlns="jabber:client"/>`,
+		err: errPassTest,
+	},
+	3: {
+		m: []mux.Option{
+			mux.Match(mux.Types("chat", "groupchat"), failHandler{}),
+		},
+		x: `<message xmlns="jabber:client"/>`,
+	},
+	4: {
+		m: []mux.Option{
+			mux.Match(mux.PayloadSpace("urn:xmpp:jingle:*"), passHandler{}),
+		},
+		x:   `<iq type="set" xmlns="jabber:client"><jingle xmlns="urn:xmpp:jingle:1"/></iq>`,
+		err: errPassTest,
+	},
+	5: {
+		m: []mux.Option{
+			mux.Match(mux.Any(mux.Stanza("presence"), mux.PayloadSpace("com.*")), passHandler{}),
+		},
+		x:   `<message xmlns="jabber:server"><test xmlns="com.example"/></message>`,
+		err: errPassTest,
+	},
+	6: {
+		// Matchers are tried in order.
+		m: []mux.Option{
+			mux.Match(mux.Stanza("message"), passHandler{}),
+			mux.Match(mux.Stanza("message"), failHandler{}),
+		},
+		x:   `<message xmlns="jabber:client"/>`,
+		err: errPassTest,
+	},
+	7: {
+		m: []mux.Option{
+			mux.Match(func(start xml.StartElement, payload xml.Name) bool {
+				return payload.Local == "test"
+			}, passHandler{}),
+		},
+		x:   `<a xmlns="com.example"><test/></a>`,
+		err: errPassTest,
+	},
+	8: {
+		m: []mux.Option{
+			mux.Match(mux.FromDomain("*"), failHandler{}),
+		},
+		// Invalid JIDs never match.
+		x: `<a from="@@" xmlns="com.example"/>`,
+	},
+	9: {
+		m: []mux.Option{
+			mux.Match(mux.Stanza("message"), passHandler{}),
+		},
+		x:   `<message xmlns="jabber:component:accept"/>`,
+		err: errPassTest,
+	},
+}
+
+func TestMatch(t *testing.T) {
+	for i, tc := range matchTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m := mux.New(tc.m...)
+			d := xml.NewDecoder(strings.NewReader(tc.x))
+			tok, _ := d.Token()
+			start := tok.(xml.StartElement)
+
+			err := m.HandleXMPP(nopEncoder{TokenReader: d}, &start)
+			if err != tc.err {
+				t.Fatalf("unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestMatchReplaysPayload(t *testing.T) {
+	var payload xml.Name
+	m := mux.New(mux.MatchFunc(mux.PayloadSpace("urn:xmpp:jingle:*"), func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		for {
+			tok, err := t.Token()
+			if err != nil {
+				return err
+			}
+			if s, ok := tok.(xml.StartElement); ok {
+				payload = s.Name
+				return nil
+			}
+		}
+	}))
+	d := xml.NewDecoder(strings.NewReader(`<iq type="set" xmlns="jabber:client"> <jingle xmlns="urn:xmpp:jingle:1"/></iq>`))
+	tok, _ := d.Token()
+	start := tok.(xml.StartElement)
+	err := m.HandleXMPP(nopEncoder{TokenReader: d}, &start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (xml.Name{Space: "urn:xmpp:jingle:1", Local: "jingle"}); payload != want {
+		t.Errorf("handler did not see replayed payload: want=%v, got=%v", want, payload)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"mellium.im/xmpp"
+)
+
+// Middleware wraps a handler to add behavior such as logging, rate limiting,
+// or authentication checks.
+// Middleware may call the wrapped handler, or return without calling it to
+// stop the element from being handled.
+type Middleware func(xmpp.Handler) xmpp.Handler
+
+// Chain wraps h in the provided middleware.
+// The first middleware is the outermost and will be called first.
+func Chain(h xmpp.Handler, mw ...Middleware) xmpp.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Use returns an option that wraps all handlers registered on the mux in the
+// provided middleware.
+// Middleware is run for every top level element, including those that do not
+// match any handler, in the order it was registered.
+func Use(mw ...Middleware) Option {
+	return func(m *ServeMux) {
+		for _, f := range mw {
+			if f == nil {
+				panic("mux: nil middleware")
+			}
+		}
+		m.middleware = append(m.middleware, mw...)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux_test
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+func recordMiddleware(name string, calls *[]string) mux.Middleware {
+	return func(h xmpp.Handler) xmpp.Handler {
+		return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			*calls = append(*calls, name)
+			return h.HandleXMPP(t, start)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	m := mux.New(
+		mux.Use(recordMiddleware("a", &calls)),
+		mux.IQ(stanza.GetIQ, xml.Name{}, passHandler{}),
+		mux.Use(recordMiddleware("b", &calls), recordMiddleware("c", &calls)),
+	)
+	d := xml.NewDecoder(strings.NewReader(`<iq type="get" xmlns="jabber:client"><test xmlns="com.example"/></iq>`))
+	tok, _ := d.Token()
+	start := tok.(xml.StartElement)
+
+	err := m.HandleXMPP(nopEncoder{TokenReader: d}, &start)
+	if err != errPassTest {
+		t.Errorf("unexpected error: want=%v, got=%v", errPassTest, err)
+	}
+	if s := strings.Join(calls, ""); s != "abc" {
+		t.Errorf("middleware called in wrong order: want=abc, got=%s", s)
+	}
+}
+
+func TestMiddlewareBlocks(t *testing.T) {
+	block := func(xmpp.Handler) xmpp.Handler {
+		return xmpp.HandlerFunc(func(xmlstream.TokenReadEncoder, *xml.StartElement) error {
+			return nil
+		})
+	}
+	m := mux.New(
+		mux.Use(block),
+		mux.IQ(stanza.GetIQ, xml.Name{}, failHandler{}),
+	)
+	d := xml.NewDecoder(strings.NewReader(`<iq type="get" xmlns="jabber:client"><test xmlns="com.example"/></iq>`))
+	tok, _ := d.Token()
+	start := tok.(xml.StartElement)
+
+	err := m.HandleXMPP(nopEncoder{TokenReader: d}, &start)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
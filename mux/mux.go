@@ -17,7 +17,6 @@ import (
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
-	"mellium.im/xmpp/internal/ns"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -47,11 +46,16 @@ func (p pattern) String() string {
 // localname will be matched.
 // Full XML names take precedence, followed by wildcard localnames, followed by
 // wildcard namespaces.
+// For more complex routing, such as matching on the sender or on sets of stanza
+// types, see Match.
 type ServeMux struct {
 	patterns         map[xml.Name]xmpp.Handler
 	iqPatterns       map[pattern]IQHandler
 	msgPatterns      map[pattern]MessageHandler
 	presencePatterns map[pattern]PresenceHandler
+	routes           []route
+	middleware       []Middleware
+	hidden           map[string]struct{}
 }
 
 // New allocates and returns a new ServeMux.
@@ -87,7 +91,7 @@ func (m *ServeMux) Handler(name xml.Name) (h xmpp.Handler, ok bool) {
 		return h, true
 	}
 
-	if name.Space == ns.Client || name.Space == ns.Server {
+	if stanzaSpace(name.Space) {
 		switch name.Local {
 		case iqStanza:
 			return xmpp.HandlerFunc(m.iqRouter), true
@@ -204,7 +208,27 @@ func (m *ServeMux) PresenceHandler(typ stanza.PresenceType, payload xml.Name) (h
 }
 
 // HandleXMPP dispatches the request to the handler that most closely matches.
+// Handlers registered with Match are tried first, in the order they were
+// registered.
+// Any middleware registered with Use wraps the dispatch.
 func (m *ServeMux) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if len(m.middleware) > 0 {
+		return Chain(xmpp.HandlerFunc(m.dispatch), m.middleware...).HandleXMPP(t, start)
+	}
+	return m.dispatch(t, start)
+}
+
+func (m *ServeMux) dispatch(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if len(m.routes) > 0 {
+		h, r, err := m.matchRoute(t, start)
+		if err != nil {
+			return err
+		}
+		if h != nil {
+			return h.HandleXMPP(r, start)
+		}
+		t = r
+	}
 	h, _ := m.Handler(start.Name)
 	return h.HandleXMPP(t, start)
 }
@@ -313,13 +337,23 @@ func forChildren(m *ServeMux, stanzaVal interface{}, t xmlstream.TokenReadEncode
 	defer iterator.Close()
 
 	for iterator.Next() {
-		start, _ := iterator.Current()
+		start, inner := iterator.Current()
 
 		var err error
 		switch s := stanzaVal.(type) {
 		case stanza.Presence:
-			br := &bufReader{r: t, buf: r.buf}
 			h, _ := m.PresenceHandler(s.Type, start.Name)
+			if ph, ok := h.(presencePayload); ok {
+				err = ph.h.HandlePresencePayload(s, struct {
+					xml.TokenReader
+					xmlstream.Encoder
+				}{
+					TokenReader: inner,
+					Encoder:     t,
+				}, start)
+				break
+			}
+			br := &bufReader{r: t, buf: r.buf}
 			err = h.HandlePresence(s, struct {
 				xml.TokenReader
 				xmlstream.Encoder
@@ -329,8 +363,18 @@ func forChildren(m *ServeMux, stanzaVal interface{}, t xmlstream.TokenReadEncode
 			})
 			r.buf = br.buf
 		case stanza.Message:
-			br := &bufReader{r: t, buf: r.buf}
 			h, _ := m.MessageHandler(s.Type, start.Name)
+			if mh, ok := h.(msgPayload); ok {
+				err = mh.h.HandleMessagePayload(s, struct {
+					xml.TokenReader
+					xmlstream.Encoder
+				}{
+					TokenReader: inner,
+					Encoder:     t,
+				}, start)
+				break
+			}
+			br := &bufReader{r: t, buf: r.buf}
 			err = h.HandleMessage(s, struct {
 				xml.TokenReader
 				xmlstream.Encoder
@@ -17,9 +17,9 @@ import (
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/marshal"
 	"mellium.im/xmpp/internal/ns"
-	"mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
 )
 
 var (
@@ -577,3 +577,54 @@ func TestLazyServeMuxMapInitialization(t *testing.T) {
 	mux.Message(stanza.NormalMessage, xml.Name{}, failHandler{})(m)
 	mux.Presence(stanza.SubscribePresence, xml.Name{}, failHandler{})(m)
 }
+
+func TestPayloadHandlers(t *testing.T) {
+	var got []string
+	record := func(start *xml.StartElement, r xml.TokenReader) error {
+		var buf strings.Builder
+		for {
+			tok, err := r.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if c, ok := tok.(xml.CharData); ok {
+				buf.Write(c)
+			}
+		}
+		got = append(got, start.Name.Local+":"+buf.String())
+		return nil
+	}
+	m := mux.New(
+		mux.MessagePayloadFunc(stanza.ChatMessage, xml.Name{Space: exampleNS}, func(msg stanza.Message, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			if msg.Type != stanza.ChatMessage {
+				t.Errorf("wrong message type: want=%q, got=%q", stanza.ChatMessage, msg.Type)
+			}
+			return record(start, r)
+		}),
+		mux.PresencePayloadFunc(stanza.AvailablePresence, xml.Name{}, func(p stanza.Presence, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			return record(start, r)
+		}),
+	)
+
+	for _, x := range []string{
+		`<message type="chat" xmlns="jabber:client"><a xmlns="com.example">one</a><b xmlns="com.example">two</b></message>`,
+		`<presence xmlns="jabber:client"><status>away</status></presence>`,
+		`<presence xmlns="jabber:client"/>`,
+	} {
+		d := xml.NewDecoder(strings.NewReader(x))
+		tok, _ := d.Token()
+		start := tok.(xml.StartElement)
+		err := m.HandleXMPP(nopEncoder{TokenReader: d}, &start)
+		if err != nil {
+			t.Fatalf("unexpected error handling %s: %v", x, err)
+		}
+	}
+
+	const expected = "a:one,b:two,status:away,:"
+	if s := strings.Join(got, ","); s != expected {
+		t.Errorf("wrong payloads handled:\nwant=%s,\n got=%s", expected, s)
+	}
+}
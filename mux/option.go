@@ -85,9 +85,49 @@ func PresenceFunc(typ stanza.PresenceType, payload xml.Name, h PresenceHandlerFu
 	return Presence(typ, payload, h)
 }
 
+// MessagePayload returns an option that matches message stanzas by type and
+// payload name.
+// Unlike Message, the handler is called once for each matching payload with
+// the payload start element and a token stream limited to its contents.
+func MessagePayload(typ stanza.MessageType, payload xml.Name, h MessagePayloadHandler) Option {
+	if h == nil {
+		panic("mux: nil message handler")
+	}
+	return Message(typ, payload, msgPayload{h: h})
+}
+
+// MessagePayloadFunc returns an option that matches message stanzas.
+// For more information see MessagePayload.
+func MessagePayloadFunc(typ stanza.MessageType, payload xml.Name, h MessagePayloadHandlerFunc) Option {
+	return MessagePayload(typ, payload, h)
+}
+
+// PresencePayload returns an option that matches presence stanzas by type and
+// payload name.
+// Unlike Presence, the handler is called once for each matching payload with
+// the payload start element and a token stream limited to its contents.
+func PresencePayload(typ stanza.PresenceType, payload xml.Name, h PresencePayloadHandler) Option {
+	if h == nil {
+		panic("mux: nil presence handler")
+	}
+	return Presence(typ, payload, presencePayload{h: h})
+}
+
+// PresencePayloadFunc returns an option that matches presence stanzas.
+// For more information see PresencePayload.
+func PresencePayloadFunc(typ stanza.PresenceType, payload xml.Name, h PresencePayloadHandlerFunc) Option {
+	return PresencePayload(typ, payload, h)
+}
+
 func isStanza(name xml.Name) bool {
 	return (name.Local == iqStanza || name.Local == msgStanza || name.Local == presStanza) &&
-		(name.Space == "" || name.Space == ns.Client || name.Space == ns.Server)
+		(name.Space == "" || stanzaSpace(name.Space))
+}
+
+// stanzaSpace reports whether space is one of the namespaces that stanzas may
+// be sent in, including the namespace used by components.
+func stanzaSpace(space string) bool {
+	return space == ns.Client || space == ns.Server || space == ns.ComponentAccept
 }
 
 // Handle returns an option that matches on the provided XML name.
@@ -58,3 +58,106 @@ type PresenceHandlerFunc func(stanza.Presence, xmlstream.TokenReadEncoder) error
 func (f PresenceHandlerFunc) HandlePresence(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
 	return f(p, t)
 }
+
+// MessagePayloadHandler responds to payloads of message stanzas.
+// Unlike MessageHandler it is passed the start element of the payload that it
+// was matched against and a token stream limited to the inside of that
+// payload, mirroring IQHandler.
+type MessagePayloadHandler interface {
+	HandleMessagePayload(stanza.Message, xmlstream.TokenReadEncoder, *xml.StartElement) error
+}
+
+// The MessagePayloadHandlerFunc type is an adapter to allow the use of ordinary
+// functions as message payload handlers.
+// If f is a function with the appropriate signature,
+// MessagePayloadHandlerFunc(f) is a MessagePayloadHandler that calls f.
+type MessagePayloadHandlerFunc func(stanza.Message, xmlstream.TokenReadEncoder, *xml.StartElement) error
+
+// HandleMessagePayload calls f(msg, t, start).
+func (f MessagePayloadHandlerFunc) HandleMessagePayload(msg stanza.Message, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	return f(msg, t, start)
+}
+
+// PresencePayloadHandler responds to payloads of presence stanzas.
+// Unlike PresenceHandler it is passed the start element of the payload that it
+// was matched against and a token stream limited to the inside of that
+// payload, mirroring IQHandler.
+type PresencePayloadHandler interface {
+	HandlePresencePayload(stanza.Presence, xmlstream.TokenReadEncoder, *xml.StartElement) error
+}
+
+// The PresencePayloadHandlerFunc type is an adapter to allow the use of
+// ordinary functions as presence payload handlers.
+// If f is a function with the appropriate signature,
+// PresencePayloadHandlerFunc(f) is a PresencePayloadHandler that calls f.
+type PresencePayloadHandlerFunc func(stanza.Presence, xmlstream.TokenReadEncoder, *xml.StartElement) error
+
+// HandlePresencePayload calls f(p, t, start).
+func (f PresencePayloadHandlerFunc) HandlePresencePayload(p stanza.Presence, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	return f(p, t, start)
+}
+
+// msgPayload adapts a MessagePayloadHandler so that it can be stored alongside
+// message handlers.
+// When it is called as a MessageHandler directly (for example, after being
+// looked up with ServeMux.MessageHandler) it is passed the first payload.
+type msgPayload struct {
+	h MessagePayloadHandler
+}
+
+func (h msgPayload) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	start, inner, err := firstPayload(t)
+	if err != nil {
+		return err
+	}
+	return h.h.HandleMessagePayload(msg, struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: inner,
+		Encoder:     t,
+	}, start)
+}
+
+// presencePayload adapts a PresencePayloadHandler so that it can be stored
+// alongside presence handlers.
+type presencePayload struct {
+	h PresencePayloadHandler
+}
+
+func (h presencePayload) HandlePresence(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+	start, inner, err := firstPayload(t)
+	if err != nil {
+		return err
+	}
+	return h.h.HandlePresencePayload(p, struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: inner,
+		Encoder:     t,
+	}, start)
+}
+
+// firstPayload skips the stanza start element and returns the first child
+// element and a reader over its contents.
+// If the stanza has no children an empty start element is returned.
+func firstPayload(r xml.TokenReader) (*xml.StartElement, xml.TokenReader, error) {
+	// Skip the stanza start token.
+	_, err := r.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		tok, err := r.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return &t, xmlstream.Inner(r), nil
+		case xml.EndElement:
+			return &xml.StartElement{}, xmlstream.MultiReader(), nil
+		}
+	}
+}
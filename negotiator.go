@@ -6,8 +6,11 @@ package xmpp
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"math/rand"
+	"time"
 
 	"mellium.im/xmpp/internal/attr"
 	intstream "mellium.im/xmpp/internal/stream"
@@ -32,6 +35,12 @@ import (
 // If a new io.ReadWriter is returned, it is set as the session's underlying
 // io.ReadWriter and the internal session state (encoders, decoders, etc.) will
 // be reset.
+// If an error is returned, negotiation stops and the error is returned from
+// NewSession or ReceiveSession.
+//
+// Custom negotiators can be built using SendStreamStart, ExpectStreamStart,
+// and NegotiateFeatures which perform the same steps as the Negotiator returned
+// by NewNegotiator.
 type Negotiator func(ctx context.Context, in, out *stream.Info, session *Session, data interface{}) (mask SessionState, rw io.ReadWriter, cache interface{}, err error)
 
 // StreamConfig contains options for configuring the default Negotiator.
@@ -39,6 +48,17 @@ type StreamConfig struct {
 	// The native language of the stream.
 	Lang string
 
+	// LangMismatch is called when initiating a stream if the remote entity
+	// responds with a different default language than the one requested in Lang.
+	// It is passed the requested language and the language declared by the
+	// remote entity (which may be empty).
+	// If it returns an error, negotiation is aborted and the error is returned.
+	// If LangMismatch is nil, the language chosen by the remote entity is
+	// accepted.
+	// The language of the input stream can be checked at any time after
+	// negotiation using InLang.
+	LangMismatch func(requested, received string) error
+
 	// A list of stream features to attempt to negotiate.
 	// Features will be called every time a new stream is started so that the user
 	// may look up required stream features based on information about an incoming
@@ -59,6 +79,29 @@ type StreamConfig struct {
 	// be re-used or appended to if desired (however, this is not required).
 	Features func(*Session, ...StreamFeature) []StreamFeature
 
+	// Required is a list of namespaces of stream features that must be
+	// negotiated before an initiated session is ready.
+	// If the remote entity does not advertise one of them, or advertises it
+	// when the session does not have the state that the feature requires (see
+	// the Necessary field on StreamFeature), negotiation fails with a
+	// *FeatureError instead of completing without the feature.
+	// Features that are prohibited by the state of the session (for example
+	// StartTLS on a connection that already uses direct TLS) are not required.
+	// Required is ignored for received sessions.
+	Required []string
+
+	// AllowInsecure permits client-to-server sessions that we initiate to
+	// authenticate without first securing the connection with TLS.
+	// By default any authentication feature (one that is Prohibited once the
+	// session is authenticated, such as SASL) requires the Secure state, so
+	// negotiation fails with a *FeatureError if the server offers
+	// authentication over a plaintext connection.
+	// AllowInsecure only disables this default; it does not clear the Secure
+	// state from features that require it on their own.
+	// This should only be set for connections that are secured by other means,
+	// for example connections to localhost or over a VPN.
+	AllowInsecure bool
+
 	// WebSocket indicates that the negotiator should use the WebSocket
 	// subprotocol defined in RFC 7395.
 	WebSocket bool
@@ -69,7 +112,232 @@ type StreamConfig struct {
 	// This can be used to build an "XML console", but users should be careful
 	// since this bypasses TLS and could expose passwords and other sensitive
 	// data.
+	//
+	// Copies are written from a separate goroutine so that slow writers never
+	// block the stream and errors returned by TeeIn and TeeOut are ignored.
+	// If more than TeeBuffer writes are waiting to be copied, data is dropped
+	// instead.
+	// The number of dropped bytes can be checked with the TeeDropped method on
+	// Session.
 	TeeIn, TeeOut io.Writer
+
+	// TeeBuffer is the number of writes to each of TeeIn and TeeOut that may be
+	// pending before data is dropped.
+	// If TeeBuffer is zero a default of 256 is used.
+	TeeBuffer int
+
+	// TeePretty causes the data copied to TeeIn and TeeOut to be decoded and
+	// written with each stanza or other top level element on its own line and
+	// child elements indented, which makes it easier to read when it is mixed
+	// with other log output.
+	// Stream headers, including those sent when the stream is restarted, are
+	// also written on their own line.
+	// If the data cannot be decoded the remainder of it is copied verbatim.
+	TeePretty bool
+
+	// TeeRedact is called with the name of each element when TeePretty is set.
+	// If it returns true the contents of the element are removed from the
+	// copies written to TeeIn and TeeOut.
+	// RedactAuth may be used to remove SASL payloads.
+	// If TeeRedact is nil, nothing is redacted.
+	TeeRedact func(xml.Name) bool
+
+	// CloseTimeout is the default amount of time to wait for the remote entity
+	// to close its input stream after Close is called on the session.
+	// It has the same effect as calling SetCloseDeadline immediately before
+	// Close.
+	// If CloseTimeout is zero, Serve waits for the remote entity to close its
+	// stream indefinitely unless a deadline is set explicitly.
+	CloseTimeout time.Duration
+
+	// NoCloseWait causes Serve to return as soon as the output stream is closed
+	// instead of waiting for the remote entity to close its stream.
+	// This is useful for fire-and-forget tools that send a few stanzas and then
+	// exit, but it means that any stanzas sent by the remote entity after we
+	// close the output stream will be lost.
+	// If NoCloseWait is set, CloseTimeout is ignored.
+	NoCloseWait bool
+
+	// If WhitespaceKeepAlive is non-zero, a single space character is written to
+	// the output stream any time nothing has been written for the given
+	// interval.
+	// This keeps NATs and other middleboxes from dropping idle connections
+	// without requiring that the server support XEP-0199: XMPP Ping.
+	// The keepalive starts once the session is ready and stops when the output
+	// stream is closed.
+	WhitespaceKeepAlive time.Duration
+
+	// ReadTimeout is the maximum amount of time that Serve waits for data from
+	// the remote entity once the session is established.
+	// If nothing is received within the timeout (for example because the
+	// connection is half-open and the remote entity has gone away without
+	// closing it) a connection-timeout stream error is sent and Serve returns
+	// it.
+	// Servers often send whitespace keepalives or pings to idle clients, but a
+	// ReadTimeout should still be longer than the longest expected period of
+	// inactivity.
+	// If ReadTimeout is zero, Serve waits indefinitely.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the maximum amount of time that each write to the
+	// connection may take once the session is established.
+	// If a write blocks for longer (for example because the remote entity has
+	// stopped reading and the connection's buffers are full), the send fails
+	// with a connection-timeout stream error and the session can no longer be
+	// used.
+	// If WriteTimeout is zero, writes may block indefinitely unless the context
+	// passed to the send method is canceled.
+	WriteTimeout time.Duration
+
+	// WriteQueue is the number of elements that may be waiting to be written by
+	// Encode and EncodeElement once the session is established.
+	// If it is greater than zero, Encode and EncodeElement encode the element
+	// and add it to a queue that is written in order by a separate goroutine,
+	// returning as soon as the element has been queued instead of waiting for
+	// the output stream to become available and the write to complete.
+	// If the queue is full they block until there is room or the context is
+	// canceled.
+	// Other sends (including Send, SendIQ, and Close) wait for the queue to be
+	// written before writing so that elements sent from a single goroutine stay
+	// in order.
+	//
+	// Errors writing a queued element are not returned to the caller that
+	// queued it.
+	// Instead any elements remaining in the queue are dropped and all future
+	// calls to Encode and EncodeElement return the error.
+	WriteQueue int
+
+	// MaxLifetime is the maximum amount of time that a session stays open once
+	// negotiation is complete.
+	// When it elapses the output stream is closed gracefully, exactly as if
+	// Close had been called, so that the remote entity can finish sending any
+	// stanzas that are in flight and Serve returns once it closes its stream.
+	// This lets large deployments proactively cycle connections (and spread
+	// reconnects across server restarts and load balancer rotations) by
+	// establishing a new session when Serve returns.
+	// If MaxLifetime is zero the session stays open indefinitely.
+	MaxLifetime time.Duration
+
+	// MaxLifetimeJitter is the upper bound of a random amount of time that is
+	// added to MaxLifetime for each session so that many sessions that were
+	// established at the same time do not all close at once.
+	// It is ignored if MaxLifetime is zero.
+	MaxLifetimeJitter time.Duration
+
+	// Interceptors are run on every stanza sent using the Send, SendElement,
+	// Encode, and EncodeElement methods (or any of the other methods that
+	// depend on them) in the order they are listed.
+	// Tokens written directly to the encoder passed to a Handler by Serve are
+	// not intercepted.
+	Interceptors []Interceptor
+
+	// ConcurrentHandlers is the maximum number of handlers that Serve runs at
+	// once on separate goroutines.
+	// If it is zero, Serve calls the handler for each element in turn from its
+	// own goroutine while holding locks on the input and output streams.
+	// For more information see Serve.
+	ConcurrentHandlers int
+
+	// HandlerTimeout is the maximum amount of time that each call to a handler
+	// passed to Serve may take.
+	// It is only enforced through the context passed to handlers that implement
+	// ContextHandler.
+	// If HandlerTimeout is zero, the context is only canceled when the session is
+	// closed.
+	HandlerTimeout time.Duration
+
+	// IQTimeout is the default amount of time that SendIQ (and the methods that
+	// depend on it) wait for a response if the context passed to them does not
+	// have a deadline.
+	// If IQTimeout is zero, they wait until the context is canceled.
+	IQTimeout time.Duration
+
+	// Filters are run on every stanza read by Serve before it is matched to a
+	// pending IQ or passed to the handler, in the order they are listed.
+	Filters []Filter
+
+	// Strict enables validation of stanzas using stanza.Validate.
+	// Outgoing stanzas that are invalid are not sent and the validation error is
+	// returned instead.
+	// Incoming stanzas that are invalid are never passed to the handler or
+	// matched to a pending IQ, and a bad-request error is sent in response
+	// (unless the invalid stanza was itself an error or has an unknown type).
+	// Validation requires that each stanza be buffered in memory.
+	Strict bool
+
+	// Metrics, if set, receives measurements of the session such as the number
+	// of stanzas sent and received and the latency of IQs.
+	Metrics Metrics
+
+	// StanzaLogger, if its Log function is set, is called with information
+	// about every stanza sent or received once the session is established.
+	StanzaLogger StanzaLogger
+
+	// FlushInterval enables batching of output once the session is established.
+	// Instead of being written to the connection as soon as they are sent,
+	// elements are buffered until FlushSize bytes are waiting or FlushInterval
+	// has passed since the oldest of them was sent, whichever comes first.
+	// This coalesces many small stanzas into fewer writes (and TLS records) at
+	// the cost of added latency.
+	// Buffered output can be written immediately by calling the Flush method on
+	// Session and is always written before the output stream is closed.
+	// If FlushInterval is zero, each element is written as soon as it is sent
+	// and FlushSize is ignored.
+	FlushInterval time.Duration
+
+	// FlushSize is the number of buffered bytes that causes output to be written
+	// before FlushInterval has passed.
+	// If FlushSize is zero, a buffer of 64KiB is used and output is only
+	// written when the buffer fills or FlushInterval passes.
+	FlushSize int
+
+	// RateLimit is the maximum number of elements per second that may be
+	// written using the Send, SendElement, Encode, and EncodeElement methods (or
+	// any of the other methods that depend on them).
+	// Calls that would exceed the limit are delayed until they can be sent or
+	// their context is canceled.
+	// This keeps bots from being disconnected by servers that limit the rate of
+	// incoming traffic.
+	// Tokens written directly to the encoder passed to a Handler by Serve are
+	// not limited.
+	// If RateLimit is zero, writes are not limited.
+	RateLimit float64
+
+	// RateBurst is the number of elements that may be written at once before
+	// RateLimit is enforced.
+	// If RateBurst is less than one, a burst of one is used.
+	RateBurst int
+
+	// MaxStanzaSize is the maximum size in bytes of each element read by Serve.
+	// If a larger element is received, a policy-violation stream error is sent
+	// and the session is closed.
+	// The size is checked as the element is read so that a peer cannot make us
+	// buffer an arbitrary amount of data.
+	// If MaxStanzaSize is zero, the size of elements is not limited.
+	MaxStanzaSize int
+
+	// MaxStanzaDepth is the maximum depth that elements read by Serve may be
+	// nested, counting the stanza itself as one.
+	// If an element is nested more deeply, a policy-violation stream error is
+	// sent and the session is closed.
+	// If MaxStanzaDepth is zero, the depth of elements is not limited.
+	MaxStanzaDepth int
+
+	// RejectRestrictedXML causes a restricted-xml stream error to be sent and
+	// the session to be closed if comments, processing instructions, or
+	// directives (such as a DOCTYPE declaration) are read by Serve as required
+	// by RFC 6120 §11.1.
+	// Entities declared in a DOCTYPE are never expanded, whether or not this
+	// option is set.
+	RejectRestrictedXML bool
+
+	// Events, if set, receives lifecycle events of the session including those
+	// that occur during negotiation.
+	// It may be shared between sessions so that subscribers do not have to be
+	// registered again after each reconnect.
+	// If Events is nil, each session has its own Events that can be subscribed
+	// to using the Subscribe method on Session.
+	Events *Events
 }
 
 // NewNegotiator creates a Negotiator that uses a collection of StreamFeatures
@@ -81,9 +349,15 @@ func NewNegotiator(cfg StreamConfig) Negotiator {
 	return negotiator(cfg)
 }
 
+const defaultTeeBuffer = 256
+
 type negotiatorState struct {
 	doRestart bool
 	cancelTee context.CancelFunc
+
+	// negotiated contains the namespaces of all features negotiated on any of
+	// the streams so far.
+	negotiated map[string]struct{}
 }
 
 func negotiator(cfg StreamConfig) Negotiator {
@@ -94,11 +368,47 @@ func negotiator(cfg StreamConfig) Negotiator {
 		// default.
 		if !ok {
 			nState = negotiatorState{
-				doRestart: true,
-				cancelTee: nil,
+				doRestart:  true,
+				cancelTee:  nil,
+				negotiated: make(map[string]struct{}),
 			}
 		}
 
+		s.closeTimeout = cfg.CloseTimeout
+		s.closeNoWait = cfg.NoCloseWait
+		s.keepAlive = cfg.WhitespaceKeepAlive
+		s.readTimeout = cfg.ReadTimeout
+		s.writeTimeout = cfg.WriteTimeout
+		s.queueDepth = cfg.WriteQueue
+		s.interceptors = cfg.Interceptors
+		s.filters = cfg.Filters
+		s.handlerTimeout = cfg.HandlerTimeout
+		s.iqTimeout = cfg.IQTimeout
+		s.strict = cfg.Strict
+		s.metrics = cfg.Metrics
+		s.stanzaLog = cfg.StanzaLogger
+		if cfg.Events != nil {
+			s.events = cfg.Events
+		}
+		s.flushSize = cfg.FlushSize
+		s.flushInterval = cfg.FlushInterval
+		s.limits = inputLimiter{
+			maxSize:  int64(cfg.MaxStanzaSize),
+			maxDepth: cfg.MaxStanzaDepth,
+			restrict: cfg.RejectRestrictedXML,
+		}
+		if cfg.RateLimit > 0 && s.limiter == nil {
+			s.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+		}
+		if cfg.ConcurrentHandlers > 0 && s.workers == nil {
+			s.workers = make(chan struct{}, cfg.ConcurrentHandlers)
+		}
+		s.maxLifetime = cfg.MaxLifetime
+		if cfg.MaxLifetime > 0 && cfg.MaxLifetimeJitter > 0 {
+			/* #nosec */
+			s.maxLifetime += time.Duration(rand.Int63n(int64(cfg.MaxLifetimeJitter)))
+		}
+
 		c := s.Conn()
 		// If the session is not already using a tee conn, but we're configured to
 		// use one, return the new teeConn and don't set any state bits.
@@ -112,7 +422,22 @@ func negotiator(cfg StreamConfig) Negotiator {
 			// This context is just for canceling the tee effect so it is not part of
 			// the normal context chain and its parent is Background.
 			ctx, cancel := context.WithCancel(context.Background())
-			c = newTeeConn(ctx, c, cfg.TeeIn, cfg.TeeOut)
+			bufSize := cfg.TeeBuffer
+			if bufSize <= 0 {
+				bufSize = defaultTeeBuffer
+			}
+			in, out := cfg.TeeIn, cfg.TeeOut
+			if cfg.TeePretty {
+				// Each connection gets its own pretty printer since the data copied
+				// after a new conn (eg. after StartTLS) is a new XML document.
+				if in != nil {
+					in = newPrettyWriter(in, cfg.TeeRedact)
+				}
+				if out != nil {
+					out = newPrettyWriter(out, cfg.TeeRedact)
+				}
+			}
+			c = newTeeConn(ctx, cancel, c, in, out, bufSize, &s.teeInDropped, &s.teeOutDropped)
 			nState.cancelTee = cancel
 			return mask, c, nState, err
 		}
@@ -183,14 +508,76 @@ func negotiator(cfg StreamConfig) Negotiator {
 					// violation of the spec. See: https://issues.prosody.im/1625
 					return mask, nil, nState, fmt.Errorf("xmpp: stream origin %s does not match previously set origin %s", s.in.Info.To, origin)
 				}
+
+				if cfg.LangMismatch != nil && cfg.Lang != s.in.Info.Lang {
+					err = cfg.LangMismatch(cfg.Lang, s.in.Info.Lang)
+					if err != nil {
+						nState.doRestart = false
+						return mask, nil, nState, err
+					}
+				}
 			}
 		}
 
 		if cfg.Features != nil {
 			features = cfg.Features(s, features...)
 		}
-		mask, rw, err = negotiateFeatures(ctx, s, data == nil, cfg.WebSocket, features)
+		negotiate := features
+		if s.state&(Received|S2S) == 0 {
+			negotiate = secureAuth(features, cfg.AllowInsecure)
+		}
+		mask, rw, err = negotiateFeatures(ctx, s, data == nil, cfg.WebSocket, negotiate)
+		for k := range s.negotiated {
+			nState.negotiated[k] = struct{}{}
+		}
 		nState.doRestart = rw != nil
+		if err == nil && mask&Ready == Ready && s.state&Received == 0 {
+			err = checkRequired(s, cfg.Required, nState.negotiated, negotiate)
+		}
 		return mask, rw, nState, err
 	}
 }
+
+// SendStreamStart writes an XML declaration and a stream header (or the
+// WebSocket open element if ws is true) to the session's underlying connection.
+// The header is addressed using the To and From fields of out and the ID and
+// Lang fields are used for the stream ID and default language.
+// The XMLNS field of out is set to jabber:server if the session has the S2S
+// bit set or jabber:client otherwise.
+//
+// SendStreamStart is meant to be used from within a Negotiator and should not
+// be called once the session is ready.
+func SendStreamStart(s *Session, out *stream.Info, ws bool) error {
+	return intstream.Send(s.Conn(), out, s.State()&S2S == S2S, ws, stream.DefaultVersion, out.Lang, out.To.String(), out.From.String(), out.ID)
+}
+
+// ExpectStreamStart reads a stream header (or the WebSocket open element if ws
+// is true) from the session's underlying connection and uses it to populate in.
+// If a stream error is read instead, it is returned.
+// If the session was not received (ie. we are the initiating entity) the
+// remote entity must set a stream ID.
+//
+// ExpectStreamStart is meant to be used from within a Negotiator and should not
+// be called once the session is ready.
+func ExpectStreamStart(ctx context.Context, s *Session, in *stream.Info, ws bool) error {
+	return intstream.Expect(ctx, in, s.in.d, s.State()&Received == Received, ws)
+}
+
+// NegotiateFeatures performs a single round of stream feature negotiation
+// using features.
+// If the session was received, the list of features is sent, otherwise the
+// list of features is read from the remote entity and any features
+// advertised by the remote entity are recorded on the session (see Feature).
+// First must be true if this is the first feature list for the session (before
+// any stream restarts) so that StartTLS can be negotiated if it is supported
+// but not advertised.
+//
+// If a feature returns a new io.ReadWriter that should replace the underlying
+// connection (requiring a stream restart), it is returned and should be
+// returned by the Negotiator.
+//
+// NegotiateFeatures is meant to be used from within a Negotiator and should not
+// be called once the session is ready.
+func NegotiateFeatures(ctx context.Context, s *Session, first, ws bool, features []StreamFeature) (mask SessionState, rw io.ReadWriter, err error) {
+	return negotiateFeatures(ctx, s, first, ws, features)
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package nick implements XEP-0172: User Nickname.
+//
+// Nicknames can be published to the users account using the personal eventing
+// protocol (PEP) so that contacts are notified when they change, and they can
+// be included in subscription requests and multi-user chat joins so that the
+// recipient has a name to show before any other information is available.
+package nick // import "mellium.im/xmpp/nick"
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco/info"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	// NS is the namespace of the nick element and the PEP node used to publish
+	// nicknames.
+	NS = "http://jabber.org/protocol/nick"
+
+	// NSNotify is the feature advertised to request that nickname updates be
+	// sent to us by our contacts' servers.
+	NSNotify = NS + "+notify"
+)
+
+const (
+	nsPubSub      = "http://jabber.org/protocol/pubsub"
+	nsPubSubEvent = "http://jabber.org/protocol/pubsub#event"
+	nsMUC         = "http://jabber.org/protocol/muc"
+)
+
+// Nick is a user nickname.
+type Nick string
+
+// TokenReader implements xmlstream.Marshaler.
+func (n Nick) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(n)),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "nick"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (n Nick) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, n.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (n Nick) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := n.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (n *Nick) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*n = Nick(s)
+	return nil
+}
+
+// Publish publishes the users nickname to their account so that contacts that
+// are subscribed to their presence are notified of the change.
+func Publish(ctx context.Context, s *xmpp.Session, nick string) error {
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Wrap(
+				Nick(nick).TokenReader(),
+				xml.StartElement{Name: xml.Name{Local: "item"}},
+			),
+			xml.StartElement{
+				Name: xml.Name{Local: "publish"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: NS}},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: nsPubSub, Local: "pubsub"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+// Subscribe sends a subscription request to the provided JID that includes our
+// nickname.
+func Subscribe(ctx context.Context, s *xmpp.Session, to jid.JID, nick string) error {
+	return s.Send(ctx, stanza.Presence{
+		To:   to.Bare(),
+		Type: stanza.SubscribePresence,
+	}.Wrap(Nick(nick).TokenReader()))
+}
+
+// Join joins the multi-user chat room at the provided occupant JID (the room
+// JID with our desired in-room nickname as the resourcepart) and includes our
+// user nickname in the join presence.
+func Join(ctx context.Context, s *xmpp.Session, occupant jid.JID, nick string) error {
+	return s.Send(ctx, stanza.Presence{
+		To: occupant,
+	}.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsMUC, Local: "x"}}),
+		Nick(nick).TokenReader(),
+	)))
+}
+
+// Handle returns an option that registers a Handler for nickname updates
+// received through PEP notifications and subscription requests.
+//
+// Because PEP notifications for all nodes share the same payload, registering
+// this handler on a mux will conflict with other handlers for PEP
+// notifications.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		event := xml.Name{Space: nsPubSubEvent, Local: "event"}
+		// Notifications are normally sent as headline messages, but some servers
+		// omit the type.
+		mux.MessagePayload("", event, h)(m)
+		mux.MessagePayload(stanza.NormalMessage, event, h)(m)
+		mux.MessagePayload(stanza.HeadlineMessage, event, h)(m)
+		mux.PresencePayload(stanza.SubscribePresence, xml.Name{Space: NS, Local: "nick"}, h)(m)
+	}
+}
+
+// Handler receives nicknames published by our contacts and nicknames included
+// in subscription requests.
+type Handler struct {
+	// Update is called with the sender and their nickname.
+	// If a contact retracts their nickname or publishes an empty one nick will be
+	// empty.
+	Update func(from jid.JID, nick string) error
+}
+
+// HandleMessagePayload implements mux.MessagePayloadHandler.
+func (h Handler) HandleMessagePayload(msg stanza.Message, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var event struct {
+		Items struct {
+			Node    string `xml:"node,attr"`
+			Items   []Nick `xml:"item>nick"`
+			Retract []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"retract"`
+		} `xml:"items"`
+	}
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&event)
+	if err != nil {
+		return err
+	}
+	items := event.Items
+	if items.Node != NS || h.Update == nil {
+		return nil
+	}
+
+	var nick Nick
+	switch {
+	case len(items.Items) > 0:
+		nick = items.Items[len(items.Items)-1]
+	case len(items.Retract) == 0:
+		return nil
+	}
+	return h.Update(msg.From, string(nick))
+}
+
+// HandlePresencePayload implements mux.PresencePayloadHandler.
+func (h Handler) HandlePresencePayload(p stanza.Presence, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if h.Update == nil {
+		return nil
+	}
+	var nick Nick
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&nick)
+	if err != nil {
+		return err
+	}
+	return h.Update(p.From, string(nick))
+}
+
+// ForFeatures implements info.FeatureIter.
+func (h Handler) ForFeatures(node string, f func(info.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	err := f(info.Feature{Var: NS})
+	if err != nil {
+		return err
+	}
+	return f(info.Feature{Var: NSNotify})
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package nick_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/nick"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler       = nick.Nick("")
+	_ xml.Unmarshaler     = (*nick.Nick)(nil)
+	_ xmlstream.Marshaler = nick.Nick("")
+	_ xmlstream.WriterTo  = nick.Nick("")
+)
+
+func TestMarshal(t *testing.T) {
+	b, err := xml.Marshal(nick.Nick("Ishmael"))
+	if err != nil {
+		t.Fatalf("error marshaling nick: %v", err)
+	}
+	const expected = `<nick xmlns="http://jabber.org/protocol/nick">Ishmael</nick>`
+	if s := string(b); s != expected {
+		t.Errorf("wrong output: want=%s, got=%s", expected, s)
+	}
+
+	var n nick.Nick
+	err = xml.Unmarshal(b, &n)
+	if err != nil {
+		t.Fatalf("error unmarshaling nick: %v", err)
+	}
+	if n != "Ishmael" {
+		t.Errorf("wrong nick unmarshaled: want=Ishmael, got=%s", n)
+	}
+}
+
+var handlerTestCases = [...]struct {
+	x      string
+	from   string
+	nick   string
+	called bool
+}{
+	0: {
+		x:      `<message from="narrator@moby-dick.lit" type="headline" xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="http://jabber.org/protocol/nick"><item><nick xmlns="http://jabber.org/protocol/nick">Ishmael</nick></item></items></event></message>`,
+		from:   "narrator@moby-dick.lit",
+		nick:   "Ishmael",
+		called: true,
+	},
+	1: {
+		x:      `<message from="narrator@moby-dick.lit" xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="http://jabber.org/protocol/nick"><retract id="current"/></items></event></message>`,
+		from:   "narrator@moby-dick.lit",
+		called: true,
+	},
+	2: {
+		x: `<message from="narrator@moby-dick.lit" type="headline" xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:example"><item><nick xmlns="http://jabber.org/protocol/nick">Ishmael</nick></item></items></event></message>`,
+	},
+	3: {
+		x:      `<presence from="narrator@moby-dick.lit" type="subscribe" xmlns="jabber:client"><nick xmlns="http://jabber.org/protocol/nick">Ishmael</nick></presence>`,
+		from:   "narrator@moby-dick.lit",
+		nick:   "Ishmael",
+		called: true,
+	},
+}
+
+func TestHandler(t *testing.T) {
+	for i, tc := range handlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var called bool
+			m := mux.New(nick.Handle(nick.Handler{
+				Update: func(from jid.JID, n string) error {
+					called = true
+					if from.String() != tc.from {
+						t.Errorf("wrong from: want=%s, got=%s", tc.from, from)
+					}
+					if n != tc.nick {
+						t.Errorf("wrong nick: want=%q, got=%q", tc.nick, n)
+					}
+					return nil
+				},
+			}))
+			d := xml.NewDecoder(strings.NewReader(tc.x))
+			tok, _ := d.Token()
+			start := tok.(xml.StartElement)
+			err := m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+			}, &start)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if called != tc.called {
+				t.Errorf("unexpected handler call: want=%t, got=%t", tc.called, called)
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	var node string
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			iq, err := stanza.NewIQ(*start)
+			if err != nil {
+				return err
+			}
+			var pubsub struct {
+				Publish struct {
+					Node string    `xml:"node,attr"`
+					Nick nick.Nick `xml:"item>nick"`
+				} `xml:"publish"`
+			}
+			err = xml.NewTokenDecoder(e).Decode(&pubsub)
+			if err != nil {
+				return err
+			}
+			node = pubsub.Publish.Node
+			if pubsub.Publish.Nick != "Ishmael" {
+				t.Errorf("wrong nick published: want=Ishmael, got=%s", pubsub.Publish.Nick)
+			}
+			_, err = xmlstream.Copy(e, iq.Result(nil))
+			return err
+		}),
+	)
+	err := nick.Publish(context.Background(), cs.Client, "Ishmael")
+	if err != nil {
+		t.Fatalf("error publishing nick: %v", err)
+	}
+	if node != nick.NS {
+		t.Errorf("published to wrong node: want=%s, got=%s", nick.NS, node)
+	}
+}
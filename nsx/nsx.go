@@ -0,0 +1,139 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package nsx contains constants for the XML namespaces used throughout this
+// module and helpers for matching them.
+//
+// Each package that implements an extension also exports its own namespace
+// constants; the constants in this package are provided so that namespaces can
+// be matched without importing the packages that implement them.
+package nsx // import "mellium.im/xmpp/nsx"
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Namespaces defined by the core XMPP RFCs.
+const (
+	Bind        = "urn:ietf:params:xml:ns:xmpp-bind"
+	Client      = "jabber:client"
+	Framing     = "urn:ietf:params:xml:ns:xmpp-framing"
+	SASL        = "urn:ietf:params:xml:ns:xmpp-sasl"
+	Server      = "jabber:server"
+	Stanza      = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	StartTLS    = "urn:ietf:params:xml:ns:xmpp-tls"
+	Stream      = "http://etherx.jabber.org/streams"
+	StreamError = "urn:ietf:params:xml:ns:xmpp-streams"
+	XML         = "http://www.w3.org/XML/1998/namespace"
+)
+
+// Namespaces defined by XMPP Extension Protocols (XEPs).
+const (
+	Bidi             = "urn:xmpp:bidi"
+	BidiFeature      = "urn:xmpp:features:bidi"
+	Blocking         = "urn:xmpp:blocking"
+	Bookmarks        = "urn:xmpp:bookmarks:1"
+	ComponentAccept  = "jabber:component:accept"
+	CompressFeature  = "http://jabber.org/features/compress"
+	CompressProtocol = "http://jabber.org/protocol/compress"
+	Conference       = "jabber:x:conference"
+	Delay            = "urn:xmpp:delay"
+	Delegation       = "urn:xmpp:delegation:1"
+	Dialback         = "jabber:server:dialback"
+	DialbackFeature  = "urn:xmpp:features:dialback"
+	DiscoInfo        = "http://jabber.org/protocol/disco#info"
+	DiscoItems       = "http://jabber.org/protocol/disco#items"
+	ExtDisco         = "urn:xmpp:extdisco:2"
+	Fallback         = "urn:xmpp:fallback:0"
+	Form             = "jabber:x:data"
+	Forward          = "urn:xmpp:forward:0"
+	Gateway          = "jabber:iq:gateway"
+	Hints            = "urn:xmpp:hints"
+	IBR2             = "urn:xmpp:register:0"
+	Jingle           = "urn:xmpp:jingle:1"
+	JingleDTLS       = "urn:xmpp:jingle:apps:dtls:0"
+	JingleICEUDP     = "urn:xmpp:jingle:transports:ice-udp:1"
+	JingleMessage    = "urn:xmpp:jingle-message:0"
+	JingleRTP        = "urn:xmpp:jingle:apps:rtp:1"
+	MUC              = "http://jabber.org/protocol/muc"
+	MUCAdmin         = "http://jabber.org/protocol/muc#admin"
+	MUCOwner         = "http://jabber.org/protocol/muc#owner"
+	MUCUser          = "http://jabber.org/protocol/muc#user"
+	Moved            = "urn:xmpp:moved:1"
+	Nick             = "http://jabber.org/protocol/nick"
+	OOB              = "jabber:x:oob"
+	OOBQuery         = "jabber:iq:oob"
+	OccupantID       = "urn:xmpp:occupant-id:0"
+	Paging           = "http://jabber.org/protocol/rsm"
+	Ping             = "urn:xmpp:ping"
+	Privilege        = "urn:xmpp:privilege:1"
+	PubSub           = "http://jabber.org/protocol/pubsub"
+	PubSubEvent      = "http://jabber.org/protocol/pubsub#event"
+	PubSubOwner      = "http://jabber.org/protocol/pubsub#owner"
+	Receipts         = "urn:xmpp:receipts"
+	Reference        = "urn:xmpp:reference:0"
+	Register         = "jabber:iq:register"
+	Reporting        = "urn:xmpp:reporting:1"
+	Roster           = "jabber:iq:roster"
+	SID              = "urn:xmpp:sid:0"
+	Styling          = "urn:xmpp:styling:0"
+	Time             = "urn:xmpp:time"
+	VCard            = "vcard-temp"
+	VCardUpdate      = "vcard-temp:x:update"
+	Version          = "jabber:iq:version"
+)
+
+// IsStanza reports whether name is the name of an IQ, message, or presence
+// stanza in the client or server namespace.
+// An empty namespace is also accepted since stanzas inherit the namespace of
+// the stream.
+func IsStanza(name xml.Name) bool {
+	switch name.Space {
+	case Client, Server, "":
+	default:
+		return false
+	}
+	switch name.Local {
+	case "iq", "message", "presence":
+		return true
+	}
+	return false
+}
+
+// IsStreamError reports whether name is the name of a stream error.
+func IsStreamError(name xml.Name) bool {
+	return name.Local == "error" && name.Space == Stream
+}
+
+// IsPubSubEvent reports whether name is the name of a pubsub event
+// notification payload.
+func IsPubSubEvent(name xml.Name) bool {
+	return name.Local == "event" && name.Space == PubSubEvent
+}
+
+// Versioned reports whether space is the namespace base followed by a version,
+// for example "urn:xmpp:reference:0" is a versioned form of
+// "urn:xmpp:reference".
+// This allows matching namespaces of extensions that may be updated with a new
+// version in the future.
+// A namespace is also considered a versioned form of itself.
+func Versioned(space, base string) bool {
+	if space == base {
+		return true
+	}
+	if !strings.HasPrefix(space, base+":") {
+		return false
+	}
+	v := space[len(base)+1:]
+	if v == "" {
+		return false
+	}
+	for _, r := range v {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
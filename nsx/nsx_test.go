@@ -0,0 +1,136 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package nsx_test
+
+import (
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/blocklist"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/component"
+	"mellium.im/xmpp/compress"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/extdisco"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/gateway"
+	"mellium.im/xmpp/hints"
+	"mellium.im/xmpp/ibr2"
+	"mellium.im/xmpp/jingle"
+	"mellium.im/xmpp/moved"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/nick"
+	"mellium.im/xmpp/nsx"
+	"mellium.im/xmpp/oob"
+	"mellium.im/xmpp/paging"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/receipts"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/s2s"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/stream"
+	"mellium.im/xmpp/styling"
+	"mellium.im/xmpp/vcard"
+	"mellium.im/xmpp/version"
+	"mellium.im/xmpp/websocket"
+	"mellium.im/xmpp/xtime"
+)
+
+// Make sure that the constants in this package never get out of sync with the
+// ones exported by the packages that implement each namespace.
+var constTestCases = [...]struct {
+	got  string
+	want string
+}{
+	0:  {got: nsx.Bidi, want: s2s.NSBidi},
+	1:  {got: nsx.BidiFeature, want: s2s.NSBidiFeature},
+	2:  {got: nsx.ComponentAccept, want: component.NSAccept},
+	3:  {got: nsx.CompressFeature, want: compress.NSFeatures},
+	4:  {got: nsx.CompressProtocol, want: compress.NSProtocol},
+	5:  {got: nsx.Delay, want: delay.NS},
+	6:  {got: nsx.Dialback, want: s2s.NSDialback},
+	7:  {got: nsx.DialbackFeature, want: s2s.NSDialbackFeature},
+	8:  {got: nsx.DiscoInfo, want: disco.NSInfo},
+	9:  {got: nsx.DiscoItems, want: disco.NSItems},
+	10: {got: nsx.Fallback, want: fallback.NS},
+	11: {got: nsx.Form, want: form.NS},
+	12: {got: nsx.Forward, want: forward.NS},
+	13: {got: nsx.Hints, want: hints.NS},
+	14: {got: nsx.IBR2, want: ibr2.NS},
+	15: {got: nsx.MUC, want: muc.NS},
+	16: {got: nsx.MUCAdmin, want: muc.NSAdmin},
+	17: {got: nsx.MUCOwner, want: muc.NSOwner},
+	18: {got: nsx.MUCUser, want: muc.NSUser},
+	19: {got: nsx.OOB, want: oob.NS},
+	20: {got: nsx.OOBQuery, want: oob.NSQuery},
+	21: {got: nsx.Paging, want: paging.NS},
+	22: {got: nsx.Ping, want: ping.NS},
+	23: {got: nsx.Receipts, want: receipts.NS},
+	24: {got: nsx.Reference, want: muc.NSReference},
+	25: {got: nsx.Roster, want: roster.NS},
+	26: {got: nsx.SID, want: stanza.NSSid},
+	27: {got: nsx.Styling, want: styling.NS},
+	28: {got: nsx.Time, want: xtime.NS},
+	29: {got: nsx.Version, want: version.NS},
+	30: {got: nsx.Framing, want: websocket.NS},
+	31: {got: nsx.Stream, want: stream.NS},
+	32: {got: nsx.StreamError, want: stream.NSError},
+	33: {got: nsx.Nick, want: nick.NS},
+	34: {got: nsx.Conference, want: muc.NSConference},
+	35: {got: nsx.Delegation, want: component.NSDelegation},
+	36: {got: nsx.Privilege, want: component.NSPrivilege},
+	37: {got: nsx.ExtDisco, want: extdisco.NS},
+	38: {got: nsx.Jingle, want: jingle.NS},
+	39: {got: nsx.JingleRTP, want: jingle.NSRTP},
+	40: {got: nsx.JingleICEUDP, want: jingle.NSICEUDP},
+	41: {got: nsx.JingleDTLS, want: jingle.NSDTLS},
+	42: {got: nsx.JingleMessage, want: jingle.NSMessage},
+	43: {got: nsx.Moved, want: moved.NS},
+	44: {got: nsx.Bookmarks, want: bookmarks.NS},
+	45: {got: nsx.OccupantID, want: muc.NSOccupantID},
+	46: {got: nsx.Blocking, want: blocklist.NS},
+	47: {got: nsx.Reporting, want: blocklist.NSReporting},
+	48: {got: nsx.Gateway, want: gateway.NS},
+	49: {got: nsx.Register, want: gateway.NSRegister},
+	50: {got: nsx.VCard, want: vcard.NS},
+	51: {got: nsx.VCardUpdate, want: vcard.NSUpdate},
+}
+
+func TestConstants(t *testing.T) {
+	for i, tc := range constTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Errorf("namespace out of sync: want=%q, got=%q", tc.want, tc.got)
+			}
+		})
+	}
+}
+
+var versionedTestCases = [...]struct {
+	space string
+	base  string
+	match bool
+}{
+	0: {space: "urn:xmpp:reference:0", base: "urn:xmpp:reference", match: true},
+	1: {space: "urn:xmpp:reference:12", base: "urn:xmpp:reference", match: true},
+	2: {space: "urn:xmpp:reference", base: "urn:xmpp:reference", match: true},
+	3: {space: "urn:xmpp:reference:", base: "urn:xmpp:reference"},
+	4: {space: "urn:xmpp:reference:x", base: "urn:xmpp:reference"},
+	5: {space: "urn:xmpp:references:0", base: "urn:xmpp:reference"},
+	6: {space: "urn:xmpp:reference:0:1", base: "urn:xmpp:reference"},
+}
+
+func TestVersioned(t *testing.T) {
+	for i, tc := range versionedTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if match := nsx.Versioned(tc.space, tc.base); match != tc.match {
+				t.Errorf("wrong match for %q and %q: want=%t, got=%t", tc.space, tc.base, tc.match, match)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package offline distinguishes messages that were stored by the server while
+// the user was offline from live traffic.
+//
+// As described in XEP-0160: Best Practices for Handling Offline Messages,
+// servers store messages sent to a user with no available resources and
+// deliver them when the user next sends initial presence.
+// Each stored message is stamped with a delay element (XEP-0203) added by the
+// server.
+package offline // import "mellium.im/xmpp/offline"
+
+import (
+	"encoding/xml"
+	"sort"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Reason is the natural language description that many servers include in the
+// delay element of offline messages.
+const Reason = "Offline Storage"
+
+// Message is a message that includes a delayed delivery stamp.
+type Message struct {
+	stanza.Message
+	Body  string      `xml:"body,omitempty"`
+	Delay delay.Delay `xml:"urn:xmpp:delay delay"`
+}
+
+// Offline reports whether the message was delivered from offline storage on
+// the provided server.
+// If server is the zero value, any delay added by a domain (rather than a user
+// or a room) or containing the standard reason is assumed to be from offline
+// storage.
+func (m Message) Offline(server jid.JID) bool {
+	if !server.Equal(jid.JID{}) {
+		return m.Delay.From.Equal(server.Domain())
+	}
+	if m.Delay.Reason == Reason {
+		return true
+	}
+	from := m.Delay.From
+	return !from.Equal(jid.JID{}) && from.Localpart() == "" && from.Resourcepart() == ""
+}
+
+// Sort orders messages by the time at which they were originally sent.
+// Messages with the same stamp retain their relative order.
+func Sort(msgs []Message) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Delay.Time.Before(msgs[j].Delay.Time)
+	})
+}
+
+// Handle returns an option that registers a Handler for delayed messages.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		d := xml.Name{Space: delay.NS, Local: "delay"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage} {
+			mux.Message(typ, d, h)(m)
+		}
+	}
+}
+
+// Handler decodes messages with a delay and passes them to Offline if they
+// came from offline storage, or to Delayed otherwise (for example, messages
+// delayed by a slow gateway).
+// Messages are passed to the callbacks in the order in which they are
+// received, which servers should keep the same as the order in which they were
+// stored.
+// Any nil callbacks are ignored.
+type Handler struct {
+	// Server is the address of the user's server.
+	// If it is the zero value, offline messages are detected using heuristics.
+	// For more information see Message.Offline.
+	Server  jid.JID
+	Offline func(Message) error
+	Delayed func(Message) error
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+func (h Handler) HandleMessage(_ stanza.Message, t xmlstream.TokenReadEncoder) error {
+	var msg Message
+	err := xml.NewTokenDecoder(t).Decode(&msg)
+	if err != nil {
+		return err
+	}
+	f := h.Delayed
+	if msg.Offline(h.Server) {
+		f = h.Offline
+	}
+	if f == nil {
+		return nil
+	}
+	return f(msg)
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package offline_test
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/offline"
+)
+
+var handlerTestCases = [...]struct {
+	server  string
+	x       string
+	offline bool
+}{
+	0: {
+		server:  "capulet.com",
+		x:       `<message from="romeo@montague.net/orchard" to="juliet@capulet.com" type="chat" xmlns="jabber:client"><body>O blessed, blessed night!</body><delay xmlns="urn:xmpp:delay" from="capulet.com" stamp="2002-09-10T23:08:25Z">Offline Storage</delay></message>`,
+		offline: true,
+	},
+	1: {
+		x:       `<message from="romeo@montague.net/orchard" to="juliet@capulet.com" xmlns="jabber:client"><body>O blessed, blessed night!</body><delay xmlns="urn:xmpp:delay" from="capulet.com" stamp="2002-09-10T23:08:25Z"/></message>`,
+		offline: true,
+	},
+	2: {
+		server: "capulet.com",
+		x:      `<message from="romeo@montague.net/orchard" to="juliet@capulet.com" type="chat" xmlns="jabber:client"><body>O blessed, blessed night!</body><delay xmlns="urn:xmpp:delay" from="gateway.montague.net" stamp="2002-09-10T23:08:25Z"/></message>`,
+	},
+	3: {
+		x: `<message from="romeo@montague.net/orchard" to="juliet@capulet.com" type="chat" xmlns="jabber:client"><body>O blessed, blessed night!</body><delay xmlns="urn:xmpp:delay" from="romeo@montague.net/orchard" stamp="2002-09-10T23:08:25Z"/></message>`,
+	},
+}
+
+func TestHandler(t *testing.T) {
+	for i, tc := range handlerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var server jid.JID
+			if tc.server != "" {
+				server = jid.MustParse(tc.server)
+			}
+			var got *offline.Message
+			var isOffline bool
+			m := mux.New(offline.Handle(offline.Handler{
+				Server: server,
+				Offline: func(msg offline.Message) error {
+					got, isOffline = &msg, true
+					return nil
+				},
+				Delayed: func(msg offline.Message) error {
+					got = &msg
+					return nil
+				},
+			}))
+			d := xml.NewDecoder(strings.NewReader(tc.x))
+			tok, _ := d.Token()
+			start := tok.(xml.StartElement)
+			err := m.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+			}, &start)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got == nil {
+				t.Fatalf("handler was not called")
+			}
+			if isOffline != tc.offline {
+				t.Errorf("wrong offline status: want=%t, got=%t", tc.offline, isOffline)
+			}
+			if got.Body != "O blessed, blessed night!" {
+				t.Errorf("wrong body: %q", got.Body)
+			}
+			if want := time.Date(2002, 9, 10, 23, 8, 25, 0, time.UTC); !got.Delay.Time.Equal(want) {
+				t.Errorf("wrong stamp: want=%v, got=%v", want, got.Delay.Time)
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	now := time.Now()
+	msgs := []offline.Message{
+		{Body: "2", Delay: delay.Delay{Time: now.Add(time.Minute)}},
+		{Body: "0", Delay: delay.Delay{Time: now}},
+		{Body: "1", Delay: delay.Delay{Time: now}},
+	}
+	offline.Sort(msgs)
+	for i, msg := range msgs {
+		if msg.Body != strconv.Itoa(i) {
+			t.Errorf("wrong order at %d: got body %s", i, msg.Body)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package oob_test
+
+import (
+	"testing"
+
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/oob"
+)
+
+func TestConformance(t *testing.T) {
+	xmpptest.RunGoldenTests(t, "testdata", func(name string) interface{} {
+		switch name {
+		case "data":
+			return &oob.Data{}
+		case "query":
+			return &oob.Query{}
+		}
+		return nil
+	})
+}
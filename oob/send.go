@@ -0,0 +1,71 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package oob
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Attach sends msg with the provided out of band data attached.
+// The URL of the first attachment is also used as the body of the message so
+// that clients that do not support out of band data can still display a link,
+// and clients that do can show it inline (for example, as an image).
+func Attach(ctx context.Context, s *xmpp.Session, msg stanza.Message, data ...Data) error {
+	inner := make([]xml.TokenReader, 0, len(data)+1)
+	if len(data) > 0 {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(data[0].URL)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		))
+	}
+	for _, d := range data {
+		inner = append(inner, d.TokenReader())
+	}
+	return s.Send(ctx, msg.Wrap(xmlstream.MultiReader(inner...)))
+}
+
+// Send sends the URL in q to the entity at the provided address in an IQ and
+// waits for it to be retrieved.
+// If the recipient rejects the URL or fails to retrieve it, a stanza.Error is
+// returned.
+func Send(ctx context.Context, s *xmpp.Session, to jid.JID, q Query) error {
+	return s.UnmarshalIQElement(ctx, q.TokenReader(), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   to,
+	}, nil)
+}
+
+// Attachments decodes a message or presence read from r and returns any out
+// of band data attached to it.
+func Attachments(r xml.TokenReader) ([]Data, error) {
+	d := xml.NewTokenDecoder(r)
+	var data []Data
+	for {
+		tok, err := d.Token()
+		switch {
+		case err == io.EOF:
+			return data, nil
+		case err != nil:
+			return data, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Space != NS || start.Name.Local != "x" {
+			continue
+		}
+		var x Data
+		err = d.DecodeElement(&x, &start)
+		if err != nil {
+			return data, err
+		}
+		data = append(data, x)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package oob_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/oob"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+func TestAttach(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	err := oob.Attach(context.Background(), s, stanza.Message{
+		To:   jid.MustParse("juliet@example.com"),
+		Type: stanza.ChatMessage,
+	}, oob.Data{URL: "https://example.net/balcony.jpg", Desc: "The balcony"})
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<body>https://example.net/balcony.jpg</body>`,
+		`<x xmlns="jabber:x:oob"><url>https://example.net/balcony.jpg</url><desc>The balcony</desc></x>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %s, got: %s", want, out)
+		}
+	}
+
+	data, err := oob.Attachments(xml.NewDecoder(strings.NewReader(out)))
+	if err != nil {
+		t.Fatalf("error decoding attachments: %v", err)
+	}
+	want := []oob.Data{{
+		XMLName: xml.Name{Space: oob.NS, Local: "x"},
+		URL:     "https://example.net/balcony.jpg",
+		Desc:    "The balcony",
+	}}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("wrong attachments: want=%+v, got=%+v", want, data)
+	}
+}
+
+func TestSend(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		var query oob.Query
+		cs := xmpptest.NewClientServer(
+			xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				iq, err := stanza.NewIQ(*start)
+				if err != nil {
+					return err
+				}
+				err = xml.NewTokenDecoder(e).Decode(&query)
+				if err != nil {
+					return err
+				}
+				resp := iq.Result(nil)
+				if reject {
+					resp = iq.Error(stanza.Error{
+						Type:      stanza.Modify,
+						Condition: stanza.NotAcceptable,
+					})
+				}
+				_, err = xmlstream.Copy(e, resp)
+				return err
+			}),
+		)
+		err := oob.Send(context.Background(), cs.Client, jid.MustParse("malvolio@jabber.org/apkjase"), oob.Query{
+			URL: "https://xmpp.org/images/promo/xmpp_server_guide_2017.pdf",
+		})
+		var stanzaErr stanza.Error
+		switch {
+		case reject && !errors.As(err, &stanzaErr):
+			t.Errorf("expected stanza error, got: %v", err)
+		case !reject && err != nil:
+			t.Errorf("unexpected error: %v", err)
+		}
+		if query.URL != "https://xmpp.org/images/promo/xmpp_server_guide_2017.pdf" {
+			t.Errorf("wrong URL sent: %q", query.URL)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package pep contains helpers for storing data on Personal Eventing Protocol
+// nodes.
+//
+// Extensions that store data in PEP, such as bookmarks or avatars, must ensure
+// that the node they publish to is configured correctly, for example so that
+// private data is not leaked to contacts.
+// This is done by including publish options with each publication as
+// described in XEP-0222: Persistent Storage of Public Data via PubSub and
+// XEP-0223: Persistent Storage of Private Data via PubSub.
+// If a node already exists with a different configuration, servers reject the
+// publication and Publish reconfigures the node before trying again.
+package pep // import "mellium.im/xmpp/pep"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package.
+const (
+	NSPubSub         = "http://jabber.org/protocol/pubsub"
+	NSOwner          = "http://jabber.org/protocol/pubsub#owner"
+	NSErrors         = "http://jabber.org/protocol/pubsub#errors"
+	NSPublishOptions = "http://jabber.org/protocol/pubsub#publish-options"
+	NSNodeConfig     = "http://jabber.org/protocol/pubsub#node_config"
+)
+
+// AccessModel controls who may subscribe to a node and retrieve its items.
+type AccessModel string
+
+// A list of access models.
+const (
+	Open      AccessModel = "open"
+	Presence  AccessModel = "presence"
+	Roster    AccessModel = "roster"
+	Whitelist AccessModel = "whitelist"
+)
+
+// Options is the configuration of a node that is sent as a precondition when
+// publishing.
+// Empty fields are not included.
+type Options struct {
+	AccessModel  AccessModel
+	PersistItems bool
+
+	// MaxItems is the maximum number of items to persist, either a number or
+	// "max" to use the largest value supported by the server.
+	MaxItems string
+
+	// SendLastPublishedItem controls when the last item is sent to subscribers,
+	// for example "never" or "on_sub_and_presence".
+	SendLastPublishedItem string
+}
+
+// Presets for commonly used node configurations.
+var (
+	// PersistentWhitelist is for private data that only the account owner may
+	// access as described in XEP-0223.
+	PersistentWhitelist = Options{
+		AccessModel:  Whitelist,
+		PersistItems: true,
+	}
+
+	// PersistentOpen is for public data that anybody may access.
+	PersistentOpen = Options{
+		AccessModel:  Open,
+		PersistItems: true,
+	}
+)
+
+func (o Options) form(formType string) (*form.Data, error) {
+	type setting struct {
+		id  string
+		val interface{}
+	}
+	fields := []form.Field{form.Hidden("FORM_TYPE")}
+	settings := []setting{{id: "FORM_TYPE", val: formType}}
+	if o.PersistItems {
+		fields = append(fields, form.Boolean("pubsub#persist_items"))
+		settings = append(settings, setting{id: "pubsub#persist_items", val: true})
+	}
+	for _, v := range [...]setting{
+		{id: "pubsub#max_items", val: o.MaxItems},
+		{id: "pubsub#send_last_published_item", val: o.SendLastPublishedItem},
+		{id: "pubsub#access_model", val: string(o.AccessModel)},
+	} {
+		if v.val == "" {
+			continue
+		}
+		fields = append(fields, form.Text(v.id))
+		settings = append(settings, v)
+	}
+	data := form.New(fields...)
+	for _, v := range settings {
+		_, err := data.Set(v.id, v.val)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Publish publishes an item to a node on the users own account using opts as
+// publish options.
+// If id is empty the server assigns an ID to the item.
+//
+// If the server rejects the publication because the node exists with a
+// different configuration, the node is reconfigured using Configure and the
+// item is published again.
+func Publish(ctx context.Context, s *xmpp.Session, node, id string, opts Options, payload xmlstream.Marshaler) error {
+	err := publish(ctx, s, node, id, opts, payload)
+	if !isPreconditionNotMet(err) {
+		return err
+	}
+	err = Configure(ctx, s, node, opts)
+	if err != nil {
+		return err
+	}
+	return publish(ctx, s, node, id, opts, payload)
+}
+
+func publish(ctx context.Context, s *xmpp.Session, node, id string, opts Options, payload xmlstream.Marshaler) error {
+	data, err := opts.form(NSPublishOptions)
+	if err != nil {
+		return err
+	}
+	submission, _ := data.Submit()
+
+	var itemAttrs []xml.Attr
+	if id != "" {
+		itemAttrs = append(itemAttrs, xml.Attr{Name: xml.Name{Local: "id"}, Value: id})
+	}
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.MultiReader(
+			xmlstream.Wrap(
+				xmlstream.Wrap(
+					payload.TokenReader(),
+					xml.StartElement{Name: xml.Name{Local: "item"}, Attr: itemAttrs},
+				),
+				xml.StartElement{
+					Name: xml.Name{Local: "publish"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+				},
+			),
+			xmlstream.Wrap(
+				submission,
+				xml.StartElement{Name: xml.Name{Local: "publish-options"}},
+			),
+		),
+		xml.StartElement{Name: xml.Name{Space: NSPubSub, Local: "pubsub"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+// Configure sets the configuration of a node on the users own account.
+func Configure(ctx context.Context, s *xmpp.Session, node string, opts Options) error {
+	data, err := opts.form(NSNodeConfig)
+	if err != nil {
+		return err
+	}
+	submission, _ := data.Submit()
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			submission,
+			xml.StartElement{
+				Name: xml.Name{Local: "configure"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "pubsub"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+func isPreconditionNotMet(err error) bool {
+	var se stanza.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.Condition == stanza.Conflict &&
+		se.Application.Name.Local == "precondition-not-met" &&
+		(se.Application.Name.Space == "" || se.Application.Name.Space == NSErrors)
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pep_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/pep"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+type payload struct{}
+
+func (payload) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: "urn:example", Local: "data"}})
+}
+
+const (
+	publishReq    = `<iq type="set"><pubsub xmlns="http://jabber.org/protocol/pubsub"><publish node="urn:example"><item id="current"><data xmlns="urn:example"/></item></publish><publish-options><x xmlns="jabber:x:data" type="submit"><field var="FORM_TYPE"><value>http://jabber.org/protocol/pubsub#publish-options</value></field><field var="pubsub#persist_items"><value>true</value></field><field var="pubsub#access_model"><value>whitelist</value></field></x></publish-options></pubsub></iq>`
+	configureReq  = `<iq type="set"><pubsub xmlns="http://jabber.org/protocol/pubsub#owner"><configure node="urn:example"><x xmlns="jabber:x:data" type="submit"><field var="FORM_TYPE"><value>http://jabber.org/protocol/pubsub#node_config</value></field><field var="pubsub#access_model"><value>whitelist</value></field></x></configure></pubsub></iq>`
+	resultResp    = `<iq type="result" xmlns="jabber:client"/>`
+	preconditions = `<iq type="error" xmlns="jabber:client"><error type="cancel"><conflict xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/><precondition-not-met xmlns="http://jabber.org/protocol/pubsub#errors"/></error></iq>`
+	forbiddenResp = `<iq type="error" xmlns="jabber:client"><error type="auth"><forbidden xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`
+)
+
+func TestPublish(t *testing.T) {
+	for i, tc := range [...]struct {
+		script [][2]string
+		err    error
+	}{
+		0: {
+			script: [][2]string{{publishReq, resultResp}},
+		},
+		1: {
+			script: [][2]string{
+				{publishReq, preconditions},
+				{configureReq, resultResp},
+				{publishReq, resultResp},
+			},
+		},
+		2: {
+			script: [][2]string{
+				{publishReq, preconditions},
+				{configureReq, forbiddenResp},
+			},
+			err: stanza.Error{Condition: stanza.Forbidden},
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m := xmpptest.NewMock(t)
+			for _, step := range tc.script {
+				m.Expect(step[0]).Reply(step[1])
+			}
+			cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+			defer cs.Close()
+
+			err := pep.Publish(context.Background(), cs.Client, "urn:example", "current", pep.PersistentWhitelist, payload{})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			m.Done()
+		})
+	}
+}
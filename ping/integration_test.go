@@ -41,6 +41,41 @@ func TestIntegrationSendPing(t *testing.T) {
 	ejabberdRun(integrationSendPing)
 }
 
+func TestIntegrationMatrix(t *testing.T) {
+	prosodyRun := prosody.Test(context.TODO(), t,
+		integration.Log(),
+		prosody.ListenC2S(),
+		prosody.ListenDirectTLS(),
+		prosody.WebSocket(),
+	)
+	prosodyRun(func(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
+		cells := integration.Matrix([]integration.Transport{
+			integration.StartTLS,
+			integration.DirectTLS,
+			integration.WebSocket,
+		}, nil, nil)
+		integration.RunMatrix(ctx, t, cmd, cells, integrationMatrixPing)
+	})
+}
+
+func integrationMatrixPing(ctx context.Context, t *testing.T, cmd *integration.Cmd, cell integration.Cell) {
+	j, pass := cmd.User()
+	session, err := cmd.DialCell(ctx, t, cell, j, pass)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	go func() {
+		err := session.Serve(nil)
+		if err != nil {
+			t.Logf("error from serve: %v", err)
+		}
+	}()
+	err = ping.Send(ctx, session, session.RemoteAddr())
+	if err != nil {
+		t.Errorf("error pinging: %v", err)
+	}
+}
+
 func integrationRecvPing(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
 	gotPing := make(chan struct{})
 	p := cmd.C2SPort()
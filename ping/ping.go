@@ -9,6 +9,7 @@ import (
 	"context"
 	"encoding/xml"
 	"errors"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
@@ -65,6 +66,53 @@ func Send(ctx context.Context, s *xmpp.Session, to jid.JID) error {
 	return err
 }
 
+// KeepAlive pings the server every interval until the context is canceled or
+// a ping fails.
+// It blocks, so it will normally be run in its own goroutine alongside a call
+// to Serve.
+//
+// If rtt is not nil it is called with the round trip time of each successful
+// ping.
+// If a ping is not answered before the next one would be sent, the session is
+// closed, its underlying connection is closed, and the error is returned so
+// that the application can reconnect.
+// If the context is canceled, the context error is returned and the session is
+// left open.
+func KeepAlive(ctx context.Context, s *xmpp.Session, interval time.Duration, rtt func(time.Duration)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		start := time.Now()
+		err := Send(pingCtx, s, s.RemoteAddr())
+		cancel()
+		switch {
+		case err == nil:
+			if rtt != nil {
+				rtt(time.Since(start))
+			}
+			continue
+		case ctx.Err() != nil:
+			// If the parent context was canceled while we were waiting on a response
+			// don't treat it as a failed ping.
+			return ctx.Err()
+		}
+
+		/* #nosec */
+		s.Close()
+		/* #nosec */
+		s.Conn().Close()
+		return err
+	}
+}
+
 // IQ is encoded as a ping request.
 type IQ struct {
 	stanza.IQ
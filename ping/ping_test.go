@@ -9,13 +9,14 @@ import (
 	"encoding/xml"
 	"strings"
 	"testing"
+	"time"
 
 	"mellium.im/xmlstream"
-	"mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/ping"
 	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
 )
 
 var (
@@ -78,6 +79,29 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+func TestKeepAlive(t *testing.T) {
+	m := mux.New(ping.Handle())
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(m),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var pings int
+	err := ping.KeepAlive(ctx, cs.Client, 50*time.Millisecond, func(rtt time.Duration) {
+		pings++
+		if pings == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("unexpected error: want=%v, got=%v", context.Canceled, err)
+	}
+	if pings != 2 {
+		t.Errorf("wrong number of pings: want=2, got=%d", pings)
+	}
+}
+
 func TestWrongIQType(t *testing.T) {
 	var b strings.Builder
 	e := xml.NewEncoder(&b)
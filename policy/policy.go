@@ -0,0 +1,253 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package policy implements a local policy layer for incoming stanzas.
+//
+// A Policy drops or bounces stanzas based on an ordered list of rules similar
+// to the privacy lists of XEP-0016: Privacy Lists, and on the rate at which
+// each sender is sending stanzas.
+// Unlike privacy lists or XEP-0191: Blocking Command the rules are enforced by
+// the client itself, which is useful for bots that are exposed to open
+// federation where the server may not enforce any policy at all.
+//
+// Policies are applied by wrapping the handler passed to Serve:
+//
+//	p := &policy.Policy{…}
+//	err := session.Serve(p.Handler(handler))
+//
+// Since Handler has the same signature as mux.Middleware it can also be
+// registered on a mux using mux.Use.
+package policy // import "mellium.im/xmpp/policy"
+
+import (
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Action is what happens to a stanza that matches a rule.
+type Action uint8
+
+// A list of actions.
+const (
+	// Allow passes the stanza on to the wrapped handler.
+	Allow Action = iota
+
+	// Drop silently discards the stanza.
+	// IQ requests that are dropped are still answered with an error by Serve,
+	// as required by RFC 6120.
+	Drop
+
+	// Bounce discards the stanza and replies with a service-unavailable error.
+	// Stanzas that are themselves errors or IQ results are never bounced, they
+	// are dropped instead to prevent loops.
+	Bounce
+)
+
+// Rule matches stanzas and decides what to do with them.
+// Empty fields match any stanza.
+type Rule struct {
+	// JID matches the sender using the same rules as privacy lists: a full JID
+	// only matches that resource, a bare JID matches any of its resources, a
+	// domain and resource matches that resource at any user of the domain, and
+	// a domain matches the domain itself and all of its users.
+	JID jid.JID
+
+	// Kinds limits the rule to stanzas with one of the given names: "message",
+	// "presence", or "iq".
+	Kinds []string
+
+	// Types limits the rule to stanzas with one of the given type attributes,
+	// for example "headline" or "subscribe".
+	// Stanzas without a type attribute match the empty string.
+	Types []string
+
+	Action Action
+}
+
+func (r Rule) matches(from jid.JID, kind, typ string) bool {
+	if !r.JID.Equal(jid.JID{}) && !matchJID(r.JID, from) {
+		return false
+	}
+	if len(r.Kinds) > 0 && !contains(r.Kinds, kind) {
+		return false
+	}
+	if len(r.Types) > 0 && !contains(r.Types, typ) {
+		return false
+	}
+	return true
+}
+
+func matchJID(rule, from jid.JID) bool {
+	switch {
+	case rule.Localpart() != "" && rule.Resourcepart() != "":
+		return rule.Equal(from)
+	case rule.Localpart() != "":
+		return rule.Equal(from.Bare())
+	case rule.Resourcepart() != "":
+		return rule.Domainpart() == from.Domainpart() && rule.Resourcepart() == from.Resourcepart()
+	}
+	return rule.Domainpart() == from.Domainpart()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is a set of rules that are applied to incoming stanzas.
+//
+// Stanzas without a from attribute come from the users own server and are
+// always allowed, as are elements that are not stanzas.
+// A Policy must not be copied after first use.
+type Policy struct {
+	// Rules are checked in order and the action of the first matching rule is
+	// taken.
+	Rules []Rule
+
+	// Default is the action taken if no rule matches.
+	Default Action
+
+	// Rate is the maximum number of stanzas per second that are accepted from
+	// any one sender (identified by its bare JID) after the initial Burst.
+	// Stanzas over the limit are treated using RateAction.
+	// If Rate is zero, senders are not limited.
+	Rate  float64
+	Burst int
+
+	// RateAction is the action taken for stanzas from senders that exceed the
+	// rate limit.
+	// If it is Allow, Drop is used instead.
+	RateAction Action
+
+	mu      sync.Mutex
+	senders map[string]*bucket
+}
+
+// maxSenders is the number of senders that are tracked for rate limiting
+// before senders that are no longer limited are forgotten.
+const maxSenders = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Check returns the action that the policy takes for a stanza with the given
+// start element.
+// If rate limiting is enabled calling Check counts as receiving the stanza.
+func (p *Policy) Check(start xml.StartElement) Action {
+	if !isStanza(start.Name) {
+		return Allow
+	}
+	_, f := attr.Get(start.Attr, "from")
+	if f == "" {
+		return Allow
+	}
+	from, err := jid.Parse(f)
+	if err != nil {
+		return Drop
+	}
+	_, typ := attr.Get(start.Attr, "type")
+
+	action := p.Default
+	for _, r := range p.Rules {
+		if r.matches(from, start.Name.Local, typ) {
+			action = r.Action
+			break
+		}
+	}
+	if action != Allow {
+		return action
+	}
+	if !p.allow(from.Bare().String()) {
+		return p.fallback(p.RateAction)
+	}
+	return Allow
+}
+
+func (p *Policy) fallback(a Action) Action {
+	if a == Allow {
+		return Drop
+	}
+	return a
+}
+
+// allow reports whether a stanza from the sender is within the rate limit.
+func (p *Policy) allow(sender string) bool {
+	if p.Rate <= 0 {
+		return true
+	}
+	burst := float64(p.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.senders == nil {
+		p.senders = make(map[string]*bucket)
+	}
+	if len(p.senders) >= maxSenders {
+		for k, b := range p.senders {
+			if b.tokens+now.Sub(b.last).Seconds()*p.Rate >= burst {
+				delete(p.senders, k)
+			}
+		}
+	}
+	b, ok := p.senders[sender]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		p.senders[sender] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * p.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Handler returns a handler that applies the policy to each element before
+// passing it to h.
+func (p *Policy) Handler(h xmpp.Handler) xmpp.Handler {
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		switch p.Check(*start) {
+		case Allow:
+			return h.HandleXMPP(t, start)
+		case Bounce:
+			_, typ := attr.Get(start.Attr, "type")
+			if typ == "error" || typ == "result" {
+				return nil
+			}
+			_, err := xmlstream.Copy(t, stanza.ErrorReply(*start, stanza.Error{
+				Type:      stanza.Cancel,
+				Condition: stanza.ServiceUnavailable,
+			}))
+			return err
+		}
+		return nil
+	})
+}
+
+func isStanza(name xml.Name) bool {
+	return (name.Local == "iq" || name.Local == "message" || name.Local == "presence") &&
+		(name.Space == "" || name.Space == ns.Client || name.Space == ns.Server)
+}
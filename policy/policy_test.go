@@ -0,0 +1,159 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package policy_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/policy"
+)
+
+var _ mux.Middleware = (&policy.Policy{}).Handler
+
+var checkTestCases = [...]struct {
+	p     *policy.Policy
+	start string
+	want  policy.Action
+}{
+	0: {
+		p:     &policy.Policy{},
+		start: `<message from="romeo@example.net/orchard" xmlns="jabber:client"/>`,
+		want:  policy.Allow,
+	},
+	1: {
+		p:     &policy.Policy{Rules: []policy.Rule{{JID: jid.MustParse("romeo@example.net"), Action: policy.Drop}}},
+		start: `<message from="romeo@example.net/orchard" xmlns="jabber:client"/>`,
+		want:  policy.Drop,
+	},
+	2: {
+		p:     &policy.Policy{Rules: []policy.Rule{{JID: jid.MustParse("romeo@example.net/balcony"), Action: policy.Drop}}},
+		start: `<message from="romeo@example.net/orchard" xmlns="jabber:client"/>`,
+		want:  policy.Allow,
+	},
+	3: {
+		p:     &policy.Policy{Rules: []policy.Rule{{JID: jid.MustParse("example.net"), Action: policy.Bounce}}},
+		start: `<message from="romeo@example.net/orchard" xmlns="jabber:client"/>`,
+		want:  policy.Bounce,
+	},
+	4: {
+		p: &policy.Policy{
+			Rules: []policy.Rule{
+				{JID: jid.MustParse("juliet@example.com"), Action: policy.Allow},
+			},
+			Default: policy.Drop,
+		},
+		start: `<message from="romeo@example.net/orchard" xmlns="jabber:client"/>`,
+		want:  policy.Drop,
+	},
+	5: {
+		p: &policy.Policy{
+			Rules: []policy.Rule{
+				{JID: jid.MustParse("juliet@example.com"), Action: policy.Allow},
+			},
+			Default: policy.Drop,
+		},
+		start: `<message from="juliet@example.com/balcony" xmlns="jabber:client"/>`,
+		want:  policy.Allow,
+	},
+	6: {
+		p:     &policy.Policy{Rules: []policy.Rule{{Kinds: []string{"presence"}, Types: []string{"subscribe"}, Action: policy.Drop}}},
+		start: `<presence from="romeo@example.net" type="subscribe" xmlns="jabber:client"/>`,
+		want:  policy.Drop,
+	},
+	7: {
+		p:     &policy.Policy{Rules: []policy.Rule{{Kinds: []string{"presence"}, Types: []string{"subscribe"}, Action: policy.Drop}}},
+		start: `<presence from="romeo@example.net/orchard" xmlns="jabber:client"/>`,
+		want:  policy.Allow,
+	},
+	8: {
+		p:     &policy.Policy{Default: policy.Drop},
+		start: `<iq type="set" id="123" xmlns="jabber:client"/>`,
+		want:  policy.Allow,
+	},
+	9: {
+		p:     &policy.Policy{Default: policy.Drop},
+		start: `<a xmlns="urn:xmpp:sm:3"/>`,
+		want:  policy.Allow,
+	},
+}
+
+func TestCheck(t *testing.T) {
+	for i, tc := range checkTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tc.start))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error decoding start element: %v", err)
+			}
+			if a := tc.p.Check(tok.(xml.StartElement)); a != tc.want {
+				t.Errorf("wrong action: want=%v, got=%v", tc.want, a)
+			}
+		})
+	}
+}
+
+func TestRate(t *testing.T) {
+	p := &policy.Policy{Rate: 0.001, Burst: 2}
+	from := func(addr string) xml.StartElement {
+		return xml.StartElement{
+			Name: xml.Name{Space: "jabber:client", Local: "message"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "from"}, Value: addr}},
+		}
+	}
+	for i, want := range []policy.Action{policy.Allow, policy.Allow, policy.Drop} {
+		if a := p.Check(from("romeo@example.net/" + strconv.Itoa(i))); a != want {
+			t.Errorf("wrong action for stanza %d: want=%v, got=%v", i, want, a)
+		}
+	}
+	if a := p.Check(from("juliet@example.com/balcony")); a != policy.Allow {
+		t.Errorf("expected other senders to be allowed, got=%v", a)
+	}
+}
+
+func TestBounce(t *testing.T) {
+	p := &policy.Policy{Default: policy.Bounce}
+	var called bool
+	h := p.Handler(xmpp.HandlerFunc(func(xmlstream.TokenReadEncoder, *xml.StartElement) error {
+		called = true
+		return nil
+	}))
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	d := xml.NewDecoder(strings.NewReader(`<message from="romeo@example.net/orchard" to="juliet@example.com" id="123" type="chat" xmlns="jabber:client"><body>Hi</body></message>`))
+	tok, _ := d.Token()
+	start := tok.(xml.StartElement)
+	err := h.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: d,
+		Encoder:     e,
+	}, &start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	if called {
+		t.Errorf("expected bounced stanza not to be handled")
+	}
+	out := buf.String()
+	for _, want := range []string{`type="error"`, `to="romeo@example.net/orchard"`, `id="123"`, `<service-unavailable`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected bounce to contain %s, got: %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package presence
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSInvisible is the namespace used by the invisible command.
+const NSInvisible = "urn:xmpp:invisible:0"
+
+// Invisible asks the server to stop broadcasting our presence while still
+// allowing us to send directed presence.
+// If probe is true the server will continue to send presence probes to our
+// contacts so that we still learn about their availability.
+func Invisible(ctx context.Context, s *xmpp.Session, probe bool) error {
+	start := xml.StartElement{Name: xml.Name{Space: NSInvisible, Local: "invisible"}}
+	if probe {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "probe"},
+			Value: "true",
+		})
+	}
+	return sendCommand(ctx, s, start)
+}
+
+// Visible asks the server to resume broadcasting our presence after a call to
+// Invisible.
+func Visible(ctx context.Context, s *xmpp.Session) error {
+	return sendCommand(ctx, s, xml.StartElement{Name: xml.Name{Space: NSInvisible, Local: "visible"}})
+}
+
+func sendCommand(ctx context.Context, s *xmpp.Session, start xml.StartElement) error {
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(nil, start), stanza.IQ{
+		Type: stanza.SetIQ,
+	}, nil)
+}
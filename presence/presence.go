@@ -0,0 +1,116 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package presence implements directed and broadcast presence and
+// XEP-0186: Invisible Command.
+package presence // import "mellium.im/xmpp/presence"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var errNoTo = errors.New("presence: directed presence requires a to address")
+
+// Broadcast sends an available presence with the provided priority to the
+// server to be broadcast to all of our contacts.
+// Payload is included in the presence after the priority element and may be
+// used to include a status message or show element.
+func Broadcast(ctx context.Context, s *xmpp.Session, priority int8, payload xml.TokenReader) error {
+	inner := []xml.TokenReader{xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(strconv.Itoa(int(priority)))),
+		xml.StartElement{Name: xml.Name{Local: "priority"}},
+	)}
+	if payload != nil {
+		inner = append(inner, payload)
+	}
+	return s.Send(ctx, stanza.Presence{}.Wrap(xmlstream.MultiReader(inner...)))
+}
+
+// Tracker sends directed presence and keeps track of the addresses that it has
+// been sent to so that they can be informed when we go offline.
+// Entities that only received directed presence from us (for example, a chat
+// room or a contact that has not approved a subscription) are not guaranteed
+// to be informed when we go invisible or the session ends unexpectedly, so
+// they should be sent unavailable presence explicitly.
+//
+// The zero value is ready to use.
+// A Tracker is safe for concurrent use by multiple goroutines.
+type Tracker struct {
+	mu   sync.Mutex
+	sent map[string]jid.JID
+}
+
+// Send sends a directed presence to p.To.
+// If the presence is available (it has no type) the address is remembered, if
+// it is unavailable the address is forgotten.
+func (t *Tracker) Send(ctx context.Context, s *xmpp.Session, p stanza.Presence, payload xml.TokenReader) error {
+	if p.To.Equal(jid.JID{}) {
+		return errNoTo
+	}
+	err := s.Send(ctx, p.Wrap(payload))
+	if err != nil {
+		return err
+	}
+
+	switch p.Type {
+	case "":
+		t.mu.Lock()
+		if t.sent == nil {
+			t.sent = make(map[string]jid.JID)
+		}
+		t.sent[p.To.String()] = p.To
+		t.mu.Unlock()
+	case stanza.UnavailablePresence:
+		t.Forget(p.To)
+	}
+	return nil
+}
+
+// Forget removes j from the list of addresses that we have sent directed
+// presence to without sending anything.
+// It may be used when the remote entity has gone offline itself.
+func (t *Tracker) Forget(j jid.JID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sent, j.String())
+}
+
+// Directed returns the addresses that we have sent directed presence to and
+// have not yet become unavailable to, sorted using jid.Sort.
+func (t *Tracker) Directed() []jid.JID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addrs := make([]jid.JID, 0, len(t.sent))
+	for _, j := range t.sent {
+		addrs = append(addrs, j)
+	}
+	jid.Sort(addrs)
+	return addrs
+}
+
+// Unavailable sends unavailable presence to every address that we have sent
+// directed presence to and forgets them.
+// If an error is encountered sending to any address it is returned and the
+// remaining addresses are not forgotten so that Unavailable can be retried.
+func (t *Tracker) Unavailable(ctx context.Context, s *xmpp.Session) error {
+	for _, j := range t.Directed() {
+		err := t.Send(ctx, s, stanza.Presence{
+			To:   j,
+			Type: stanza.UnavailablePresence,
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package presence_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/presence"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var invisibleTestCases = [...]struct {
+	f    func(context.Context, *xmpp.Session) error
+	name xml.Name
+	attr []xml.Attr
+}{
+	0: {
+		f: func(ctx context.Context, s *xmpp.Session) error {
+			return presence.Invisible(ctx, s, false)
+		},
+		name: xml.Name{Space: presence.NSInvisible, Local: "invisible"},
+	},
+	1: {
+		f: func(ctx context.Context, s *xmpp.Session) error {
+			return presence.Invisible(ctx, s, true)
+		},
+		name: xml.Name{Space: presence.NSInvisible, Local: "invisible"},
+		attr: []xml.Attr{{Name: xml.Name{Local: "probe"}, Value: "true"}},
+	},
+	2: {
+		f:    presence.Visible,
+		name: xml.Name{Space: presence.NSInvisible, Local: "visible"},
+	},
+}
+
+func TestInvisible(t *testing.T) {
+	for i, tc := range invisibleTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var payload xml.StartElement
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+					iq, err := stanza.NewIQ(*start)
+					if err != nil {
+						return err
+					}
+					if iq.Type != stanza.SetIQ {
+						t.Errorf("wrong IQ type: want=%v, got=%v", stanza.SetIQ, iq.Type)
+					}
+					tok, err := e.Token()
+					if err != nil {
+						return err
+					}
+					payload = tok.(xml.StartElement)
+					_, err = xmlstream.Copy(e, iq.Result(nil))
+					return err
+				}),
+			)
+			err := tc.f(context.Background(), cs.Client)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if payload.Name != tc.name {
+				t.Errorf("wrong payload: want=%v, got=%v", tc.name, payload.Name)
+			}
+			if len(payload.Attr) != len(tc.attr) || len(tc.attr) > 0 && !reflect.DeepEqual(payload.Attr, tc.attr) {
+				t.Errorf("wrong attributes: want=%v, got=%v", tc.attr, payload.Attr)
+			}
+		})
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	err := presence.Broadcast(context.Background(), s, -1, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "<priority>-1</priority>") {
+		t.Errorf("expected priority in output, got: %s", out)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	ctx := context.Background()
+	room := jid.MustParse("coven@chat.shakespeare.lit/thirdwitch")
+	friend := jid.MustParse("juliet@example.com/balcony")
+
+	tracker := &presence.Tracker{}
+	err := tracker.Send(ctx, s, stanza.Presence{}, nil)
+	if err == nil {
+		t.Errorf("expected error sending directed presence with no to address")
+	}
+	for _, j := range []jid.JID{room, friend} {
+		err = tracker.Send(ctx, s, stanza.Presence{To: j}, nil)
+		if err != nil {
+			t.Fatalf("error sending directed presence: %v", err)
+		}
+	}
+	if directed := tracker.Directed(); !reflect.DeepEqual(directed, []jid.JID{room, friend}) {
+		t.Errorf("wrong directed addresses: want=%v, got=%v", []jid.JID{room, friend}, directed)
+	}
+
+	err = tracker.Send(ctx, s, stanza.Presence{To: room, Type: stanza.UnavailablePresence}, nil)
+	if err != nil {
+		t.Fatalf("error sending unavailable presence: %v", err)
+	}
+	if directed := tracker.Directed(); !reflect.DeepEqual(directed, []jid.JID{friend}) {
+		t.Errorf("room was not forgotten after unavailable: got=%v", directed)
+	}
+
+	buf.Reset()
+	err = tracker.Unavailable(ctx, s)
+	if err != nil {
+		t.Fatalf("error sending unavailable presence: %v", err)
+	}
+	if directed := tracker.Directed(); len(directed) != 0 {
+		t.Errorf("expected no directed addresses after cleanup, got %v", directed)
+	}
+	out := buf.String()
+	if !strings.Contains(out, `to="`+friend.String()+`"`) || !strings.Contains(out, `type="unavailable"`) {
+		t.Errorf("expected unavailable presence to be sent to %v, got: %s", friend, out)
+	}
+}
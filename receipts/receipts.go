@@ -184,7 +184,7 @@ func (h *Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder
 				return nil
 			}
 
-			c <- struct{}{}
+			close(c)
 			return nil
 		case "request":
 			msg.From, msg.To = msg.To, msg.From
@@ -238,38 +238,94 @@ func (h *Handler) SendMessage(ctx context.Context, s *xmpp.Session, r xml.TokenR
 //
 // SendMessageElement is safe for concurrent use by multiple goroutines.
 func (h *Handler) SendMessageElement(ctx context.Context, s *xmpp.Session, payload xml.TokenReader, msg stanza.Message) error {
-	if h.sent == nil {
-		h.m.Lock()
-		h.sent = make(map[string]chan struct{})
-		h.m.Unlock()
+	t, err := h.SendMessageTracked(ctx, s, payload, msg)
+	if err != nil {
+		return err
+	}
+	err = t.Wait(ctx)
+	if err != nil {
+		t.Cancel()
 	}
+	return err
+}
 
+// SendMessageTracked is like SendMessageElement except that it returns as soon
+// as the message has been written to the session instead of blocking until the
+// receipt is received.
+// The returned Tracked can be used to wait for the receipt.
+//
+// Only XEP-0184 receipts are tracked, an acknowledgement from the server that
+// it received the message is not a delivery receipt.
+//
+// SendMessageTracked is safe for concurrent use by multiple goroutines.
+func (h *Handler) SendMessageTracked(ctx context.Context, s *xmpp.Session, payload xml.TokenReader, msg stanza.Message) (*Tracked, error) {
 	if msg.ID == "" {
 		msg.ID = attr.RandomID()
 	}
 
 	c := make(chan struct{})
 	h.m.Lock()
+	if h.sent == nil {
+		h.sent = make(map[string]chan struct{})
+	}
 	h.sent[msg.ID] = c
 	h.m.Unlock()
 
+	t := &Tracked{
+		ID:   msg.ID,
+		done: c,
+		h:    h,
+	}
+
 	r := Requested{Value: true}.TokenReader()
 	if payload != nil {
 		r = xmlstream.MultiReader(payload, r)
 	}
 	err := s.SendElement(ctx, r, msg.StartElement())
 	if err != nil {
-		return err
+		t.Cancel()
+		return nil, err
 	}
+	return t, nil
+}
+
+// Tracked is a message that was sent with a request for a delivery receipt.
+type Tracked struct {
+	// ID is the ID of the message that was sent.
+	ID string
+
+	done chan struct{}
+	h    *Handler
+}
+
+// Done returns a channel that is closed when the delivery receipt for the
+// message is received.
+// If tracking is canceled before the receipt is received the channel is never
+// closed.
+func (t *Tracked) Done() <-chan struct{} {
+	return t.done
+}
 
+// Wait blocks until the delivery receipt is received or the context is
+// canceled.
+// If the context is canceled first, Wait returns the context error but the
+// message is still tracked and Wait may be called again.
+func (t *Tracked) Wait(ctx context.Context) error {
 	select {
-	case <-c:
+	case <-t.done:
 		return nil
 	case <-ctx.Done():
-		h.m.Lock()
-		delete(h.sent, msg.ID)
-		h.m.Unlock()
-		close(c)
 		return ctx.Err()
 	}
 }
+
+// Cancel stops tracking the message.
+// Any receipt received at a later time will not be associated with the
+// message, but can still be handled by the Handler.
+func (t *Tracked) Cancel() {
+	t.h.m.Lock()
+	defer t.h.m.Unlock()
+	if c, ok := t.h.sent[t.ID]; ok && c == t.done {
+		delete(t.h.sent, t.ID)
+	}
+}
@@ -11,13 +11,14 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/ns"
-	"mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/receipts"
 	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
 )
 
 var (
@@ -182,16 +183,27 @@ func TestClosedDoesNotPanic(t *testing.T) {
 	}
 }
 
+type cancelAfterWrite struct {
+	*bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func (w cancelAfterWrite) Write(p []byte) (int, error) {
+	defer w.cancel()
+	return w.Buffer.Write(p)
+}
+
 // TODO: find a way to test that SendMessageElement actually matches up the
 // response correctly (ie. don't timeout, use the test server).
 func TestRoundTrip(t *testing.T) {
 	h := &receipts.Handler{}
 
+	// Cancel the context as soon as the request has been written so that we
+	// don't wait for a response that will never come.
+	ctx, cancel := context.WithCancel(context.Background())
 	var req bytes.Buffer
-	s := xmpptest.NewSession(0, &req)
+	s := xmpptest.NewSession(0, cancelAfterWrite{Buffer: &req, cancel: cancel})
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
 	err := h.SendMessageElement(ctx, s, nil, stanza.Message{
 		ID:   "123",
 		Type: stanza.NormalMessage,
@@ -228,3 +240,53 @@ func TestRoundTrip(t *testing.T) {
 		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, out)
 	}
 }
+
+func TestTracked(t *testing.T) {
+	h := &receipts.Handler{}
+
+	var req bytes.Buffer
+	s := xmpptest.NewSession(0, &req)
+
+	tracked, err := h.SendMessageTracked(context.Background(), s, nil, stanza.Message{
+		Type: stanza.ChatMessage,
+	})
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	if tracked.ID == "" {
+		t.Fatalf("expected an ID to be generated")
+	}
+	if !strings.Contains(req.String(), `id="`+tracked.ID+`"`) {
+		t.Errorf("message with ID %q not found in output: %s", tracked.ID, req.String())
+	}
+	select {
+	case <-tracked.Done():
+		t.Fatalf("done before the receipt was received")
+	default:
+	}
+
+	msg := stanza.Message{
+		XMLName: xml.Name{Space: ns.Client, Local: "message"},
+		Type:    stanza.ChatMessage,
+	}
+	r := msg.Wrap(xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "received", Space: receipts.NS},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: tracked.ID}},
+	}))
+	err = h.HandleMessage(msg, struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: r,
+		Encoder:     xml.NewEncoder(&bytes.Buffer{}),
+	})
+	if err != nil {
+		t.Fatalf("error handling response: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = tracked.Wait(ctx); err != nil {
+		t.Errorf("unexpected error waiting for receipt: %v", err)
+	}
+}
@@ -55,9 +55,9 @@ func (i *Iter) Next() bool {
 		return false
 	}
 	start, r := i.iter.Current()
-	d := xml.NewTokenDecoder(r)
+	d := xml.NewTokenDecoder(xmlstream.Wrap(xmlstream.Inner(r), *start))
 	item := Item{}
-	i.err = d.DecodeElement(&item, start)
+	i.err = d.Decode(&item)
 	if i.err != nil {
 		return false
 	}
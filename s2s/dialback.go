@@ -0,0 +1,276 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package s2s
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stream"
+)
+
+// Namespaces used by server dialback, provided as a convenience.
+const (
+	// NSDialback is the namespace used for dialback elements.
+	NSDialback = "jabber:server:dialback"
+
+	// NSDialbackFeature is the namespace used for advertising dialback support.
+	NSDialbackFeature = "urn:xmpp:features:dialback"
+)
+
+// Errors returned by dialback negotiation.
+var (
+	ErrDialbackInvalid = errors.New("s2s: dialback key was not valid")
+)
+
+// DialbackKey generates a dialback key using the method recommended by
+// XEP-0185: Dialback Key Generation and Validation.
+// The receiving and originating addresses are the domains of the receiving and
+// originating servers and id is the stream ID assigned by the receiving server.
+func DialbackKey(secret []byte, receiving, originating jid.JID, id string) string {
+	secretHash := sha256.Sum256(secret)
+	h := hmac.New(sha256.New, []byte(hex.EncodeToString(secretHash[:])))
+	// hash.Write never returns an error per the documentation.
+	/* #nosec */
+	_, _ = h.Write([]byte(receiving.Domainpart() + " " + originating.Domainpart() + " " + id))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyFunc is a function that is used by the receiving server to check a
+// dialback key with the authoritative server for the originating domain,
+// normally by dialing back to the originating domain and sending a db:verify
+// element.
+// The receiving and originating addresses are the domains of the receiving and
+// originating servers and id is the stream ID that was assigned by the
+// receiving server.
+type VerifyFunc func(ctx context.Context, receiving, originating jid.JID, id, key string) (bool, error)
+
+// Dialback returns a stream feature for authenticating server-to-server
+// connections using XEP-0220: Server Dialback.
+//
+// When initiating a connection, the feature generates a key using secret and
+// DialbackKey and sends it to the receiving server.
+// When receiving a connection, verify is called to check the key sent by the
+// originating server.
+// If the key is not valid, ErrDialbackInvalid is returned.
+// If the key is for a domain other than the session's local address a
+// host-unknown stream error is returned, and once the key has been verified
+// the session's remote address is set to the originating domain.
+func Dialback(secret []byte, verify VerifyFunc) xmpp.StreamFeature {
+	return xmpp.StreamFeature{
+		Name:       xml.Name{Space: NSDialbackFeature, Local: "dialback"},
+		Prohibited: xmpp.Authn,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
+			if err := e.EncodeToken(start); err != nil {
+				return false, err
+			}
+			errStart := xml.StartElement{Name: xml.Name{Local: "errors"}}
+			if err := e.EncodeToken(errStart); err != nil {
+				return false, err
+			}
+			if err := e.EncodeToken(errStart.End()); err != nil {
+				return false, err
+			}
+			return false, e.EncodeToken(start.End())
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			parsed := struct {
+				XMLName xml.Name `xml:"urn:xmpp:features:dialback dialback"`
+			}{}
+			return false, nil, d.DecodeElement(&parsed, start)
+		},
+		Negotiate: func(ctx context.Context, session *xmpp.Session, data interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+			if (session.State() & xmpp.Received) == xmpp.Received {
+				return dialbackReceive(ctx, session, verify)
+			}
+			return dialbackInitiate(ctx, session, secret)
+		},
+	}
+}
+
+type dialbackResult struct {
+	XMLName xml.Name `xml:"jabber:server:dialback result"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+	Key     string   `xml:",chardata"`
+}
+
+func (r dialbackResult) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Space: NSDialback, Local: "result"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "from"}, Value: r.From},
+			{Name: xml.Name{Local: "to"}, Value: r.To},
+		},
+	}
+	if r.Type != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: r.Type})
+	}
+	var inner xml.TokenReader
+	if r.Key != "" {
+		inner = xmlstream.Token(xml.CharData(r.Key))
+	}
+	return xmlstream.Wrap(inner, start)
+}
+
+func dialbackInitiate(ctx context.Context, session *xmpp.Session, secret []byte) (xmpp.SessionState, io.ReadWriter, error) {
+	local := session.LocalAddr().Domain()
+	remote := session.RemoteAddr().Domain()
+	result := dialbackResult{
+		From: local.String(),
+		To:   remote.String(),
+		Key:  DialbackKey(secret, remote, local, session.InSID()),
+	}
+
+	w := session.TokenWriter()
+	defer w.Close()
+	_, err := xmlstream.Copy(w, result.TokenReader())
+	if err != nil {
+		return 0, nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	r := session.TokenReader()
+	defer r.Close()
+	d := xml.NewTokenDecoder(r)
+	tok, err := d.Token()
+	if err != nil {
+		return 0, nil, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Space != NSDialback || start.Name.Local != "result" {
+		return 0, nil, stream.UnsupportedStanzaType
+	}
+	resp := dialbackResult{}
+	err = d.DecodeElement(&resp, &start)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.Type != "valid" {
+		return 0, nil, ErrDialbackInvalid
+	}
+	return xmpp.Authn, nil, nil
+}
+
+func dialbackReceive(ctx context.Context, session *xmpp.Session, verify VerifyFunc) (xmpp.SessionState, io.ReadWriter, error) {
+	r := session.TokenReader()
+	defer r.Close()
+	d := xml.NewTokenDecoder(r)
+	tok, err := d.Token()
+	if err != nil {
+		return 0, nil, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Space != NSDialback || start.Name.Local != "result" {
+		return 0, nil, stream.UnsupportedStanzaType
+	}
+	req := dialbackResult{}
+	err = d.DecodeElement(&req, &start)
+	if err != nil {
+		return 0, nil, err
+	}
+	originating, err := jid.Parse(req.From)
+	if err != nil {
+		return 0, nil, stream.ImproperAddressing
+	}
+	receiving, err := jid.Parse(req.To)
+	if err != nil {
+		return 0, nil, stream.ImproperAddressing
+	}
+	if !receiving.Equal(session.LocalAddr().Domain()) {
+		return 0, nil, stream.HostUnknown
+	}
+
+	valid := false
+	if verify != nil {
+		valid, err = verify(ctx, receiving, originating, session.OutSID(), req.Key)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	resp := dialbackResult{
+		From: req.To,
+		To:   req.From,
+		Type: "invalid",
+	}
+	if valid {
+		resp.Type = "valid"
+	}
+	w := session.TokenWriter()
+	defer w.Close()
+	_, err = xmlstream.Copy(w, resp.TokenReader())
+	if err != nil {
+		return 0, nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return 0, nil, err
+	}
+	if !valid {
+		return 0, nil, ErrDialbackInvalid
+	}
+	session.SetRemoteAddr(originating.Domain())
+	return xmpp.Authn, nil, nil
+}
+
+// VerifyHandler returns a handler that responds to db:verify requests sent by
+// receiving servers when acting as the authoritative server for an originating
+// domain.
+// Keys are checked against keys generated with DialbackKey and secret.
+// It should be registered on a multiplexer for the verify element in the
+// NSDialback namespace.
+func VerifyHandler(secret []byte) xmpp.Handler {
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		req := struct {
+			XMLName xml.Name `xml:"jabber:server:dialback verify"`
+			From    string   `xml:"from,attr"`
+			To      string   `xml:"to,attr"`
+			ID      string   `xml:"id,attr"`
+			Key     string   `xml:",chardata"`
+		}{}
+		err := xml.NewTokenDecoder(xmlstream.Wrap(xmlstream.Inner(t), *start)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		originating, err := jid.Parse(req.From)
+		if err != nil {
+			return stream.ImproperAddressing
+		}
+		receiving, err := jid.Parse(req.To)
+		if err != nil {
+			return stream.ImproperAddressing
+		}
+
+		typ := "invalid"
+		expected := DialbackKey(secret, receiving, originating, req.ID)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(req.Key)) == 1 {
+			typ = "valid"
+		}
+		_, err = xmlstream.Copy(t, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: NSDialback, Local: "verify"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "from"}, Value: req.To},
+				{Name: xml.Name{Local: "to"}, Value: req.From},
+				{Name: xml.Name{Local: "id"}, Value: req.ID},
+				{Name: xml.Name{Local: "type"}, Value: typ},
+			},
+		}))
+		return err
+	})
+}
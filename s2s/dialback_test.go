@@ -0,0 +1,109 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package s2s_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/s2s"
+	"mellium.im/xmpp/stream"
+)
+
+var (
+	dialbackSecret = []byte("secret")
+	dialbackDomain = jid.MustParse("example.net")
+	// The test sessions are assigned the stream ID "123" by the remote end.
+	dialbackKey = s2s.DialbackKey(dialbackSecret, dialbackDomain, dialbackDomain, "123")
+)
+
+func verifyKey(ctx context.Context, receiving, originating jid.JID, id, key string) (bool, error) {
+	return key == "good", nil
+}
+
+var dialbackTestCases = [...]xmpptest.FeatureTestCase{
+	0: {
+		Feature:    s2s.Dialback(dialbackSecret, nil),
+		In:         `<result xmlns="jabber:server:dialback" from="example.net" to="example.net" type="valid"/>`,
+		Out:        `<result xmlns="jabber:server:dialback" from="example.net" to="example.net">` + dialbackKey + `</result>`,
+		FinalState: xmpp.Authn,
+	},
+	1: {
+		Feature: s2s.Dialback(dialbackSecret, nil),
+		In:      `<result xmlns="jabber:server:dialback" from="example.net" to="example.net" type="invalid"/>`,
+		Out:     `<result xmlns="jabber:server:dialback" from="example.net" to="example.net">` + dialbackKey + `</result>`,
+		Err:     s2s.ErrDialbackInvalid,
+	},
+	2: {
+		State:      xmpp.Received,
+		Feature:    s2s.Dialback(dialbackSecret, verifyKey),
+		In:         `<result xmlns="jabber:server:dialback" from="a.example" to="example.net">good</result>`,
+		Out:        `<result xmlns="jabber:server:dialback" from="example.net" to="a.example" type="valid"></result>`,
+		FinalState: xmpp.Authn,
+	},
+	3: {
+		State:   xmpp.Received,
+		Feature: s2s.Dialback(dialbackSecret, verifyKey),
+		In:      `<result xmlns="jabber:server:dialback" from="a.example" to="example.net">bad</result>`,
+		Out:     `<result xmlns="jabber:server:dialback" from="example.net" to="a.example" type="invalid"></result>`,
+		Err:     s2s.ErrDialbackInvalid,
+	},
+	4: {
+		State:   xmpp.Received,
+		Feature: s2s.Dialback(dialbackSecret, verifyKey),
+		In:      `<result xmlns="jabber:server:dialback" from="a.example" to="b.example">good</result>`,
+		Err:     stream.HostUnknown,
+	},
+}
+
+func TestDialback(t *testing.T) {
+	xmpptest.RunFeatureTests(t, dialbackTestCases[:])
+}
+
+func TestVerifyHandler(t *testing.T) {
+	key := s2s.DialbackKey(dialbackSecret, jid.MustParse("b.example"), jid.MustParse("a.example"), "1234")
+	for _, tc := range []struct {
+		key string
+		typ string
+	}{
+		{key: key, typ: "valid"},
+		{key: "bad", typ: "invalid"},
+	} {
+		t.Run(tc.typ, func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(`<verify xmlns="jabber:server:dialback" from="a.example" to="b.example" id="1234">` + tc.key + `</verify>`))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error popping start token: %v", err)
+			}
+			start := tok.(xml.StartElement)
+
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			err = s2s.VerifyHandler(dialbackSecret).HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+				Encoder:     e,
+			}, &start)
+			if err != nil {
+				t.Fatalf("error handling verify: %v", err)
+			}
+			if err = e.Flush(); err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			expected := `<verify xmlns="jabber:server:dialback" from="b.example" to="a.example" id="1234" type="` + tc.typ + `"></verify>`
+			if out := buf.String(); out != expected {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, out)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/ns"
 	"mellium.im/xmpp/internal/saslerr"
+	"mellium.im/xmpp/jid"
 )
 
 var (
@@ -56,24 +57,13 @@ func newSASL(identity, password string, permissions func(*sasl.Negotiator) bool,
 			if err != nil {
 				return true, err
 			}
-
-			startMechanism := xml.StartElement{Name: xml.Name{Space: "", Local: "mechanism"}}
+			names := make([]string, 0, len(mechanisms))
 			for _, m := range mechanisms {
-				select {
-				case <-ctx.Done():
-					return true, ctx.Err()
-				default:
-				}
-
-				if err = e.EncodeToken(startMechanism); err != nil {
-					return true, err
-				}
-				if err = e.EncodeToken(xml.CharData(m.Name)); err != nil {
-					return true, err
-				}
-				if err = e.EncodeToken(startMechanism.End()); err != nil {
-					return true, err
-				}
+				names = append(names, m.Name)
+			}
+			err = listMechanisms(ctx, e, names)
+			if err != nil {
+				return true, err
 			}
 			return true, e.EncodeToken(start.End())
 		},
@@ -87,7 +77,23 @@ func newSASL(identity, password string, permissions func(*sasl.Negotiator) bool,
 		},
 		Negotiate: func(ctx context.Context, session *Session, data interface{}) (SessionState, io.ReadWriter, error) {
 			if (session.State() & Received) == Received {
-				return negotiateServer(ctx, identity, password, permissions, session, data, mechanisms...)
+				return negotiateServer(ctx, session, func(name string) saslServer {
+					for _, m := range mechanisms {
+						if m.Name != name {
+							continue
+						}
+						opts := []sasl.Option{
+							sasl.Credentials(func() ([]byte, []byte, []byte) {
+								return []byte(session.LocalAddr().Localpart()), []byte(password), []byte(identity)
+							}),
+						}
+						if connState := session.ConnectionState(); connState.Version != 0 {
+							opts = append(opts, sasl.TLSState(connState))
+						}
+						return sasl.NewServer(m, permissions, opts...)
+					}
+					return nil
+				})
 			}
 
 			return negotiateClient(ctx, identity, password, session, data, mechanisms...)
@@ -95,7 +101,40 @@ func newSASL(identity, password string, permissions func(*sasl.Negotiator) bool,
 	}
 }
 
-func negotiateServer(ctx context.Context, identity, password string, permissions func(*sasl.Negotiator) bool, session *Session, data interface{}, mechanisms ...sasl.Mechanism) (SessionState, io.ReadWriter, error) {
+// listMechanisms writes a mechanism element for each of names.
+func listMechanisms(ctx context.Context, e xmlstream.TokenWriter, names []string) error {
+	startMechanism := xml.StartElement{Name: xml.Name{Space: "", Local: "mechanism"}}
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if err := e.EncodeToken(startMechanism); err != nil {
+			return err
+		}
+		if err := e.EncodeToken(xml.CharData(name)); err != nil {
+			return err
+		}
+		if err := e.EncodeToken(startMechanism.End()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saslServer is the server side of a SASL exchange.
+// It is satisfied by *sasl.Negotiator.
+type saslServer interface {
+	Step(challenge []byte) (more bool, resp []byte, err error)
+}
+
+// negotiateServer performs the server side of SASL authentication.
+// The newServer function is called to begin the exchange with the mechanism
+// selected by the client and should return nil if the mechanism is not
+// supported.
+func negotiateServer(ctx context.Context, session *Session, newServer func(name string) saslServer) (SessionState, io.ReadWriter, error) {
 	w := session.TokenWriter()
 	/* #nosec */
 	defer w.Close()
@@ -105,9 +144,8 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 	d := xml.NewTokenDecoder(r)
 
 	var (
-		selected sasl.Mechanism
-		server   *sasl.Negotiator
-		resp     []byte
+		server saslServer
+		resp   []byte
 	)
 	for more := true; more; {
 		tok, err := d.Token()
@@ -137,16 +175,10 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 
 		switch selection.XMLName {
 		case xml.Name{Space: ns.SASL, Local: "auth"}:
-			selected = sasl.Mechanism{}
-			for _, m := range mechanisms {
-				if selection.Name == m.Name {
-					selected = m
-					break
-				}
-			}
+			server = newServer(selection.Name)
 
 			// No matching mechanism found…
-			if selected.Name == "" {
+			if server == nil {
 				err = sendSASLError(w, saslerr.Failure{
 					Condition: saslerr.InvalidMechanism,
 				})
@@ -155,18 +187,6 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 				}
 				return 0, nil, errNoMechanisms
 			}
-
-			opts := []sasl.Option{
-				sasl.Credentials(func() ([]byte, []byte, []byte) {
-					return []byte(session.LocalAddr().Localpart()), []byte(password), []byte(identity)
-				}),
-			}
-
-			if connState := session.ConnectionState(); connState.Version != 0 {
-				opts = append(opts, sasl.TLSState(connState))
-			}
-
-			server = sasl.NewServer(selected, permissions, opts...)
 		case xml.Name{Space: ns.SASL, Local: "abort"}:
 			err = sendSASLError(w, saslerr.Failure{
 				Condition: saslerr.Aborted,
@@ -178,7 +198,7 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 		case xml.Name{Space: ns.SASL, Local: "response"}:
 			// We never got the initial <auth/> payload and selected a mechanism. This
 			// would be bad, so error out.
-			if server == nil || selected.Name == "" {
+			if server == nil {
 				err = sendSASLError(w, saslerr.Failure{
 					Condition: saslerr.MalformedRequest,
 				})
@@ -203,10 +223,11 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 		var decodedData []byte
 		if l > 1 {
 			decodedData = make([]byte, l)
-			_, err = base64.StdEncoding.Decode(decodedData, selection.Payload)
+			n, err := base64.StdEncoding.Decode(decodedData, selection.Payload)
 			if err != nil {
 				return 0, nil, err
 			}
+			decodedData = decodedData[:n]
 		}
 		more, resp, err = server.Step(decodedData)
 		switch err {
@@ -254,6 +275,9 @@ func negotiateServer(ctx context.Context, identity, password string, permissions
 	}
 
 	// If there is no more, but there was no error, auth was successful!
+	if a, ok := server.(interface{ authenticated() jid.JID }); ok {
+		session.setRemoteAddr(a.authenticated())
+	}
 	var encodedResp []byte
 	if len(resp) >= 0 {
 		encodedResp = make([]byte, base64.StdEncoding.EncodedLen(len(resp)))
@@ -0,0 +1,349 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	/* #nosec */
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"mellium.im/sasl"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/jid"
+)
+
+// defaultSCRAMIterations is the iteration count used when SCRAM credentials
+// are derived from a plaintext password.
+const defaultSCRAMIterations = 4096
+
+// SCRAMCredentials are the values that a server stores to verify SCRAM
+// authentication without knowing the user's password.
+type SCRAMCredentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewSCRAMCredentials derives the stored credentials for a password.
+// The hash must be either sha1.New or sha256.New, matching the mechanism that
+// the credentials will be used with.
+func NewSCRAMCredentials(h func() hash.Hash, password string, salt []byte, iterations int) SCRAMCredentials {
+	salted := hi(h, []byte(password), salt, iterations)
+	clientKey := hmacSum(h, salted, []byte("Client Key"))
+	storedKey := h()
+	/* #nosec */
+	storedKey.Write(clientKey)
+	return SCRAMCredentials{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey.Sum(nil),
+		ServerKey:  hmacSum(h, salted, []byte("Server Key")),
+	}
+}
+
+// SASLAuth is used by SASLAuthServer to look up and verify the credentials of
+// users.
+// Mechanisms are only offered if the callbacks they require are set.
+// Any error returned from a callback causes authentication to fail.
+type SASLAuth struct {
+	// Password returns the plaintext password of a user.
+	// If it is set PLAIN is offered, and SCRAM is offered using credentials
+	// derived from the password if the SCRAM callback is not set.
+	Password func(username string) (string, error)
+
+	// SCRAM returns the stored credentials of a user for the named hash, either
+	// "SHA-1" or "SHA-256".
+	// If it is set SCRAM-SHA-256, SCRAM-SHA-1, and PLAIN are offered.
+	SCRAM func(username, hash string) (SCRAMCredentials, error)
+
+	// External returns the username authenticated by the client certificates
+	// presented during the TLS handshake and the authorization identity
+	// requested by the client (which may be empty).
+	// If it is set EXTERNAL is offered.
+	External func(state tls.ConnectionState, authzid string) (username string, err error)
+}
+
+func (a SASLAuth) mechanisms() []string {
+	var names []string
+	if a.SCRAM != nil || a.Password != nil {
+		names = append(names, "SCRAM-SHA-256", "SCRAM-SHA-1", "PLAIN")
+	}
+	if a.External != nil {
+		names = append(names, "EXTERNAL")
+	}
+	return names
+}
+
+// SASLAuthServer returns a stream feature for authenticating clients on
+// received sessions using SASL.
+// Unlike SASLServer, credentials are verified using the callbacks in auth so
+// the mechanisms do not need to be implemented by the caller.
+// Once authentication succeeds the session's RemoteAddr is the bare JID of the
+// authenticated user.
+// It panics if none of the callbacks in auth are set.
+func SASLAuthServer(auth SASLAuth) StreamFeature {
+	names := auth.mechanisms()
+	if len(names) == 0 {
+		panic("xmpp: must set at least one SASL credential callback")
+	}
+	return StreamFeature{
+		Name:       xml.Name{Space: ns.SASL, Local: "mechanisms"},
+		Necessary:  Secure,
+		Prohibited: Authn,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
+			err := e.EncodeToken(start)
+			if err != nil {
+				return true, err
+			}
+			err = listMechanisms(ctx, e, names)
+			if err != nil {
+				return true, err
+			}
+			return true, e.EncodeToken(start.End())
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			return true, nil, d.Skip()
+		},
+		Negotiate: func(ctx context.Context, session *Session, data interface{}) (SessionState, io.ReadWriter, error) {
+			if (session.State() & Received) != Received {
+				return 0, nil, errNoMechanisms
+			}
+			return negotiateServer(ctx, session, func(name string) saslServer {
+				for _, n := range names {
+					if n != name {
+						continue
+					}
+					return &authServer{
+						auth:      auth,
+						mechanism: name,
+						domain:    session.LocalAddr().Domainpart(),
+						tlsState:  session.ConnectionState(),
+					}
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// authServer implements the server side of the mechanisms offered by
+// SASLAuthServer.
+type authServer struct {
+	auth      SASLAuth
+	mechanism string
+	domain    string
+	tlsState  tls.ConnectionState
+	user      jid.JID
+
+	// SCRAM state.
+	step        int
+	creds       SCRAMCredentials
+	h           func() hash.Hash
+	gs2Header   string
+	nonce       string
+	clientFirst string
+	serverFirst string
+}
+
+func (a *authServer) authenticated() jid.JID {
+	return a.user
+}
+
+func (a *authServer) Step(challenge []byte) (bool, []byte, error) {
+	switch a.mechanism {
+	case "PLAIN":
+		return false, nil, a.plain(challenge)
+	case "EXTERNAL":
+		return false, nil, a.external(challenge)
+	case "SCRAM-SHA-1":
+		return a.scram(sha1.New, "SHA-1", challenge)
+	case "SCRAM-SHA-256":
+		return a.scram(sha256.New, "SHA-256", challenge)
+	}
+	return false, nil, sasl.ErrAuthn
+}
+
+// setUser records the authenticated user if the authorization identity is
+// empty or matches them.
+func (a *authServer) setUser(username, authzid string) error {
+	user, err := jid.New(username, a.domain, "")
+	if err != nil {
+		return sasl.ErrAuthn
+	}
+	if authzid != "" && authzid != username && authzid != user.String() {
+		return sasl.ErrAuthn
+	}
+	a.user = user
+	return nil
+}
+
+func (a *authServer) plain(challenge []byte) error {
+	parts := bytes.Split(challenge, []byte{0})
+	if len(parts) != 3 {
+		return sasl.ErrAuthn
+	}
+	authzid, username, password := string(parts[0]), string(parts[1]), parts[2]
+
+	var ok bool
+	switch {
+	case a.auth.Password != nil:
+		stored, err := a.auth.Password(username)
+		if err != nil {
+			return sasl.ErrAuthn
+		}
+		ok = subtle.ConstantTimeCompare([]byte(stored), password) == 1
+	default:
+		creds, err := a.auth.SCRAM(username, "SHA-256")
+		if err != nil {
+			return sasl.ErrAuthn
+		}
+		derived := NewSCRAMCredentials(sha256.New, string(password), creds.Salt, creds.Iterations)
+		ok = hmac.Equal(derived.StoredKey, creds.StoredKey)
+	}
+	if !ok {
+		return sasl.ErrAuthn
+	}
+	return a.setUser(username, authzid)
+}
+
+func (a *authServer) external(challenge []byte) error {
+	if len(a.tlsState.PeerCertificates) == 0 {
+		return sasl.ErrAuthn
+	}
+	username, err := a.auth.External(a.tlsState, string(challenge))
+	if err != nil {
+		return sasl.ErrAuthn
+	}
+	return a.setUser(username, string(challenge))
+}
+
+func (a *authServer) scram(h func() hash.Hash, hashName string, challenge []byte) (bool, []byte, error) {
+	a.step++
+	switch a.step {
+	case 1:
+		// client-first-message: gs2-header client-first-message-bare
+		msg := string(challenge)
+		parts := strings.SplitN(msg, ",", 3)
+		if len(parts) != 3 || (parts[0] != "n" && parts[0] != "y") {
+			// Channel binding ("p=") is not supported.
+			return false, nil, sasl.ErrAuthn
+		}
+		authzid := strings.TrimPrefix(parts[1], "a=")
+		a.gs2Header = parts[0] + "," + parts[1] + ","
+		a.clientFirst = parts[2]
+		attrs := scramAttrs(a.clientFirst)
+		username := strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attrs["n"])
+		clientNonce := attrs["r"]
+		if username == "" || clientNonce == "" {
+			return false, nil, sasl.ErrAuthn
+		}
+
+		var err error
+		switch {
+		case a.auth.SCRAM != nil:
+			a.creds, err = a.auth.SCRAM(username, hashName)
+		default:
+			var password string
+			password, err = a.auth.Password(username)
+			if err == nil {
+				salt := make([]byte, 16)
+				_, err = rand.Read(salt)
+				a.creds = NewSCRAMCredentials(h, password, salt, defaultSCRAMIterations)
+			}
+		}
+		if err != nil {
+			return false, nil, sasl.ErrAuthn
+		}
+		if err = a.setUser(username, authzid); err != nil {
+			return false, nil, err
+		}
+		a.h = h
+		a.nonce = clientNonce + attr.RandomID()
+		a.serverFirst = "r=" + a.nonce +
+			",s=" + base64.StdEncoding.EncodeToString(a.creds.Salt) +
+			",i=" + strconv.Itoa(a.creds.Iterations)
+		return true, []byte(a.serverFirst), nil
+	case 2:
+		// client-final-message: channel-binding,nonce,proof
+		msg := string(challenge)
+		idx := strings.LastIndex(msg, ",p=")
+		if idx == -1 {
+			return false, nil, sasl.ErrAuthn
+		}
+		withoutProof := msg[:idx]
+		attrs := scramAttrs(msg)
+		if attrs["c"] != base64.StdEncoding.EncodeToString([]byte(a.gs2Header)) || attrs["r"] != a.nonce {
+			return false, nil, sasl.ErrAuthn
+		}
+		proof, err := base64.StdEncoding.DecodeString(attrs["p"])
+		if err != nil || len(proof) != len(a.creds.StoredKey) {
+			return false, nil, sasl.ErrAuthn
+		}
+
+		authMessage := []byte(a.clientFirst + "," + a.serverFirst + "," + withoutProof)
+		clientSignature := hmacSum(a.h, a.creds.StoredKey, authMessage)
+		clientKey := make([]byte, len(proof))
+		for i := range proof {
+			clientKey[i] = proof[i] ^ clientSignature[i]
+		}
+		storedKey := a.h()
+		/* #nosec */
+		storedKey.Write(clientKey)
+		if !hmac.Equal(storedKey.Sum(nil), a.creds.StoredKey) {
+			a.user = jid.JID{}
+			return false, nil, sasl.ErrAuthn
+		}
+		serverSignature := hmacSum(a.h, a.creds.ServerKey, authMessage)
+		return false, []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+	}
+	return false, nil, sasl.ErrAuthn
+}
+
+// scramAttrs parses a comma separated list of SCRAM attributes.
+func scramAttrs(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(msg, ",") {
+		if len(field) < 2 || field[1] != '=' {
+			continue
+		}
+		attrs[field[:1]] = field[2:]
+	}
+	return attrs
+}
+
+func hmacSum(h func() hash.Hash, key, msg []byte) []byte {
+	mac := hmac.New(h, key)
+	/* #nosec */
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// hi is the PBKDF2 based Hi function from RFC 5802.
+func hi(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	u := hmacSum(h, password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	out := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSum(h, password, u)
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
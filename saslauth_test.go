@@ -0,0 +1,129 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+)
+
+var errUnknownUser = errors.New("unknown user")
+
+func passwordAuth(username string) (string, error) {
+	if username != "juliet" {
+		return "", errUnknownUser
+	}
+	return "romeo", nil
+}
+
+func scramAuth(username, hashName string) (xmpp.SCRAMCredentials, error) {
+	if username != "juliet" {
+		return xmpp.SCRAMCredentials{}, errUnknownUser
+	}
+	h := sha256.New
+	if hashName == "SHA-1" {
+		h = sha1.New
+	}
+	return xmpp.NewSCRAMCredentials(h, "romeo", []byte("salt"), 4096), nil
+}
+
+var saslAuthTestCases = [...]struct {
+	auth      xmpp.SASLAuth
+	mechanism sasl.Mechanism
+	password  string
+	err       bool
+}{
+	0: {
+		auth:      xmpp.SASLAuth{Password: passwordAuth},
+		mechanism: sasl.Plain,
+		password:  "romeo",
+	},
+	1: {
+		auth:      xmpp.SASLAuth{Password: passwordAuth},
+		mechanism: sasl.Plain,
+		password:  "tybalt",
+		err:       true,
+	},
+	2: {
+		auth:      xmpp.SASLAuth{Password: passwordAuth},
+		mechanism: sasl.ScramSha256,
+		password:  "romeo",
+	},
+	3: {
+		auth:      xmpp.SASLAuth{SCRAM: scramAuth},
+		mechanism: sasl.ScramSha1,
+		password:  "romeo",
+	},
+	4: {
+		auth:      xmpp.SASLAuth{SCRAM: scramAuth},
+		mechanism: sasl.ScramSha256,
+		password:  "tybalt",
+		err:       true,
+	},
+	5: {
+		auth:      xmpp.SASLAuth{SCRAM: scramAuth},
+		mechanism: sasl.Plain,
+		password:  "romeo",
+	},
+}
+
+func TestSASLAuthServer(t *testing.T) {
+	for i, tc := range saslAuthTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			defer serverConn.Close()
+
+			type result struct {
+				s   *xmpp.Session
+				err error
+			}
+			received := make(chan result, 1)
+			go func() {
+				s, err := xmpp.ReceiveSession(context.Background(), serverConn, xmpp.Secure, xmpp.NewNegotiator(xmpp.StreamConfig{
+					Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+						return []xmpp.StreamFeature{xmpp.SASLAuthServer(tc.auth), xmpp.BindResource()}
+					},
+				}))
+				if err != nil {
+					/* #nosec */
+					serverConn.Close()
+				}
+				received <- result{s: s, err: err}
+			}()
+
+			origin := jid.MustParse("juliet@example.net")
+			_, err := xmpp.NewSession(context.Background(), origin.Domain(), origin, clientConn, xmpp.Secure, xmpp.NewNegotiator(xmpp.StreamConfig{
+				Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+					return []xmpp.StreamFeature{xmpp.SASL("", tc.password, tc.mechanism), xmpp.BindResource()}
+				},
+			}))
+			switch {
+			case tc.err && err == nil:
+				t.Fatalf("expected authentication to fail")
+			case !tc.err && err != nil:
+				t.Fatalf("error negotiating client session: %v", err)
+			case tc.err:
+				return
+			}
+			r := <-received
+			if r.err != nil {
+				t.Fatalf("error negotiating server session: %v", r.err)
+			}
+			if addr := r.s.RemoteAddr().Bare().String(); addr != "juliet@example.net" {
+				t.Errorf("wrong authenticated address: want=juliet@example.net, got=%s", addr)
+			}
+		})
+	}
+}
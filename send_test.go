@@ -10,15 +10,17 @@ import (
 	"crypto/rand"
 	"encoding/xml"
 	"errors"
+	"net"
 	"strconv"
 	"testing"
 	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
-	"mellium.im/xmpp/internal/xmpptest"
+	intxmpptest "mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
 )
 
 const (
@@ -221,7 +223,7 @@ var sendTests = [...]struct {
 		err: errExpected,
 	},
 	1: {
-		r: &xmpptest.Tokens{
+		r: &intxmpptest.Tokens{
 			xml.EndElement{Name: xml.Name{Local: "iq"}},
 		},
 		err: xmpp.ErrNotStart,
@@ -310,3 +312,146 @@ func TestSend(t *testing.T) {
 		})
 	}
 }
+
+func TestSendCanceled(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	/* #nosec */
+	defer serverConn.Close()
+	s := xmpptest.NewSession(0, clientConn)
+
+	// Nothing ever reads from the server side of the pipe, so the write will
+	// block until the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := s.Send(ctx, stanza.Message{To: to, Type: stanza.ChatMessage}.Wrap(nil))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: want=%v, got=%v", context.Canceled, err)
+	}
+}
+
+var sendStanzaTests = [...]struct {
+	state    xmpp.SessionState
+	msg      *stanza.Message
+	presence *stanza.Presence
+	id       string
+	err      error
+}{
+	0: {
+		msg: &stanza.Message{To: to, Type: stanza.ChatMessage},
+	},
+	1: {
+		msg: &stanza.Message{ID: "abc", To: to, From: jid.MustParse("test@example.net")},
+		id:  "abc",
+	},
+	2: {
+		msg: &stanza.Message{To: to, From: jid.MustParse("other@example.net")},
+		err: xmpp.ErrInvalidAddress,
+	},
+	3: {
+		state: xmpp.S2S,
+		msg:   &stanza.Message{To: to},
+		err:   xmpp.ErrInvalidAddress,
+	},
+	4: {
+		state: xmpp.S2S,
+		msg:   &stanza.Message{From: jid.MustParse("example.com")},
+		err:   xmpp.ErrInvalidAddress,
+	},
+	5: {
+		state:    xmpp.S2S,
+		presence: &stanza.Presence{From: jid.MustParse("example.com")},
+	},
+	6: {
+		presence: &stanza.Presence{ID: "123", Type: stanza.SubscribePresence, To: to},
+		id:       "123",
+	},
+	7: {
+		presence: &stanza.Presence{From: jid.MustParse("test@example.net/other")},
+		err:      xmpp.ErrInvalidAddress,
+	},
+}
+
+func TestSendStanza(t *testing.T) {
+	for i, tc := range sendStanzaTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			s := xmpptest.NewSession(tc.state, buf)
+
+			var id string
+			var err error
+			var name string
+			if tc.msg != nil {
+				name = "message"
+				id, err = s.SendMessage(context.Background(), *tc.msg, nil)
+			} else {
+				name = "presence"
+				id, err = s.SendPresence(context.Background(), *tc.presence, nil)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if tc.err != nil {
+				if buf.Len() != 0 {
+					t.Errorf("expected nothing to be sent, got: %q", buf.String())
+				}
+				return
+			}
+			switch {
+			case id == "":
+				t.Errorf("expected an ID to be assigned")
+			case tc.id != "" && id != tc.id:
+				t.Errorf("wrong ID: want=%q, got=%q", tc.id, id)
+			}
+
+			d := xml.NewDecoder(buf)
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error decoding sent stanza: %v", err)
+			}
+			start, ok := tok.(xml.StartElement)
+			if !ok || start.Name.Local != name {
+				t.Fatalf("expected %s start element, got %v", name, tok)
+			}
+			for _, a := range start.Attr {
+				if a.Name.Local == "id" && a.Value != id {
+					t.Errorf("sent ID does not match returned ID: want=%q, got=%q", id, a.Value)
+				}
+			}
+		})
+	}
+}
+
+type getIQQuery struct {
+	XMLName xml.Name `xml:"urn:example query"`
+	Value   string   `xml:"value,omitempty"`
+}
+
+func TestGetIQ(t *testing.T) {
+	cs := xmpptest.NewClientServer(xmpptest.ServerScript(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="urn:example"><value>ok</value></query></iq>`,
+		`<iq type="error" xmlns="jabber:client"><error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`,
+	))
+	defer cs.Close()
+
+	to := jid.MustParse("example.net")
+	var got getIQQuery
+	err := xmpp.GetIQ(context.Background(), cs.Client, to, getIQQuery{}, &got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Value != "ok" {
+		t.Errorf("wrong value decoded: want=ok, got=%q", got.Value)
+	}
+
+	err = xmpp.GetIQ(context.Background(), cs.Client, to, getIQQuery{}, &got)
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		t.Fatalf("expected stanza error, got: %v", err)
+	}
+	if stanzaErr.Condition != stanza.ItemNotFound {
+		t.Errorf("wrong condition: want=%v, got=%v", stanza.ItemNotFound, stanzaErr.Condition)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var errNotStanza = errors.New("server: routed element was not a stanza")
+
+// Router maps the addresses of users to the sessions that they are connected
+// with and delivers stanzas to them using the rules in RFC 6121 § 8.5.
+// The zero value is a router with no sessions that is ready to use.
+//
+// Sessions are not available to receive stanzas addressed to a bare JID until
+// they have sent initial presence, which must be recorded by calling
+// SetPresence.
+// Stanzas that do not need to be delivered (for example, presence addressed to
+// an unavailable resource) are silently dropped.
+//
+// Router is safe for concurrent use by multiple goroutines.
+type Router struct {
+	// Offline is called with messages of type "normal" or "chat" that are
+	// addressed to a user with no available resources.
+	// It is typically used to store the message for later delivery.
+	// If Offline is nil, a service-unavailable error is returned from Route.
+	Offline func(ctx context.Context, msg stanza.Message, r xml.TokenReader) error
+
+	mu    sync.RWMutex
+	users map[string]map[string]*route
+}
+
+type route struct {
+	session   *xmpp.Session
+	available bool
+	priority  int8
+}
+
+// Add registers the session under its remote address, which must be a full
+// JID.
+// If another session is registered under the same address it is replaced.
+func (r *Router) Add(s *xmpp.Session) {
+	addr := s.RemoteAddr()
+	bare := addr.Bare().String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.users == nil {
+		r.users = make(map[string]map[string]*route)
+	}
+	resources, ok := r.users[bare]
+	if !ok {
+		resources = make(map[string]*route)
+		r.users[bare] = resources
+	}
+	resources[addr.Resourcepart()] = &route{session: s}
+}
+
+// Remove unregisters the session.
+// It does nothing if a different session has since been registered under the
+// same address.
+func (r *Router) Remove(s *xmpp.Session) {
+	addr := s.RemoteAddr()
+	bare := addr.Bare().String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resources := r.users[bare]
+	if rt, ok := resources[addr.Resourcepart()]; !ok || rt.session != s {
+		return
+	}
+	delete(resources, addr.Resourcepart())
+	if len(resources) == 0 {
+		delete(r.users, bare)
+	}
+}
+
+// SetPresence records whether the session bound to the full JID j is
+// available and its priority.
+func (r *Router) SetPresence(j jid.JID, available bool, priority int8) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rt, ok := r.users[j.Bare().String()][j.Resourcepart()]; ok {
+		rt.available = available
+		rt.priority = priority
+	}
+}
+
+// Lookup returns the session bound to the full JID j.
+func (r *Router) Lookup(j jid.JID) (*xmpp.Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rt, ok := r.users[j.Bare().String()][j.Resourcepart()]
+	if !ok {
+		return nil, false
+	}
+	return rt.session, true
+}
+
+// InUse reports whether a session is registered for the full JID j.
+// It can be used as the InUse option of xmpp.BindConfig.
+func (r *Router) InUse(j jid.JID) bool {
+	_, ok := r.Lookup(j)
+	return ok
+}
+
+// Route delivers the stanza read from stanzaReader to the sessions of its
+// recipient.
+// IQs addressed to a bare JID must be handled by the server on behalf of the
+// user, so Route returns a service-unavailable error for them.
+// Errors of type stanza.Error should be returned to the sender of the stanza.
+func (r *Router) Route(ctx context.Context, stanzaReader xml.TokenReader) error {
+	var toks tokens
+	_, err := xmlstream.Copy(&toks, stanzaReader)
+	if err != nil {
+		return err
+	}
+	if len(toks) == 0 {
+		return errNotStanza
+	}
+	start, ok := toks[0].(xml.StartElement)
+	if !ok {
+		return errNotStanza
+	}
+
+	switch start.Name.Local {
+	case "iq":
+		iq, err := stanza.NewIQ(start)
+		if err != nil {
+			return err
+		}
+		if iq.To.Resourcepart() == "" || !r.deliverFull(ctx, iq.To, toks) {
+			return r.unavailable(iq.Type == stanza.ResultIQ || iq.Type == stanza.ErrorIQ)
+		}
+		return nil
+	case "message":
+		msg, err := stanza.NewMessage(start)
+		if err != nil {
+			return err
+		}
+		return r.routeMessage(ctx, msg, toks)
+	case "presence":
+		p, err := stanza.NewPresence(start)
+		if err != nil {
+			return err
+		}
+		if p.To.Resourcepart() != "" {
+			r.deliverFull(ctx, p.To, toks)
+			return nil
+		}
+		r.deliverBare(ctx, p.To, toks, func(rt *route) bool {
+			return rt.available
+		})
+		return nil
+	}
+	return errNotStanza
+}
+
+func (r *Router) routeMessage(ctx context.Context, msg stanza.Message, toks tokens) error {
+	if msg.To.Resourcepart() != "" && r.deliverFull(ctx, msg.To, toks) {
+		return nil
+	}
+
+	switch msg.Type {
+	case stanza.ErrorMessage:
+		return nil
+	case stanza.GroupChatMessage:
+		return r.unavailable(false)
+	case stanza.HeadlineMessage:
+		if msg.To.Resourcepart() != "" {
+			return nil
+		}
+		r.deliverBare(ctx, msg.To, toks, func(rt *route) bool {
+			return rt.available && rt.priority >= 0
+		})
+		return nil
+	}
+
+	// Normal and chat messages go to the available resources with the highest
+	// non-negative priority.
+	r.mu.RLock()
+	var highest int8 = -1
+	for _, rt := range r.users[msg.To.Bare().String()] {
+		if rt.available && rt.priority > highest {
+			highest = rt.priority
+		}
+	}
+	r.mu.RUnlock()
+	if highest >= 0 && r.deliverBare(ctx, msg.To, toks, func(rt *route) bool {
+		return rt.available && rt.priority == highest
+	}) {
+		return nil
+	}
+	if r.Offline != nil {
+		return r.Offline(ctx, msg, toks.reader())
+	}
+	return r.unavailable(false)
+}
+
+// deliverFull sends the stanza to the session bound to j and reports whether
+// one was found.
+func (r *Router) deliverFull(ctx context.Context, j jid.JID, toks tokens) bool {
+	s, ok := r.Lookup(j)
+	if !ok {
+		return false
+	}
+	/* #nosec */
+	s.Send(ctx, toks.reader())
+	return true
+}
+
+// deliverBare sends the stanza to every session of the user j that matches f
+// and reports whether any were found.
+func (r *Router) deliverBare(ctx context.Context, j jid.JID, toks tokens, f func(*route) bool) bool {
+	r.mu.RLock()
+	var sessions []*xmpp.Session
+	for _, rt := range r.users[j.Bare().String()] {
+		if f(rt) {
+			sessions = append(sessions, rt.session)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, s := range sessions {
+		/* #nosec */
+		s.Send(ctx, toks.reader())
+	}
+	return len(sessions) > 0
+}
+
+// unavailable returns the error that should be sent in response to a stanza
+// that could not be delivered, or nil if the stanza must not be responded to.
+func (r *Router) unavailable(isResponse bool) error {
+	if isResponse {
+		return nil
+	}
+	return stanza.Error{Type: stanza.Cancel, Condition: stanza.ServiceUnavailable}
+}
+
+// tokens buffers a stanza so that it can be delivered to multiple sessions.
+type tokens []xml.Token
+
+func (t *tokens) EncodeToken(tok xml.Token) error {
+	*t = append(*t, xml.CopyToken(tok))
+	return nil
+}
+
+func (t tokens) reader() xml.TokenReader {
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if len(t) == 0 {
+			return nil, io.EOF
+		}
+		tok := t[0]
+		t = t[1:]
+		return tok, nil
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/server"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+// newSession returns a session with the provided remote address that writes
+// anything sent to it to w.
+func newSession(addr string, w io.Writer) *xmpp.Session {
+	s, err := xmpp.NewSession(context.Background(), jid.MustParse("example.net"), jid.MustParse(addr), struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream from="` + addr + `" to="example.net" id="123" version="1.0" xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">`),
+		Writer: w,
+	}, xmpp.Received, xmpptest.NopNegotiator(xmpp.Received))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+type resource struct {
+	addr      string
+	available bool
+	priority  int8
+}
+
+var routeTestCases = [...]struct {
+	resources []resource
+	stanza    string
+	delivered []bool
+	offline   bool
+	err       error
+}{
+	0: {
+		resources: []resource{{addr: "juliet@example.net/balcony", available: true}, {addr: "juliet@example.net/chamber", available: true}},
+		stanza:    `<message to="juliet@example.net/chamber" type="chat" xmlns="jabber:client"/>`,
+		delivered: []bool{false, true},
+	},
+	1: {
+		resources: []resource{{addr: "juliet@example.net/balcony", available: true, priority: 1}, {addr: "juliet@example.net/chamber", available: true}},
+		stanza:    `<message to="juliet@example.net" type="chat" xmlns="jabber:client"/>`,
+		delivered: []bool{true, false},
+	},
+	2: {
+		resources: []resource{{addr: "juliet@example.net/balcony", available: true, priority: -1}, {addr: "juliet@example.net/chamber"}},
+		stanza:    `<message to="juliet@example.net" type="chat" xmlns="jabber:client"/>`,
+		delivered: []bool{false, false},
+		offline:   true,
+	},
+	3: {
+		resources: []resource{{addr: "juliet@example.net/balcony", available: true, priority: -1}, {addr: "juliet@example.net/chamber", available: true}},
+		stanza:    `<presence to="juliet@example.net" xmlns="jabber:client"/>`,
+		delivered: []bool{true, true},
+	},
+	4: {
+		resources: []resource{{addr: "juliet@example.net/balcony", available: true}},
+		stanza:    `<iq to="juliet@example.net/chamber" type="get" id="1" xmlns="jabber:client"/>`,
+		delivered: []bool{false},
+		err:       stanza.Error{Type: stanza.Cancel, Condition: stanza.ServiceUnavailable},
+	},
+	5: {
+		resources: []resource{{addr: "juliet@example.net/balcony", available: true}, {addr: "juliet@example.net/chamber", available: true}},
+		stanza:    `<message to="juliet@example.net/orchard" type="chat" xmlns="jabber:client"/>`,
+		delivered: []bool{true, true},
+	},
+}
+
+func TestRoute(t *testing.T) {
+	for i, tc := range routeTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var offline bool
+			r := &server.Router{
+				Offline: func(context.Context, stanza.Message, xml.TokenReader) error {
+					offline = true
+					return nil
+				},
+			}
+			bufs := make([]*bytes.Buffer, len(tc.resources))
+			for i, res := range tc.resources {
+				bufs[i] = &bytes.Buffer{}
+				r.Add(newSession(res.addr, bufs[i]))
+				r.SetPresence(jid.MustParse(res.addr), res.available, res.priority)
+			}
+
+			err := r.Route(context.Background(), xml.NewDecoder(strings.NewReader(tc.stanza)))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			for i, buf := range bufs {
+				if got := buf.Len() > 0; got != tc.delivered[i] {
+					t.Errorf("wrong delivery to %s: want=%t, got=%t", tc.resources[i].addr, tc.delivered[i], got)
+				}
+			}
+			if offline != tc.offline {
+				t.Errorf("wrong offline delivery: want=%t, got=%t", tc.offline, offline)
+			}
+		})
+	}
+}
+
+func TestRouterRemove(t *testing.T) {
+	r := &server.Router{}
+	old := newSession("juliet@example.net/balcony", &bytes.Buffer{})
+	r.Add(old)
+	if !r.InUse(jid.MustParse("juliet@example.net/balcony")) {
+		t.Fatalf("expected resource to be in use")
+	}
+	replacement := newSession("juliet@example.net/balcony", &bytes.Buffer{})
+	r.Add(replacement)
+	r.Remove(old)
+	if s, ok := r.Lookup(jid.MustParse("juliet@example.net/balcony")); !ok || s != replacement {
+		t.Errorf("removing a replaced session should not remove the replacement")
+	}
+	r.Remove(replacement)
+	if r.InUse(jid.MustParse("juliet@example.net/balcony")) {
+		t.Errorf("expected resource to be released")
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package server accepts client connections and negotiates XMPP sessions from
+// the server's perspective.
+//
+// It is a building block for writing gateways, test servers, and other
+// services that clients connect to directly and does not implement a full
+// XMPP server.
+// Sessions are handed to a user supplied handler once STARTTLS (if
+// configured), authentication, and resource binding are complete.
+package server // import "mellium.im/xmpp/server"
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+)
+
+// ErrServerClosed is returned by Serve after Close is called.
+var ErrServerClosed = errors.New("server: server closed")
+
+// Config configures a Server.
+type Config struct {
+	// TLSConfig is used to offer STARTTLS.
+	// If it is nil and Secure is not set, clients can never authenticate
+	// because SASL requires a secure connection.
+	TLSConfig *tls.Config
+
+	// Secure indicates that the listener already provides transport security
+	// (for example, a listener returned by tls.Listen, or a local socket used
+	// in tests) so that STARTTLS is not required.
+	Secure bool
+
+	// Auth is used to look up user credentials during SASL authentication.
+	// If any of its callbacks are set, Mechanisms and Permissions are ignored.
+	Auth xmpp.SASLAuth
+
+	// Mechanisms are the SASL mechanisms offered to clients.
+	// If Mechanisms is empty, PLAIN is offered.
+	Mechanisms []sasl.Mechanism
+
+	// Permissions is called to verify the credentials provided by clients
+	// during SASL authentication.
+	// If Permissions is nil, no clients can authenticate.
+	Permissions func(*sasl.Negotiator) bool
+
+	// Bind is called to assign a full JID to an authenticated user during
+	// resource binding.
+	// It is passed the user's bare JID and the resource requested by the client
+	// (which may be empty).
+	// If Bind is nil, a random resource is assigned.
+	Bind func(user jid.JID, requested string) (jid.JID, error)
+
+	// StreamConfig is used for any other options when negotiating sessions.
+	// Its Features option is ignored.
+	StreamConfig xmpp.StreamConfig
+
+	// NegotiateTimeout is the maximum amount of time that negotiating a session
+	// may take.
+	// If it is zero, there is no limit.
+	NegotiateTimeout time.Duration
+
+	// Handler is called on its own goroutine with each session once
+	// negotiation is complete.
+	// The session and its underlying connection are closed when Handler
+	// returns.
+	Handler func(*xmpp.Session)
+
+	// Router, if set, has each session added to it once negotiation is complete
+	// and removed when the handler returns.
+	Router *Router
+
+	// ErrorLog is used to log errors that occur while accepting connections
+	// and negotiating sessions.
+	// If it is nil, errors are not logged.
+	ErrorLog *log.Logger
+}
+
+// Server accepts connections and negotiates client sessions.
+type Server struct {
+	cfg Config
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// New returns a server that uses the provided config.
+func New(cfg Config) *Server {
+	if len(cfg.Mechanisms) == 0 {
+		cfg.Mechanisms = []sasl.Mechanism{sasl.Plain}
+	}
+	return &Server{
+		cfg:       cfg,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on l and negotiates sessions on each of them in a
+// new goroutine.
+// Serve always returns a non-nil error and closes l.
+// After Close is called the returned error is ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		/* #nosec */
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		/* #nosec */
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				s.logf("server: error accepting connection: %v", err)
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !s.track(conn) {
+			/* #nosec */
+			conn.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops all listeners and closes every active connection.
+// It then waits for any running handlers to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for c := range s.conns {
+		/* #nosec */
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		/* #nosec */
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	session, err := s.negotiate(conn)
+	if err != nil {
+		s.logf("server: error negotiating session with %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if s.cfg.Router != nil {
+		s.cfg.Router.Add(session)
+		defer s.cfg.Router.Remove(session)
+	}
+	if s.cfg.Handler != nil {
+		s.cfg.Handler(session)
+	}
+	err = session.Close()
+	if err != nil {
+		s.logf("server: error closing session with %s: %v", session.RemoteAddr(), err)
+	}
+}
+
+func (s *Server) negotiate(conn net.Conn) (*xmpp.Session, error) {
+	ctx := context.Background()
+	if s.cfg.NegotiateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.NegotiateTimeout)
+		defer cancel()
+	}
+
+	// The username is recorded during authentication so that it can be used to
+	// build the JID assigned during resource binding.
+	var username string
+	permissions := func(n *sasl.Negotiator) bool {
+		if s.cfg.Permissions == nil || !s.cfg.Permissions(n) {
+			return false
+		}
+		user, _, _ := n.Credentials()
+		username = string(user)
+		return true
+	}
+
+	var state xmpp.SessionState
+	if s.cfg.Secure {
+		state |= xmpp.Secure
+	}
+	cfg := s.cfg.StreamConfig
+	cfg.Features = func(session *xmpp.Session, _ ...xmpp.StreamFeature) []xmpp.StreamFeature {
+		var features []xmpp.StreamFeature
+		if s.cfg.TLSConfig != nil {
+			features = append(features, xmpp.StartTLS(s.cfg.TLSConfig))
+		}
+		if s.useAuth() {
+			return append(features,
+				xmpp.SASLAuthServer(s.cfg.Auth),
+				xmpp.BindCustom(func(user jid.JID, requested string) (jid.JID, error) {
+					return s.bind(user.Bare(), requested)
+				}),
+			)
+		}
+		domain := session.LocalAddr().Domain()
+		return append(features,
+			xmpp.SASLServer(permissions, s.cfg.Mechanisms...),
+			xmpp.BindCustom(func(_ jid.JID, requested string) (jid.JID, error) {
+				user, err := jid.New(username, domain.String(), "")
+				if err != nil {
+					return jid.JID{}, err
+				}
+				return s.bind(user, requested)
+			}),
+		)
+	}
+	return xmpp.ReceiveSession(ctx, conn, state, xmpp.NewNegotiator(cfg))
+}
+
+func (s *Server) useAuth() bool {
+	return s.cfg.Auth.Password != nil || s.cfg.Auth.SCRAM != nil || s.cfg.Auth.External != nil
+}
+
+func (s *Server) bind(user jid.JID, requested string) (jid.JID, error) {
+	if s.cfg.Bind != nil {
+		return s.cfg.Bind(user, requested)
+	}
+	return user.WithResource(attr.RandomID())
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.cfg.ErrorLog != nil {
+		s.cfg.ErrorLog.Printf(format, v...)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/server"
+)
+
+var errClosed = errors.New("listener closed")
+
+// pipeListener is a net.Listener that returns the server side of in-memory
+// pipes.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Dial() net.Conn {
+	client, srv := net.Pipe()
+	l.conns <- srv
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, errClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "pipe"}
+}
+
+func TestServer(t *testing.T) {
+	sessions := make(chan jid.JID, 1)
+	srv := server.New(server.Config{
+		Secure: true,
+		Permissions: func(n *sasl.Negotiator) bool {
+			user, pass, _ := n.Credentials()
+			return string(user) == "juliet" && string(pass) == "romeo"
+		},
+		Bind: func(user jid.JID, requested string) (jid.JID, error) {
+			return user.WithResource("balcony")
+		},
+		Handler: func(s *xmpp.Session) {
+			sessions <- s.RemoteAddr()
+		},
+	})
+	l := newPipeListener()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	conn := l.Dial()
+	origin := jid.MustParse("juliet@example.net")
+	s, err := xmpp.NewSession(context.Background(), origin.Domain(), origin, conn, xmpp.Secure, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{
+				xmpp.SASL("", "romeo", sasl.Plain),
+				xmpp.BindResource(),
+			}
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	if addr := s.LocalAddr().String(); addr != "juliet@example.net/balcony" {
+		t.Errorf("wrong address bound on client: want=juliet@example.net/balcony, got=%s", addr)
+	}
+	if addr := (<-sessions).String(); addr != "juliet@example.net/balcony" {
+		t.Errorf("wrong address on server session: want=juliet@example.net/balcony, got=%s", addr)
+	}
+
+	err = srv.Close()
+	if err != nil {
+		t.Fatalf("error closing server: %v", err)
+	}
+	if err = <-errs; err != server.ErrServerClosed {
+		t.Errorf("wrong error from Serve: want=%v, got=%v", server.ErrServerClosed, err)
+	}
+}
+
+func TestServerBadPassword(t *testing.T) {
+	srv := server.New(server.Config{
+		Secure: true,
+		Permissions: func(n *sasl.Negotiator) bool {
+			user, pass, _ := n.Credentials()
+			return string(user) == "juliet" && string(pass) == "romeo"
+		},
+		Handler: func(s *xmpp.Session) {
+			t.Errorf("handler called for session that failed to authenticate")
+		},
+	})
+	l := newPipeListener()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	conn := l.Dial()
+	origin := jid.MustParse("juliet@example.net")
+	_, err := xmpp.NewSession(context.Background(), origin.Domain(), origin, conn, xmpp.Secure, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{
+				xmpp.SASL("", "tybalt", sasl.Plain),
+				xmpp.BindResource(),
+			}
+		},
+	}))
+	if err == nil {
+		t.Errorf("expected negotiation to fail with the wrong password")
+	}
+
+	err = srv.Close()
+	if err != nil {
+		t.Fatalf("error closing server: %v", err)
+	}
+	if err = <-errs; err != server.ErrServerClosed {
+		t.Errorf("wrong error from Serve: want=%v, got=%v", server.ErrServerClosed, err)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package serverinfo_test
+
+import (
+	"log"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/server"
+	"mellium.im/xmpp/serverinfo"
+)
+
+func ExampleAddresses_Form() {
+	addrs := serverinfo.Addresses{
+		Abuse:    []string{"mailto:abuse@example.net"},
+		Security: []string{"xmpp:security@example.net"},
+	}
+	m := mux.New(disco.Handle(disco.Handler{
+		Account: disco.Registry{
+			Forms: []*form.Data{addrs.Form()},
+		},
+	}))
+	srv := server.New(server.Config{
+		Handler: func(s *xmpp.Session) {
+			err := s.Serve(m)
+			if err != nil {
+				log.Printf("error serving session: %v", err)
+			}
+		},
+	})
+	defer srv.Close()
+}
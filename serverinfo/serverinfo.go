@@ -0,0 +1,107 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package serverinfo implements XEP-0157: Contact Addresses for XMPP Services.
+//
+// Services advertise addresses that can be used to contact their
+// administrators, for example to report abuse, using an extended information
+// form in their service discovery information.
+// Servers can publish their addresses by adding the form returned by Form to
+// the Account registry of a disco.Handler.
+package serverinfo // import "mellium.im/xmpp/serverinfo"
+
+import (
+	"context"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+)
+
+// NS is the FORM_TYPE of the form used to publish contact addresses.
+const NS = "http://jabber.org/network/serverinfo"
+
+// Addresses are the contact addresses of a service.
+// Each address is a URI, for example "mailto:abuse@example.net" or
+// "xmpp:admin@example.net".
+type Addresses struct {
+	Abuse    []string
+	Admin    []string
+	Feedback []string
+	Sales    []string
+	Security []string
+	Status   []string
+	Support  []string
+}
+
+func (a *Addresses) fields() []struct {
+	name string
+	addr *[]string
+} {
+	return []struct {
+		name string
+		addr *[]string
+	}{
+		{name: "abuse-addresses", addr: &a.Abuse},
+		{name: "admin-addresses", addr: &a.Admin},
+		{name: "feedback-addresses", addr: &a.Feedback},
+		{name: "sales-addresses", addr: &a.Sales},
+		{name: "security-addresses", addr: &a.Security},
+		{name: "status-addresses", addr: &a.Status},
+		{name: "support-addresses", addr: &a.Support},
+	}
+}
+
+// Form returns a result form containing the addresses that can be included in
+// service discovery responses.
+// Fields without any addresses are omitted.
+func (a Addresses) Form() *form.Data {
+	opts := []form.Field{
+		form.Result,
+		form.Hidden("FORM_TYPE", form.Value(NS)),
+	}
+	for _, f := range a.fields() {
+		if len(*f.addr) == 0 {
+			continue
+		}
+		values := make([]form.Option, 0, len(*f.addr))
+		for _, addr := range *f.addr {
+			values = append(values, form.Value(addr))
+		}
+		opts = append(opts, form.ListMulti(f.name, values...))
+	}
+	return form.New(opts...)
+}
+
+// FromForm reads addresses from a form.
+// If the form does not have the correct FORM_TYPE, ok is false.
+func FromForm(data *form.Data) (a Addresses, ok bool) {
+	if data == nil {
+		return a, false
+	}
+	if disco.FormType(data) != NS {
+		return a, false
+	}
+	for _, f := range a.fields() {
+		values, _ := data.Values(f.name)
+		*f.addr = append([]string(nil), values...)
+	}
+	return a, true
+}
+
+// Get fetches the contact addresses of the provided service.
+// If the service does not publish any addresses, the zero value is returned.
+func Get(ctx context.Context, s *xmpp.Session, to jid.JID) (Addresses, error) {
+	info, err := disco.GetInfo(ctx, "", to, s)
+	if err != nil {
+		return Addresses{}, err
+	}
+	data, ok := info.Form(NS)
+	if !ok {
+		return Addresses{}, nil
+	}
+	a, _ := FromForm(data)
+	return a, nil
+}
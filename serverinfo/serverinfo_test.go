@@ -0,0 +1,67 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package serverinfo_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/serverinfo"
+	"mellium.im/xmpp/xmpptest"
+)
+
+func TestRoundTrip(t *testing.T) {
+	addrs := serverinfo.Addresses{
+		Abuse:   []string{"mailto:abuse@shakespeare.lit", "xmpp:abuse@shakespeare.lit"},
+		Support: []string{"https://shakespeare.lit/support.php"},
+	}
+	b, err := xml.Marshal(addrs.Form())
+	if err != nil {
+		t.Fatalf("error marshaling form: %v", err)
+	}
+	data := &form.Data{}
+	err = xml.Unmarshal(b, data)
+	if err != nil {
+		t.Fatalf("error unmarshaling form: %v", err)
+	}
+	out, ok := serverinfo.FromForm(data)
+	if !ok {
+		t.Fatalf("form was not recognized: %s", b)
+	}
+	if !reflect.DeepEqual(out, addrs) {
+		t.Errorf("wrong addresses:\nwant=%+v,\n got=%+v", addrs, out)
+	}
+
+	_, ok = serverinfo.FromForm(form.New(form.Result, form.Hidden("FORM_TYPE", form.Value("urn:example"))))
+	if ok {
+		t.Errorf("expected form with wrong FORM_TYPE to be ignored")
+	}
+}
+
+func TestGet(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="get" to="shakespeare.lit"><query xmlns="http://jabber.org/protocol/disco#info"/></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="http://jabber.org/protocol/disco#info"><identity category="server" type="im"/><feature var="http://jabber.org/protocol/disco#info"/><x xmlns="jabber:x:data" type="result"><field var="FORM_TYPE" type="hidden"><value>urn:example</value></field></x><x xmlns="jabber:x:data" type="result"><field var="FORM_TYPE" type="hidden"><value>http://jabber.org/network/serverinfo</value></field><field var="abuse-addresses"><value>mailto:abuse@shakespeare.lit</value><value>xmpp:abuse@shakespeare.lit</value></field><field var="admin-addresses"><value>xmpp:admin@shakespeare.lit</value></field></x></query></iq>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	addrs, err := serverinfo.Get(context.Background(), cs.Client, jid.MustParse("shakespeare.lit"))
+	if err != nil {
+		t.Fatalf("error fetching addresses: %v", err)
+	}
+	want := serverinfo.Addresses{
+		Abuse: []string{"mailto:abuse@shakespeare.lit", "xmpp:abuse@shakespeare.lit"},
+		Admin: []string{"xmpp:admin@shakespeare.lit"},
+	}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Errorf("wrong addresses:\nwant=%+v,\n got=%+v", want, addrs)
+	}
+	m.Done()
+}
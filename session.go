@@ -16,6 +16,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"mellium.im/xmlstream"
@@ -33,6 +34,8 @@ import (
 var (
 	ErrInputStreamClosed  = errors.New("xmpp: attempted to read token from closed stream")
 	ErrOutputStreamClosed = errors.New("xmpp: attempted to write token to closed stream")
+	ErrNoKeepAlive        = errors.New("xmpp: connection does not support TCP keep-alives")
+	ErrInvalidAddress     = errors.New("xmpp: invalid stanza addressing")
 )
 
 var errNotStart = errors.New("xmpp: SendElement did not begin with a StartElement")
@@ -80,8 +83,15 @@ const (
 // A Session represents an XMPP session comprising an input and an output XML
 // stream.
 type Session struct {
+	// The number of bytes dropped by TeeIn and TeeOut.
+	// These are accessed atomically and must be 64-bit aligned so they are kept
+	// at the start of the struct.
+	teeInDropped  uint64
+	teeOutDropped uint64
+
 	conn      net.Conn
 	connState func() tls.ConnectionState
+	netConn   net.Conn
 
 	state      SessionState
 	stateMutex sync.RWMutex
@@ -94,13 +104,71 @@ type Session struct {
 
 	sentIQMutex sync.Mutex
 	sentIQs     map[string]chan xmlstream.TokenReadCloser
+	iqTimeout   time.Duration
+	strict      bool
+	limiter     *rateLimiter
+
+	// inClosed is closed when the input stream is closed so that any pending IQs
+	// can fail immediately and inErr is the error (if any) that caused the input
+	// stream to be closed.
+	// inErr is only written once, before inClosed is closed, so it may be read
+	// without holding a lock by anything that has received from inClosed.
+	inClosed chan struct{}
+	inErr    error
+
+	closeTimeout time.Duration
+	closeNoWait  bool
+	keepAlive    time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	interceptors []Interceptor
+	workers      chan struct{}
+	workerWG     sync.WaitGroup
+	workerMu     sync.Mutex
+	workerErr    error
+	filters      []Filter
+	maxLifetime  time.Duration
+	limits       inputLimiter
+	metrics      Metrics
+	stanzaLog    StanzaLogger
+	events       *Events
+	expires      time.Time
+	lifetime     *time.Timer
+
+	flushSize     int
+	flushInterval time.Duration
+
+	// queue holds the elements waiting to be written by Encode and
+	// EncodeElement, or is nil if WriteQueue was not set on the StreamConfig.
+	queue      *writeQueue
+	queueDepth int
+
+	// ctx is canceled when the session is closed and is the parent of the
+	// contexts passed to handlers.
+	ctx            context.Context
+	cancel         context.CancelFunc
+	handlerTimeout time.Duration
+
+	// deferred is a queue of elements waiting to be sent by SendDeferred.
+	// It is protected by deferredMu, and deferredSendMu is held while elements
+	// from the queue are being sent to keep them in order.
+	deferred       []tokenBuffer
+	deferredMu     sync.Mutex
+	deferredSendMu sync.Mutex
 
 	in struct {
 		stream.Info
-		d      xml.TokenReader
-		ctx    context.Context
-		cancel context.CancelFunc
+		d       xml.TokenReader
+		dec     *xml.Decoder
+		counter *byteCounter
+		ctx     context.Context
+		cancel  context.CancelFunc
 		sync.Locker
+
+		// gotStreamErr is set when a stream error is received from the remote
+		// entity.
+		// It is protected by the input lock.
+		gotStreamErr bool
 	}
 	out struct {
 		stream.Info
@@ -108,6 +176,8 @@ type Session struct {
 			xmlstream.TokenWriter
 			xmlstream.Flusher
 		}
+		batch  *batchWriter
+		cancel *cancelWriter
 		sync.Locker
 	}
 }
@@ -150,8 +220,11 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 		features:   make(map[string]interface{}),
 		negotiated: make(map[string]struct{}),
 		sentIQs:    make(map[string]chan xmlstream.TokenReadCloser),
+		inClosed:   make(chan struct{}),
 		state:      state,
+		events:     &Events{},
 	}
+	s.netConn, _ = rw.(net.Conn)
 
 	if s.state&Received == Received {
 		s.in.Info.To = location
@@ -170,9 +243,10 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 	}
 	s.out.Locker = &sync.Mutex{}
 	s.in.Locker = &sync.Mutex{}
-	s.in.d = xml.NewDecoder(s.conn)
+	s.in.d = s.newDecoder(s.conn)
 	s.out.e = xml.NewEncoder(s.conn)
 	s.in.ctx, s.in.cancel = context.WithCancel(context.Background())
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	// If rw was already a *tls.Conn, go ahead and mark the connection as secure
 	// so that we don't try to negotiate StartTLS.
@@ -181,6 +255,7 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 	}
 
 	// Call negotiate until the ready bit is set.
+	negotiateStart := time.Now()
 	var data interface{}
 	for s.state&Ready == 0 {
 		var mask SessionState
@@ -188,6 +263,9 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 		// Clear the info if the stream was restarted (but preserve to/from so that
 		// we can verify that it has not changed).
 		if rw != nil {
+			if s.out.Info.XMLNS != "" {
+				s.publish(StreamRestartEvent, nil)
+			}
 			s.in.Info = stream.Info{
 				To:   s.in.Info.To,
 				From: s.in.Info.From,
@@ -212,26 +290,147 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 			if tc, ok := s.conn.(tlsConn); ok {
 				s.connState = tc.ConnectionState
 			}
-			s.in.d = xml.NewDecoder(s.conn)
+			s.in.d = s.newDecoder(s.conn)
 			s.out.e = xml.NewEncoder(s.conn)
 		}
 		s.state |= mask
 	}
 
-	s.in.d = intstream.Reader(s.in.d)
+	s.in.d = streamErrReader{r: intstream.Reader(s.in.d), s: s}
+	if s.limits.maxSize > 0 || s.limits.maxDepth > 0 || s.limits.restrict {
+		l := s.limits
+		l.r = s.in.d
+		l.d = s.in.dec
+		l.c = s.in.counter
+		l.reset()
+		s.in.d = &l
+	}
+	if s.stanzaLog.Log != nil {
+		s.in.d = &logReader{r: s.in.d, rec: stanzaRecorder{logger: s.stanzaLog, dir: Incoming}}
+	}
 	streamNS := ns.Client
 	if s.state&S2S == S2S {
 		streamNS = ns.Server
 	}
-	se := &stanzaEncoder{TokenWriteFlusher: s.out.e, ns: streamNS}
+	if s.readTimeout > 0 {
+		s.in.counter.r = idleReader{r: s.in.counter.r, c: s.conn, d: s.readTimeout}
+	}
+	var idle *idleWriter
+	s.out.cancel = &cancelWriter{w: s.conn}
+	var w io.Writer = s.out.cancel
+	if s.writeTimeout > 0 {
+		w = timeoutWriter{w: w, c: s.conn, d: s.writeTimeout}
+	}
+	if s.metrics != nil {
+		s.metrics.Negotiated(time.Since(negotiateStart))
+		s.in.counter.metrics = s.metrics
+		w = metricsWriter{w: w, m: s.metrics}
+	}
+	if s.keepAlive > 0 {
+		// Replace the encoder so that we can keep track of the last time anything
+		// was written to the output stream.
+		idle = &idleWriter{w: w}
+		idle.touch()
+		w = idle
+	}
+	if s.flushInterval > 0 {
+		s.out.batch = newBatchWriter(w, s.flushSize, s.flushInterval)
+		w = s.out.batch
+	}
+	s.out.e = xml.NewEncoder(w)
+	if s.stanzaLog.Log != nil {
+		s.out.e = &logWriter{TokenWriteFlusher: s.out.e, rec: stanzaRecorder{logger: s.stanzaLog, dir: Outgoing}}
+	}
+	se := &stanzaEncoder{TokenWriteFlusher: s.out.e, ns: streamNS, metrics: s.metrics}
 	if s.state&S2S == S2S {
-		se.from = s.LocalAddr()
+		se.from = s.LocalAddr().String()
 	}
 	s.out.e = se
+	if s.queueDepth > 0 {
+		s.queue = newWriteQueue(s.queueDepth)
+		go s.queue.run(s)
+	}
+
+	if idle != nil {
+		go s.whitespaceKeepAlive(idle)
+	}
+	if s.maxLifetime > 0 {
+		s.expires = time.Now().Add(s.maxLifetime)
+		s.lifetime = time.AfterFunc(s.maxLifetime, func() {
+			/* #nosec */
+			s.Close()
+		})
+	}
+	s.publish(NegotiatedEvent, nil)
 
 	return s, nil
 }
 
+// Flush immediately writes any output that is being held back because of the
+// FlushInterval and FlushSize options on the sessions StreamConfig.
+// If output is not being batched, Flush does nothing.
+//
+// Flush is safe for concurrent use by multiple goroutines.
+func (s *Session) Flush() error {
+	if s.out.batch == nil {
+		return nil
+	}
+	return s.out.batch.Flush()
+}
+
+// Expires returns the time at which the session will be closed because it has
+// reached the MaxLifetime set in its StreamConfig.
+// If the session does not have a maximum lifetime ok is false.
+func (s *Session) Expires() (t time.Time, ok bool) {
+	return s.expires, s.lifetime != nil
+}
+
+// idleWriter is an io.Writer that records the last time it was written to.
+type idleWriter struct {
+	// last must be accessed atomically and is kept first in the struct to
+	// guarantee 64-bit alignment.
+	last int64
+	w    io.Writer
+}
+
+func (w *idleWriter) touch() {
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+}
+
+func (w *idleWriter) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&w.last))
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.touch()
+	return w.w.Write(p)
+}
+
+// whitespaceKeepAlive writes a single space to the output stream any time it
+// has been idle for the keepalive interval.
+// It returns when the output stream is closed or a write fails.
+func (s *Session) whitespaceKeepAlive(idle *idleWriter) {
+	ticker := time.NewTicker(s.keepAlive)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if time.Since(idle.idleSince()) < s.keepAlive {
+			continue
+		}
+
+		s.out.Lock()
+		if s.State()&OutputStreamClosed == OutputStreamClosed {
+			s.out.Unlock()
+			return
+		}
+		_, err := idle.Write([]byte{' '})
+		s.out.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
 // DialSession uses a default client or server dialer to create a TCP connection
 // and attempts to negotiate an XMPP session over it.
 func DialSession(ctx context.Context, location, origin jid.JID, rw io.ReadWriter, state SessionState, negotiate Negotiator) (*Session, error) {
@@ -268,6 +467,30 @@ func DialClientSession(ctx context.Context, origin jid.JID, features ...StreamFe
 	}))
 }
 
+// DialSecureClientSession is like DialClientSession except that the session
+// must be secured with TLS before it is established.
+// If the connection does not use direct TLS, StartTLS with the default
+// configuration is added to the features (unless they already contain StartTLS)
+// and negotiation fails if the server does not offer it.
+func DialSecureClientSession(ctx context.Context, origin jid.JID, features ...StreamFeature) (*Session, error) {
+	conn, err := dial.Client(ctx, "tcp", origin)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := containsStartTLS(features); !ok {
+		features = append([]StreamFeature{StartTLS(nil)}, features...)
+	}
+	return NewSession(ctx, origin.Domain(), origin, conn, 0, NewNegotiator(StreamConfig{
+		Features: func(_ *Session, f ...StreamFeature) []StreamFeature {
+			if f != nil {
+				return f
+			}
+			return features
+		},
+		Required: []string{ns.StartTLS},
+	}))
+}
+
 // DialServerSession uses a default dialer to create a TCP connection and
 // attempts to negotiate an XMPP server-to-server session over it.
 //
@@ -347,27 +570,55 @@ func ReceiveServerSession(ctx context.Context, location, origin jid.JID, rw io.R
 // stream.Error, the error is marshaled and sent over the XML stream.
 // If any other error type is returned, it is marshaled as an
 // undefined-condition StreamError.
+// Handlers can end the session with an application specific condition or human
+// readable text by returning an error created with the ApplicationError and
+// WithText methods on stream.Error.
 // If a stream error is received while serving it is not passed to the handler.
 // Instead, Serve unmarshals the error, closes the session, and returns it (h
 // handles stanza level errors, the session handles stream level errors).
+// The returned error can be inspected using errors.As with a stream.Error to
+// get the condition, text, and application specific condition sent by the
+// remote entity.
 // If serve handles an incoming IQ stanza and the handler does not write a
 // response (an IQ with the same ID and type "result" or "error"), Serve writes
 // an error IQ with a service-unavailable payload.
 //
 // If the user closes the output stream by calling Close, Serve continues until
 // the input stream is closed by the remote entity as above, or the deadline set
-// by SetCloseDeadline is reached in which case a timeout error is returned.
+// by SetCloseDeadline (or the CloseTimeout set on the StreamConfig) is reached
+// in which case a timeout error is returned.
+// If the session was negotiated with NoCloseWait set, Serve returns nil as soon
+// as the output stream is closed.
 // Serve takes a lock on the input and output stream before calling the handler,
 // so the handler should not close over the session or use any of its send
 // methods or a deadlock will occur.
+// Handlers that need to send stanzas other than the ones written to the
+// encoder they are passed may use SendDeferred instead.
 // After Serve finishes running the handler, it flushes the output stream.
+//
+// If the session was negotiated with ConcurrentHandlers set, Serve instead
+// reads each element into memory and calls the handler from a separate
+// goroutine without holding any locks so that handlers may use the session's
+// send methods (including SendIQ).
+// Anything written to the handler's encoder is buffered and written to the
+// stream after the handler returns.
+// In this mode, handlers may run in a different order than the elements were
+// received and the first error returned by a handler causes Serve to return
+// once it has finished reading the current element.
+// Before returning, Serve waits for all running handlers to finish.
 func (s *Session) Serve(h Handler) (err error) {
 	if h == nil {
 		h = nopHandler{}
 	}
 
 	defer func() {
-		s.closeInputStream()
+		if e := s.handlerErr(); e != nil {
+			err = e
+		}
+		s.closeInputStream(err)
+		// Wait for any handlers that are still running on other goroutines and for
+		// any deferred sends to be written.
+		s.workerWG.Wait()
 		e := s.Close()
 		if err == nil {
 			err = e
@@ -375,16 +626,27 @@ func (s *Session) Serve(h Handler) (err error) {
 	}()
 
 	for {
+		if err := s.handlerErr(); err != nil {
+			return err
+		}
 		select {
 		case <-s.in.ctx.Done():
+			if s.closedNoWait() {
+				return nil
+			}
 			return s.in.ctx.Err()
 		default:
 		}
 		err := handleInputStream(s, h)
-		switch err {
-		case nil:
+		switch {
+		case err == nil:
 			// No error and no sentinal error telling us to shut down; try again!
-		case io.EOF:
+		case err == io.EOF:
+			return nil
+		case s.closedNoWait():
+			// We closed the output stream and aren't waiting on the remote entity
+			// to do the same, so any error reading is the result of us closing the
+			// input stream.
 			return nil
 		default:
 			return s.sendError(err)
@@ -408,8 +670,17 @@ func (s *Session) sendError(err error) (e error) {
 
 	se := stream.Error{}
 	if errors.As(err, &se) {
-		if _, e = se.WriteXML(s.out.e); e != nil {
-			return e
+		// Stream errors that were received from the remote entity are returned
+		// without sending them back.
+		if !s.in.gotStreamErr {
+			if _, e = se.WriteXML(s.out.e); e != nil {
+				return e
+			}
+			// The closing stream tag is written directly to the connection, so make
+			// sure the error is not left in the encoder's buffer.
+			if e = s.out.e.Flush(); e != nil {
+				return e
+			}
 		}
 		if e = s.closeSession(); e != nil {
 			return e
@@ -452,11 +723,41 @@ func (r iqResponder) Close() error {
 	return nil
 }
 
+// canReply reports whether an invalid stanza may be responded to with an
+// error.
+// Errors are never responded to, and neither are stanzas with an unknown type
+// since they may have been errors.
+func canReply(start xml.StartElement) bool {
+	_, typ := attr.Get(start.Attr, "type")
+	switch start.Name.Local {
+	case "iq":
+		switch stanza.IQType(typ) {
+		case stanza.GetIQ, stanza.SetIQ, stanza.ResultIQ:
+			return true
+		}
+	case "message":
+		switch stanza.MessageType(typ) {
+		case "", stanza.NormalMessage, stanza.ChatMessage, stanza.GroupChatMessage, stanza.HeadlineMessage:
+			return true
+		}
+	case "presence":
+		switch stanza.PresenceType(typ) {
+		case stanza.AvailablePresence, stanza.ProbePresence, stanza.SubscribePresence,
+			stanza.SubscribedPresence, stanza.UnavailablePresence, stanza.UnsubscribePresence,
+			stanza.UnsubscribedPresence:
+			return true
+		}
+	}
+	return false
+}
+
 func handleInputStream(s *Session, handler Handler) (err error) {
 	discard := xmlstream.Discard()
 	rc := s.TokenReader()
 	defer rc.Close()
-	r := intstream.Reader(rc)
+	// The input stream was already wrapped to handle stream level elements when
+	// the session was established, so we don't need to do it again here.
+	var r xml.TokenReader = rc
 
 	tok, err := r.Token()
 	if err != nil {
@@ -480,16 +781,18 @@ func handleInputStream(s *Session, handler Handler) (err error) {
 		return fmt.Errorf("xmpp: stream in a bad state, expected start element or whitespace but got %T", tok)
 	}
 
+	if s.metrics != nil && isStanza(start.Name) {
+		_, typ := attr.Get(start.Attr, "type")
+		s.metrics.StanzaReceived(start.Name.Local, typ)
+	}
+
 	// If this is a stanza, normalize the "from" attribute.
 	if isStanza(start.Name) {
 		for i, attr := range start.Attr {
 			if attr.Name.Local == "from" /*&& attr.Name.Space == start.Name.Space*/ {
-				local := s.LocalAddr().Bare().String()
-				// Try a direct comparison first to avoid expensive JID parsing.
-				// TODO: really we should be parsing the JID here in case the server
-				// is using a different version of PRECIS, stringprep, etc. and the
-				// canonical representation isn't the same.
-				if attr.Value == local {
+				// EqualString only parses the attribute if it is not already in
+				// canonical form, so this does not allocate in the common case.
+				if s.LocalAddr().Bare().EqualString(attr.Value) {
 					start.Attr[i].Value = ""
 				}
 				break
@@ -497,6 +800,55 @@ func handleInputStream(s *Session, handler Handler) (err error) {
 		}
 	}
 
+	if len(s.filters) > 0 && isStanza(start.Name) {
+		inner := xmlstream.Inner(r)
+		defer func() {
+			// Advance to the end of the original element in case the filters did
+			// not read all of it.
+			_, e := xmlstream.Copy(discard, inner)
+			if err == nil {
+				err = e
+			}
+		}()
+		filtered, err := s.filter(xmlstream.MultiReader(xmlstream.Token(start), inner, xmlstream.Token(start.End())), start)
+		if err != nil || filtered == nil {
+			return err
+		}
+		tok, err := filtered.Token()
+		if err != nil {
+			return err
+		}
+		var ok bool
+		start, ok = tok.(xml.StartElement)
+		if !ok {
+			return fmt.Errorf("xmpp: expected filter to return a start element, got %T", tok)
+		}
+		r = filtered
+	}
+
+	if s.strict && isStanza(start.Name) {
+		var buf tokenBuffer
+		_, err = xmlstream.Copy(&buf, xmlstream.MultiReader(xmlstream.Token(start), xmlstream.Inner(r), xmlstream.Token(start.End())))
+		if err != nil {
+			return err
+		}
+		err = stanza.Validate(buf.Reader())
+		if err != nil {
+			var se stanza.Error
+			if !canReply(start) || !errors.As(err, &se) {
+				return nil
+			}
+			w := s.TokenWriter()
+			defer w.Close()
+			_, err = xmlstream.Copy(w, stanza.ErrorReply(start, se))
+			if err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		r = buf[1:].Reader()
+	}
+
 	var id string
 	var needsResp bool
 	if isIQ(start.Name) {
@@ -532,14 +884,34 @@ func handleInputStream(s *Session, handler Handler) (err error) {
 
 noreply:
 
+	if s.workers != nil {
+		return s.dispatch(handler, start, r, id, needsResp)
+	}
+
 	w := s.TokenWriter()
 	defer w.Close()
+	return s.handleElement(handler, start, xmlstream.MultiReader(xmlstream.Inner(r), xmlstream.Token(start.End())), w, id, needsResp)
+}
+
+// handleElement calls the handler for a single top level element and writes a
+// default response to IQs that were not responded to.
+func (s *Session) handleElement(handler Handler, start xml.StartElement, r xml.TokenReader, w xmlstream.TokenWriteFlusher, id string, needsResp bool) (err error) {
 	rw := &responseChecker{
-		TokenReader: xmlstream.MultiReader(xmlstream.Inner(r), xmlstream.Token(start.End())),
+		TokenReader: r,
 		TokenWriter: w,
 		id:          id,
 	}
-	if err := handler.HandleXMPP(rw, &start); err != nil {
+	if ch, ok := handler.(ContextHandler); ok {
+		ctx, cancel := s.ctx, context.CancelFunc(func() {})
+		if s.handlerTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, s.handlerTimeout)
+		}
+		err = ch.HandleXMPPContext(ctx, rw, &start)
+		cancel()
+	} else {
+		err = handler.HandleXMPP(rw, &start)
+	}
+	if err != nil {
 		return err
 	}
 
@@ -572,10 +944,92 @@ noreply:
 
 	// Advance to the end of the current element before attempting to read the
 	// next.
-	_, err = xmlstream.Copy(discard, rw)
+	_, err = xmlstream.Copy(xmlstream.Discard(), rw)
 	return err
 }
 
+// dispatch buffers the rest of the element being read from r and calls the
+// handler for it from a new goroutine once one of the session's workers is
+// available.
+// Any output from the handler is buffered and written to the session in one
+// go after the handler returns.
+func (s *Session) dispatch(handler Handler, start xml.StartElement, r xml.TokenReader, id string, needsResp bool) error {
+	var toks tokenBuffer
+	_, err := xmlstream.Copy(&toks, xmlstream.Inner(r))
+	if err != nil {
+		return err
+	}
+	toks = append(toks, start.End())
+
+	s.workerWG.Add(1)
+	go func() {
+		defer s.workerWG.Done()
+		// The worker is acquired on the new goroutine and not by the read loop so
+		// that responses to IQs sent by running handlers can still be read when
+		// all workers are busy.
+		s.workers <- struct{}{}
+		defer func() {
+			<-s.workers
+		}()
+
+		var out tokenBuffer
+		err := s.handleElement(handler, start, toks.Reader(), &out, id, needsResp)
+		if err == nil && len(out) > 0 {
+			w := s.TokenWriter()
+			_, err = xmlstream.Copy(w, out.Reader())
+			if e := w.Close(); err == nil {
+				err = e
+			}
+		}
+		if err != nil {
+			s.workerMu.Lock()
+			first := s.workerErr == nil
+			if first {
+				s.workerErr = err
+			}
+			s.workerMu.Unlock()
+			if first {
+				/* #nosec */
+				s.sendError(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// handlerErr returns the first error returned by a handler that was run
+// concurrently.
+func (s *Session) handlerErr() error {
+	s.workerMu.Lock()
+	defer s.workerMu.Unlock()
+	return s.workerErr
+}
+
+// tokenBuffer is a token writer that stores copies of the tokens written to it
+// so that they can be read back later.
+type tokenBuffer []xml.Token
+
+func (b *tokenBuffer) EncodeToken(t xml.Token) error {
+	*b = append(*b, xml.CopyToken(t))
+	return nil
+}
+
+func (b *tokenBuffer) Flush() error {
+	return nil
+}
+
+// Reader returns a token reader over the buffered tokens.
+func (b tokenBuffer) Reader() xml.TokenReader {
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if len(b) == 0 {
+			return nil, io.EOF
+		}
+		t := b[0]
+		b = b[1:]
+		return t, nil
+	})
+}
+
 type responseChecker struct {
 	xml.TokenReader
 	xmlstream.TokenWriter
@@ -615,15 +1069,93 @@ func (s *Session) Feature(namespace string) (data interface{}, ok bool) {
 	return data, ok
 }
 
+// Features returns the namespaces of all features that were advertised for
+// the current stream along with their canonical representation as returned by
+// the feature's Parse function.
+// Features that are not supported by the session (or were not negotiated
+// because their Necessary or Prohibited states did not match) have nil data.
+//
+// The returned map is a copy and may be modified by the caller.
+// To be notified of the features advertised after each stream restart (for
+// example to see the features offered after authentication) subscribe to
+// FeaturesEvent using the Events option on StreamConfig.
+func (s *Session) Features() map[string]interface{} {
+	return copyFeatures(s.features)
+}
+
+func copyFeatures(f map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(f))
+	for k, v := range f {
+		m[k] = v
+	}
+	return m
+}
+
 // Conn returns the Session's backing connection.
 //
 // This should almost never be read from or written to, but is useful during
 // stream negotiation for wrapping the existing connection in a new layer (eg.
 // compression or TLS).
+// Writing to the connection directly after the session has been negotiated is
+// deprecated because it can easily corrupt the XML stream; use Send, Encode, or
+// TokenWriter instead.
+// To inspect the connection or change its behavior see NetConn, TLSState, and
+// SetKeepAlive.
 func (s *Session) Conn() net.Conn {
 	return s.conn
 }
 
+// TeeDropped returns the number of bytes that were not copied to the TeeIn and
+// TeeOut writers configured on the StreamConfig because the writers could not
+// keep up with the stream.
+func (s *Session) TeeDropped() (in, out uint64) {
+	return atomic.LoadUint64(&s.teeInDropped), atomic.LoadUint64(&s.teeOutDropped)
+}
+
+// NetConn returns the network connection that the session was originally
+// created with, before any layers such as TLS or compression were negotiated.
+// If the session was not created with a net.Conn, ok will be false.
+//
+// NetConn is provided so that the local and remote network addresses can be
+// inspected and deadlines or socket options can be set.
+// The connection must never be read from or written to.
+func (s *Session) NetConn() (c net.Conn, ok bool) {
+	return s.netConn, s.netConn != nil
+}
+
+// TLSState returns the TLS state of the underlying connection.
+// If TLS has not been negotiated, ok will be false.
+func (s *Session) TLSState() (state tls.ConnectionState, ok bool) {
+	state = s.ConnectionState()
+	return state, state.HandshakeComplete
+}
+
+// SetKeepAlive enables TCP keep-alives with the given period on the network
+// connection that the session was created with.
+// If period is less than or equal to zero keep-alives are disabled.
+// If the connection does not support keep-alives (for example, because it is
+// not a TCP connection) ErrNoKeepAlive is returned.
+//
+// SetKeepAlive is not related to the WhitespaceKeepAlive option on
+// StreamConfig which sends keep-alives as part of the XML stream.
+func (s *Session) SetKeepAlive(period time.Duration) error {
+	kc, ok := s.netConn.(interface {
+		SetKeepAlive(bool) error
+		SetKeepAlivePeriod(time.Duration) error
+	})
+	if !ok {
+		return ErrNoKeepAlive
+	}
+	if period <= 0 {
+		return kc.SetKeepAlive(false)
+	}
+	err := kc.SetKeepAlive(true)
+	if err != nil {
+		return err
+	}
+	return kc.SetKeepAlivePeriod(period)
+}
+
 type lockWriteCloser struct {
 	w   *Session
 	err error
@@ -708,6 +1240,7 @@ func (lrc *lockReadCloser) Close() error {
 // After the TokenWriteCloser has been closed, any future writes will return
 // io.EOF.
 func (s *Session) TokenWriter() xmlstream.TokenWriteFlushCloser {
+	s.queue.wait()
 	s.out.Lock()
 
 	return &lockWriteCloser{
@@ -736,6 +1269,7 @@ func (s *Session) TokenReader() xmlstream.TokenReadCloser {
 // Calling Close() multiple times will only result in one closing
 // </stream:stream> being sent.
 func (s *Session) Close() error {
+	s.queue.wait()
 	s.out.Lock()
 	defer s.out.Unlock()
 	s.stateMutex.Lock()
@@ -744,23 +1278,147 @@ func (s *Session) Close() error {
 	return s.closeSession()
 }
 
+// SendDeferred reads the first element from r into memory and queues it to be
+// sent as soon as the output stream is available.
+// Unlike the other send methods it never blocks waiting for the output stream,
+// so it is safe to call from within a handler passed to Serve, in which case
+// the element is sent after the handler returns.
+// Elements are sent in the order they were queued.
+//
+// Only errors reading the element from r are returned.
+// An error writing the element is not reported, but it will generally leave the
+// session unusable so that any future sends will also fail.
+//
+// SendDeferred is safe for concurrent use by multiple goroutines.
+func (s *Session) SendDeferred(r xml.TokenReader) error {
+	var toks tokenBuffer
+	tok, err := r.Token()
+	if err != nil {
+		return err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return errNotStart
+	}
+	toks = append(toks, start.Copy())
+	_, err = xmlstream.Copy(&toks, xmlstream.Inner(r))
+	if err != nil {
+		return err
+	}
+	toks = append(toks, start.End())
+
+	s.deferredMu.Lock()
+	s.deferred = append(s.deferred, toks)
+	s.deferredMu.Unlock()
+
+	s.workerWG.Add(1)
+	go func() {
+		defer s.workerWG.Done()
+		s.deferredSendMu.Lock()
+		defer s.deferredSendMu.Unlock()
+
+		s.deferredMu.Lock()
+		queue := s.deferred
+		s.deferred = nil
+		s.deferredMu.Unlock()
+
+		for _, el := range queue {
+			err := send(context.Background(), s, el.Reader(), nil)
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Shutdown gracefully shuts down the session.
+// It ends the output stream (waiting for any sends that are in progress to be
+// flushed first) and any further attempts to send return an error.
+// It then waits for the remote entity to close the input stream before closing
+// the underlying connection.
+// If the context has a deadline it is used as the close deadline (see
+// SetCloseDeadline).
+// If the context is canceled before the remote entity closes its stream, the
+// connection is closed immediately and the context error is returned.
+//
+// Shutdown relies on a concurrent call to Serve to read the closing tag from
+// the remote entity, otherwise it waits until the context is canceled.
+func (s *Session) Shutdown(ctx context.Context) error {
+	err := s.Close()
+	if deadline, ok := ctx.Deadline(); ok && err == nil {
+		err = s.SetCloseDeadline(deadline)
+	}
+	if err == nil {
+		select {
+		case <-s.inClosed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	closeErr := s.Conn().Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func (s *Session) closeSession() error {
 	if s.state&OutputStreamClosed == OutputStreamClosed {
 		return nil
 	}
 
 	s.state |= OutputStreamClosed
+	s.cancel()
+	s.queue.close()
+	s.publish(OutputClosedEvent, nil)
+	if s.lifetime != nil {
+		s.lifetime.Stop()
+	}
+	// An abandoned write may still be in progress, so writing the closing tag
+	// could interleave with it.
+	if s.out.cancel.wasAbandoned() {
+		return errWriteAbandoned
+	}
+	// Any batched output must be written before the closing tag since the tag is
+	// written directly to the connection.
+	if s.out.batch != nil {
+		if err := s.out.batch.Flush(); err != nil {
+			return err
+		}
+	}
 	// We wrote the opening stream instead of encoding it, so do the same with the
 	// closing to ensure that the encoder doesn't think the tokens are mismatched.
+	var n int
 	var err error
 	switch xmlns := s.out.Info.Name.Space; xmlns {
 	case ns.WS:
-		_, err = s.Conn().Write([]byte(closeStreamWSTag))
+		n, err = s.Conn().Write([]byte(closeStreamWSTag))
 	default:
 		// case stream.NS:
-		_, err = s.Conn().Write([]byte(closeStreamTag))
+		n, err = s.Conn().Write([]byte(closeStreamTag))
 	}
-	return err
+	if n > 0 && s.metrics != nil {
+		s.metrics.BytesWritten(n)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case s.closeNoWait:
+		return s.SetCloseDeadline(time.Now())
+	case s.closeTimeout > 0:
+		return s.SetCloseDeadline(time.Now().Add(s.closeTimeout))
+	}
+	return nil
+}
+
+// closedNoWait reports whether the output stream has been closed and the
+// session was configured not to wait for the input stream to be closed.
+func (s *Session) closedNoWait() bool {
+	return s.closeNoWait && s.State()&OutputStreamClosed == OutputStreamClosed
 }
 
 // State returns the current state of the session. For more information, see the
@@ -781,6 +1439,17 @@ func (s *Session) OutSID() string {
 	return s.out.ID
 }
 
+// InLang returns the default language (xml:lang) declared on the input stream.
+func (s *Session) InLang() string {
+	return s.in.Lang
+}
+
+// OutLang returns the default language (xml:lang) declared on the output
+// stream.
+func (s *Session) OutLang() string {
+	return s.out.Lang
+}
+
 // LocalAddr returns the Origin address for initiated connections, or the
 // Location for received connections.
 func (s *Session) LocalAddr() jid.JID {
@@ -793,11 +1462,37 @@ func (s *Session) RemoteAddr() jid.JID {
 	return s.in.Info.From
 }
 
+// setLocalAddr changes the address of the local entity, for example after a
+// resource has been bound.
+// It must only be called during stream negotiation.
+func (s *Session) setLocalAddr(j jid.JID) {
+	s.in.Info.To = j
+	s.out.Info.From = j
+}
+
+// setRemoteAddr changes the address of the remote entity, for example after it
+// has authenticated.
+// It must only be called during stream negotiation.
+func (s *Session) setRemoteAddr(j jid.JID) {
+	s.in.Info.From = j
+	s.out.Info.To = j
+}
+
+// SetRemoteAddr changes the address of the remote entity.
+// It is meant to be used by stream features that authenticate the remote
+// entity, such as server dialback, and must only be called from a feature's
+// Negotiate function.
+func (s *Session) SetRemoteAddr(j jid.JID) {
+	s.setRemoteAddr(j)
+}
+
 // SetCloseDeadline sets a deadline for the input stream to be closed by the
 // other side.
 // If the input stream is not closed by the deadline, the input stream is marked
 // as closed and any blocking calls to Serve will return an error.
 // This is normally called just before a call to Close.
+// To set a default deadline for every session see the CloseTimeout field on
+// StreamConfig.
 func (s *Session) SetCloseDeadline(t time.Time) error {
 	oldCancel := s.in.cancel
 	s.in.ctx, s.in.cancel = context.WithDeadline(context.Background(), t)
@@ -834,45 +1529,94 @@ func (s *Session) EncodeIQElement(ctx context.Context, payload interface{}, iq s
 }
 
 // Encode writes the XML encoding of v to the stream.
+// If the context is canceled before the write completes, Encode returns the
+// context error.
+//
+// If the WriteQueue option was set on the StreamConfig, Encode returns as soon
+// as the element has been added to the queue (see WriteQueue for details).
 //
 // For more information see "encoding/xml".Encode.
-func (s *Session) Encode(ctx context.Context, v interface{}) error {
-	s.out.Lock()
-	defer s.out.Unlock()
-
-	if deadline, ok := ctx.Deadline(); ok {
-		err := s.conn.SetDeadline(deadline)
+func (s *Session) Encode(ctx context.Context, v interface{}) (err error) {
+	if s.queue != nil {
+		var buf tokenBuffer
+		err := marshal.EncodeXML(&buf, v)
+		if err != nil {
+			return err
+		}
+		return s.enqueue(ctx, buf)
+	}
+	if len(s.interceptors) > 0 || s.strict {
+		r, err := marshal.TokenReader(v)
 		if err != nil {
 			return err
 		}
-		/* #nosec */
-		defer s.conn.SetDeadline(time.Time{})
+		return send(ctx, s, r, nil)
 	}
 
-	return marshal.EncodeXML(s.out.e, v)
+	err = s.limiter.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.out.Lock()
+	defer s.out.Unlock()
+
+	w, stop, err := s.watchWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = stop(err)
+	}()
+
+	return marshal.EncodeXML(w, v)
 }
 
 // EncodeElement writes the XML encoding of v to the stream, using start as the
 // outermost tag in the encoding.
 //
+// If the WriteQueue option was set on the StreamConfig, EncodeElement returns
+// as soon as the element has been added to the queue (see WriteQueue for
+// details).
+//
 // For more information see "encoding/xml".EncodeElement.
-func (s *Session) EncodeElement(ctx context.Context, v interface{}, start xml.StartElement) error {
-	s.out.Lock()
-	defer s.out.Unlock()
-
-	if deadline, ok := ctx.Deadline(); ok {
-		err := s.conn.SetDeadline(deadline)
+func (s *Session) EncodeElement(ctx context.Context, v interface{}, start xml.StartElement) (err error) {
+	if len(s.interceptors) > 0 || s.strict || s.queue != nil {
+		var buf tokenBuffer
+		err := marshal.EncodeXMLElement(&buf, v, start)
 		if err != nil {
 			return err
 		}
-		/* #nosec */
-		defer s.conn.SetDeadline(time.Time{})
+		if s.queue != nil {
+			return s.enqueue(ctx, buf)
+		}
+		return send(ctx, s, buf.Reader(), nil)
 	}
 
-	return marshal.EncodeXMLElement(s.out.e, v, start)
+	err = s.limiter.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.out.Lock()
+	defer s.out.Unlock()
+
+	w, stop, err := s.watchWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = stop(err)
+	}()
+
+	return marshal.EncodeXMLElement(w, v, start)
 }
 
 // Send transmits the first element read from the provided token reader.
+// If the context is canceled before the element has been written, Send stops
+// writing and returns the context error.
+// A canceled send may leave a partial element on the stream, so the session
+// should be closed after Send returns a context error.
 //
 // Send is safe for concurrent use by multiple goroutines.
 func (s *Session) Send(ctx context.Context, r xml.TokenReader) error {
@@ -881,31 +1625,140 @@ func (s *Session) Send(ctx context.Context, r xml.TokenReader) error {
 
 // SendElement is like Send except that it uses start as the outermost tag in
 // the encoding and uses the entire token stream as the payload.
+// If r is nil, the element is sent with no payload.
 //
 // SendElement is safe for concurrent use by multiple goroutines.
 func (s *Session) SendElement(ctx context.Context, r xml.TokenReader, start xml.StartElement) error {
 	return send(ctx, s, r, &start)
 }
 
-func send(ctx context.Context, s *Session, r xml.TokenReader, start *xml.StartElement) error {
+// SendMessage sends a message stanza with the provided payload.
+// If msg does not have an ID, a random ID is assigned.
+// The ID of the message that was sent is returned so that responses such as
+// delivery receipts and errors can be correlated with the message.
+//
+// Before the message is sent its addressing is validated: on client-to-server
+// sessions the from address must be empty or match the session's local
+// address, and on server-to-server sessions both addresses must be set.
+// If validation fails an error wrapping ErrInvalidAddress is returned and
+// nothing is sent.
+//
+// SendMessage is safe for concurrent use by multiple goroutines.
+func (s *Session) SendMessage(ctx context.Context, msg stanza.Message, payload xml.TokenReader) (id string, err error) {
+	err = s.checkAddress(msg.To, msg.From, true)
+	if err != nil {
+		return "", err
+	}
+	if msg.ID == "" {
+		msg.ID = attr.RandomID()
+	}
+	return msg.ID, s.Send(ctx, msg.Wrap(payload))
+}
+
+// SendPresence is like SendMessage except that it sends a presence stanza.
+// Because presence is often broadcast, the to address is not required on
+// server-to-server sessions.
+// For more information see SendMessage.
+//
+// SendPresence is safe for concurrent use by multiple goroutines.
+func (s *Session) SendPresence(ctx context.Context, p stanza.Presence, payload xml.TokenReader) (id string, err error) {
+	err = s.checkAddress(p.To, p.From, false)
+	if err != nil {
+		return "", err
+	}
+	if p.ID == "" {
+		p.ID = attr.RandomID()
+	}
+	return p.ID, s.Send(ctx, p.Wrap(payload))
+}
+
+// checkAddress validates the addressing of a stanza that is about to be sent.
+func (s *Session) checkAddress(to, from jid.JID, requireTo bool) error {
+	state := s.State()
+	switch {
+	case state&S2S == S2S:
+		if from.Equal(jid.JID{}) {
+			return fmt.Errorf("stanzas sent over server-to-server sessions must have a from address: %w", ErrInvalidAddress)
+		}
+		if requireTo && to.Equal(jid.JID{}) {
+			return fmt.Errorf("stanzas sent over server-to-server sessions must have a to address: %w", ErrInvalidAddress)
+		}
+	case state&Received == 0:
+		if !from.Equal(jid.JID{}) && !from.Equal(s.LocalAddr()) {
+			return fmt.Errorf("from address %s does not match the session address %s: %w", from, s.LocalAddr(), ErrInvalidAddress)
+		}
+	}
+	return nil
+}
+
+func send(ctx context.Context, s *Session, r xml.TokenReader, start *xml.StartElement) (err error) {
+	err = s.limiter.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Anything queued by Encode must be written first so that elements sent from
+	// a single goroutine stay in order.
+	s.queue.wait()
+	return writeElement(ctx, s, r, start)
+}
+
+// enqueue adds an encoded element to the write queue.
+func (s *Session) enqueue(ctx context.Context, el tokenBuffer) error {
+	if s.strict && len(el) > 0 {
+		if start, ok := el[0].(xml.StartElement); ok && isStanzaEmptySpace(start.Name) {
+			// Validate before queueing so that invalid stanzas are reported to the
+			// caller instead of failing the queue.
+			err := stanza.Validate(el.Reader())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := s.limiter.wait(ctx)
+	if err != nil {
+		return err
+	}
+	return s.queue.push(ctx, el)
+}
+
+// writeElement writes the first element read from r (or the payload r wrapped
+// in start if start is not nil) to the output stream.
+func writeElement(ctx context.Context, s *Session, r xml.TokenReader, start *xml.StartElement) (err error) {
 	s.out.Lock()
 	defer s.out.Unlock()
 
-	if deadline, ok := ctx.Deadline(); ok {
-		err := s.conn.SetDeadline(deadline)
+	w, stop, err := s.watchWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = stop(err)
+	}()
+
+	if r == nil {
+		r = xmlstream.MultiReader()
+	}
+	if start == nil {
+		tok, err := r.Token()
 		if err != nil {
 			return err
 		}
-		/* #nosec */
-		defer s.conn.SetDeadline(time.Time{})
+
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			return errNotStart
+		}
+		start = &el
+		r = xmlstream.Inner(r)
 	}
 
-	if start == nil {
+	if len(s.interceptors) > 0 && isStanzaEmptySpace(start.Name) {
+		r = s.intercept(xmlstream.Wrap(r, *start), *start)
 		tok, err := r.Token()
 		if err != nil {
 			return err
 		}
-
 		el, ok := tok.(xml.StartElement)
 		if !ok {
 			return errNotStart
@@ -914,19 +1767,231 @@ func send(ctx context.Context, s *Session, r xml.TokenReader, start *xml.StartEl
 		r = xmlstream.Inner(r)
 	}
 
-	err := s.out.e.EncodeToken(*start)
+	if s.strict && isStanzaEmptySpace(start.Name) {
+		var buf tokenBuffer
+		_, err = xmlstream.Copy(&buf, xmlstream.Wrap(r, *start))
+		if err != nil {
+			return err
+		}
+		err = stanza.Validate(buf.Reader())
+		if err != nil {
+			return err
+		}
+		r = buf[1 : len(buf)-1].Reader()
+	}
+
+	err = w.EncodeToken(*start)
 	if err != nil {
 		return err
 	}
-	_, err = xmlstream.Copy(s.out.e, r)
+	_, err = xmlstream.Copy(w, r)
 	if err != nil {
 		return err
 	}
-	err = s.out.e.EncodeToken(start.End())
+	err = w.EncodeToken(start.End())
 	if err != nil {
 		return err
 	}
-	return s.out.e.Flush()
+	return w.Flush()
+}
+
+// aLongTimeAgo is a non-zero time in the past used to immediately unblock
+// writes on the underlying connection.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// cancelChunkSize is the maximum number of bytes that cancelWriter writes to
+// the underlying writer at once.
+const cancelChunkSize = 4096
+
+// errWriteAbandoned is returned by writes after an earlier write was abandoned
+// because its context was canceled.
+var errWriteAbandoned = errors.New("xmpp: output stream is unusable after a canceled write")
+
+// cancelWriter writes to the underlying connection in chunks and stops as soon
+// as the context of the current write is canceled, even if the underlying
+// writer does not support deadlines.
+// If a write is blocked when the context is canceled it is abandoned and all
+// future writes fail since part of an element may have been written.
+type cancelWriter struct {
+	w io.Writer
+
+	mu        sync.Mutex
+	ctx       context.Context
+	abandoned bool
+}
+
+type writeResult struct {
+	n   int
+	err error
+}
+
+// setContext sets the context used for future writes.
+// A nil context means writes can not be canceled.
+func (w *cancelWriter) setContext(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ctx = ctx
+}
+
+// wasAbandoned reports whether a write was abandoned.
+// It is safe to call wasAbandoned on a nil writer.
+func (w *cancelWriter) wasAbandoned() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.abandoned
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.abandoned {
+		return 0, errWriteAbandoned
+	}
+	if w.ctx == nil || w.ctx.Done() == nil {
+		return w.w.Write(p)
+	}
+
+	var n int
+	for len(p) > 0 {
+		if err := w.ctx.Err(); err != nil {
+			return n, err
+		}
+		chunk := p
+		if len(chunk) > cancelChunkSize {
+			chunk = chunk[:cancelChunkSize]
+		}
+		// The chunk is copied because the write may still be in progress after we
+		// return and the caller reuses p.
+		chunk = append([]byte(nil), chunk...)
+		c := make(chan writeResult, 1)
+		go func() {
+			nn, err := w.w.Write(chunk)
+			c <- writeResult{n: nn, err: err}
+		}()
+		var r writeResult
+		select {
+		case r = <-c:
+		case <-w.ctx.Done():
+			// Prefer the result if the write completed at the same time.
+			select {
+			case r = <-c:
+			default:
+				w.abandoned = true
+				return n, w.ctx.Err()
+			}
+		}
+		n += r.n
+		if r.err != nil {
+			return n, r.err
+		}
+		p = p[r.n:]
+	}
+	return n, nil
+}
+
+// ctxWriter is a token writer that stops writing and returns the context error
+// as soon as the context is canceled.
+type ctxWriter struct {
+	ctx context.Context
+	w   xmlstream.TokenWriteFlusher
+}
+
+func (w ctxWriter) EncodeToken(t xml.Token) error {
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	default:
+	}
+	return w.w.EncodeToken(t)
+}
+
+func (w ctxWriter) Flush() error {
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	default:
+	}
+	return w.w.Flush()
+}
+
+// watchWrite returns a token writer for the output stream that honors the
+// deadline and cancelation of ctx.
+// If the output stream has already been closed it returns
+// ErrOutputStreamClosed.
+// If the context has a deadline it is set as the write deadline of the
+// underlying connection.
+// If the context is canceled while a write is blocked, the write is abandoned
+// and the write deadline is moved into the past so that connections that
+// support deadlines unblock it.
+// Because an aborted write may leave part of an element on the wire (and
+// corrupts the TLS state if TLS is in use), the session should not be used
+// after a canceled write.
+//
+// The returned stop function must be called once writing is complete.
+// It releases any resources used to watch the context and replaces the error
+// from the write with the context error if the context was canceled.
+// The output lock must be held when watchWrite is called and until stop
+// returns.
+func (s *Session) watchWrite(ctx context.Context) (xmlstream.TokenWriteFlusher, func(error) error, error) {
+	if s.State()&OutputStreamClosed == OutputStreamClosed {
+		return nil, nil, ErrOutputStreamClosed
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		err := s.conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	done := ctx.Done()
+	if done == nil {
+		return s.out.e, func(err error) error {
+			if hasDeadline {
+				/* #nosec */
+				s.conn.SetWriteDeadline(time.Time{})
+			}
+			return err
+		}, nil
+	}
+
+	s.out.cancel.setContext(ctx)
+
+	stopWatch := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		select {
+		case <-done:
+			/* #nosec */
+			s.conn.SetWriteDeadline(aLongTimeAgo)
+		case <-stopWatch:
+		}
+	}()
+
+	return ctxWriter{ctx: ctx, w: s.out.e}, func(err error) error {
+		close(stopWatch)
+		<-watchDone
+		s.out.cancel.setContext(nil)
+		canceled := ctx.Err() != nil
+		// If a write was abandoned it may still be blocked on the connection, so
+		// leave the write deadline in the past to unblock it.
+		if (hasDeadline || canceled) && !s.out.cancel.wasAbandoned() {
+			/* #nosec */
+			s.conn.SetWriteDeadline(time.Time{})
+		}
+		if err != nil && canceled {
+			return ctx.Err()
+		}
+		return err
+	}, nil
 }
 
 func iqNeedsResp(attrs []xml.Attr) bool {
@@ -974,8 +2039,13 @@ func isStanzaEmptySpace(name xml.Name) bool {
 //
 // If the context is closed before the response is received, SendIQ immediately
 // returns the context error.
+// If the context does not have a deadline and IQTimeout was set on the
+// session's StreamConfig, the timeout is applied to the context.
 // Any response received at a later time will not be associated with the
 // original request but can still be handled by the Serve handler.
+// Similarly, if the input stream is closed before the response is received (for
+// example because Serve returned after reading a stream error or EOF), SendIQ
+// immediately returns an error wrapping ErrInputStreamClosed.
 //
 // If an error is returned, the response will be nil; the converse is not
 // necessarily true.
@@ -1015,6 +2085,14 @@ func (s *Session) SendIQ(ctx context.Context, r xml.TokenReader) (xmlstream.Toke
 	return nil, s.SendElement(ctx, xmlstream.Inner(r), start)
 }
 
+// PendingIQs returns the number of IQs sent using SendIQ (or any of the methods
+// that depend on it) that are still waiting for a response.
+func (s *Session) PendingIQs() int {
+	s.sentIQMutex.Lock()
+	defer s.sentIQMutex.Unlock()
+	return len(s.sentIQs)
+}
+
 // SendIQElement is like SendIQ except that it wraps the payload in an
 // Info/Query (IQ) element.
 // For more information see SendIQ.
@@ -1042,6 +2120,31 @@ func (s *Session) UnmarshalIQElement(ctx context.Context, payload xml.TokenReade
 	return unmarshalIQ(ctx, iq.Wrap(payload), v, s)
 }
 
+// GetIQ sends an IQ of type get to the provided address with the XML encoding
+// of payload as its child and decodes the payload of the response into v.
+// If the response is an error IQ, the error is decoded and returned as a
+// stanza.Error.
+// If payload is nil an empty IQ is sent, and if v is nil the payload of the
+// response is discarded.
+//
+// GetIQ is like UnmarshalIQElement except that payload may be any value that
+// can be marshaled by "encoding/xml".
+// This module supports versions of Go that do not have type parameters, so
+// the response is decoded into v instead of being returned.
+//
+// GetIQ is safe for concurrent use by multiple goroutines.
+func GetIQ(ctx context.Context, s *Session, to jid.JID, payload, v interface{}) error {
+	var r xml.TokenReader
+	if payload != nil {
+		var err error
+		r, err = marshal.TokenReader(payload)
+		if err != nil {
+			return err
+		}
+	}
+	return s.UnmarshalIQElement(ctx, r, stanza.IQ{To: to, Type: stanza.GetIQ}, v)
+}
+
 // IterIQ is like SendIQ except that error replies are unmarshaled into a
 // stanza.Error and returned and otherwise an iterator over the children of the
 // response payload is returned.
@@ -1146,7 +2249,13 @@ func unmarshalIQ(ctx context.Context, iq xml.TokenReader, v interface{}, s *Sess
 }
 
 func (s *Session) sendResp(ctx context.Context, id string, payload xml.TokenReader, start xml.StartElement) (xmlstream.TokenReadCloser, error) {
+	if _, ok := ctx.Deadline(); !ok && s.iqTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.iqTimeout)
+		defer cancel()
+	}
 	c := make(chan xmlstream.TokenReadCloser)
+	sent := time.Now()
 
 	s.sentIQMutex.Lock()
 	s.sentIQs[id] = c
@@ -1164,92 +2273,166 @@ func (s *Session) sendResp(ctx context.Context, id string, payload xml.TokenRead
 
 	select {
 	case rr := <-c:
+		if s.metrics != nil {
+			s.metrics.IQLatency(time.Since(sent))
+		}
 		return rr, nil
 	case <-ctx.Done():
 		close(c)
 		return nil, ctx.Err()
+	case <-s.inClosed:
+		close(c)
+		return nil, s.inputClosedErr()
 	}
 }
 
-// closeInputStream immediately marks the input stream as closed and cancels any
-// deadlines associated with it.
-func (s *Session) closeInputStream() {
+// closeInputStream immediately marks the input stream as closed, cancels any
+// deadlines associated with it, and causes any pending IQs to fail.
+// If err is non-nil it is recorded as the reason that the stream was closed.
+func (s *Session) closeInputStream(err error) {
 	s.in.Lock()
 	defer s.in.Unlock()
 	s.stateMutex.Lock()
 	defer s.stateMutex.Unlock()
+	if s.state&InputStreamClosed == 0 {
+		s.inErr = err
+		close(s.inClosed)
+		s.publish(InputClosedEvent, err)
+	}
+	s.cancel()
 	s.state |= InputStreamClosed
 	s.in.cancel()
 }
 
+// inputClosedErr returns the error that pending IQs fail with when the input
+// stream is closed.
+// It must only be called after inClosed has been closed.
+// It does not lock stateMutex because Close may hold it while blocked on a
+// write to a remote entity that has stopped reading.
+func (s *Session) inputClosedErr() error {
+	if s.inErr != nil {
+		return fmt.Errorf("%w: %v", ErrInputStreamClosed, s.inErr)
+	}
+	return ErrInputStreamClosed
+}
+
+// attrPool holds the backing arrays used by stanzaEncoder when it has to
+// rewrite the attributes of a start element.
+// They are returned to the pool as soon as the token has been encoded, which
+// is safe because the xml.Encoder does not retain attributes.
+var attrPool = sync.Pool{
+	New: func() interface{} {
+		attrs := make([]xml.Attr, 0, 8)
+		return &attrs
+	},
+}
+
 type stanzaEncoder struct {
 	xmlstream.TokenWriteFlusher
 	depth int
-	from  jid.JID
-	ns    string
+	// from is the pre-computed string form of the address added to stanzas
+	// that do not have a from attribute, or the empty string.
+	from    string
+	ns      string
+	metrics Metrics
+}
+
+// dropAttr reports whether an attribute should be removed from a start
+// element before it is encoded.
+func dropAttr(start xml.StartElement, a xml.Attr, stanza bool) bool {
+	// For all start elements, regardless of depth, prevent duplicate xmlns
+	// attributes. See https://mellium.im/issue/75
+	// Prefix declarations are also removed since the encoder declares any
+	// prefixes it needs itself and would otherwise write the declarations out
+	// as attributes in a bogus namespace.
+	if a.Name.Local == "xmlns" && start.Name.Space != "" || a.Name.Space == "xmlns" {
+		return true
+	}
+	if !stanza || a.Value != "" {
+		return false
+	}
+	switch a.Name.Local {
+	case "id", "from":
+		// RFC6120 § 8.1.3
+		// For <message/> and <presence/> stanzas, it is RECOMMENDED for the
+		// originating entity to include an 'id' attribute; for <iq/> stanzas,
+		// it is REQUIRED.
+		//
+		// RFC6120 § 4.7.1
+		// the 'to' and 'from' attributes are OPTIONAL on stanzas sent over
+		// XML streams qualified by the 'jabber:client' namespace, whereas
+		// they are REQUIRED on stanzas sent over XML streams qualified by the
+		// 'jabber: server' namespace
+		//
+		// Empty values are removed and replaced with generated ones below.
+		return true
+	}
+	return false
 }
 
 func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 	switch tok := t.(type) {
 	case xml.StartElement:
 		se.depth++
-		// Add required attributes if missing:
-		if se.depth == 1 && isStanzaEmptySpace(tok.Name) {
-			if tok.Name.Space == "" {
-				tok.Name.Space = se.ns
-			}
-			var foundID, foundFrom bool
-			attrs := tok.Attr[:0]
-			for _, attr := range tok.Attr {
-				switch attr.Name.Local {
-				case "id":
-					// RFC6120 § 8.1.3
-					// For <message/> and <presence/> stanzas, it is RECOMMENDED for the
-					// originating entity to include an 'id' attribute; for <iq/> stanzas,
-					// it is REQUIRED.
-					if attr.Value == "" {
-						continue
-					}
-					foundID = true
-				case "from":
-					// RFC6120 § 4.7.1
-					// the 'to' and 'from' attributes are OPTIONAL on stanzas sent over
-					// XML streams qualified by the 'jabber:client' namespace, whereas
-					// they are REQUIRED on stanzas sent over XML streams qualified by the
-					// 'jabber: server' namespace
-					if attr.Value == "" {
-						continue
-					}
-					foundFrom = true
-				}
-				attrs = append(attrs, attr)
-			}
-			tok.Attr = attrs
-			if f := se.from.String(); f != "" && !foundFrom {
-				tok.Attr = append(tok.Attr, xml.Attr{
-					Name:  xml.Name{Local: "from"},
-					Value: se.from.String(),
-				})
+		stanza := se.depth == 1 && isStanzaEmptySpace(tok.Name)
+		if stanza && tok.Name.Space == "" {
+			tok.Name.Space = se.ns
+		}
+		if stanza && se.metrics != nil {
+			_, typ := attr.Get(tok.Attr, "type")
+			se.metrics.StanzaSent(tok.Name.Local, typ)
+		}
+
+		// Most elements do not need their attributes rewritten, so check first
+		// and avoid copying them.
+		var drop, foundID, foundFrom bool
+		for _, a := range tok.Attr {
+			if dropAttr(tok, a, stanza) {
+				drop = true
+				continue
 			}
-			if !foundID {
-				tok.Attr = append(tok.Attr, xml.Attr{
-					Name:  xml.Name{Local: "id"},
-					Value: attr.RandomID(),
-				})
+			switch a.Name.Local {
+			case "id":
+				foundID = true
+			case "from":
+				foundFrom = true
 			}
 		}
+		addFrom := stanza && !foundFrom && se.from != ""
+		addID := stanza && !foundID
+		if !drop && !addFrom && !addID {
+			return se.TokenWriteFlusher.EncodeToken(tok)
+		}
 
-		// For all start elements, regardless of depth, prevent duplicate xmlns
-		// attributes. See https://mellium.im/issue/75
-		attrs := tok.Attr[:0]
-		for _, attr := range tok.Attr {
-			if attr.Name.Local == "xmlns" && tok.Name.Space != "" {
-				continue
+		// Never modify the callers attributes in place.
+		buf := attrPool.Get().(*[]xml.Attr)
+		attrs := (*buf)[:0]
+		for _, a := range tok.Attr {
+			if !dropAttr(tok, a, stanza) {
+				attrs = append(attrs, a)
 			}
-			attrs = append(attrs, attr)
+		}
+		if addFrom {
+			attrs = append(attrs, xml.Attr{
+				Name:  xml.Name{Local: "from"},
+				Value: se.from,
+			})
+		}
+		if addID {
+			attrs = append(attrs, xml.Attr{
+				Name:  xml.Name{Local: "id"},
+				Value: attr.RandomID(),
+			})
 		}
 		tok.Attr = attrs
-		t = tok
+		err := se.TokenWriteFlusher.EncodeToken(tok)
+		// Clear the attributes so that the pool does not keep their values alive.
+		for i := range attrs {
+			attrs[i] = xml.Attr{}
+		}
+		*buf = attrs[:0]
+		attrPool.Put(buf)
+		return err
 	case xml.EndElement:
 		if se.depth == 1 && tok.Name.Space == "" && isStanzaEmptySpace(tok.Name) {
 			tok.Name.Space = se.ns
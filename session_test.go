@@ -11,19 +11,25 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
 	intstream "mellium.im/xmpp/internal/stream"
-	"mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/stream"
+	"mellium.im/xmpp/xmpptest"
 )
 
 var _ fmt.Stringer = xmpp.SessionState(0)
@@ -111,6 +117,20 @@ var readyFeature = xmpp.StreamFeature{
 	},
 }
 
+// helperNegotiator is a negotiator built from the public negotiation helpers.
+func helperNegotiator(ctx context.Context, in, out *stream.Info, session *xmpp.Session, data interface{}) (xmpp.SessionState, io.ReadWriter, interface{}, error) {
+	err := xmpp.SendStreamStart(session, out, false)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	err = xmpp.ExpectStreamStart(ctx, session, in, false)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	mask, rw, err := xmpp.NegotiateFeatures(ctx, session, data == nil, false, []xmpp.StreamFeature{readyFeature})
+	return mask, rw, true, err
+}
+
 var negotiateTests = [...]negotiateTestCase{
 	0: {negotiator: errNegotiator, err: errTestNegotiate},
 	1: {
@@ -140,6 +160,119 @@ var negotiateTests = [...]negotiateTestCase{
 		initialState: xmpp.S2S,
 		finalState:   xmpp.Ready | xmpp.S2S,
 	},
+	4: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Lang: "de",
+			LangMismatch: func(requested, received string) error {
+				return fmt.Errorf("lang mismatch: %s %s", requested, received)
+			},
+		}),
+		in:           `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out:          `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:server' xmlns:stream='http://etherx.jabber.org/streams' version='1.0' xml:lang='de'>`,
+		err:          errors.New("lang mismatch: de en"),
+		initialState: xmpp.S2S,
+		finalState:   xmpp.S2S,
+	},
+	5: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Lang: "en",
+			LangMismatch: func(requested, received string) error {
+				return fmt.Errorf("lang mismatch: %s %s", requested, received)
+			},
+			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				return []xmpp.StreamFeature{readyFeature}
+			},
+		}),
+		in:           `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out:          `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:server' xmlns:stream='http://etherx.jabber.org/streams' version='1.0' xml:lang='en'>`,
+		initialState: xmpp.S2S,
+		finalState:   xmpp.Ready | xmpp.S2S,
+	},
+	6: {
+		negotiator:   helperNegotiator,
+		in:           `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out:          `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:server' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`,
+		initialState: xmpp.S2S,
+		finalState:   xmpp.Ready | xmpp.S2S,
+	},
+	7: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				return []xmpp.StreamFeature{readyFeature}
+			},
+			Required: []string{"urn:example"},
+		}),
+		in:           `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features></stream:features>`,
+		out:          `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:server' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`,
+		err:          errors.New("xmpp: required stream feature urn:example was not advertised"),
+		initialState: xmpp.S2S,
+		finalState:   xmpp.S2S,
+	},
+	8: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				f := readyFeature
+				f.Necessary = xmpp.Secure
+				return []xmpp.StreamFeature{f}
+			},
+		}),
+		in:           `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out:          `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:server' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`,
+		err:          errors.New("xmpp: stream feature urn:example cannot be negotiated without session state Secure"),
+		initialState: xmpp.S2S,
+		finalState:   xmpp.S2S,
+	},
+	9: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				return []xmpp.StreamFeature{readyFeature}
+			},
+			Required: []string{"urn:example"},
+		}),
+		in:           `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out:          `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:server' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`,
+		initialState: xmpp.S2S,
+		finalState:   xmpp.Ready | xmpp.S2S,
+	},
+	10: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				f := readyFeature
+				f.Prohibited = xmpp.Authn
+				return []xmpp.StreamFeature{f}
+			},
+		}),
+		in:  `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:client'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out: `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`,
+		err: errors.New("xmpp: stream feature urn:example cannot be negotiated without session state Secure"),
+	},
+	11: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				f := readyFeature
+				f.Prohibited = xmpp.Authn
+				return []xmpp.StreamFeature{f}
+			},
+			AllowInsecure: true,
+		}),
+		in:         `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:client'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out:        `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`,
+		finalState: xmpp.Ready,
+	},
+	12: {
+		negotiator: xmpp.NewNegotiator(xmpp.StreamConfig{
+			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				f := readyFeature
+				f.Necessary = xmpp.Secure
+				f.Prohibited = xmpp.Authn
+				return []xmpp.StreamFeature{f}
+			},
+			AllowInsecure: true,
+		}),
+		in:  `<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:client'><stream:features><ready xmlns='urn:example'/></stream:features>`,
+		out: `<?xml version="1.0" encoding="UTF-8"?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`,
+		err: errors.New("xmpp: stream feature urn:example cannot be negotiated without session state Secure"),
+	},
 }
 
 func TestNegotiator(t *testing.T) {
@@ -428,3 +561,1114 @@ func TestNegotiateStreamError(t *testing.T) {
 		t.Errorf("unexpected client err: want=%v, got=%v", stream.Conflict, err)
 	}
 }
+
+func TestNetConn(t *testing.T) {
+	s := xmpptest.NewSession(0, &bytes.Buffer{})
+	if _, ok := s.NetConn(); ok {
+		t.Errorf("expected no net.Conn for session not created with one")
+	}
+	if _, ok := s.TLSState(); ok {
+		t.Errorf("expected no TLS state for session without TLS")
+	}
+	if err := s.SetKeepAlive(time.Minute); err != xmpp.ErrNoKeepAlive {
+		t.Errorf("unexpected error setting keep-alive: want=%v, got=%v", xmpp.ErrNoKeepAlive, err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			/* #nosec */
+			c.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer conn.Close()
+
+	ready := func(context.Context, *stream.Info, *stream.Info, *xmpp.Session, interface{}) (xmpp.SessionState, io.ReadWriter, interface{}, error) {
+		return xmpp.Ready, nil, nil, nil
+	}
+	s, err = xmpp.NewSession(context.Background(), jid.MustParse("example.net"), jid.MustParse("test@example.net"), conn, 0, ready)
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	nc, ok := s.NetConn()
+	if !ok || nc != conn {
+		t.Errorf("wrong net.Conn: want=%v, got=%v (%t)", conn, nc, ok)
+	}
+	if err := s.SetKeepAlive(time.Minute); err != nil {
+		t.Errorf("unexpected error enabling keep-alive: %v", err)
+	}
+	if err := s.SetKeepAlive(0); err != nil {
+		t.Errorf("unexpected error disabling keep-alive: %v", err)
+	}
+}
+
+type blockingWriter chan struct{}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w
+	return len(p), nil
+}
+
+func TestTeeDoesNotBlock(t *testing.T) {
+	block := make(blockingWriter)
+	defer close(block)
+
+	buf := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: buf,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		TeeIn:     block,
+		TeeOut:    block,
+		TeeBuffer: 1,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	const writes = 5
+	for i := 0; i < writes; i++ {
+		err = s.Send(context.Background(), stanza.Message{Type: stanza.ChatMessage}.Wrap(nil))
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+	if _, out := s.TeeDropped(); out == 0 {
+		t.Errorf("expected data to be dropped by the blocked tee")
+	}
+	if n := strings.Count(buf.String(), "<message"); n != writes {
+		t.Errorf("wrong number of messages written: want=%d, got=%d", writes, n)
+	}
+}
+
+func addAttrInterceptor(name, value string) xmpp.Interceptor {
+	return func(r xml.TokenReader, _ xml.StartElement) xml.TokenReader {
+		first := true
+		return xmlstream.ReaderFunc(func() (xml.Token, error) {
+			tok, err := r.Token()
+			if start, ok := tok.(xml.StartElement); ok && first {
+				first = false
+				start.Attr = append(start.Copy().Attr, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+				tok = start
+			}
+			return tok, err
+		})
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: buf,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		Interceptors: []xmpp.Interceptor{
+			addAttrInterceptor("a", "1"),
+			addAttrInterceptor("b", "2"),
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	buf.Reset()
+
+	err = s.Send(context.Background(), stanza.Message{ID: "123", Type: stanza.ChatMessage}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	err = s.Encode(context.Background(), stanza.Presence{ID: "456"})
+	if err != nil {
+		t.Fatalf("error encoding presence: %v", err)
+	}
+	err = s.Send(context.Background(), xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: "urn:example", Local: "nonza"}}))
+	if err != nil {
+		t.Fatalf("error sending nonza: %v", err)
+	}
+
+	out := buf.String()
+	if n := strings.Count(out, `a="1" b="2"`); n != 2 {
+		t.Errorf("expected both stanzas to be intercepted in order, got %d:\n%s", n, out)
+	}
+	if strings.Contains(out, `nonza xmlns="urn:example" a=`) {
+		t.Errorf("did not expect non-stanza elements to be intercepted:\n%s", out)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: &bytes.Buffer{},
+	}
+	const lifetime = 10 * time.Millisecond
+	before := time.Now()
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		MaxLifetime:       lifetime,
+		MaxLifetimeJitter: lifetime,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	expires, ok := s.Expires()
+	if !ok {
+		t.Fatalf("expected session to have a maximum lifetime")
+	}
+	if expires.Before(before.Add(lifetime)) || expires.After(time.Now().Add(2*lifetime)) {
+		t.Errorf("expiry %v out of range", expires)
+	}
+
+	timeout := time.After(time.Second)
+	for s.State()&xmpp.OutputStreamClosed == 0 {
+		select {
+		case <-timeout:
+			t.Fatalf("session was not closed after its maximum lifetime")
+		case <-time.After(lifetime):
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features><message id='1' from='blocked@example.net'><body>spam</body></message><message id='2' from='juliet@example.net'><body>test</body></message></stream:stream>`),
+		Writer: &bytes.Buffer{},
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		Filters: []xmpp.Filter{
+			func(r xml.TokenReader, start xml.StartElement) (xml.TokenReader, error) {
+				for _, attr := range start.Attr {
+					if attr.Name.Local == "from" && attr.Value == "blocked@example.net" {
+						return nil, nil
+					}
+				}
+				return r, nil
+			},
+			func(r xml.TokenReader, start xml.StartElement) (xml.TokenReader, error) {
+				// Strip the payload.
+				return xmlstream.Wrap(nil, start), nil
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	var handled []string
+	err = s.Serve(xmpp.HandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var b strings.Builder
+		e := xml.NewEncoder(&b)
+		err := e.EncodeToken(*start)
+		if err != nil {
+			return err
+		}
+		_, err = xmlstream.Copy(e, r)
+		if err != nil {
+			return err
+		}
+		err = e.Flush()
+		handled = append(handled, b.String())
+		return err
+	}))
+	if err != nil {
+		t.Fatalf("error serving: %v", err)
+	}
+
+	expected := []string{`<message xmlns="jabber:server" id="2" from="juliet@example.net"></message>`}
+	if !reflect.DeepEqual(handled, expected) {
+		t.Errorf("wrong stanzas handled:\nwant=%v,\n got=%v", expected, handled)
+	}
+}
+
+type notifyWriter chan struct{}
+
+func (w notifyWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func TestPendingIQFailsOnClose(t *testing.T) {
+	pr, pw := io.Pipe()
+	written := make(notifyWriter, 1)
+	go func() {
+		/* #nosec */
+		pw.Write([]byte(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`))
+	}()
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: pr,
+		Writer: written,
+	}, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	select {
+	case <-written:
+	default:
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(nil)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	iqErr := make(chan error, 1)
+	go func() {
+		_, err := s.SendIQ(ctx, stanza.IQ{
+			ID:   "123",
+			Type: stanza.GetIQ,
+			From: jid.MustParse("example.net"),
+		}.Wrap(nil))
+		iqErr <- err
+	}()
+
+	// Once the IQ has been written, break the input stream.
+	<-written
+	/* #nosec */
+	pw.CloseWithError(io.ErrUnexpectedEOF)
+
+	err = <-iqErr
+	if !errors.Is(err, xmpp.ErrInputStreamClosed) {
+		t.Errorf("wrong error from pending IQ: want=%v, got=%v", xmpp.ErrInputStreamClosed, err)
+	}
+	<-serveErr
+}
+
+func TestShutdown(t *testing.T) {
+	pr, pw := io.Pipe()
+	written := make(notifyWriter, 1)
+	go func() {
+		/* #nosec */
+		pw.Write([]byte(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`))
+	}()
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: pr,
+		Writer: written,
+	}, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	select {
+	case <-written:
+	default:
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(nil)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(ctx)
+	}()
+
+	// Once our closing tag is written, close the remote stream.
+	<-written
+	_, err = pw.Write([]byte(`</stream:stream>`))
+	if err != nil {
+		t.Fatalf("error closing remote stream: %v", err)
+	}
+
+	if err = <-shutdownErr; err != nil {
+		t.Errorf("unexpected error shutting down: %v", err)
+	}
+	if err = <-serveErr; err != nil {
+		t.Errorf("unexpected error from serve: %v", err)
+	}
+	if st := s.State(); st&xmpp.OutputStreamClosed == 0 || st&xmpp.InputStreamClosed == 0 {
+		t.Errorf("expected both streams to be closed, got state %v", st)
+	}
+	err = s.Send(context.Background(), stanza.Message{}.Wrap(nil))
+	if err != xmpp.ErrOutputStreamClosed {
+		t.Errorf("wrong error sending after shutdown: want=%v, got=%v", xmpp.ErrOutputStreamClosed, err)
+	}
+}
+
+func TestConcurrentHandlers(t *testing.T) {
+	out := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features><message id='1' from='juliet@example.net'/><message id='2' from='juliet@example.net'/></stream:stream>`),
+		Writer: out,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		ConcurrentHandlers: 2,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	out.Reset()
+
+	second := make(chan struct{})
+	err = s.Serve(xmpp.HandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		_, id := attr.Get(start.Attr, "id")
+		switch id {
+		case "1":
+			// The first handler can only finish if the second one runs concurrently.
+			select {
+			case <-second:
+			case <-time.After(5 * time.Second):
+				return errors.New("handlers were not run concurrently")
+			}
+		case "2":
+			close(second)
+		}
+		return r.EncodeToken(xml.CharData(id))
+	}))
+	if err != nil {
+		t.Fatalf("error serving: %v", err)
+	}
+	if o := out.String(); !strings.HasPrefix(o, "12") && !strings.HasPrefix(o, "21") {
+		t.Errorf("expected output from both handlers, got %q", o)
+	}
+}
+
+func TestSendDeferred(t *testing.T) {
+	out := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features><message id='1' from='juliet@example.net'/></stream:stream>`),
+		Writer: out,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	out.Reset()
+
+	err = s.Serve(xmpp.HandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		// Using any of the normal send methods here would deadlock.
+		err := s.SendDeferred(stanza.Message{ID: "deferred", Type: stanza.ChatMessage}.Wrap(nil))
+		if err != nil {
+			return err
+		}
+		return r.EncodeToken(xml.CharData("handled"))
+	}))
+	if err != nil {
+		t.Fatalf("error serving: %v", err)
+	}
+
+	o := out.String()
+	handled := strings.Index(o, "handled")
+	deferred := strings.Index(o, `id="deferred"`)
+	closed := strings.Index(o, "</stream:stream>")
+	if handled == -1 || deferred == -1 || closed == -1 || handled > deferred || deferred > closed {
+		t.Errorf("expected deferred message to be sent after the handler returned and before the stream was closed, got %q", o)
+	}
+}
+
+func TestIQTimeout(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: &bytes.Buffer{},
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		IQTimeout: 10 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	// Serve is not running so the response will never be read.
+	_, err = s.SendIQ(context.Background(), stanza.IQ{
+		ID:   "123",
+		Type: stanza.GetIQ,
+		From: jid.MustParse("example.net"),
+	}.Wrap(nil))
+	if err != context.DeadlineExceeded {
+		t.Errorf("wrong error: want=%v, got=%v", context.DeadlineExceeded, err)
+	}
+	if n := s.PendingIQs(); n != 0 {
+		t.Errorf("expected no pending IQs after timeout, got %d", n)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	out := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features><iq id='1' type='get' from='juliet@example.net' to='romeo@example.com'></iq><message id='2' type='bad' from='juliet@example.net'/><message id='3' from='juliet@example.net'><body>test</body></message></stream:stream>`),
+		Writer: out,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		Strict: true,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	err = s.Send(context.Background(), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   jid.MustParse("romeo@example.com"),
+		From: jid.MustParse("juliet@example.net"),
+	}.Wrap(nil))
+	if !errors.Is(err, stanza.Error{Condition: stanza.BadRequest}) {
+		t.Errorf("expected sending invalid IQ to fail with bad-request, got %v", err)
+	}
+
+	out.Reset()
+	var handled []string
+	err = s.Serve(xmpp.HandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		_, id := attr.Get(start.Attr, "id")
+		handled = append(handled, id)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("error serving: %v", err)
+	}
+
+	expected := []string{"3"}
+	if !reflect.DeepEqual(handled, expected) {
+		t.Errorf("wrong stanzas handled: want=%v, got=%v", expected, handled)
+	}
+	o := out.String()
+	for _, expectedOut := range []string{
+		`id="1" to="juliet@example.net" from="romeo@example.com" type="error"`,
+		`<bad-request xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">`,
+	} {
+		if !strings.Contains(o, expectedOut) {
+			t.Errorf("expected %s in output, got %s", expectedOut, o)
+		}
+	}
+	if strings.Contains(o, `id="2"`) {
+		t.Errorf("did not expect a reply to the invalid message: %s", o)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: &bytes.Buffer{},
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		RateLimit: 20,
+		RateBurst: 2,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	msg := stanza.Message{To: jid.MustParse("romeo@example.com")}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err = s.Send(context.Background(), msg.Wrap(nil))
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+	// Two messages fit in the burst and the remaining two are delayed by 50ms
+	// each.
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("messages were not rate limited, took %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.Encode(ctx, msg)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected waiting send to be canceled, got %v", err)
+	}
+}
+
+var inputLimitTestCases = [...]struct {
+	cfg   xmpp.StreamConfig
+	in    string
+	err   error
+	close bool
+}{
+	0: {
+		cfg: xmpp.StreamConfig{MaxStanzaSize: 100},
+		in:  `<message id='1' to='romeo@example.com'><body>Short</body></message>`,
+	},
+	1: {
+		cfg:   xmpp.StreamConfig{MaxStanzaSize: 100},
+		in:    `<message id='1' to='romeo@example.com'><body>` + strings.Repeat("a", 64) + `</body></message>`,
+		err:   stream.PolicyViolation,
+		close: true,
+	},
+	2: {
+		cfg:   xmpp.StreamConfig{MaxStanzaSize: 100},
+		in:    `<message id='1' to='romeo@example.com'><body>` + strings.Repeat("a", 10000) + `</body></message>`,
+		err:   stream.PolicyViolation,
+		close: true,
+	},
+	3: {
+		cfg: xmpp.StreamConfig{MaxStanzaDepth: 3},
+		in:  `<message id='1'><a><b/></a></message>`,
+	},
+	4: {
+		cfg:   xmpp.StreamConfig{MaxStanzaDepth: 3},
+		in:    `<message id='1'><a><b><c/></b></a></message>`,
+		err:   stream.PolicyViolation,
+		close: true,
+	},
+	5: {
+		in: `<message id='1'><!-- comment --></message>`,
+	},
+	6: {
+		cfg:   xmpp.StreamConfig{RejectRestrictedXML: true},
+		in:    `<message id='1'><!-- comment --></message>`,
+		err:   stream.RestrictedXML,
+		close: true,
+	},
+}
+
+func TestInputLimits(t *testing.T) {
+	for i, tc := range inputLimitTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := &bytes.Buffer{}
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>` + tc.in + `</stream:stream>`),
+				Writer: out,
+			}
+			cfg := tc.cfg
+			cfg.Features = func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+				return []xmpp.StreamFeature{readyFeature}
+			}
+			s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(cfg))
+			if err != nil {
+				t.Fatalf("error negotiating session: %v", err)
+			}
+			out.Reset()
+			err = s.Serve(xmpp.HandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				for {
+					_, err := r.Token()
+					switch {
+					case err == io.EOF:
+						return nil
+					case err != nil:
+						return err
+					}
+				}
+			}))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			if tc.close && !strings.Contains(out.String(), tc.err.Error()) {
+				t.Errorf("expected stream error to be sent, got: %s", out.String())
+			}
+		})
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	out := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: out,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		FlushInterval: time.Hour,
+		FlushSize:     200,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	out.Reset()
+
+	msg := stanza.Message{ID: "1", To: jid.MustParse("romeo@example.com")}
+	err = s.Send(context.Background(), msg.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending first message: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected output to be buffered, got: %s", out.String())
+	}
+	err = s.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	if !strings.Contains(out.String(), `id="1"`) {
+		t.Fatalf("expected buffered message to be flushed, got: %s", out.String())
+	}
+
+	// Exceeding FlushSize writes the buffered output without waiting.
+	out.Reset()
+	for i := 0; i < 5; i++ {
+		msg.ID = strconv.Itoa(i)
+		err = s.Send(context.Background(), msg.Wrap(nil))
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+	if out.Len() < 200 {
+		t.Errorf("expected output to be flushed once FlushSize was reached, got: %s", out.String())
+	}
+
+	// Closing the session writes any remaining output before the end of the
+	// stream.
+	msg.ID = "last"
+	err = s.Send(context.Background(), msg.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending last message: %v", err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("error closing session: %v", err)
+	}
+	if o := out.String(); !strings.Contains(o, `id="last"`) || !strings.HasSuffix(o, `</stream:stream>`) {
+		t.Errorf("expected remaining output to be flushed before closing, got: %s", o)
+	}
+}
+
+func TestWhitespaceKeepAlive(t *testing.T) {
+	out := &stallWriter{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: out,
+	}
+	const interval = 50 * time.Millisecond
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		WhitespaceKeepAlive: interval,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	defer s.Close()
+	reset := func() {
+		out.mu.Lock()
+		defer out.mu.Unlock()
+		out.buf.Reset()
+	}
+	reset()
+
+	// While the stream is busy no whitespace should be written.
+	msg := stanza.Message{To: jid.MustParse("romeo@example.com")}
+	for i := 0; i < 30; i++ {
+		msg.ID = strconv.Itoa(i)
+		err = s.Send(context.Background(), msg.Wrap(nil))
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+		time.Sleep(interval / 10)
+	}
+	if o := out.String(); strings.HasPrefix(o, " ") || strings.Contains(o, "> ") {
+		t.Errorf("did not expect whitespace while the stream was busy, got: %q", o)
+	}
+
+	// Once the stream is idle whitespace should be written.
+	reset()
+	time.Sleep(4 * interval)
+	o := out.String()
+	if o == "" || strings.Trim(o, " ") != "" {
+		t.Errorf("expected only whitespace while the stream was idle, got: %q", o)
+	}
+}
+
+func TestSendDoesNotModifyAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	attrs := []xml.Attr{
+		{Name: xml.Name{Local: "id"}, Value: ""},
+		{Name: xml.Name{Local: "xmlns"}, Value: "jabber:client"},
+		{Name: xml.Name{Local: "to"}, Value: "juliet@example.com"},
+	}
+	orig := append([]xml.Attr(nil), attrs...)
+	err := s.SendElement(context.Background(), nil, xml.StartElement{
+		Name: xml.Name{Space: "jabber:client", Local: "message"},
+		Attr: attrs,
+	})
+	if err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	if !reflect.DeepEqual(attrs, orig) {
+		t.Errorf("attributes were modified: want=%v, got=%v", orig, attrs)
+	}
+	if out := buf.String(); !strings.Contains(out, `to="juliet@example.com"`) || !strings.Contains(out, `id="`) {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+type testMetrics struct {
+	negotiated      int
+	sent, received  []string
+	written, read   int
+	iqLatencyCalled bool
+}
+
+func (m *testMetrics) Negotiated(time.Duration)        { m.negotiated++ }
+func (m *testMetrics) StanzaSent(kind, typ string)     { m.sent = append(m.sent, kind+":"+typ) }
+func (m *testMetrics) StanzaReceived(kind, typ string) { m.received = append(m.received, kind+":"+typ) }
+func (m *testMetrics) BytesWritten(n int)              { m.written += n }
+func (m *testMetrics) BytesRead(n int)                 { m.read += n }
+func (m *testMetrics) IQLatency(time.Duration)         { m.iqLatencyCalled = true }
+
+func TestMetrics(t *testing.T) {
+	out := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		// Bytes read during negotiation are not recorded, so make sure that the
+		// stanzas are read separately once the session has been established.
+		Reader: io.MultiReader(
+			strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+			strings.NewReader(`<message id='1' type='chat' from='juliet@example.net'/><presence id='2' from='juliet@example.net'/></stream:stream>`),
+		),
+		Writer: out,
+	}
+	m := &testMetrics{}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		Metrics: m,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	if m.negotiated != 1 {
+		t.Errorf("wrong number of negotiations: want=1, got=%d", m.negotiated)
+	}
+
+	out.Reset()
+	err = s.Send(context.Background(), stanza.Message{
+		To:   jid.MustParse("juliet@example.net"),
+		Type: stanza.HeadlineMessage,
+	}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	err = s.Serve(nil)
+	if err != nil {
+		t.Fatalf("error serving: %v", err)
+	}
+
+	if want := []string{"message:headline"}; !reflect.DeepEqual(m.sent, want) {
+		t.Errorf("wrong stanzas sent: want=%v, got=%v", want, m.sent)
+	}
+	if want := []string{"message:chat", "presence:"}; !reflect.DeepEqual(m.received, want) {
+		t.Errorf("wrong stanzas received: want=%v, got=%v", want, m.received)
+	}
+	if m.written != out.Len() {
+		t.Errorf("wrong number of bytes written: want=%d, got=%d", out.Len(), m.written)
+	}
+	if m.read == 0 {
+		t.Errorf("expected bytes read to be recorded")
+	}
+}
+
+func TestStanzaLogger(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features><message id='1' type='chat' from='juliet@example.net' to='romeo@example.com'><body>secret</body></message></stream:stream>`),
+		Writer: &bytes.Buffer{},
+	}
+	var logs []xmpp.StanzaLog
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		StanzaLogger: xmpp.StanzaLogger{
+			Log: func(l xmpp.StanzaLog) {
+				logs = append(logs, l)
+			},
+			Payload: true,
+			Redact:  xmpp.RedactBodies,
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	err = s.Send(context.Background(), stanza.Message{
+		ID:   "2",
+		To:   jid.MustParse("juliet@example.net"),
+		Type: stanza.HeadlineMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData("secret")),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	err = s.Serve(nil)
+	if err != nil {
+		t.Fatalf("error serving: %v", err)
+	}
+
+	if len(logs) != 2 {
+		t.Fatalf("wrong number of stanzas logged: want=2, got=%d", len(logs))
+	}
+	out, in := logs[0], logs[1]
+	if out.Direction != xmpp.Outgoing || out.Kind != "message" || out.Type != "headline" || out.ID != "2" || out.To.String() != "juliet@example.net" {
+		t.Errorf("wrong outgoing log entry: %+v", out)
+	}
+	if in.Direction != xmpp.Incoming || in.Kind != "message" || in.Type != "chat" || in.ID != "1" || in.From.String() != "juliet@example.net" || in.To.String() != "romeo@example.com" {
+		t.Errorf("wrong incoming log entry: %+v", in)
+	}
+	for _, l := range logs {
+		if l.Size <= len(l.Payload) {
+			t.Errorf("expected redacted payload to be smaller than the stanza: size=%d, payload=%q", l.Size, l.Payload)
+		}
+		if bytes.Contains(l.Payload, []byte("secret")) {
+			t.Errorf("expected body to be redacted, got: %s", l.Payload)
+		}
+		if l.Time.IsZero() {
+			t.Errorf("expected log time to be set")
+		}
+	}
+}
+
+func TestServeStreamErrorNotEchoed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features><stream:error><policy-violation xmlns='urn:ietf:params:xml:ns:xmpp-streams'/><text xmlns='urn:ietf:params:xml:ns:xmpp-streams'>slow down</text><limit xmlns='urn:example'/></stream:error>`),
+		Writer: buf,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	buf.Reset()
+
+	err = s.Serve(nil)
+	var se stream.Error
+	if !errors.As(err, &se) {
+		t.Fatalf("expected stream error, got: %v", err)
+	}
+	if se.Err != "policy-violation" || se.TextLang("") != "slow down" || se.Application() == nil {
+		t.Errorf("wrong stream error: %+v", se)
+	}
+	if out := buf.String(); strings.Contains(out, "policy-violation") {
+		t.Errorf("did not expect received stream error to be sent back: %s", out)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		/* #nosec */
+		io.Copy(ioutil.Discard, serverConn)
+	}()
+	go func() {
+		/* #nosec */
+		serverConn.Write([]byte(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`))
+	}()
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, clientConn, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		ReadTimeout:  50 * time.Millisecond,
+		WriteTimeout: time.Second,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(nil)
+	}()
+	select {
+	case err = <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for Serve to return")
+	}
+	if !errors.Is(err, stream.ConnectionTimeout) {
+		t.Errorf("wrong error: want=%v, got=%v", stream.ConnectionTimeout, err)
+	}
+}
+
+// stallWriter blocks writes once stall is set until release is closed.
+type stallWriter struct {
+	stall   int32
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&w.stall) == 1 {
+		<-w.release
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *stallWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestWriteQueue(t *testing.T) {
+	w := &stallWriter{release: make(chan struct{})}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader(`<stream:stream id='316732270768047465' version='1.0' xml:lang='en' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:server'><stream:features><ready xmlns='urn:example'/></stream:features>`),
+		Writer: w,
+	}
+	s, err := xmpp.NewSession(context.Background(), jid.JID{}, jid.JID{}, rw, xmpp.S2S, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{readyFeature}
+		},
+		WriteQueue: 2,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	atomic.StoreInt32(&w.stall, 1)
+
+	type msg struct {
+		XMLName xml.Name `xml:"message"`
+		ID      string   `xml:"id,attr"`
+	}
+	// The first element is being written (and is stalled) and the second is
+	// waiting in the queue, so neither should block.
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err = s.Encode(ctx, msg{ID: strconv.Itoa(i)})
+		cancel()
+		if err != nil {
+			t.Fatalf("error queueing message %d: %v", i, err)
+		}
+	}
+	// The queue is full, so this should block until the context is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err = s.Encode(ctx, msg{ID: "dropped"})
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error queueing to a full queue: want=%v, got=%v", context.DeadlineExceeded, err)
+	}
+
+	close(w.release)
+	err = s.Send(context.Background(), stanza.Message{ID: "2", Type: stanza.ChatMessage}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("error closing session: %v", err)
+	}
+
+	out := w.String()
+	if strings.Contains(out, "dropped") {
+		t.Errorf("message that failed to be queued was sent: %s", out)
+	}
+	last := -1
+	for i := 0; i < 3; i++ {
+		idx := strings.Index(out, `id="`+strconv.Itoa(i)+`"`)
+		if idx <= last {
+			t.Fatalf("message %d missing or out of order: %s", i, out)
+		}
+		last = idx
+	}
+	if !strings.HasSuffix(out, "</stream:stream>") {
+		t.Errorf("expected stream to be closed after the queue was written: %s", out)
+	}
+	err = s.Encode(context.Background(), msg{ID: "closed"})
+	if !errors.Is(err, xmpp.ErrOutputStreamClosed) {
+		t.Errorf("wrong error queueing after close: want=%v, got=%v", xmpp.ErrOutputStreamClosed, err)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza
+
+import (
+	"encoding/xml"
+	"sort"
+
+	"golang.org/x/text/language"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/ns"
+)
+
+// Body is the human readable contents of a message in one or more languages.
+//
+// It is a map of language tags to text in a given language.
+// Text that does not have an xml:lang attribute (and thus uses the language of
+// the message) is stored with an empty key.
+// The keys are not validated to make sure they comply with BCP 47.
+//
+// When used as a field in a struct, each body element is added to the map.
+// For example:
+//
+//	struct {
+//	    stanza.Message
+//	    Body stanza.Body `xml:"body"`
+//	}
+type Body map[string]string
+
+// Match returns the text that best matches the provided list of preferred
+// languages along with the language tag (map key) it was stored under.
+// If there are no preferred languages or none of them match, the text without
+// a language is returned if it exists.
+func (b Body) Match(prefs ...language.Tag) (text, lang string) {
+	return matchLang(b, prefs)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (b Body) TokenReader() xml.TokenReader {
+	return langTokenReader("body", b)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (b Body) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, b.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (b Body) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := b.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// It adds the text of a single body element to the map.
+func (b *Body) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if *b == nil {
+		*b = make(Body)
+	}
+	return unmarshalLang(d, start, *b)
+}
+
+// Subject is the topic of a message in one or more languages.
+//
+// It behaves the same way as Body, for more information see Body.
+type Subject map[string]string
+
+// Match returns the text that best matches the provided list of preferred
+// languages along with the language tag (map key) it was stored under.
+// If there are no preferred languages or none of them match, the text without
+// a language is returned if it exists.
+func (s Subject) Match(prefs ...language.Tag) (text, lang string) {
+	return matchLang(s, prefs)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s Subject) TokenReader() xml.TokenReader {
+	return langTokenReader("subject", s)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s Subject) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s Subject) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// It adds the text of a single subject element to the map.
+func (s *Subject) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if *s == nil {
+		*s = make(Subject)
+	}
+	return unmarshalLang(d, start, *s)
+}
+
+// sortedLangs returns the keys of m in a stable order with the empty language
+// first.
+func sortedLangs(m map[string]string) []string {
+	langs := make([]string, 0, len(m))
+	for lang := range m {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+func langTokenReader(local string, m map[string]string) xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, lang := range sortedLangs(m) {
+		var attrs []xml.Attr
+		if lang != "" {
+			attrs = []xml.Attr{{
+				Name:  xml.Name{Space: ns.XML, Local: "lang"},
+				Value: lang,
+			}}
+		}
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(m[lang])),
+			xml.StartElement{Name: xml.Name{Local: local}, Attr: attrs},
+		))
+	}
+	return xmlstream.MultiReader(inner...)
+}
+
+func unmarshalLang(d *xml.Decoder, start xml.StartElement, m map[string]string) error {
+	var text string
+	err := d.DecodeElement(&text, &start)
+	if err != nil {
+		return err
+	}
+	var lang string
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "lang" && attr.Name.Space == ns.XML {
+			lang = attr.Value
+			break
+		}
+	}
+	m[lang] = text
+	return nil
+}
+
+func matchLang(m map[string]string, prefs []language.Tag) (text, lang string) {
+	if len(m) == 0 {
+		return "", ""
+	}
+	langs := sortedLangs(m)
+	// The first supported tag is the default if nothing matches, so make sure
+	// that the text without a language comes first (it does since the sorted
+	// list always starts with the empty string if it exists).
+	supported := make([]language.Tag, 0, len(langs))
+	keys := make([]string, 0, len(langs))
+	for _, l := range langs {
+		tag := language.Und
+		if l != "" {
+			var err error
+			tag, err = language.Parse(l)
+			if err != nil {
+				continue
+			}
+		}
+		supported = append(supported, tag)
+		keys = append(keys, l)
+	}
+	if len(supported) == 0 {
+		return "", ""
+	}
+	_, idx, _ := language.NewMatcher(supported).Match(prefs...)
+	return m[keys[idx]], keys[idx]
+}
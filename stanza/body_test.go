@@ -0,0 +1,88 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"golang.org/x/text/language"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xmlstream.Marshaler = stanza.Body{}
+	_ xmlstream.WriterTo  = stanza.Body{}
+	_ xml.Marshaler       = stanza.Body{}
+	_ xml.Unmarshaler     = (*stanza.Body)(nil)
+	_ xmlstream.Marshaler = stanza.Subject{}
+	_ xmlstream.WriterTo  = stanza.Subject{}
+	_ xml.Marshaler       = stanza.Subject{}
+	_ xml.Unmarshaler     = (*stanza.Subject)(nil)
+)
+
+type langMessage struct {
+	stanza.Message
+	Subject stanza.Subject `xml:"subject,omitempty"`
+	Body    stanza.Body    `xml:"body,omitempty"`
+}
+
+func TestBodyRoundTrip(t *testing.T) {
+	const input = `<message xmlns="jabber:client" xml:lang="en" type="chat"><subject>Greeting</subject><body>Hello</body><body xml:lang="de">Hallo</body><body xml:lang="fr">Bonjour</body></message>`
+	var msg langMessage
+	err := xml.Unmarshal([]byte(input), &msg)
+	if err != nil {
+		t.Fatalf("error unmarshaling message: %v", err)
+	}
+	expectedBody := stanza.Body{"": "Hello", "de": "Hallo", "fr": "Bonjour"}
+	if !reflect.DeepEqual(msg.Body, expectedBody) {
+		t.Errorf("wrong body: want=%v, got=%v", expectedBody, msg.Body)
+	}
+	expectedSubject := stanza.Subject{"": "Greeting"}
+	if !reflect.DeepEqual(msg.Subject, expectedSubject) {
+		t.Errorf("wrong subject: want=%v, got=%v", expectedSubject, msg.Subject)
+	}
+
+	b, err := xml.Marshal(msg.Body)
+	if err != nil {
+		t.Fatalf("error marshaling body: %v", err)
+	}
+	const expected = `<body>Hello</body><body xml:lang="de">Hallo</body><body xml:lang="fr">Bonjour</body>`
+	if s := string(b); s != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, s)
+	}
+}
+
+func TestBodyMatch(t *testing.T) {
+	body := stanza.Body{"": "Hello", "de": "Hallo", "fr": "Bonjour"}
+	for i, tc := range [...]struct {
+		body  stanza.Body
+		prefs []language.Tag
+		text  string
+		lang  string
+	}{
+		0: {body: body, text: "Hello"},
+		1: {body: body, prefs: []language.Tag{language.German}, text: "Hallo", lang: "de"},
+		2: {body: body, prefs: []language.Tag{language.French}, text: "Bonjour", lang: "fr"},
+		3: {body: body, prefs: []language.Tag{language.Japanese}, text: "Hello"},
+		4: {body: body, prefs: []language.Tag{language.Japanese, language.German}, text: "Hallo", lang: "de"},
+		5: {},
+		6: {body: stanza.Body{"de": "Hallo"}, prefs: []language.Tag{language.English}, text: "Hallo", lang: "de"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			text, lang := tc.body.Match(tc.prefs...)
+			if text != tc.text {
+				t.Errorf("wrong text: want=%q, got=%q", tc.text, text)
+			}
+			if lang != tc.lang {
+				t.Errorf("wrong lang: want=%q, got=%q", tc.lang, lang)
+			}
+		})
+	}
+}
@@ -6,7 +6,7 @@ package stanza
 
 import (
 	"encoding/xml"
-	"io"
+	"sort"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/ns"
@@ -253,12 +253,66 @@ const (
 // Normally there will just be one with an empty language (eg. "": "Some
 // error").
 // The keys are not validated to make sure they comply with BCP 47.
+//
+// Application is an optional application-specific condition element.
+// Only its name and attributes are marshaled and if the local name is empty it
+// is not included at all.
+//
+// Err is an optional underlying error that is never marshaled, it is used to
+// wrap local errors so that they can still be inspected with errors.Is and
+// errors.As after being converted to a stanza error.
 type Error struct {
-	XMLName   xml.Name
-	By        jid.JID
-	Type      ErrorType
-	Condition Condition
-	Text      map[string]string
+	XMLName     xml.Name
+	By          jid.JID
+	Type        ErrorType
+	Condition   Condition
+	Text        map[string]string
+	Application xml.StartElement
+	Err         error
+}
+
+// WrapError returns a stanza error with the provided condition and type that
+// wraps err.
+// The wrapped error is not sent over the wire, if a human readable description
+// should be sent it must be added to Text explicitly.
+func WrapError(err error, condition Condition, typ ErrorType) Error {
+	return Error{
+		Type:      typ,
+		Condition: condition,
+		Err:       err,
+	}
+}
+
+// ErrorReply returns a token reader that contains an error reply to the stanza
+// with the provided start element.
+// The to and from attributes are swapped, the type is set to "error", and all
+// other attributes (such as the ID) are kept.
+// Any payload of the original stanza is not included.
+func ErrorReply(start xml.StartElement, err Error) xml.TokenReader {
+	reply := xml.StartElement{
+		Name: start.Name,
+		Attr: make([]xml.Attr, 0, len(start.Attr)+1),
+	}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "" {
+			switch attr.Name.Local {
+			case "to":
+				attr.Name.Local = "from"
+			case "from":
+				attr.Name.Local = "to"
+			case "type":
+				continue
+			}
+		}
+		reply.Attr = append(reply.Attr, attr)
+	}
+	reply.Attr = append(reply.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: "error"})
+	return xmlstream.Wrap(err.TokenReader(), reply)
+}
+
+// Unwrap returns the underlying error, if any.
+func (se Error) Unwrap() error {
+	return se.Err
 }
 
 // Is will be used by errors.Is when comparing errors.
@@ -285,7 +339,11 @@ func (se Error) Is(target error) bool {
 }
 
 // Error satisfies the error interface by returning the condition.
+// If the error wraps another error, the wrapped error's text is appended.
 func (se Error) Error() string {
+	if se.Err != nil {
+		return string(se.Condition) + ": " + se.Err.Error()
+	}
 	return string(se.Condition)
 }
 
@@ -303,10 +361,23 @@ func (se Error) TokenReader() xml.TokenReader {
 		start.Attr = append(start.Attr, a)
 	}
 
-	var text xml.TokenReader = xmlstream.ReaderFunc(func() (xml.Token, error) {
-		return nil, io.EOF
-	})
-	for lang, data := range se.Text {
+	inner := []xml.TokenReader{
+		xmlstream.Wrap(
+			nil,
+			xml.StartElement{
+				Name: xml.Name{Space: ns.Stanza, Local: string(se.Condition)},
+			},
+		),
+	}
+
+	// Sort the languages so that the output is stable.
+	langs := make([]string, 0, len(se.Text))
+	for lang := range se.Text {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		data := se.Text[lang]
 		if data == "" {
 			continue
 		}
@@ -318,27 +389,21 @@ func (se Error) TokenReader() xml.TokenReader {
 				Value: lang,
 			}}
 		}
-		text = xmlstream.Wrap(
-			xmlstream.ReaderFunc(func() (xml.Token, error) {
-				return xml.CharData(data), io.EOF
-			}),
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(data)),
 			xml.StartElement{
 				Name: xml.Name{Space: ns.Stanza, Local: "text"},
 				Attr: attrs,
 			},
-		)
+		))
+	}
+
+	if se.Application.Name.Local != "" {
+		inner = append(inner, xmlstream.Wrap(nil, se.Application.Copy()))
 	}
 
 	return xmlstream.Wrap(
-		xmlstream.MultiReader(
-			xmlstream.Wrap(
-				nil,
-				xml.StartElement{
-					Name: xml.Name{Space: ns.Stanza, Local: string(se.Condition)},
-				},
-			),
-			text,
-		),
+		xmlstream.MultiReader(inner...),
 		start,
 	)
 }
@@ -360,34 +425,66 @@ func (se Error) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
 
 // UnmarshalXML satisfies the xml.Unmarshaler interface for StanzaError.
 func (se *Error) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	decoded := struct {
-		Condition struct {
-			XMLName xml.Name
-		} `xml:",any"`
-		Type ErrorType `xml:"type,attr"`
-		By   jid.JID   `xml:"by,attr"`
-		Text []struct {
-			Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
-			Data string `xml:",chardata"`
-		} `xml:"urn:ietf:params:xml:ns:xmpp-stanzas text"`
-	}{}
-	if err := d.DecodeElement(&decoded, &start); err != nil {
-		return err
-	}
-	se.Type = decoded.Type
-	se.By = decoded.By
-	if decoded.Condition.XMLName.Space == ns.Stanza {
-		se.Condition = Condition(decoded.Condition.XMLName.Local)
+	for _, attr := range start.Attr {
+		if attr.Name.Space != "" {
+			continue
+		}
+		switch attr.Name.Local {
+		case "type":
+			se.Type = ErrorType(attr.Value)
+		case "by":
+			if err := se.By.UnmarshalXMLAttr(attr); err != nil {
+				return err
+			}
+		}
 	}
 
-	for _, text := range decoded.Text {
-		if text.Data == "" {
-			continue
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
 		}
-		if se.Text == nil {
-			se.Text = make(map[string]string)
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == ns.Stanza && t.Name.Local == "text":
+				text := struct {
+					Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+					Data string `xml:",chardata"`
+				}{}
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return err
+				}
+				if text.Data == "" {
+					continue
+				}
+				if se.Text == nil {
+					se.Text = make(map[string]string)
+				}
+				se.Text[text.Lang] = text.Data
+				continue
+			case t.Name.Space == ns.Stanza:
+				se.Condition = Condition(t.Name.Local)
+			case se.Application.Name.Local == "":
+				se.Application = t.Copy()
+				// Namespace declarations are recreated from the name when marshaling.
+				attrs := se.Application.Attr[:0]
+				for _, attr := range se.Application.Attr {
+					if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+						continue
+					}
+					attrs = append(attrs, attr)
+				}
+				if len(attrs) == 0 {
+					attrs = nil
+				}
+				se.Application.Attr = attrs
+			}
+			if err := d.Skip(); err != nil {
+				return err
+			}
 		}
-		se.Text[text.Lang] = text.Data
 	}
-	return nil
 }
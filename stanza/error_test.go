@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"mellium.im/xmlstream"
@@ -129,6 +130,9 @@ func TestMarshalStanzaError(t *testing.T) {
 		3: {stanza.Error{Type: stanza.Wait, Condition: stanza.UndefinedCondition}, `<error type="wait"><undefined-condition xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></undefined-condition></error>`, false},
 		4: {stanza.Error{Type: stanza.Modify, By: jid.MustParse("test@example.net"), Condition: stanza.SubscriptionRequired}, `<error type="modify" by="test@example.net"><subscription-required xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></subscription-required></error>`, false},
 		5: {stanza.Error{Type: stanza.Continue, Condition: stanza.ServiceUnavailable, Text: simpleText}, `<error type="continue"><service-unavailable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></service-unavailable><text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">test</text></error>`, false},
+		6: {stanza.Error{Condition: stanza.BadRequest, Text: map[string]string{"en": "test", "de": "German"}}, `<error><bad-request xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></bad-request><text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas" xml:lang="de">German</text><text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas" xml:lang="en">test</text></error>`, false},
+		7: {stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented, Application: xml.StartElement{Name: xml.Name{Space: "urn:example", Local: "unsupported"}, Attr: []xml.Attr{{Name: xml.Name{Local: "feature"}, Value: "test"}}}}, `<error type="cancel"><feature-not-implemented xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></feature-not-implemented><unsupported xmlns="urn:example" feature="test"></unsupported></error>`, false},
+		8: {stanza.WrapError(errors.New("oops"), stanza.InternalServerError, stanza.Wait), `<error type="wait"><internal-server-error xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></internal-server-error></error>`, false},
 	} {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			b, err := xml.Marshal(data.se)
@@ -187,6 +191,11 @@ func TestUnmarshalStanzaError(t *testing.T) {
 			stanza.Error{Condition: stanza.RecipientUnavailable, Text: map[string]string{
 				"ac-u": "test",
 			}}, false},
+		13: {`<error type="cancel"><feature-not-implemented xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/><unsupported xmlns="urn:example" feature="test"><ignored/></unsupported><other xmlns="urn:example"/></error>`,
+			stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented, Application: xml.StartElement{
+				Name: xml.Name{Space: "urn:example", Local: "unsupported"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "feature"}, Value: "test"}},
+			}}, false},
 	} {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			se2 := stanza.Error{}
@@ -209,3 +218,43 @@ func TestUnmarshalStanzaError(t *testing.T) {
 		})
 	}
 }
+
+func TestWrapError(t *testing.T) {
+	errTest := errors.New("test")
+	err := fmt.Errorf("wrapped: %w", stanza.WrapError(errTest, stanza.InternalServerError, stanza.Wait))
+	if !errors.Is(err, errTest) {
+		t.Errorf("expected wrapped error to match the original error")
+	}
+	if !errors.Is(err, stanza.Error{Condition: stanza.InternalServerError}) {
+		t.Errorf("expected wrapped error to match the stanza error")
+	}
+	const expected = "wrapped: internal-server-error: test"
+	if s := err.Error(); s != expected {
+		t.Errorf("wrong error text: want=%q, got=%q", expected, s)
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	start := xml.StartElement{
+		Name: xml.Name{Space: "jabber:client", Local: "message"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "id"}, Value: "123"},
+			{Name: xml.Name{Local: "to"}, Value: "juliet@example.com"},
+			{Name: xml.Name{Local: "from"}, Value: "romeo@example.net"},
+			{Name: xml.Name{Local: "type"}, Value: "chat"},
+		},
+	}
+	_, err := xmlstream.Copy(e, stanza.ErrorReply(start, stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound}))
+	if err != nil {
+		t.Fatalf("error encoding reply: %v", err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatalf("error flushing reply: %v", err)
+	}
+	const expected = `<message xmlns="jabber:client" id="123" from="juliet@example.com" to="romeo@example.net" type="error"><error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></item-not-found></error></message>`
+	if s := buf.String(); s != expected {
+		t.Errorf("wrong reply:\nwant=%s,\n got=%s", expected, s)
+	}
+}
@@ -97,6 +97,33 @@ func (msg Message) Wrap(payload xml.TokenReader) xml.TokenReader {
 	return xmlstream.Wrap(payload, msg.StartElement())
 }
 
+// Reply returns a new message header that can be used to respond to msg.
+// The to and from addresses are swapped and the type and language are kept.
+// The ID is cleared since a reply is a new stanza and should have its own ID.
+// If msg is a groupchat message the reply is addressed to the bare JID of the
+// room instead of to the occupant that sent the original message.
+func (msg Message) Reply() Message {
+	reply := Message{
+		XMLName: msg.XMLName,
+		To:      msg.From,
+		From:    msg.To,
+		Lang:    msg.Lang,
+		Type:    msg.Type,
+	}
+	if msg.Type == GroupChatMessage {
+		reply.To = reply.To.Bare()
+	}
+	return reply
+}
+
+// Error returns a token reader that wraps err in a message stanza with the to
+// and from attributes switched and the type set to ErrorMessage.
+func (msg Message) Error(err Error) xml.TokenReader {
+	msg.Type = ErrorMessage
+	msg.From, msg.To = msg.To, msg.From
+	return msg.Wrap(err.TokenReader())
+}
+
 // MessageType is the type of a message stanza.
 // It should normally be one of the constants defined in this package.
 type MessageType string
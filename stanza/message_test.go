@@ -177,3 +177,58 @@ func getLangAttr(start xml.StartElement) xml.Attr {
 	}
 	return langAttr
 }
+
+func TestMessageReply(t *testing.T) {
+	for i, tc := range [...]struct {
+		msg   stanza.Message
+		reply stanza.Message
+	}{
+		0: {
+			msg: stanza.Message{
+				ID:   "123",
+				To:   jid.MustParse("juliet@example.com/balcony"),
+				From: jid.MustParse("romeo@example.net/orchard"),
+				Lang: "en",
+				Type: stanza.ChatMessage,
+			},
+			reply: stanza.Message{
+				To:   jid.MustParse("romeo@example.net/orchard"),
+				From: jid.MustParse("juliet@example.com/balcony"),
+				Lang: "en",
+				Type: stanza.ChatMessage,
+			},
+		},
+		1: {
+			msg: stanza.Message{
+				ID:   "123",
+				To:   jid.MustParse("juliet@example.com/balcony"),
+				From: jid.MustParse("coven@chat.example.net/thirdwitch"),
+				Type: stanza.GroupChatMessage,
+			},
+			reply: stanza.Message{
+				To:   jid.MustParse("coven@chat.example.net"),
+				From: jid.MustParse("juliet@example.com/balcony"),
+				Type: stanza.GroupChatMessage,
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			reply := tc.msg.Reply()
+			if reply.ID != tc.reply.ID {
+				t.Errorf("wrong ID: want=%q, got=%q", tc.reply.ID, reply.ID)
+			}
+			if !reply.To.Equal(tc.reply.To) {
+				t.Errorf("wrong to: want=%v, got=%v", tc.reply.To, reply.To)
+			}
+			if !reply.From.Equal(tc.reply.From) {
+				t.Errorf("wrong from: want=%v, got=%v", tc.reply.From, reply.From)
+			}
+			if reply.Type != tc.reply.Type {
+				t.Errorf("wrong type: want=%q, got=%q", tc.reply.Type, reply.Type)
+			}
+			if reply.Lang != tc.reply.Lang {
+				t.Errorf("wrong lang: want=%q, got=%q", tc.reply.Lang, reply.Lang)
+			}
+		})
+	}
+}
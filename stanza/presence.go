@@ -102,6 +102,30 @@ func (p Presence) Wrap(payload xml.TokenReader) xml.TokenReader {
 	return xmlstream.Wrap(payload, p.StartElement())
 }
 
+// Reply returns a new presence header that can be used to respond to p.
+// The to and from addresses are swapped and the ID and language are kept so
+// that the response can be matched to the original presence (for example, when
+// approving a subscription request).
+// The type is cleared and should be set to the appropriate response type by
+// the caller.
+func (p Presence) Reply() Presence {
+	return Presence{
+		XMLName: p.XMLName,
+		ID:      p.ID,
+		To:      p.From,
+		From:    p.To,
+		Lang:    p.Lang,
+	}
+}
+
+// Error returns a token reader that wraps err in a presence stanza with the to
+// and from attributes switched and the type set to ErrorPresence.
+func (p Presence) Error(err Error) xml.TokenReader {
+	p.Type = ErrorPresence
+	p.From, p.To = p.To, p.From
+	return p.Wrap(err.TokenReader())
+}
+
 // PresenceType is the type of a presence stanza.
 // It should normally be one of the constants defined in this package.
 type PresenceType string
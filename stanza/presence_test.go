@@ -212,3 +212,30 @@ func TestPresenceFromStartElement(t *testing.T) {
 		})
 	}
 }
+
+func TestPresenceReply(t *testing.T) {
+	p := stanza.Presence{
+		ID:   "123",
+		To:   jid.MustParse("juliet@example.com"),
+		From: jid.MustParse("romeo@example.net"),
+		Lang: "en",
+		Type: stanza.SubscribePresence,
+	}
+	reply := p.Reply()
+	reply.Type = stanza.SubscribedPresence
+	if reply.ID != p.ID {
+		t.Errorf("wrong ID: want=%q, got=%q", p.ID, reply.ID)
+	}
+	if !reply.To.Equal(p.From) {
+		t.Errorf("wrong to: want=%v, got=%v", p.From, reply.To)
+	}
+	if !reply.From.Equal(p.To) {
+		t.Errorf("wrong from: want=%v, got=%v", p.To, reply.From)
+	}
+	if reply.Lang != p.Lang {
+		t.Errorf("wrong lang: want=%q, got=%q", p.Lang, reply.Lang)
+	}
+	if p.Type != stanza.SubscribePresence {
+		t.Errorf("original presence was modified")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
+)
+
+// Validate reads a single stanza from r and checks that it follows the rules
+// for stanzas defined in RFC 6120 §8.
+// For example, IQs must have an ID and a valid type, IQs of type "get" or "set"
+// must contain exactly one payload, and stanzas of type "error" must contain
+// an error element.
+//
+// If the stanza is invalid, the returned error is an Error with the
+// BadRequest condition that wraps a description of the problem.
+// If r does not contain a stanza or cannot be read, the error is returned
+// as-is.
+func Validate(r xml.TokenReader) error {
+	tok, err := r.Token()
+	if err != nil {
+		return err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return fmt.Errorf("stanza: expected start element, got %T", tok)
+	}
+	validSpace := start.Name.Space == "" || start.Name.Space == ns.Client || start.Name.Space == ns.Server
+	if !validSpace || !isStanzaLocal(start.Name.Local) {
+		return invalid("expected iq, message, or presence but got {%s}%s", start.Name.Space, start.Name.Local)
+	}
+
+	// Count the direct children of the stanza and look for an error element.
+	var children int
+	var hasError bool
+	depth := 1
+	for depth > 0 {
+		tok, err := r.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 1 {
+				children++
+				if t.Name.Local == "error" && (t.Name.Space == "" || t.Name.Space == start.Name.Space) {
+					hasError = true
+				}
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	_, id := attr.Get(start.Attr, "id")
+	_, typ := attr.Get(start.Attr, "type")
+	switch start.Name.Local {
+	case "iq":
+		if id == "" {
+			return invalid("iq is missing the required id attribute")
+		}
+		switch IQType(typ) {
+		case GetIQ, SetIQ:
+			if children != 1 {
+				return invalid("iq of type %q must contain exactly one payload, got %d", typ, children)
+			}
+		case ResultIQ:
+			if children > 1 {
+				return invalid("iq of type %q must contain zero or one payloads, got %d", typ, children)
+			}
+		case ErrorIQ:
+		default:
+			return invalid("invalid iq type %q", typ)
+		}
+	case "message":
+		switch MessageType(typ) {
+		case "", NormalMessage, ChatMessage, GroupChatMessage, HeadlineMessage, ErrorMessage:
+		default:
+			return invalid("invalid message type %q", typ)
+		}
+	case "presence":
+		switch PresenceType(typ) {
+		case AvailablePresence, ErrorPresence, ProbePresence, SubscribePresence,
+			SubscribedPresence, UnavailablePresence, UnsubscribePresence,
+			UnsubscribedPresence:
+		default:
+			return invalid("invalid presence type %q", typ)
+		}
+	}
+	if typ == "error" && !hasError {
+		return invalid("%s of type \"error\" must contain an error element", start.Name.Local)
+	}
+	return nil
+}
+
+func invalid(format string, v ...interface{}) error {
+	return WrapError(fmt.Errorf("stanza: "+format, v...), BadRequest, Modify)
+}
+
+func isStanzaLocal(local string) bool {
+	return local == "iq" || local == "message" || local == "presence"
+}
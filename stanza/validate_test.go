@@ -0,0 +1,53 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp/stanza"
+)
+
+var validateTestCases = [...]struct {
+	x       string
+	invalid bool
+}{
+	0:  {x: `<iq xmlns="jabber:client" id="1" type="get"><query xmlns="urn:example"/></iq>`},
+	1:  {x: `<iq xmlns="jabber:client" type="get"><query xmlns="urn:example"/></iq>`, invalid: true},
+	2:  {x: `<iq xmlns="jabber:client" id="1" type="get"></iq>`, invalid: true},
+	3:  {x: `<iq xmlns="jabber:client" id="1" type="set"><a xmlns="urn:example"/><b xmlns="urn:example"/></iq>`, invalid: true},
+	4:  {x: `<iq xmlns="jabber:client" id="1" type="result"/>`},
+	5:  {x: `<iq xmlns="jabber:client" id="1" type="result"><a xmlns="urn:example"/><b xmlns="urn:example"/></iq>`, invalid: true},
+	6:  {x: `<iq xmlns="jabber:client" id="1" type="bad"><a xmlns="urn:example"/></iq>`, invalid: true},
+	7:  {x: `<iq xmlns="jabber:client" id="1" type="error"><a xmlns="urn:example"/></iq>`, invalid: true},
+	8:  {x: `<iq xmlns="jabber:client" id="1" type="error"><error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`},
+	9:  {x: `<message xmlns="jabber:server"><body>test</body></message>`},
+	10: {x: `<message xmlns="jabber:client" type="bad"/>`, invalid: true},
+	11: {x: `<presence xmlns="jabber:client" type="subscribe"/>`},
+	12: {x: `<presence xmlns="jabber:client" type="available"/>`, invalid: true},
+	13: {x: `<presence xmlns="jabber:client" type="error"/>`, invalid: true},
+	14: {x: `<message type="chat"><a><b/><c/></a></message>`},
+	15: {x: `<iq xmlns="jabber:client" id="1" type="get"><a><b/><c/></a></iq>`},
+	16: {x: `<foo xmlns="jabber:client"/>`, invalid: true},
+	17: {x: `<iq xmlns="urn:example" id="1" type="result"/>`, invalid: true},
+}
+
+func TestValidate(t *testing.T) {
+	for i, tc := range validateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := stanza.Validate(xml.NewDecoder(strings.NewReader(tc.x)))
+			switch {
+			case tc.invalid && !errors.Is(err, stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}):
+				t.Errorf("expected bad-request error, got %v", err)
+			case !tc.invalid && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+)
+
+// Direction indicates whether a stanza was sent or received.
+type Direction uint8
+
+// A list of possible directions.
+const (
+	Incoming Direction = iota
+	Outgoing
+)
+
+// String satisfies the fmt.Stringer interface.
+func (d Direction) String() string {
+	if d == Outgoing {
+		return "out"
+	}
+	return "in"
+}
+
+// StanzaLog describes a single stanza that was sent or received.
+type StanzaLog struct {
+	Direction Direction
+	// Time is the time at which the stanza began to be sent or received.
+	Time time.Time
+	// Kind is the local name of the stanza: "iq", "message", or "presence".
+	Kind string
+	// Type, From, To, and ID are the values of the corresponding attributes.
+	Type     string
+	From, To jid.JID
+	ID       string
+	// Size is the length of the stanza in bytes when encoded by this package.
+	// It may differ slightly from the number of bytes actually sent or received
+	// over the connection.
+	Size int
+	// Payload is the encoded stanza with any redacted elements emptied.
+	// It is only set if the Payload option of StanzaLogger is set.
+	Payload []byte
+}
+
+// StanzaLogger configures logging of the stanzas sent and received on a
+// session.
+// Unlike TeeIn and TeeOut, stanzas are logged after they have been parsed so
+// that they do not have to be decoded again and sensitive content can be
+// redacted.
+type StanzaLogger struct {
+	// Log is called for each stanza after it has been fully sent or received.
+	// It is called synchronously from the goroutine sending or receiving the
+	// stanza, so it should return quickly.
+	// If Log is nil, stanzas are not logged.
+	Log func(StanzaLog)
+
+	// Payload causes the encoded stanza to be included in each log entry.
+	Payload bool
+
+	// Redact is called with the name of each element in the stanza when
+	// Payload is set.
+	// If it returns true the contents of the element are removed from the
+	// logged payload.
+	// If Redact is nil, nothing is redacted.
+	Redact func(xml.Name) bool
+}
+
+// RedactBodies is a function that may be used as the Redact option of
+// StanzaLogger to remove the bodies and subjects of messages from logs.
+func RedactBodies(name xml.Name) bool {
+	return name.Local == "body" || name.Local == "subject"
+}
+
+// stanzaRecorder collects the tokens of each stanza passing through the
+// session in one direction and logs it once it is complete.
+type stanzaRecorder struct {
+	logger StanzaLogger
+	dir    Direction
+	depth  int
+	active bool
+	toks   tokenBuffer
+	entry  StanzaLog
+}
+
+func (r *stanzaRecorder) record(t xml.Token) {
+	switch tok := t.(type) {
+	case xml.StartElement:
+		if r.depth == 0 && isStanzaEmptySpace(tok.Name) {
+			r.active = true
+			r.entry = StanzaLog{
+				Direction: r.dir,
+				Time:      time.Now(),
+				Kind:      tok.Name.Local,
+			}
+			_, r.entry.Type = attr.Get(tok.Attr, "type")
+			_, r.entry.ID = attr.Get(tok.Attr, "id")
+			_, from := attr.Get(tok.Attr, "from")
+			_, to := attr.Get(tok.Attr, "to")
+			// Malformed addresses are left as the zero value.
+			/* #nosec */
+			r.entry.From, _ = jid.Parse(from)
+			/* #nosec */
+			r.entry.To, _ = jid.Parse(to)
+		}
+		r.depth++
+	case xml.EndElement:
+		r.depth--
+	}
+	if !r.active {
+		return
+	}
+	/* #nosec */
+	r.toks.EncodeToken(t)
+	if r.depth == 0 {
+		r.finish()
+	}
+}
+
+func (r *stanzaRecorder) finish() {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	/* #nosec */
+	xmlstream.Copy(e, r.toks.Reader())
+	/* #nosec */
+	e.Flush()
+	r.entry.Size = buf.Len()
+
+	if r.logger.Payload {
+		if r.logger.Redact != nil {
+			buf.Reset()
+			/* #nosec */
+			xmlstream.Copy(e, redact(r.toks.Reader(), r.logger.Redact))
+			/* #nosec */
+			e.Flush()
+		}
+		r.entry.Payload = buf.Bytes()
+	}
+
+	r.logger.Log(r.entry)
+	r.active = false
+	r.toks = r.toks[:0]
+}
+
+// redact removes the contents of any element for which f returns true.
+func redact(r xml.TokenReader, f func(xml.Name) bool) xml.TokenReader {
+	var skip int
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		for {
+			tok, err := r.Token()
+			if err != nil {
+				return tok, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if skip > 0 {
+					skip++
+					continue
+				}
+				if f(t.Name) {
+					skip = 1
+				}
+				return tok, nil
+			case xml.EndElement:
+				if skip > 1 {
+					skip--
+					continue
+				}
+				skip = 0
+				return tok, nil
+			}
+			if skip > 0 {
+				continue
+			}
+			return tok, nil
+		}
+	})
+}
+
+// logReader records stanzas read from the input stream.
+type logReader struct {
+	r   xml.TokenReader
+	rec stanzaRecorder
+}
+
+func (l *logReader) Token() (xml.Token, error) {
+	tok, err := l.r.Token()
+	if tok != nil {
+		l.rec.record(tok)
+	}
+	return tok, err
+}
+
+// logWriter records stanzas written to the output stream.
+type logWriter struct {
+	xmlstream.TokenWriteFlusher
+	rec stanzaRecorder
+}
+
+func (l *logWriter) EncodeToken(t xml.Token) error {
+	err := l.TokenWriteFlusher.EncodeToken(t)
+	if err == nil {
+		l.rec.record(t)
+	}
+	return err
+}
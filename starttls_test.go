@@ -16,7 +16,7 @@ import (
 
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/internal/ns"
-	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/xmpptest"
 )
 
 // There is no room for variation on the starttls feature negotiation, so step
@@ -178,6 +178,7 @@ type Error struct {
 
 	innerXML xml.TokenReader
 	payload  xml.TokenReader
+	app      []xml.Token
 }
 
 // Is will be used by errors.Is when comparing errors.
@@ -256,8 +257,32 @@ func (s *Error) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 				Lang:  lang,
 				Value: t.Text,
 			})
+			continue
 		case start.Name.Space == NSError:
 			s.Err = start.Name.Local
+		case s.app == nil:
+			// The first element that is not in the stream error namespace is the
+			// application specific condition.
+			spaces := []string{""}
+			s.app = append(s.app, defaultSpace(start, &spaces))
+			for len(spaces) > 1 {
+				tok, err := d.Token()
+				if err != nil {
+					return err
+				}
+				switch t := tok.(type) {
+				case xml.StartElement:
+					tok = defaultSpace(t, &spaces)
+				case xml.EndElement:
+					spaces = spaces[:len(spaces)-1]
+					t.Name.Space = ""
+					tok = t
+				default:
+					tok = xml.CopyToken(tok)
+				}
+				s.app = append(s.app, tok)
+			}
+			continue
 		}
 		if err = d.Skip(); err != nil {
 			return err
@@ -265,6 +290,32 @@ func (s *Error) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	}
 }
 
+// defaultSpace returns a copy of start in the default namespace so that it is
+// encoded the same way that it was received.
+// The namespace declarations that were resolved by the decoder are dropped and
+// an xmlns attribute is added only if the element's namespace differs from its
+// parent's, which is the last element of spaces.
+// The element's namespace is pushed on to spaces.
+func defaultSpace(start xml.StartElement, spaces *[]string) xml.StartElement {
+	attrs := make([]xml.Attr, 1, len(start.Attr)+1)
+	for _, a := range start.Attr {
+		if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	space := start.Name.Space
+	if parent := (*spaces)[len(*spaces)-1]; space != parent {
+		attrs[0] = xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: space}
+	} else {
+		attrs = attrs[1:]
+	}
+	*spaces = append(*spaces, space)
+	start.Name.Space = ""
+	start.Attr = attrs
+	return start
+}
+
 // MarshalXML satisfies the xml package's Marshaler interface and allows
 // StreamError's to be correctly marshaled back into XML.
 func (s Error) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
@@ -282,10 +333,10 @@ func (s Error) WriteXML(w xmlstream.TokenWriter) (n int, err error) {
 // the error.
 func (s Error) TokenReader() xml.TokenReader {
 	inner := xmlstream.Wrap(s.innerXML, xml.StartElement{Name: xml.Name{Local: s.Err, Space: NSError}})
-	if s.payload != nil {
+	if payload := s.Application(); payload != nil {
 		inner = xmlstream.MultiReader(
 			inner,
-			s.payload,
+			payload,
 		)
 	}
 	for _, txt := range s.Text {
@@ -324,6 +375,60 @@ func (s Error) ApplicationError(r xml.TokenReader) Error {
 	return s
 }
 
+// Application returns the application specific condition of the error.
+// For errors that were unmarshaled it returns a new token reader over the first
+// element that is not in the NSError namespace each time it is called.
+// For errors created with ApplicationError it returns the reader that was
+// passed to ApplicationError.
+// If the error has no application specific condition, Application returns
+// nil.
+func (s Error) Application() xml.TokenReader {
+	if s.payload != nil {
+		return s.payload
+	}
+	if s.app == nil {
+		return nil
+	}
+	toks := s.app
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if len(toks) == 0 {
+			return nil, io.EOF
+		}
+		tok := toks[0]
+		toks = toks[1:]
+		return tok, nil
+	})
+}
+
+// WithText returns a copy of the Error with human readable text in the given
+// language (which may be empty) added to it.
+func (s Error) WithText(lang, text string) Error {
+	s.Text = append(s.Text[:len(s.Text):len(s.Text)], struct {
+		Lang  string
+		Value string
+	}{
+		Lang:  lang,
+		Value: text,
+	})
+	return s
+}
+
+// TextLang returns the human readable text of the error in the given language.
+// If there is no text in that language the text without a language, or failing
+// that the first text, is returned.
+func (s Error) TextLang(lang string) string {
+	var fallback string
+	for i, t := range s.Text {
+		switch {
+		case t.Lang == lang:
+			return t.Value
+		case t.Lang == "" || i == 0:
+			fallback = t.Value
+		}
+	}
+	return fallback
+}
+
 // InnerXML returns a copy of the Error that marshals the provided reader after
 // the error condition start token.
 // Multiple, chained, calls to InnerXML will  replace the inner XML each time
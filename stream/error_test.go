@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"mellium.im/xmlstream"
@@ -161,6 +162,69 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
+func TestUnmarshalApplication(t *testing.T) {
+	const in = `<error xmlns="http://etherx.jabber.org/streams"><undefined-condition xmlns="urn:ietf:params:xml:ns:xmpp-streams"></undefined-condition><text xmlns="urn:ietf:params:xml:ns:xmpp-streams" xml:lang="en">too slow</text><text xmlns="urn:ietf:params:xml:ns:xmpp-streams" xml:lang="de">zu langsam</text><rate-exceeded xmlns="urn:example"><limit>10</limit></rate-exceeded></error>`
+	s := stream.Error{}
+	err := xml.Unmarshal([]byte(in), &s)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if s.Err != "undefined-condition" {
+		t.Errorf("wrong condition: want=undefined-condition, got=%s", s.Err)
+	}
+	if text := s.TextLang("de"); text != "zu langsam" {
+		t.Errorf("wrong text: want=%q, got=%q", "zu langsam", text)
+	}
+	if text := s.TextLang("fr"); text != "too slow" {
+		t.Errorf("wrong fallback text: want=%q, got=%q", "too slow", text)
+	}
+	for i := 0; i < 2; i++ {
+		var b strings.Builder
+		e := xml.NewEncoder(&b)
+		_, err = xmlstream.Copy(e, s.Application())
+		if err != nil {
+			t.Fatalf("error encoding application condition: %v", err)
+		}
+		err = e.Flush()
+		if err != nil {
+			t.Fatalf("error flushing: %v", err)
+		}
+		const want = `<rate-exceeded xmlns="urn:example"><limit>10</limit></rate-exceeded>`
+		if out := b.String(); out != want {
+			t.Errorf("wrong application condition: want=%s, got=%s", want, out)
+		}
+	}
+	var app struct {
+		XMLName xml.Name `xml:"urn:example rate-exceeded"`
+		Limit   int      `xml:"urn:example limit"`
+	}
+	err = xml.NewTokenDecoder(s.Application()).Decode(&app)
+	if err != nil {
+		t.Fatalf("error decoding application condition: %v", err)
+	}
+	if app.Limit != 10 {
+		t.Errorf("wrong limit decoded from application condition: want=10, got=%d", app.Limit)
+	}
+	if stream.RestrictedXML.Application() != nil {
+		t.Errorf("expected no application condition")
+	}
+}
+
+func TestWithText(t *testing.T) {
+	se := stream.PolicyViolation.WithText("en", "slow down")
+	if len(stream.PolicyViolation.Text) != 0 {
+		t.Errorf("did not expect WithText to modify the original error")
+	}
+	xb, err := xml.Marshal(se)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const want = `<error xmlns="http://etherx.jabber.org/streams"><policy-violation xmlns="urn:ietf:params:xml:ns:xmpp-streams"></policy-violation><text xmlns="urn:ietf:params:xml:ns:xmpp-streams" xml:lang="en">slow down</text></error>`
+	if string(xb) != want {
+		t.Errorf("bad output:\nwant=`%s`,\n got=`%s`", want, xb)
+	}
+}
+
 func TestErrorReturnsCondition(t *testing.T) {
 	if stream.RestrictedXML.Error() != "restricted-xml" {
 		t.Error("error should return the error condition")
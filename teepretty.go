@@ -0,0 +1,254 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/stream"
+)
+
+// RedactAuth is a function that may be used as the TeeRedact option of
+// StreamConfig to remove SASL payloads, which may contain passwords or other
+// credentials, from the copies written to TeeIn and TeeOut.
+func RedactAuth(name xml.Name) bool {
+	return name.Space == ns.SASL && (name.Local == "auth" ||
+		name.Local == "response" ||
+		name.Local == "challenge" ||
+		name.Local == "success")
+}
+
+// prettyWriter is an io.WriteCloser that decodes the XML written to it and
+// writes it to an underlying writer with each top level element on its own
+// line and child elements indented.
+// If the XML cannot be decoded, the remaining data is copied verbatim.
+type prettyWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+}
+
+type nsDecl struct {
+	prefix, uri string
+}
+
+type prettyPrinter struct {
+	w      *bufio.Writer
+	redact func(xml.Name) bool
+
+	// depth is the depth relative to the most recent stream header.
+	depth int
+	// open is true if the last start element has not yet been closed with ">".
+	open bool
+	// inline is true if text was written after the last start element.
+	inline bool
+	// skip is the depth of the redacted element whose contents are being
+	// skipped, or zero.
+	skip int
+
+	decls  []nsDecl
+	scopes []int
+}
+
+func newPrettyWriter(w io.Writer, redact func(xml.Name) bool) *prettyWriter {
+	pr, pw := io.Pipe()
+	p := &prettyWriter{
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		pp := &prettyPrinter{
+			w:      bufio.NewWriter(w),
+			redact: redact,
+		}
+		d := xml.NewDecoder(pr)
+		for {
+			tok, err := d.RawToken()
+			if err != nil {
+				/* #nosec */
+				pp.w.Flush()
+				if err != io.EOF {
+					// Copy anything that we couldn't decode as-is and make sure that the
+					// pipe is drained so that writes never block.
+					/* #nosec */
+					io.Copy(w, pr)
+				}
+				return
+			}
+			if pp.token(tok) {
+				/* #nosec */
+				pp.w.Flush()
+			}
+		}
+	}()
+	return p
+}
+
+func (p *prettyWriter) Write(b []byte) (int, error) {
+	return p.pw.Write(b)
+}
+
+// Close stops decoding and waits for any buffered output to be written.
+func (p *prettyWriter) Close() error {
+	err := p.pw.Close()
+	<-p.done
+	return err
+}
+
+func (p *prettyPrinter) resolve(name xml.Name) xml.Name {
+	if name.Space == "xml" || name.Space == "xmlns" {
+		return name
+	}
+	for i := len(p.decls) - 1; i >= 0; i-- {
+		if p.decls[i].prefix == name.Space {
+			return xml.Name{Space: p.decls[i].uri, Local: name.Local}
+		}
+	}
+	return xml.Name{Local: name.Local}
+}
+
+func (p *prettyPrinter) push(start xml.StartElement) {
+	n := 0
+	for _, a := range start.Attr {
+		switch {
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			p.decls = append(p.decls, nsDecl{uri: a.Value})
+		case a.Name.Space == "xmlns":
+			p.decls = append(p.decls, nsDecl{prefix: a.Name.Local, uri: a.Value})
+		default:
+			continue
+		}
+		n++
+	}
+	p.scopes = append(p.scopes, n)
+}
+
+func (p *prettyPrinter) pop() {
+	if len(p.scopes) == 0 {
+		return
+	}
+	n := p.scopes[len(p.scopes)-1]
+	p.scopes = p.scopes[:len(p.scopes)-1]
+	p.decls = p.decls[:len(p.decls)-n]
+}
+
+func (p *prettyPrinter) closeStart() {
+	if p.open {
+		p.w.WriteByte('>')
+		p.open = false
+	}
+}
+
+func (p *prettyPrinter) newline(depth int) {
+	p.w.WriteByte('\n')
+	if depth > 1 {
+		p.w.WriteString(strings.Repeat("  ", depth-1))
+	}
+}
+
+func writeName(w *bufio.Writer, name xml.Name) {
+	if name.Space != "" {
+		w.WriteString(name.Space)
+		w.WriteByte(':')
+	}
+	w.WriteString(name.Local)
+}
+
+// token writes the token and reports whether a line was completed at the top
+// level of the stream, meaning that the output should be flushed.
+func (p *prettyPrinter) token(t xml.Token) bool {
+	switch tok := t.(type) {
+	case xml.StartElement:
+		if p.skip > 0 {
+			p.depth++
+			return false
+		}
+		p.push(tok)
+		name := p.resolve(tok.Name)
+		p.closeStart()
+		if name.Space == stream.NS && name.Local == "stream" {
+			// Stream headers (including those sent when the stream is restarted)
+			// are never closed, so treat each one as a new document.
+			n := p.scopes[len(p.scopes)-1]
+			p.depth = 0
+			p.decls = append(p.decls[:0], p.decls[len(p.decls)-n:]...)
+			p.scopes = append(p.scopes[:0], n)
+		} else {
+			p.depth++
+		}
+		if p.depth > 1 {
+			p.newline(p.depth)
+		}
+		p.w.WriteByte('<')
+		writeName(p.w, tok.Name)
+		for _, a := range tok.Attr {
+			p.w.WriteByte(' ')
+			writeName(p.w, a.Name)
+			p.w.WriteString(`="`)
+			/* #nosec */
+			xml.EscapeText(p.w, []byte(a.Value))
+			p.w.WriteByte('"')
+		}
+		p.open = true
+		p.inline = false
+		if p.depth == 0 {
+			p.closeStart()
+			p.w.WriteByte('\n')
+			return true
+		}
+		if p.redact != nil && p.redact(name) {
+			p.skip = p.depth
+		}
+	case xml.EndElement:
+		if p.skip > 0 && p.depth > p.skip {
+			p.depth--
+			return false
+		}
+		p.skip = 0
+		p.pop()
+		switch {
+		case p.open:
+			p.w.WriteString("/>")
+			p.open = false
+		case p.inline:
+			p.w.WriteString("</")
+			writeName(p.w, tok.Name)
+			p.w.WriteByte('>')
+		default:
+			if p.depth > 0 {
+				p.newline(p.depth)
+			}
+			p.w.WriteString("</")
+			writeName(p.w, tok.Name)
+			p.w.WriteByte('>')
+		}
+		p.inline = false
+		p.depth--
+		if p.depth <= 0 {
+			p.depth = 0
+			p.w.WriteByte('\n')
+			return true
+		}
+	case xml.CharData:
+		if p.skip > 0 {
+			return false
+		}
+		text := strings.TrimSpace(string(tok))
+		if text == "" {
+			return false
+		}
+		p.closeStart()
+		/* #nosec */
+		xml.EscapeText(p.w, []byte(text))
+		p.inline = true
+	case xml.ProcInst, xml.Directive, xml.Comment:
+		// Declarations and comments are not useful for debugging, drop them.
+	}
+	return false
+}
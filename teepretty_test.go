@@ -0,0 +1,74 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp"
+)
+
+const prettyHeader = `<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>`
+
+var prettyTestCases = [...]struct {
+	in     string
+	redact func(xml.Name) bool
+	out    string
+}{
+	0: {
+		in: `<?xml version="1.0"?>` + prettyHeader + `<message to="a&amp;b"><body>hi</body><x xmlns="urn:example"><y/></x></message><presence/>`,
+		out: `<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">
+<message to="a&amp;b">
+  <body>hi</body>
+  <x xmlns="urn:example">
+    <y/>
+  </x>
+</message>
+<presence/>
+`,
+	},
+	1: {
+		in:     prettyHeader + `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">c2VjcmV0</auth>` + prettyHeader + `</stream:stream>`,
+		redact: xmpp.RedactAuth,
+		out: `<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">
+<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN"/>
+<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">
+</stream:stream>
+`,
+	},
+	2: {
+		in: prettyHeader + `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">c2VjcmV0</auth>`,
+		out: `<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">
+<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">c2VjcmV0</auth>
+`,
+	},
+	3: {
+		in:  `<a>&bogus;</a>`,
+		out: `<a`,
+	},
+}
+
+func TestTeePretty(t *testing.T) {
+	for i, tc := range prettyTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := xmpp.NewPrettyWriter(&buf, tc.redact)
+			_, err := w.Write([]byte(tc.in))
+			if err != nil {
+				t.Fatalf("unexpected error writing: %v", err)
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatalf("unexpected error closing: %v", err)
+			}
+			if out := buf.String(); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
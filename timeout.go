@@ -0,0 +1,75 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"mellium.im/xmpp/stream"
+)
+
+// SetReadDeadline sets the read deadline on the session's connection.
+// A zero value for t means reads will not time out.
+// If the deadline is reached, Serve returns an error and the session can no
+// longer be used.
+// To bound the amount of time that the remote entity may remain silent see
+// the ReadTimeout option on StreamConfig instead.
+func (s *Session) SetReadDeadline(t time.Time) error {
+	return s.Conn().SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the session's connection.
+// A zero value for t means writes will not time out.
+// If the deadline is reached while an element is being written the session
+// can no longer be used.
+// To bound the amount of time that each write may take see the WriteTimeout
+// option on StreamConfig instead.
+func (s *Session) SetWriteDeadline(t time.Time) error {
+	return s.Conn().SetWriteDeadline(t)
+}
+
+// idleReader fails reads that do not complete within a timeout by moving the
+// read deadline of the connection into the past.
+// Unlike setting a deadline before each read this does not interfere with
+// other deadlines set on the connection, such as the close deadline.
+type idleReader struct {
+	r io.Reader
+	c net.Conn
+	d time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	t := time.AfterFunc(r.d, func() {
+		/* #nosec */
+		r.c.SetReadDeadline(aLongTimeAgo)
+	})
+	n, err := r.r.Read(p)
+	if !t.Stop() && err != nil {
+		return n, stream.ConnectionTimeout
+	}
+	return n, err
+}
+
+// timeoutWriter fails writes that do not complete within a timeout by moving
+// the write deadline of the connection into the past.
+type timeoutWriter struct {
+	w io.Writer
+	c net.Conn
+	d time.Duration
+}
+
+func (w timeoutWriter) Write(p []byte) (int, error) {
+	t := time.AfterFunc(w.d, func() {
+		/* #nosec */
+		w.c.SetWriteDeadline(aLongTimeAgo)
+	})
+	n, err := w.w.Write(p)
+	if !t.Stop() && err != nil {
+		return n, stream.ConnectionTimeout
+	}
+	return n, err
+}
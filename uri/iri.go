@@ -34,12 +34,63 @@ type URI struct {
 	// Otherwise it is the auth address if present in an xmpp:// URI or IRI.
 	AuthAddr jid.JID
 
-	// Action is the first query component without a value and normally determines
-	// the action to take when handling the URI. For example, the query string
-	// might be ?join to join a chatroom, or ?message to send a message.
+	// Action is the first query component if it does not have a value and
+	// normally determines the action to take when handling the URI. For example,
+	// the query string might be ?join to join a chatroom, or ?message to send a
+	// message.
 	//
 	// For more information see XEP-0147: XMPP URI Scheme Query Components.
 	Action string
+
+	// Params contains the key-value pairs from the query component, not
+	// including the action.
+	Params url.Values
+}
+
+// New returns a URI that performs action on the recipient address to.
+// If action is empty the URI will not have a query component.
+func New(to jid.JID, action string, params url.Values) *URI {
+	u := &URI{
+		ToAddr: to,
+		Action: action,
+		Params: params,
+	}
+	u.URL = u.build()
+	return u
+}
+
+// build constructs a url.URL from the addresses, action, and parameters.
+func (u *URI) build() *url.URL {
+	uu := &url.URL{
+		Scheme:   "xmpp",
+		RawQuery: encodeQuery(u.Action, u.Params),
+	}
+	if u.AuthAddr.Equal(jid.JID{}) {
+		uu.Opaque = escapeJID(u.ToAddr)
+		return uu
+	}
+	if local := u.AuthAddr.Localpart(); local != "" {
+		uu.User = url.User(local)
+	}
+	uu.Host = u.AuthAddr.Domainpart()
+	if !u.ToAddr.Equal(jid.JID{}) {
+		uu.Path = "/" + u.ToAddr.String()
+	}
+	return uu
+}
+
+func escapeJID(j jid.JID) string {
+	var b strings.Builder
+	if local := j.Localpart(); local != "" {
+		b.WriteString(url.PathEscape(local))
+		b.WriteByte('@')
+	}
+	b.WriteString(url.PathEscape(j.Domainpart()))
+	if res := j.Resourcepart(); res != "" {
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(res))
+	}
+	return b.String()
 }
 
 // TODO: encoding and escaping, see
@@ -92,19 +143,23 @@ func Parse(rawuri string) (*URI, error) {
 		}
 	}
 
-	for k, v := range u.Query() {
-		if len(v) == 0 || len(v) == 1 && v[0] == "" {
-			uri.Action = k
-			break
-		}
+	uri.Action, uri.Params, err = parseQuery(u.RawQuery)
+	if err != nil {
+		return nil, err
 	}
 
-	return uri, err
+	return uri, nil
 }
 
 // String reassembles the URI or IRI Into a valid IRI string.
+// If the URI was not created by Parse or New (ie. the embedded URL is nil) it
+// is built from the addresses, action, and parameters.
 func (u *URI) String() string {
-	iri, _ := toIRI(u.URL.String(), true)
+	uu := u.URL
+	if uu == nil {
+		uu = u.build()
+	}
+	iri, _ := toIRI(uu.String(), true)
 	return iri
 }
 
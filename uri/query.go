@@ -0,0 +1,162 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package uri
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmpp/jid"
+)
+
+// Actions registered in the XMPP URI/IRI Querytypes registry.
+// The parameters defined for each action are listed alongside it.
+const (
+	// Command executes an ad-hoc command.
+	// Parameters: action, node.
+	Command = "command"
+
+	// Disco queries the recipient using service discovery.
+	// Parameters: node, request, type.
+	Disco = "disco"
+
+	// Invite invites the recipient to a multi-user chat room.
+	// Parameters: jid, password.
+	Invite = "invite"
+
+	// Join joins a multi-user chat room.
+	// Parameters: password.
+	Join = "join"
+
+	// Message sends a message to the recipient.
+	// Parameters: body, from, id, subject, thread, type.
+	Message = "message"
+
+	// Pubsub subscribes to or unsubscribes from a pubsub node.
+	// Parameters: action, node.
+	Pubsub = "pubsub"
+
+	// Recvfile receives a file from the recipient.
+	// Parameters: mime-type, name, sid, size.
+	Recvfile = "recvfile"
+
+	// Register registers with the recipient.
+	Register = "register"
+
+	// Remove removes the recipient from the roster.
+	Remove = "remove"
+
+	// Roster adds the recipient to the roster.
+	// Parameters: group, name.
+	Roster = "roster"
+
+	// Sendfile sends a file to the recipient.
+	Sendfile = "sendfile"
+
+	// Subscribe subscribes to the recipient's presence.
+	Subscribe = "subscribe"
+
+	// Unregister cancels a registration with the recipient.
+	Unregister = "unregister"
+
+	// Unsubscribe unsubscribes from the recipient's presence.
+	Unsubscribe = "unsubscribe"
+
+	// VCard retrieves the recipient's vCard.
+	VCard = "vcard"
+)
+
+// Param returns the first value of the query parameter key or the empty string
+// if no such parameter exists.
+func (u *URI) Param(key string) string {
+	return u.Params.Get(key)
+}
+
+// ParamJID parses the query parameter key (for example, the "jid" parameter of
+// an invite or the "from" parameter of a message) as a JID.
+// If the parameter does not exist the zero JID is returned.
+func (u *URI) ParamJID(key string) (jid.JID, error) {
+	v := u.Params.Get(key)
+	if v == "" {
+		return jid.JID{}, nil
+	}
+	return jid.Parse(v)
+}
+
+// ParamInt parses the query parameter key (for example, the "size" parameter
+// of a recvfile action) as an integer.
+// If the parameter does not exist 0 is returned.
+func (u *URI) ParamInt(key string) (int64, error) {
+	v := u.Params.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// parseQuery splits a query component into the action and its parameters.
+// XEP-0147 uses ";" to separate components, but "&" is also accepted.
+func parseQuery(query string) (action string, params url.Values, err error) {
+	params = make(url.Values)
+	for i, component := range strings.FieldsFunc(query, func(r rune) bool {
+		return r == ';' || r == '&'
+	}) {
+		idx := strings.IndexByte(component, '=')
+		if idx == -1 {
+			if i == 0 {
+				action, err = url.PathUnescape(component)
+				if err != nil {
+					return "", nil, err
+				}
+				continue
+			}
+			idx = len(component)
+		}
+		key, err := url.PathUnescape(component[:idx])
+		if err != nil {
+			return "", nil, err
+		}
+		var val string
+		if idx < len(component) {
+			val, err = url.PathUnescape(component[idx+1:])
+			if err != nil {
+				return "", nil, err
+			}
+		}
+		params[key] = append(params[key], val)
+	}
+	return action, params, nil
+}
+
+// encodeQuery builds a query component from an action and its parameters.
+// Parameters are sorted by key and separated by ";" as recommended by
+// XEP-0147.
+func encodeQuery(action string, params url.Values) string {
+	var b strings.Builder
+	b.WriteString(escapeQuery(action))
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range params[k] {
+			if b.Len() > 0 {
+				b.WriteByte(';')
+			}
+			b.WriteString(escapeQuery(k))
+			b.WriteByte('=')
+			b.WriteString(escapeQuery(v))
+		}
+	}
+	return b.String()
+}
+
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package uri_test
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/uri"
+)
+
+var buildTests = [...]struct {
+	u   *uri.URI
+	out string
+}{
+	0: {
+		u:   uri.New(jid.MustParse("romeo@montague.net"), "", nil),
+		out: "xmpp:romeo@montague.net",
+	},
+	1: {
+		u: uri.New(jid.MustParse("romeo@montague.net"), uri.Roster, url.Values{
+			"name":  {"Romeo Montague"},
+			"group": {"Friends"},
+		}),
+		out: "xmpp:romeo@montague.net?roster;group=Friends;name=Romeo Montague",
+	},
+	2: {
+		u:   uri.New(jid.MustParse("romeo@montague.net/orchard"), uri.Subscribe, nil),
+		out: "xmpp:romeo@montague.net/orchard?subscribe",
+	},
+	3: {
+		u: &uri.URI{
+			AuthAddr: jid.MustParse("guest@example.com"),
+			ToAddr:   jid.MustParse("support@example.com"),
+			Action:   uri.Message,
+		},
+		out: "xmpp://guest@example.com/support@example.com?message",
+	},
+	4: {
+		u: &uri.URI{
+			ToAddr: jid.MustParse("darkcave@macbeth.shakespeare.lit"),
+			Action: uri.Invite,
+			Params: url.Values{"jid": {"hecate@shakespeare.lit"}},
+		},
+		out: "xmpp:darkcave@macbeth.shakespeare.lit?invite;jid=hecate@shakespeare.lit",
+	},
+}
+
+func TestBuild(t *testing.T) {
+	for i, tc := range buildTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := tc.u.String()
+			if out != tc.out {
+				t.Fatalf("wrong output: want=%q, got=%q", tc.out, out)
+			}
+			u, err := uri.Parse(out)
+			if err != nil {
+				t.Fatalf("error parsing built URI: %v", err)
+			}
+			if u.Action != tc.u.Action {
+				t.Errorf("wrong action after round trip: want=%q, got=%q", tc.u.Action, u.Action)
+			}
+			if !u.ToAddr.Equal(tc.u.ToAddr) {
+				t.Errorf("wrong recipient after round trip: want=%v, got=%v", tc.u.ToAddr, u.ToAddr)
+			}
+		})
+	}
+}
+
+var paramTests = [...]struct {
+	raw    string
+	action string
+	params url.Values
+}{
+	0: {
+		raw:    "xmpp:romeo@montague.net?recvfile;sid=a0;mime-type=text%2Fplain;name=reply.txt;size=2048",
+		action: uri.Recvfile,
+		params: url.Values{
+			"sid":       {"a0"},
+			"mime-type": {"text/plain"},
+			"name":      {"reply.txt"},
+			"size":      {"2048"},
+		},
+	},
+	1: {
+		raw:    "xmpp:romeo@montague.net?roster;group=Friends;group=Family",
+		action: uri.Roster,
+		params: url.Values{"group": {"Friends", "Family"}},
+	},
+	2: {
+		// Only the first component can be the action.
+		raw:    "xmpp:romeo@montague.net?name=Romeo;roster",
+		params: url.Values{"name": {"Romeo"}, "roster": {""}},
+	},
+	3: {
+		raw:    "xmpp:romeo@montague.net",
+		params: url.Values{},
+	},
+}
+
+func TestParams(t *testing.T) {
+	for i, tc := range paramTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			u, err := uri.Parse(tc.raw)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if u.Action != tc.action {
+				t.Errorf("wrong action: want=%q, got=%q", tc.action, u.Action)
+			}
+			if !reflect.DeepEqual(u.Params, tc.params) {
+				t.Errorf("wrong params: want=%v, got=%v", tc.params, u.Params)
+			}
+		})
+	}
+}
+
+func TestTypedParams(t *testing.T) {
+	u, err := uri.Parse("xmpp:darkcave@macbeth.shakespeare.lit?invite;jid=hecate@shakespeare.lit;size=10;bad=@")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := u.ParamJID("jid")
+	if err != nil {
+		t.Fatalf("error parsing JID param: %v", err)
+	}
+	if want := jid.MustParse("hecate@shakespeare.lit"); !j.Equal(want) {
+		t.Errorf("wrong JID: want=%v, got=%v", want, j)
+	}
+	_, err = u.ParamJID("bad")
+	if err == nil {
+		t.Errorf("expected error parsing invalid JID param")
+	}
+	size, err := u.ParamInt("size")
+	if err != nil {
+		t.Fatalf("error parsing int param: %v", err)
+	}
+	if size != 10 {
+		t.Errorf("wrong size: want=10, got=%d", size)
+	}
+	size, err = u.ParamInt("missing")
+	if err != nil || size != 0 {
+		t.Errorf("expected missing param to be 0 with no error, got %d, %v", size, err)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package vcard implements vCard based avatars.
+//
+// Avatars are stored in the users vCard as defined in XEP-0054: vcard-temp.
+// To let contacts know when an avatar changes without fetching the vCard
+// every time, the hash of the avatar is included in each presence broadcast
+// as described in XEP-0153: vCard-Based Avatars.
+// This is mostly useful on servers that do not support PEP based avatars.
+package vcard // import "mellium.im/xmpp/vcard"
+
+import (
+	"context"
+	/* #nosec */
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"strings"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package.
+const (
+	NS       = "vcard-temp"
+	NSUpdate = "vcard-temp:x:update"
+)
+
+// Hash returns the hex encoded SHA-1 hash of the avatar image data that is
+// used to identify an avatar in presence broadcasts.
+func Hash(data []byte) string {
+	/* #nosec */
+	h := sha1.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+// Update is the avatar information included in presence broadcasts.
+type Update struct {
+	// Ready is false if the sender has not yet fetched its own vCard and does
+	// not know whether it has an avatar.
+	// Contacts should not change their cached avatar for the sender in this
+	// case.
+	Ready bool
+
+	// Photo is the hash of the avatar image, or the empty string if Ready is
+	// true and the sender has no avatar.
+	Photo string
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (u Update) TokenReader() xml.TokenReader {
+	var inner xml.TokenReader
+	if u.Ready || u.Photo != "" {
+		var hash xml.TokenReader
+		if u.Photo != "" {
+			hash = xmlstream.Token(xml.CharData(u.Photo))
+		}
+		inner = xmlstream.Wrap(hash, xml.StartElement{Name: xml.Name{Local: "photo"}})
+	}
+	return xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: NSUpdate, Local: "x"}})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (u Update) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, u.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (u Update) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := u.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (u *Update) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Photo *string `xml:"photo"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*u = Update{}
+	if s.Photo != nil {
+		u.Ready = true
+		u.Photo = strings.TrimSpace(*s.Photo)
+	}
+	return nil
+}
+
+// Advertiser attaches the hash of our avatar to outgoing presence broadcasts.
+// The zero value advertises that the avatar is not yet known.
+// It is safe for concurrent use.
+type Advertiser struct {
+	mu     sync.Mutex
+	update Update
+}
+
+// SetAvatar sets the avatar image data to advertise.
+// If data is empty, presence will advertise that there is no avatar.
+func (a *Advertiser) SetAvatar(data []byte) {
+	u := Update{Ready: true}
+	if len(data) > 0 {
+		u.Photo = Hash(data)
+	}
+	a.mu.Lock()
+	a.update = u
+	a.mu.Unlock()
+}
+
+// Update returns the avatar information that is currently advertised.
+func (a *Advertiser) Update() Update {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.update
+}
+
+// Intercept has the signature of an xmpp.Interceptor and can be added to the
+// Interceptors of a StreamConfig.
+// It inserts the avatar information into every available presence that is
+// sent.
+func (a *Advertiser) Intercept(r xml.TokenReader, start xml.StartElement) xml.TokenReader {
+	if start.Name.Local != "presence" {
+		return r
+	}
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "type" && attr.Value != string(stanza.AvailablePresence) {
+			return r
+		}
+	}
+	u := a.Update()
+	var (
+		depth int
+		rest  xml.TokenReader
+	)
+	// Append the update just before the presence end element.
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if rest != nil {
+			return rest.Token()
+		}
+		tok, err := r.Token()
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				rest = xmlstream.MultiReader(u.TokenReader(), xmlstream.Token(tok), r)
+				return rest.Token()
+			}
+		}
+		return tok, err
+	})
+}
+
+// Handle returns an option that registers a Handler for avatar information in
+// presence broadcasts.
+func Handle(h Handler) mux.Option {
+	return mux.PresencePayload(stanza.AvailablePresence, xml.Name{Space: NSUpdate, Local: "x"}, h)
+}
+
+// Handler is notified of the avatar hashes of contacts.
+type Handler struct {
+	// Update is called for every presence that contains avatar information.
+	// If the hash differs from the cached avatar of the sender the vCard should
+	// be fetched again.
+	Update func(p stanza.Presence, u Update) error
+}
+
+// HandlePresencePayload implements mux.PresencePayloadHandler.
+func (h Handler) HandlePresencePayload(p stanza.Presence, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if h.Update == nil {
+		return nil
+	}
+	var u Update
+	err := xml.NewTokenDecoder(xmlstream.Wrap(t, *start)).Decode(&u)
+	if err != nil {
+		return err
+	}
+	return h.Update(p, u)
+}
+
+// Photo is an avatar image.
+type Photo struct {
+	Type string
+	Data []byte
+}
+
+// FetchPhoto fetches the avatar from the vCard of the provided JID.
+// If the vCard does not contain an avatar, the zero value is returned.
+func FetchPhoto(ctx context.Context, s *xmpp.Session, to jid.JID) (Photo, error) {
+	var resp struct {
+		XMLName xml.Name `xml:"vcard-temp vCard"`
+		Type    string   `xml:"PHOTO>TYPE"`
+		BinVal  string   `xml:"PHOTO>BINVAL"`
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "vCard"},
+	}), stanza.IQ{
+		To:   to.Bare(),
+		Type: stanza.GetIQ,
+	}, &resp)
+	if err != nil {
+		return Photo{}, err
+	}
+	if resp.BinVal == "" {
+		return Photo{}, nil
+	}
+	// Line breaks are commonly included in the base64 data and must be removed
+	// before decoding.
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(resp.BinVal), ""))
+	if err != nil {
+		return Photo{}, err
+	}
+	return Photo{
+		Type: strings.TrimSpace(resp.Type),
+		Data: data,
+	}, nil
+}
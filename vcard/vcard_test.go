@@ -0,0 +1,180 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package vcard_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/vcard"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var (
+	_ xml.Marshaler              = vcard.Update{}
+	_ xml.Unmarshaler            = (*vcard.Update)(nil)
+	_ xmlstream.Marshaler        = vcard.Update{}
+	_ xmlstream.WriterTo         = vcard.Update{}
+	_ mux.PresencePayloadHandler = vcard.Handler{}
+	_ xmpp.Interceptor           = (&vcard.Advertiser{}).Intercept
+)
+
+var updateTestCases = [...]struct {
+	update vcard.Update
+	xml    string
+}{
+	0: {
+		xml: `<x xmlns="vcard-temp:x:update"></x>`,
+	},
+	1: {
+		update: vcard.Update{Ready: true},
+		xml:    `<x xmlns="vcard-temp:x:update"><photo></photo></x>`,
+	},
+	2: {
+		update: vcard.Update{Ready: true, Photo: "01b87fcd030b72895ff8e88db57ec525450f000d"},
+		xml:    `<x xmlns="vcard-temp:x:update"><photo>01b87fcd030b72895ff8e88db57ec525450f000d</photo></x>`,
+	},
+}
+
+func TestUpdate(t *testing.T) {
+	for i, tc := range updateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out, err := xml.Marshal(tc.update)
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			if string(out) != tc.xml {
+				t.Errorf("wrong output: want=%s, got=%s", tc.xml, out)
+			}
+			var u vcard.Update
+			err = xml.Unmarshal(out, &u)
+			if err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			if u != tc.update {
+				t.Errorf("wrong update: want=%+v, got=%+v", tc.update, u)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	const want = "a9993e364706816aba3e25717850c26c9cd0d89d"
+	if h := vcard.Hash([]byte("abc")); h != want {
+		t.Errorf("wrong hash: want=%s, got=%s", want, h)
+	}
+}
+
+var interceptTestCases = [...]struct {
+	in       string
+	inserted bool
+}{
+	0: {
+		in:       `<presence xmlns="jabber:client"><show>away</show></presence>`,
+		inserted: true,
+	},
+	1: {
+		in: `<presence type="unavailable" xmlns="jabber:client"></presence>`,
+	},
+	2: {
+		in: `<message xmlns="jabber:client"></message>`,
+	},
+}
+
+func TestIntercept(t *testing.T) {
+	a := &vcard.Advertiser{}
+	a.SetAvatar([]byte("abc"))
+	for i, tc := range interceptTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error decoding start element: %v", err)
+			}
+			start := tok.(xml.StartElement)
+			var buf bytes.Buffer
+			e := xml.NewEncoder(&buf)
+			r := a.Intercept(xmlstream.Wrap(xmlstream.Inner(d), start), start)
+			// Prevent duplicate xmlns attributes. See https://mellium.im/issue/75
+			r = xmlstream.RemoveAttr(func(start xml.StartElement, attr xml.Attr) bool {
+				return start.Name.Local == "presence" && attr.Name.Local == "xmlns"
+			})(r)
+			_, err = xmlstream.Copy(e, r)
+			if err != nil {
+				t.Fatalf("error encoding: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			const update = `<show xmlns="jabber:client">away</show><x xmlns="vcard-temp:x:update"><photo>a9993e364706816aba3e25717850c26c9cd0d89d</photo></x></presence>`
+			out := buf.String()
+			if inserted := strings.Contains(out, "vcard-temp:x:update"); inserted != tc.inserted {
+				t.Fatalf("wrong value for inserted: want=%t, got=%t: %s", tc.inserted, inserted, out)
+			}
+			if tc.inserted && !strings.HasSuffix(out, update) {
+				t.Errorf("wrong output: want suffix %s, got=%s", update, out)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var (
+		from jid.JID
+		got  vcard.Update
+	)
+	m := mux.New(vcard.Handle(vcard.Handler{
+		Update: func(p stanza.Presence, u vcard.Update) error {
+			from = p.From
+			got = u
+			return nil
+		},
+	}))
+	d := xml.NewDecoder(strings.NewReader(`<presence from="juliet@example.com/balcony" xmlns="jabber:client"><x xmlns="vcard-temp:x:update"><photo>sha1-hash-of-image</photo></x></presence>`))
+	tok, _ := d.Token()
+	start := tok.(xml.StartElement)
+	err := m.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: d,
+		Encoder:     xml.NewEncoder(&bytes.Buffer{}),
+	}, &start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := jid.MustParse("juliet@example.com/balcony"); !from.Equal(want) {
+		t.Errorf("wrong from: want=%s, got=%s", want, from)
+	}
+	if want := (vcard.Update{Ready: true, Photo: "sha1-hash-of-image"}); got != want {
+		t.Errorf("wrong update: want=%+v, got=%+v", want, got)
+	}
+}
+
+func TestFetchPhoto(t *testing.T) {
+	cs := xmpptest.NewClientServer(xmpptest.ServerScript(
+		`<iq type="result" xmlns="jabber:client"><vCard xmlns="vcard-temp"><PHOTO><TYPE>image/png</TYPE><BINVAL>YW
+Jj</BINVAL></PHOTO></vCard></iq>`,
+	))
+	defer cs.Close()
+
+	p, err := vcard.FetchPhoto(context.Background(), cs.Client, jid.MustParse("juliet@example.com"))
+	if err != nil {
+		t.Fatalf("error fetching photo: %v", err)
+	}
+	if p.Type != "image/png" || string(p.Data) != "abc" {
+		t.Errorf("wrong photo: got=%+v", p)
+	}
+}
@@ -2,7 +2,10 @@
 // Use of this source code is governed by the BSD 2-clause
 // license that can be found in the LICENSE file.
 
-// Package version queries a remote entity for software version info.
+// Package version implements XEP-0092: Software Version.
+//
+// It can be used to query a remote entity for software version info or to
+// respond to such queries.
 package version // import "mellium.im/xmpp/version"
 
 import (
@@ -11,7 +14,9 @@ import (
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -78,3 +83,39 @@ func GetIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session) (Query, error) {
 	err := s.UnmarshalIQ(ctx, iq.Wrap(query.TokenReader()), &query)
 	return query, err
 }
+
+// Handle returns an option that registers a Handler for software version
+// requests.
+func Handle(h Handler) mux.Option {
+	return mux.IQ(stanza.GetIQ, xml.Name{Local: "query", Space: NS}, h)
+}
+
+// Handler responds to requests for our software version.
+// Any empty fields are omitted from the response.
+type Handler struct {
+	Name    string
+	Version string
+	OS      string
+}
+
+// HandleIQ responds to software version requests.
+func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if iq.Type != stanza.GetIQ || start.Name.Local != "query" || start.Name.Space != NS {
+		return nil
+	}
+
+	_, err := xmlstream.Copy(t, iq.Result(Query{
+		Name:    h.Name,
+		Version: h.Version,
+		OS:      h.OS,
+	}.TokenReader()))
+	return err
+}
+
+// ForFeatures implements disco.FeatureIter.
+func (h Handler) ForFeatures(node string, f func(disco.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	return f(disco.Feature{Var: NS})
+}
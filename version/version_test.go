@@ -13,15 +13,19 @@ import (
 	"testing"
 
 	"mellium.im/xmlstream"
-	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/disco"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/version"
+	"mellium.im/xmpp/xmpptest"
 )
 
 var (
 	_ xmlstream.Marshaler = (*version.Query)(nil)
 	_ xmlstream.WriterTo  = (*version.Query)(nil)
+	_ mux.IQHandler       = version.Handler{}
+	_ disco.FeatureIter   = version.Handler{}
 )
 
 var marshalTests = [...]struct {
@@ -101,3 +105,39 @@ func TestGet(t *testing.T) {
 		t.Errorf("unexpected response: want=%v, got=%v", query, resp)
 	}
 }
+
+func TestHandler(t *testing.T) {
+	h := version.Handler{
+		Name:    "name",
+		Version: "ver",
+		OS:      "os",
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(version.Handle(h))),
+	)
+	resp, err := version.Get(context.Background(), cs.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error querying version: %v", err)
+	}
+	query := version.Query{
+		XMLName: xml.Name{Space: version.NS, Local: "query"},
+		Name:    h.Name,
+		Version: h.Version,
+		OS:      h.OS,
+	}
+	if !reflect.DeepEqual(resp, query) {
+		t.Errorf("unexpected response: want=%v, got=%v", query, resp)
+	}
+
+	var features []disco.Feature
+	err = h.ForFeatures("", func(f disco.Feature) error {
+		features = append(features, f)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error iterating over features: %v", err)
+	}
+	if len(features) != 1 || features[0].Var != version.NS {
+		t.Errorf("wrong features advertised: %v", features)
+	}
+}
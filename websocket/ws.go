@@ -11,7 +11,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"sort"
 	"strings"
 
 	"golang.org/x/net/websocket"
@@ -172,20 +171,7 @@ func (d *Dialer) Dial(ctx context.Context, addr jid.JID) (net.Conn, error) {
 	if len(urls) == 0 {
 		return nil, fmt.Errorf("websocket: no XMPP websocket endpoint found on %s", addr.Domainpart())
 	}
-	// Prioritize wss over anything else, then ws, then anything else that will
-	// likely just result in an error.
-	sort.Slice(urls, func(i, j int) bool {
-		switch {
-		case strings.HasPrefix(urls[i], "wss:"):
-			return true
-		case strings.HasPrefix(urls[i], "wss:"):
-			return false
-		case strings.HasPrefix(urls[i], "ws:"):
-			return true
-		}
-		return false
-	})
-
+	// The URLs are returned in priority order with secure WebSockets first.
 	var conn net.Conn
 	var cfg *websocket.Config
 	for _, u := range urls {
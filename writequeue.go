@@ -0,0 +1,129 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"context"
+	"sync"
+)
+
+// writeQueue is an ordered queue of elements waiting to be written to the
+// output stream by a dedicated goroutine.
+// Once a write fails, the remaining elements are dropped and the error is
+// returned from any future attempt to queue an element.
+type writeQueue struct {
+	// slots limits the number of elements in the queue.
+	slots chan struct{}
+	// notify wakes the writer when elements are added or the queue is closed.
+	notify chan struct{}
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []tokenBuffer
+	writing bool
+	closed  bool
+	err     error
+}
+
+func newWriteQueue(depth int) *writeQueue {
+	q := &writeQueue{
+		slots:  make(chan struct{}, depth),
+		notify: make(chan struct{}, 1),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *writeQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// push adds an element to the queue, blocking until there is room for it or
+// the context is canceled.
+func (q *writeQueue) push(ctx context.Context, el tokenBuffer) error {
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	q.mu.Lock()
+	switch {
+	case q.err != nil:
+		err := q.err
+		q.mu.Unlock()
+		<-q.slots
+		return err
+	case q.closed:
+		q.mu.Unlock()
+		<-q.slots
+		return ErrOutputStreamClosed
+	}
+	q.items = append(q.items, el)
+	q.mu.Unlock()
+	q.wake()
+	return nil
+}
+
+// wait blocks until all queued elements have been written or dropped.
+// It is safe to call wait on a nil queue.
+func (q *writeQueue) wait() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) > 0 || q.writing {
+		q.cond.Wait()
+	}
+}
+
+// close stops the writer once any queued elements have been handled.
+// It is safe to call close on a nil queue.
+func (q *writeQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.wake()
+}
+
+// run writes queued elements to the session until the queue is closed.
+func (q *writeQueue) run(s *Session) {
+	for range q.notify {
+		q.mu.Lock()
+		for len(q.items) > 0 {
+			el := q.items[0]
+			q.items = q.items[1:]
+			failed := q.err != nil
+			q.writing = true
+			q.mu.Unlock()
+
+			var err error
+			if !failed {
+				// The context of the caller that queued the element may be canceled as
+				// soon as it returns, so it cannot be used for the write.
+				err = writeElement(context.Background(), s, el.Reader(), nil)
+			}
+			<-q.slots
+
+			q.mu.Lock()
+			q.writing = false
+			if err != nil && q.err == nil {
+				q.err = err
+			}
+			q.cond.Broadcast()
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return
+		}
+	}
+}
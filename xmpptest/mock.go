@@ -0,0 +1,265 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+)
+
+// TranscriptEntry is a stanza sent or received by a Mock.
+// The direction is relative to the mock server, so stanzas sent by the client
+// are Incoming.
+type TranscriptEntry struct {
+	Direction xmpp.Direction
+	XML       string
+}
+
+// Mock is a scripted server that checks the stanzas sent to it against a list
+// of expectations and replies to each with canned responses.
+// It is used with a ClientServer by passing it to ServerMock.
+//
+// Expectations must be met in the order in which they were declared.
+// Any stanza that does not match the next expectation causes the test to fail.
+type Mock struct {
+	tb           testing.TB
+	mu           sync.Mutex
+	expectations []*Expectation
+	transcript   []TranscriptEntry
+}
+
+// NewMock returns a mock server that reports failures to tb.
+func NewMock(tb testing.TB) *Mock {
+	return &Mock{tb: tb}
+}
+
+// Expectation is a stanza that a Mock expects to receive.
+type Expectation struct {
+	pattern string
+	match   node
+	replies []string
+}
+
+// Expect adds an expectation that the next stanza received will match the
+// pattern.
+// The pattern is an XML string and matches any stanza that contains all of its
+// attributes, character data, and child elements.
+// Elements in the pattern without a namespace match elements in any
+// namespace.
+//
+// Expect panics if the pattern is not valid XML.
+func (m *Mock) Expect(pattern string) *Expectation {
+	n, err := parseNode(xml.NewDecoder(strings.NewReader(pattern)))
+	if err != nil {
+		panic(err)
+	}
+	e := &Expectation{pattern: pattern, match: n}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// Reply sets the responses that are sent when the expectation is met.
+// If a response is an IQ without an id attribute, the id of the stanza being
+// responded to is used.
+func (e *Expectation) Reply(responses ...string) *Expectation {
+	e.replies = append(e.replies, responses...)
+	return e
+}
+
+// Done fails the test if any expectations have not been met.
+func (m *Mock) Done() {
+	m.tb.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expectations {
+		m.tb.Errorf("xmpptest: expected stanza matching %s was never received", e.pattern)
+	}
+}
+
+// Transcript returns every stanza received and sent by the mock server so far.
+func (m *Mock) Transcript() []TranscriptEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := make([]TranscriptEntry, len(m.transcript))
+	copy(t, m.transcript)
+	return t
+}
+
+// ServerMock sets up the server side of a ClientServer to be handled by m.
+func ServerMock(m *Mock) Option {
+	return ServerHandler(m)
+}
+
+// HandleXMPP satisfies the xmpp.Handler interface.
+func (m *Mock) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var toks tokens
+	_, err := xmlstream.Copy(&toks, xmlstream.Wrap(xmlstream.Inner(t), *start))
+	if err != nil {
+		return err
+	}
+	received, err := parseNode(toks.reader())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.transcript = append(m.transcript, TranscriptEntry{
+		Direction: xmpp.Incoming,
+		XML:       toks.String(),
+	})
+	if len(m.expectations) == 0 {
+		m.mu.Unlock()
+		m.tb.Errorf("xmpptest: unexpected stanza %s", toks.String())
+		return nil
+	}
+	e := m.expectations[0]
+	if !e.match.matches(received) {
+		m.mu.Unlock()
+		m.tb.Errorf("xmpptest: unexpected stanza:\nwant=%s,\n got=%s", e.pattern, toks.String())
+		return nil
+	}
+	m.expectations = m.expectations[1:]
+	for _, reply := range e.replies {
+		m.transcript = append(m.transcript, TranscriptEntry{
+			Direction: xmpp.Outgoing,
+			XML:       reply,
+		})
+	}
+	m.mu.Unlock()
+
+	_, id := attr.Get(start.Attr, "id")
+	for _, reply := range e.replies {
+		_, err = xmlstream.Copy(t, scriptedReader(reply, id))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tokens buffers copies of the tokens in a stanza.
+type tokens []xml.Token
+
+func (b *tokens) EncodeToken(t xml.Token) error {
+	*b = append(*b, xml.CopyToken(t))
+	return nil
+}
+
+func (b tokens) reader() xml.TokenReader {
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if len(b) == 0 {
+			return nil, io.EOF
+		}
+		t := b[0]
+		b = b[1:]
+		return t, nil
+	})
+}
+
+func (b tokens) String() string {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	/* #nosec */
+	xmlstream.Copy(e, b.reader())
+	/* #nosec */
+	e.Flush()
+	return buf.String()
+}
+
+// node is a simplified XML element tree used for matching stanzas.
+type node struct {
+	name     xml.Name
+	attr     []xml.Attr
+	text     string
+	children []node
+}
+
+// parseNode reads the first element from r.
+func parseNode(r xml.TokenReader) (node, error) {
+	for {
+		tok, err := r.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return node{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return parseElement(r, start)
+		}
+	}
+}
+
+func parseElement(r xml.TokenReader, start xml.StartElement) (node, error) {
+	n := node{name: start.Name}
+	for _, a := range start.Attr {
+		if a.Name.Local == "xmlns" || a.Name.Space == "xmlns" {
+			continue
+		}
+		n.attr = append(n.attr, a)
+	}
+	var text strings.Builder
+	for {
+		tok, err := r.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := parseElement(r, t)
+			if err != nil {
+				return n, err
+			}
+			n.children = append(n.children, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			n.text = strings.TrimSpace(text.String())
+			return n, nil
+		}
+	}
+}
+
+// matches reports whether got contains everything in the pattern n.
+func (n node) matches(got node) bool {
+	if n.name.Local != got.name.Local || (n.name.Space != "" && n.name.Space != got.name.Space) {
+		return false
+	}
+	for _, a := range n.attr {
+		idx, v := attr.Get(got.attr, a.Name.Local)
+		if idx == -1 || v != a.Value {
+			return false
+		}
+	}
+	if n.text != "" && n.text != got.text {
+		return false
+	}
+	for _, child := range n.children {
+		var found bool
+		for _, c := range got.children {
+			if child.matches(c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest_test
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+type recordTB struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recordTB) Helper() {}
+
+func (r *recordTB) Errorf(format string, v ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, v...))
+}
+
+func TestMock(t *testing.T) {
+	m := xmpptest.NewMock(t)
+	m.Expect(`<iq type="get"><query xmlns="jabber:iq:version"/></iq>`).Reply(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="jabber:iq:version"><name>test</name></query></iq>`,
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	resp, err := cs.Client.SendIQElement(context.Background(), xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: "jabber:iq:version", Local: "query"},
+	}), stanza.IQ{
+		ID:   "123",
+		Type: stanza.GetIQ,
+	})
+	if err != nil {
+		t.Fatalf("error sending IQ: %v", err)
+	}
+	err = resp.Close()
+	if err != nil {
+		t.Fatalf("error closing response: %v", err)
+	}
+	m.Done()
+
+	transcript := m.Transcript()
+	if len(transcript) != 2 {
+		t.Fatalf("wrong transcript length: want=2, got=%d", len(transcript))
+	}
+	if transcript[0].Direction != xmpp.Incoming || !strings.Contains(transcript[0].XML, `id="123"`) {
+		t.Errorf("wrong first transcript entry: %+v", transcript[0])
+	}
+	if transcript[1].Direction != xmpp.Outgoing || !strings.Contains(transcript[1].XML, `<name>test</name>`) {
+		t.Errorf("wrong second transcript entry: %+v", transcript[1])
+	}
+}
+
+func TestMockUnexpected(t *testing.T) {
+	tb := &recordTB{TB: t}
+	m := xmpptest.NewMock(tb)
+	m.Expect(`<message type="chat"/>`)
+	m.Expect(`<presence/>`)
+	cs := xmpptest.NewClientServer(xmpptest.ServerMock(m))
+	defer cs.Close()
+
+	// The mock does not reply to unexpected stanzas, so the server responds
+	// with an error.
+	resp, err := cs.Client.SendIQElement(context.Background(), nil, stanza.IQ{
+		ID:   "123",
+		To:   jid.MustParse("juliet@example.net"),
+		Type: stanza.GetIQ,
+	})
+	if err != nil {
+		t.Fatalf("error sending IQ: %v", err)
+	}
+	err = resp.Close()
+	if err != nil {
+		t.Fatalf("error closing response: %v", err)
+	}
+	m.Done()
+	if len(tb.errs) != 3 {
+		t.Errorf("wrong number of errors: want=3, got=%d: %v", len(tb.errs), tb.errs)
+	}
+}
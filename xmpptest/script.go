@@ -0,0 +1,69 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest
+
+import (
+	"encoding/xml"
+	"strings"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+)
+
+// ServerScript sets up the server side of a ClientServer to reply to each
+// stanza that it receives with the next of the provided responses in turn.
+// Once every response has been sent, further stanzas are handled as if the
+// server had no handler.
+//
+// Responses are XML strings containing a single stanza.
+// If a response is an IQ without an id attribute, the id of the stanza being
+// responded to is used so that the response can be matched to an IQ sent by
+// the client.
+// If a response is the empty string, no response is sent for the
+// corresponding stanza.
+func ServerScript(responses ...string) Option {
+	var mu sync.Mutex
+	return ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		mu.Lock()
+		if len(responses) == 0 {
+			mu.Unlock()
+			return nil
+		}
+		resp := responses[0]
+		responses = responses[1:]
+		mu.Unlock()
+
+		if resp == "" {
+			return nil
+		}
+		_, id := attr.Get(start.Attr, "id")
+		_, err := xmlstream.Copy(t, scriptedReader(resp, id))
+		return err
+	})
+}
+
+// scriptedReader returns a token reader over the XML in resp that sets the id
+// attribute of the outermost element if it is an IQ without one.
+func scriptedReader(resp, id string) xml.TokenReader {
+	d := xml.NewDecoder(strings.NewReader(resp))
+	first := true
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		tok, err := d.Token()
+		if err != nil || !first {
+			return tok, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			return tok, err
+		}
+		first = false
+		if idx, _ := attr.Get(start.Attr, "id"); start.Name.Local == "iq" && idx == -1 {
+			start = start.Copy()
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: id})
+		}
+		return start, nil
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest_test
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+func TestServerScript(t *testing.T) {
+	cs := xmpptest.NewClientServer(xmpptest.ServerScript(
+		`<iq type="result" xmlns="jabber:client"><query xmlns="jabber:iq:version"><name>test</name></query></iq>`,
+	))
+	defer cs.Close()
+
+	resp, err := cs.Client.SendIQElement(context.Background(), nil, stanza.IQ{
+		ID:   "123",
+		Type: stanza.GetIQ,
+	})
+	if err != nil {
+		t.Fatalf("error sending IQ: %v", err)
+	}
+	var iq struct {
+		stanza.IQ
+		Name string `xml:"jabber:iq:version query>name"`
+	}
+	err = xml.NewTokenDecoder(resp).Decode(&iq)
+	if err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	err = resp.Close()
+	if err != nil {
+		t.Fatalf("error closing response: %v", err)
+	}
+	if iq.ID != "123" || iq.Type != stanza.ResultIQ || iq.Name != "test" {
+		t.Errorf("wrong response: %+v", iq)
+	}
+}
@@ -3,7 +3,12 @@
 // license that can be found in the LICENSE file.
 
 // Package xmpptest provides utilities for XMPP testing.
-package xmpptest // import "mellium.im/xmpp/internal/xmpptest"
+//
+// It can be used to unit test handlers and other code that depends on an
+// xmpp.Session without connecting to a real server.
+// Sessions created by this package skip stream negotiation and communicate
+// over an in-memory pipe.
+package xmpptest // import "mellium.im/xmpp/xmpptest"
 
 import (
 	"context"
@@ -15,6 +15,7 @@ import (
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
@@ -121,6 +122,21 @@ func Get(ctx context.Context, s *xmpp.Session, to jid.JID) (time.Time, error) {
 	return data.Time, err
 }
 
+// GetOffset is like Get except that it also returns the estimated offset
+// between the remote entity's clock and the local clock.
+// The offset is calculated by assuming that the remote time was sampled halfway
+// through the round trip and is positive if the remote clock is ahead of the
+// local clock.
+func GetOffset(ctx context.Context, s *xmpp.Session, to jid.JID) (time.Time, time.Duration, error) {
+	sent := time.Now()
+	remote, err := Get(ctx, s, to)
+	if err != nil {
+		return remote, 0, err
+	}
+	rtt := time.Since(sent)
+	return remote, remote.Sub(sent.Add(rtt / 2)), nil
+}
+
 // Handle returns an option that registers a Handler for entity time requests.
 func Handle(h Handler) mux.Option {
 	return mux.IQ(stanza.GetIQ, xml.Name{Local: "time", Space: NS}, h)
@@ -148,3 +164,11 @@ func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml
 	_, err := xmlstream.Copy(t, iq.Result(tt.TokenReader()))
 	return err
 }
+
+// ForFeatures implements disco.FeatureIter.
+func (h Handler) ForFeatures(node string, f func(disco.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	return f(disco.Feature{Var: NS})
+}
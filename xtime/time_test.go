@@ -11,8 +11,9 @@ import (
 	"time"
 
 	"mellium.im/xmlstream"
-	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/disco"
 	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/xmpptest"
 	"mellium.im/xmpp/xtime"
 )
 
@@ -23,6 +24,7 @@ var (
 	_ xmlstream.WriterTo  = xtime.Time{}
 	_ xml.MarshalerAttr   = xtime.Time{}
 	_ xml.UnmarshalerAttr = (*xtime.Time)(nil)
+	_ disco.FeatureIter   = xtime.Handler{}
 )
 
 func TestRoundTrip(t *testing.T) {
@@ -48,6 +50,26 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+func TestGetOffset(t *testing.T) {
+	const skew = 2 * time.Hour
+	h := xtime.Handler{
+		TimeFunc: func() time.Time {
+			return time.Now().Add(skew)
+		},
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(xtime.Handle(h))),
+	)
+
+	_, offset, err := xtime.GetOffset(context.Background(), cs.Client, cs.Server.LocalAddr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := offset - skew; diff < -time.Second || diff > time.Second {
+		t.Errorf("wrong offset: want≈%v, got=%v", skew, offset)
+	}
+}
+
 func TestAttrMarshal(t *testing.T) {
 	zeroTime := time.Time{}.Add(24 * time.Hour)
 	xt := xtime.Time{Time: zeroTime}